@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+)
+
+// figureColors are the colors of successive series or nodes in charts - the
+// plotly default color cycle.
+var figureColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+var figurePage = template.Must(template.New("figure").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body>
+{{.SVG}}
+</body>
+</html>
+`))
+
+// writeFigure writes a chart to path: an SVG image drawn by svg if path ends
+// in .svg, the plotly JSON figure spec if it ends in .json, and otherwise a
+// self-contained HTML page with the SVG image inlined, which needs nothing
+// else to be viewed.
+func writeFigure(path, title string, spec interface{}, svg func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch lower := strings.ToLower(path); {
+	case strings.HasSuffix(lower, ".svg"):
+		w := bufio.NewWriter(f)
+		if err := svg(w); err != nil {
+			return err
+		} else if err := w.Flush(); err != nil {
+			return err
+		}
+	case strings.HasSuffix(lower, ".json"):
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(spec); err != nil {
+			return err
+		}
+	default:
+		var buf bytes.Buffer
+		if err := svg(&buf); err != nil {
+			return err
+		}
+		err := figurePage.Execute(f, struct {
+			Title string
+			SVG   template.HTML
+		}{title, template.HTML(buf.String())})
+		if err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
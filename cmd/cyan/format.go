@@ -0,0 +1,266 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rwcarlsen/cyan/nuc"
+)
+
+// Output formats of command results selected with the -format flag.
+const (
+	tableFormat   = "table"
+	csvFormat     = "csv"
+	jsonFormat    = "json"
+	parquetFormat = "parquet"
+)
+
+var formats = []string{tableFormat, csvFormat, jsonFormat, parquetFormat}
+
+// rowWriter writes the header and rows of a command's results in one output
+// format.  Row values are those scanned from a query: nil (NULL), int64,
+// float64, string, or []byte - or bool for results computed by a command.
+type rowWriter interface {
+	Header(cols []string) error
+	Row(vals []interface{}) error
+	Flush() error
+}
+
+// openOut returns a writer of command results to the file at path, or to
+// stdout if path is empty, gzip compressed if gz is true or path ends in
+// .gz.  The returned function flushes the compressed stream and closes the
+// file and must be called once the results are written, or the file is
+// truncated.
+func openOut(path string, gz bool) (w io.Writer, closeOut func() error, err error) {
+	var f io.WriteCloser = nopCloser{os.Stdout}
+	if path != "" {
+		if f, err = os.Create(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !gz && !strings.HasSuffix(path, ".gz") {
+		return f, f.Close, nil
+	}
+	zw := gzip.NewWriter(f)
+	return zw, func() error {
+		if err := zw.Close(); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}, nil
+}
+
+// nopCloser is a WriteCloser whose Close does nothing, for stdout.
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// checkFormat exits if format isn't one of the output formats.
+func checkFormat(format string) {
+	for _, f := range formats {
+		if f == format {
+			return
+		}
+	}
+	log.Fatalf("invalid output format %q (want one of %v)", format, formats)
+}
+
+// newRowWriter returns a writer of results to w in the given format,
+// writing any NucId column in the -nucnames style.
+func newRowWriter(w io.Writer, format string) rowWriter {
+	rw := formatWriter(w, format)
+	if *nucnames != "" {
+		return &nucNameWriter{rowWriter: rw, col: -1}
+	}
+	return rw
+}
+
+// formatWriter returns a writer of results to w in the given format.
+func formatWriter(w io.Writer, format string) rowWriter {
+	switch format {
+	case tableFormat:
+		return tableWriter{tabwriter.NewWriter(w, 4, 4, 1, ' ', 0)}
+	case csvFormat:
+		cw := csv.NewWriter(w)
+		cw.UseCRLF = true // RFC 4180 line endings
+		return csvWriter{cw}
+	case jsonFormat:
+		return &jsonWriter{w: w}
+	case parquetFormat:
+		return &parquetWriter{w: w}
+	}
+	checkFormat(format)
+	return nil
+}
+
+// nucNameWriter writes the nuclide ids of the NucId column of results as
+// names in the -nucnames style.
+type nucNameWriter struct {
+	rowWriter
+	col int
+}
+
+func (n *nucNameWriter) Header(cols []string) error {
+	for i, c := range cols {
+		if strings.EqualFold(c, "nucid") {
+			n.col = i
+		}
+	}
+	return n.rowWriter.Header(cols)
+}
+
+func (n *nucNameWriter) Row(vals []interface{}) error {
+	if n.col >= 0 {
+		if id, ok := vals[n.col].(int64); ok {
+			vals[n.col] = nuc.Format(nuc.Nuc(id), nucStyle)
+		}
+	}
+	return n.rowWriter.Row(vals)
+}
+
+// valString returns the text of a scanned value and false if it is NULL.
+func valString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case []byte:
+		return string(v), true
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return fmt.Sprint(v), true
+}
+
+// tableWriter aligns the columns of results for reading in a terminal.
+type tableWriter struct{ tw *tabwriter.Writer }
+
+func (t tableWriter) Header(cols []string) error {
+	if *noheader {
+		return nil
+	}
+	for _, c := range cols {
+		if _, err := t.tw.Write([]byte(c + "\t")); err != nil {
+			return err
+		}
+	}
+	_, err := t.tw.Write([]byte("\n"))
+	return err
+}
+
+func (t tableWriter) Row(vals []interface{}) error {
+	for _, v := range vals {
+		s, ok := valString(v)
+		if !ok {
+			s = "NULL"
+		}
+		if _, err := t.tw.Write([]byte(s + "\t")); err != nil {
+			return err
+		}
+	}
+	_, err := t.tw.Write([]byte("\n"))
+	return err
+}
+
+func (t tableWriter) Flush() error { return t.tw.Flush() }
+
+// csvWriter writes results as CSV records.  NULL values are empty fields.
+type csvWriter struct{ w *csv.Writer }
+
+func (c csvWriter) Header(cols []string) error {
+	if *noheader {
+		return nil
+	}
+	return c.w.Write(cols)
+}
+
+func (c csvWriter) Row(vals []interface{}) error {
+	rec := make([]string, len(vals))
+	for i, v := range vals {
+		rec[i], _ = valString(v)
+	}
+	return c.w.Write(rec)
+}
+
+func (c csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonWriter writes results as a JSON array of records, one object per row,
+// keyed by the lower cased column names.  Numbers keep their type and NULL
+// values are null.
+type jsonWriter struct {
+	w    io.Writer
+	keys []string
+	n    int
+}
+
+func (j *jsonWriter) Header(cols []string) error {
+	j.keys = make([]string, len(cols))
+	for i, c := range cols {
+		j.keys[i] = strings.ToLower(c)
+	}
+	_, err := io.WriteString(j.w, "[")
+	return err
+}
+
+func (j *jsonWriter) Row(vals []interface{}) error {
+	rec := make(map[string]interface{}, len(vals))
+	for i, v := range vals {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		rec[j.keys[i]] = v
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	sep := "\n"
+	if j.n > 0 {
+		sep = ",\n"
+	}
+	j.n++
+	_, err = io.WriteString(j.w, sep+string(data))
+	return err
+}
+
+func (j *jsonWriter) Flush() error {
+	_, err := io.WriteString(j.w, "\n]\n")
+	return err
+}
+
+// writeRecords writes results computed by a command rather than read from a
+// query to w in the given format.
+func writeRecords(w io.Writer, format string, cols []string, recs [][]interface{}) {
+	rw := newRowWriter(w, format)
+	fatalif(rw.Header(cols))
+	for _, rec := range recs {
+		fatalif(rw.Row(rec))
+	}
+	fatalif(rw.Flush())
+}
+
+// plotFormat returns the format of results written for plotting if plotit
+// is true - gnuplot reads the table format - and -format otherwise.
+func plotFormat(plotit bool) string {
+	if plotit {
+		return tableFormat
+	}
+	return *format
+}
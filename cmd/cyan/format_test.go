@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/parquet"
+)
+
+func TestOpenOutGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cyan-out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a .gz suffix or the gz flag compresses the output
+	for _, c := range []struct {
+		Name string
+		Gz   bool
+	}{{"inv.csv.gz", false}, {"inv.csv", true}} {
+		path := filepath.Join(dir, c.Name)
+		w, closeOut, err := openOut(path, c.Gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := formatWriter(w, csvFormat)
+		if err := rw.Header([]string{"Time", "Quantity"}); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := rw.Row([]interface{}{int64(i), 1.5}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rw.Flush(); err != nil {
+			t.Fatal(err)
+		} else if err := closeOut(); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("%v: %v", c.Name, err)
+		}
+		data, err := ioutil.ReadAll(zr)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%v: truncated stream: %v", c.Name, err)
+		}
+		want := "Time,Quantity\r\n"
+		for i := 0; i < 1000; i++ {
+			want += fmt.Sprintf("%v,1.5\r\n", i)
+		}
+		if string(data) != want {
+			t.Errorf("%v: round trip differs:\n%.200v", c.Name, string(data))
+		}
+	}
+
+	path := filepath.Join(dir, "inv.csv")
+	w, closeOut, err := openOut(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("plain\n"))
+	if err := closeOut(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "plain\n" {
+		t.Errorf("want uncompressed output, got %q (err=%v)", data, err)
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	rw := formatWriter(&buf, csvFormat)
+	rw.Header([]string{"Name", "Quantity", "Note"})
+	rw.Row([]interface{}{[]byte("a,b"), 1.5, `say "hi"`})
+	rw.Row([]interface{}{"two\nlines", int64(2), nil})
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// RFC 4180 quoting and line endings - also within quoted fields - with
+	// NULL as an empty field
+	want := "Name,Quantity,Note\r\n" +
+		"\"a,b\",1.5,\"say \"\"hi\"\"\"\r\n" +
+		"\"two\r\nlines\",2,\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	rw := formatWriter(&buf, jsonFormat)
+	rw.Header([]string{"AgentId", "Quantity", "Prototype", "Note"})
+	rw.Row([]interface{}{int64(7), 1.5, []byte("reactor"), nil})
+	rw.Row([]interface{}{int64(8), 2.0, "sink", true})
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// numbers keep their type and keys are lower cased
+	want := "[\n" +
+		`{"agentid":7,"note":null,"prototype":"reactor","quantity":1.5},` + "\n" +
+		`{"agentid":8,"note":true,"prototype":"sink","quantity":2}` + "\n]\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// an empty result is still an array
+	buf.Reset()
+	rw = formatWriter(&buf, jsonFormat)
+	rw.Header([]string{"AgentId"})
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var recs []interface{}
+	if err := json.Unmarshal(buf.Bytes(), &recs); err != nil || recs == nil || len(recs) != 0 {
+		t.Errorf("want empty array, got %q (err=%v)", buf.String(), err)
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	rw := formatWriter(&buf, parquetFormat)
+	rw.Header([]string{"Time", "Quantity"})
+	for i := 0; i < 10; i++ {
+		if err := rw.Row([]interface{}{int64(i), float64(i) / 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := parquet.ReadMeta(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	} else if m.NumRows != 10 {
+		t.Errorf("want 10 rows, got %v", m.NumRows)
+	}
+}
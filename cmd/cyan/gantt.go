@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/rwcarlsen/cyan/query"
+)
+
+// ganttBar is the lifetime of one agent - from its entry up to its exit or
+// the end of the simulation.
+type ganttBar struct {
+	Label      string
+	Proto      string
+	Start, End int
+}
+
+// ganttBars returns the bars of ags grouped by prototype, each group in
+// order of entry, and the prototypes in order.  Agents that never exited
+// end at time end.
+func ganttBars(ags []query.AgentInfo, end int) (bars []ganttBar, protos []string) {
+	ags = append([]query.AgentInfo(nil), ags...)
+	sort.Slice(ags, func(i, j int) bool {
+		a, b := ags[i], ags[j]
+		if a.Proto != b.Proto {
+			return a.Proto < b.Proto
+		} else if a.Enter != b.Enter {
+			return a.Enter < b.Enter
+		}
+		return a.Id < b.Id
+	})
+	for _, a := range ags {
+		bar := ganttBar{fmt.Sprintf("%v %v", a.Proto, a.Id), a.Proto, a.Enter, end}
+		if a.Exit >= 0 {
+			bar.End = a.Exit
+		}
+		if len(protos) == 0 || protos[len(protos)-1] != a.Proto {
+			protos = append(protos, a.Proto)
+		}
+		bars = append(bars, bar)
+	}
+	return bars, protos
+}
+
+// ganttSpec is a plotly figure with a horizontal bar trace per prototype.
+type ganttSpec struct {
+	Data   []ganttTrace           `json:"data"`
+	Layout map[string]interface{} `json:"layout"`
+}
+
+type ganttTrace struct {
+	Type        string                 `json:"type"`
+	Orientation string                 `json:"orientation"`
+	Name        string                 `json:"name"`
+	Y           []string               `json:"y"`
+	X           []int                  `json:"x"`
+	Base        []int                  `json:"base"`
+	Marker      map[string]interface{} `json:"marker"`
+}
+
+// newGantt returns the plotly figure of bars with a trace of each prototype
+// in protos.
+func newGantt(bars []ganttBar, protos []string, title string) *ganttSpec {
+	traces := map[string]*ganttTrace{}
+	spec := &ganttSpec{Layout: map[string]interface{}{
+		"title":   title,
+		"barmode": "overlay",
+		"xaxis":   map[string]interface{}{"title": "Time (Months)"},
+		"yaxis":   map[string]interface{}{"autorange": "reversed", "type": "category"},
+		"height":  200 + 20*len(bars),
+	}}
+	for i, p := range protos {
+		spec.Data = append(spec.Data, ganttTrace{Type: "bar", Orientation: "h", Name: p,
+			Marker: map[string]interface{}{"color": figureColors[i%len(figureColors)]}})
+	}
+	for i := range spec.Data {
+		traces[spec.Data[i].Name] = &spec.Data[i]
+	}
+	for _, b := range bars {
+		tr := traces[b.Proto]
+		tr.Y = append(tr.Y, b.Label)
+		tr.X = append(tr.X, b.End-b.Start)
+		tr.Base = append(tr.Base, b.Start)
+	}
+	return spec
+}
+
+// writeGantt writes a gantt chart of the lifetimes of ags, with agents that
+// never exited ending at time end, to path with writeFigure: an SVG image,
+// the plotly JSON figure, or an HTML page showing the image.
+func writeGantt(path string, ags []query.AgentInfo, end int, title string) error {
+	bars, protos := ganttBars(ags, end)
+	return writeFigure(path, title, newGantt(bars, protos, title), func(w io.Writer) error {
+		return ganttSVG(w, bars, protos, end, title)
+	})
+}
+
+// ganttSVG draws the bars as an SVG image with a row per agent labeled on
+// the left and a time axis with about ten ticks along the bottom.
+func ganttSVG(w io.Writer, bars []ganttBar, protos []string, end int, title string) error {
+	const (
+		row    = 18
+		top    = 40
+		left   = 160
+		plotw  = 720
+		bottom = 40
+	)
+	if end <= 0 {
+		end = 1
+	}
+	x := func(t int) float64 { return left + float64(t)*plotw/float64(end) }
+	height := top + row*len(bars) + bottom
+	colors := map[string]string{}
+	for i, p := range protos {
+		colors[p] = figureColors[i%len(figureColors)]
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%v" height="%v" font-family="sans-serif" font-size="11">`+"\n", left+plotw+20, height)
+	fmt.Fprintf(&buf, `<text x="%v" y="20" font-size="14" text-anchor="middle">%v</text>`+"\n", left+plotw/2, html.EscapeString(title))
+	for i, b := range bars {
+		y := top + i*row
+		fmt.Fprintf(&buf, `<text x="%v" y="%v" text-anchor="end">%v</text>`+"\n", left-6, y+row-5, html.EscapeString(b.Label))
+		fmt.Fprintf(&buf, `<rect x="%.1f" y="%v" width="%.1f" height="%v" fill="%v"><title>%v: %v to %v</title></rect>`+"\n",
+			x(b.Start), y+2, x(b.End)-x(b.Start), row-4, colors[b.Proto], html.EscapeString(b.Label), b.Start, b.End)
+	}
+
+	axis := top + row*len(bars)
+	fmt.Fprintf(&buf, `<line x1="%v" y1="%v" x2="%v" y2="%v" stroke="black"/>`+"\n", left, axis, left+plotw, axis)
+	step := int(math.Pow(10, math.Floor(math.Log10(float64(end)/10))))
+	if step < 1 {
+		step = 1
+	}
+	for _, m := range []int{1, 2, 5, 10} {
+		if end/(m*step) <= 10 {
+			step *= m
+			break
+		}
+	}
+	for t := 0; t <= end; t += step {
+		fmt.Fprintf(&buf, `<line x1="%.1f" y1="%v" x2="%.1f" y2="%v" stroke="black"/>`+"\n", x(t), axis, x(t), axis+4)
+		fmt.Fprintf(&buf, `<text x="%.1f" y="%v" text-anchor="middle">%v</text>`+"\n", x(t), axis+16, t)
+	}
+	fmt.Fprintf(&buf, `<text x="%v" y="%v" text-anchor="middle">Time (Months)</text>`+"\n", left+plotw/2, axis+32)
+	fmt.Fprintln(&buf, "</svg>")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/query"
+)
+
+func TestGanttBars(t *testing.T) {
+	ags := []query.AgentInfo{
+		{Id: 3, Proto: "sink", Enter: 0, Exit: -1},
+		{Id: 2, Proto: "reactor", Enter: 4, Exit: 9},
+		{Id: 1, Proto: "reactor", Enter: 1, Exit: 5},
+	}
+	bars, protos := ganttBars(ags, 10)
+	want := []ganttBar{
+		{"reactor 1", "reactor", 1, 5},
+		{"reactor 2", "reactor", 4, 9},
+		{"sink 3", "sink", 0, 10},
+	}
+	if !reflect.DeepEqual(bars, want) {
+		t.Errorf("want bars %v, got %v", want, bars)
+	}
+	if want := []string{"reactor", "sink"}; !reflect.DeepEqual(protos, want) {
+		t.Errorf("want prototypes %v, got %v", want, protos)
+	}
+	if ags[0].Id != 3 {
+		t.Errorf("ganttBars reordered its argument")
+	}
+}
+
+func TestGanttSVG(t *testing.T) {
+	bars := []ganttBar{
+		{"reactor 1", "reactor", 0, 50},
+		{"sink <2>", "sink", 25, 100},
+	}
+	var buf bytes.Buffer
+	if err := ganttSVG(&buf, bars, []string{"reactor", "sink"}, 100, "a & b"); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	if n := strings.Count(svg, "<rect "); n != len(bars) {
+		t.Errorf("want %v bars, got %v", len(bars), n)
+	}
+	// the first bar spans half of the 720 wide plot from its left edge
+	if !strings.Contains(svg, `<rect x="160.0" y="42" width="360.0"`) {
+		t.Errorf("want the first bar at the start of the axis:\n%v", svg)
+	}
+	if !strings.Contains(svg, `fill="`+figureColors[1]+`"`) {
+		t.Errorf("want the second prototype in the second color:\n%v", svg)
+	}
+	// ticks every 10 months from 0 through 100
+	if n := strings.Count(svg, `text-anchor="middle">`); n != 11+2 {
+		t.Errorf("want 11 tick labels besides the title and axis label, got %v", n-2)
+	}
+	if !strings.Contains(svg, "sink &lt;2&gt;") || !strings.Contains(svg, "a &amp; b") {
+		t.Errorf("labels aren't escaped:\n%v", svg)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"flag"
@@ -12,10 +13,15 @@ import (
 	"math"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/tabwriter"
 	"text/template"
+	"time"
 
 	"code.google.com/p/go-uuid/uuid"
 	"github.com/rwcarlsen/cyan/nuc"
@@ -29,8 +35,16 @@ var (
 	custom    = flag.String("custom", "", "path to custom sql query spec file")
 	showquery = flag.Bool("query", false, "show query SQL for a subcommand instead of executing it")
 	dbname    = flag.String("db", "", "cyclus sqlite database to query")
+	autopost  = flag.Bool("auto-post", true, "build the simid's inventories first if they haven't been (-auto-post=false only queries tables already built)")
+	derived   = flag.String("derived", "", "sqlite file holding the post processed tables (inventories, agents, etc.) so the -db database is opened read-only and left unmodified")
 	simidstr  = flag.String("simid", "", "simulation id in hex (empty string defaults to first sim id in database")
 	noheader  = flag.Bool("noheader", false, "don't print header line with output data")
+	format    = flag.String("format", tableFormat, "output `format` of command results: table, csv, json, or parquet")
+	outpath   = flag.String("o", "", "write command results to this `file` instead of stdout (gzip compressed if it ends in .gz)")
+	gzipout   = flag.Bool("gzip", false, "gzip compress command results")
+	nucnames  = flag.String("nucnames", "", "write nuclides in this `style`: name (U235), hyphen (U-235), or id (922350000) - by default nuclide columns are named by name and NucId values are ids")
+	nucdata   = flag.String("nucdata", "", "CSV `file` of nuclear data replacing the built in data of decay related commands, with columns nuclide, half-life (s), decay heat (W/kg), and daughters (e.g. \"Am241:0.99998 U237:2.45e-5\")")
+	nucgroups = flag.String("nucgroups", "", "JSON `file` defining custom nuclide groups, e.g. {\"recycled\": [\"Pu\", \"Am241\", \"minoractinides\"]}")
 )
 
 var simid []byte
@@ -39,6 +53,16 @@ var command string
 
 var db *sql.DB
 
+// out receives command results: stdout or the -o file.
+var out io.Writer = os.Stdout
+
+// nucStyle is the -nucnames style of nuclide names in results.
+var nucStyle = nuc.StyleName
+
+// compat is db with statements mapped onto its cyclus schema version, detected
+// once when it is opened.  Queries read the database through it.
+var compat query.DB
+
 var cmds = NewCmdSet()
 
 // map[cmdname]sqltext
@@ -51,6 +75,7 @@ func init() {
 	cmds.Register("version", "show simulation's cyclus version info", doVersion)
 	cmds.Register("post", "post process the database", doPost)
 	cmds.Register("table", "show the contents of a specific table", doTable)
+	cmds.Register("sql", "run a custom SQL query", doSql)
 	cmds.Register("ts", "investigate time-series data tables", doTimeSeries)
 	cmds.RegisterDiv("Agents")
 	cmds.Register("agents", "list all agents in the simulation", doAgents)
@@ -58,18 +83,31 @@ func init() {
 	cmds.Register("deployed", "time series total active deployments by prototype", doDeployed)
 	cmds.Register("built", "time series of new builds by prototype", doBuilt)
 	cmds.Register("decom", "time series of a decommissionings by prototype", doDecom)
+	cmds.Register("deploy", "time series of builds, decommissionings, and deployed fleet of every prototype", doDeploy)
 	cmds.Register("ages", "list ages of agents at a particular time step", doAges)
 	cmds.RegisterDiv("Flow")
 	cmds.Register("commods", "show commodity transaction counts and quantities", doCommods)
 	cmds.Register("flow", "time series of material transacted between agents", doFlow)
 	cmds.Register("flowgraph", "generate a graphviz dot script of flows between agents", doFlowGraph)
+	cmds.Register("flowmatrix", "matrix of material transacted between every pair of prototypes", doFlowMatrix)
 	cmds.Register("trans", "time series of transaction quantity over time", doTrans)
 	cmds.RegisterDiv("Other")
 	cmds.Register("inv", "time series of inventory by prototype", doInv)
 	cmds.Register("power", "time series of power produced", doPower)
+	cmds.Register("capacity", "time series of installed electric capacity", doCapacity)
+	cmds.Register("capfactor", "capacity factors of power producers over an interval", doCapFactor)
 	cmds.Register("energy", "thermal energy (J) generated between 2 timesteps", doEnergy)
 	cmds.Register("created", "material created by agents between 2 timesteps", doCreated)
+	cmds.Register("swu", "time series of the separative work and feed of enrichment facilities", doSWU)
 	cmds.Register("taint", "taint analysis...", doTaint)
+	cmds.Register("ancestry", "generate a graphviz dot script of a resource's heritage", doAncestry)
+	cmds.RegisterDiv("Decay")
+	cmds.Register("heat", "time series of the decay heat (W) of inventories or discharged material", doHeat)
+	cmds.Register("activity", "time series of the radioactivity of inventories or transacted material", doActivity)
+	cmds.Register("tox", "time series of the radiotoxicity (Sv) of inventories or transacted material", doTox)
+	cmds.RegisterDiv("Economics")
+	cmds.Register("costs", "undiscounted and discounted cash flows of agents from economic archetypes", doCosts)
+	cmds.Register("lcoe", "levelized cost of electricity (money/MWh) of power producers", doLCOE)
 }
 
 func main() {
@@ -103,6 +141,16 @@ func main() {
 		return
 	}
 
+	checkFormat(*format)
+	if *nucnames != "" {
+		var err error
+		nucStyle, err = nuc.ParseStyle(*nucnames)
+		fatalif(err)
+	}
+	w, closeOut, err := openOut(*outpath, *gzipout)
+	fatalif(err)
+	out = w
+
 	if *custom != "" {
 		data, err := ioutil.ReadFile(*custom)
 		fatalif(err)
@@ -111,9 +159,17 @@ func main() {
 
 	// run command
 	cmds.Execute(flag.Args())
+	fatalif(closeOut())
 }
 
+// doCustom writes the results of the command's query to w in the -format
+// output format.
 func doCustom(w io.Writer, cmd string, args ...interface{}) {
+	doCustomAs(w, *format, cmd, args...)
+}
+
+// doCustomAs is like doCustom but writes the results in the given format.
+func doCustomAs(w io.Writer, format string, cmd string, args ...interface{}) {
 	s, ok := customSql[cmd]
 	if !ok {
 		log.Fatalf("Invalid command/query %v", cmd)
@@ -122,59 +178,40 @@ func doCustom(w io.Writer, cmd string, args ...interface{}) {
 		return
 	}
 
-	rows, err := db.Query(s, args...)
+	rows, err := compat.Query(s, args...)
 	fatalif(err)
+	defer rows.Close()
 
-	tw := tabwriter.NewWriter(w, 4, 4, 1, ' ', 0)
+	rw := newRowWriter(w, format)
 	cols, err := rows.Columns()
 	fatalif(err)
 
 	simidcol := -1
-	if !*noheader {
-		// write header line
-		for i, c := range cols {
-			if strings.Contains(strings.ToLower(c), "simid") {
-				simidcol = i
-			}
-			_, err := tw.Write([]byte(c + "\t"))
-			fatalif(err)
+	for i, c := range cols {
+		if strings.Contains(strings.ToLower(c), "simid") {
+			simidcol = i
 		}
-		_, err = tw.Write([]byte("\n"))
-		fatalif(err)
 	}
+	fatalif(rw.Header(cols))
 
+	vals := make([]interface{}, len(cols))
 	vs := make([]interface{}, len(cols))
-	vals := make([]*sql.NullString, len(cols))
 	for i := range vals {
-		vals[i] = &sql.NullString{}
-		vs[i] = vals[i]
+		vs[i] = &vals[i]
 	}
 
 	for rows.Next() {
-		for i := range vals {
-			vals[i].Valid = false
-		}
-
 		err := rows.Scan(vs...)
 		fatalif(err)
 
-		for i, v := range vals {
-			if v.Valid {
-				s = v.String
-				if i == simidcol {
-					s = uuid.UUID(v.String).String()
-				}
-				tw.Write([]byte(s + "\t"))
-			} else {
-				tw.Write([]byte("NULL\t"))
-			}
+		if simidcol >= 0 && vals[simidcol] != nil {
+			id, _ := valString(vals[simidcol])
+			vals[simidcol] = uuid.UUID(id).String()
 		}
-
-		_, err = tw.Write([]byte("\n"))
-		fatalif(err)
+		fatalif(rw.Row(vals))
 	}
 	fatalif(rows.Err())
-	fatalif(tw.Flush())
+	fatalif(rw.Flush())
 }
 
 func doSims(cmd string, args []string) {
@@ -185,14 +222,29 @@ func doSims(cmd string, args []string) {
 		fs.PrintDefaults()
 	}
 	fs.Parse(args)
-	initdb()
-	s := `
-SELECT i.SimId AS SimId,Duration,Handle,Decay
-FROM Info As i
-JOIN DecayMode AS d ON i.SimId=d.SimId 
-`
-	customSql[cmd] = s
-	doCustom(os.Stdout, cmd)
+	noQuery(cmd)
+	if !opendb() {
+		return
+	}
+
+	sims, err := query.SimList(compat)
+	fatalif(err)
+	cols := []string{"SimId", "Handle", "Start", "Duration", "Agents", "Transactions", "Cyclus", "Decay", "PostProcessed"}
+	var recs [][]interface{}
+	for _, sm := range sims {
+		recs = append(recs, []interface{}{
+			uuid.UUID(sm.Id).String(),
+			sm.Handle,
+			fmt.Sprintf("%v-%02v", sm.InitialYear, sm.InitialMonth),
+			int64(sm.Duration),
+			int64(sm.NAgents),
+			int64(sm.NTransactions),
+			sm.CyclusVersion,
+			sm.Decay,
+			sm.PostProcessed,
+		})
+	}
+	writeRecords(out, *format, cols, recs)
 }
 
 func doVersion(cmd string, args []string) {
@@ -222,18 +274,166 @@ WHERE i.simid=?
 		s = `SELECT Spec AS Archetype,Version FROM AgentVersions WHERE simid=?`
 	}
 	customSql[cmd] = s
-	doCustom(os.Stdout, cmd, simid)
+	doCustom(out, cmd, simid)
 }
 
 func doPost(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	manifest := fs.String("manifest", "", "file listing simids to process in order (newline or comma separated, '#' comments) instead of all simids")
+	stale := fs.Bool("stale", false, "only build inventories for simids with resources but no inventories yet")
+	force := fs.Bool("force", false, "rebuild inventories of simids already post processed instead of skipping them")
+	verbosity := fs.Int("v", 0, "progress verbosity (-1 silent, 0 phase banners, 1 per-root and per-statement timing)")
+	jobs := fs.Int("j", 1, "number of simids to build inventories for concurrently")
+	workers := fs.Int("workers", 1, "number of goroutines walking each simid's resources")
+	progress := fs.Bool("progress", false, "show a progress bar for each simid on stderr instead of logging progress")
+	pqpath := fs.String("parquet", "", "also write the inventories of the simids to this Parquet `file` (with each simid appended to the name if there are several)")
+	shards := fs.Int("shards", 1, "split each -parquet file by agent into this many `files` written concurrently (with the shard number appended to the name)")
 	fs.Usage = func() {
-		log.Printf("Usage: %v", cmd)
+		log.Printf("Usage: %v [cyclus-db]", cmd)
 		log.Printf("%v\n", cmds.Help(cmd))
+		log.Printf("Builds inventories for every simid (or those selected by flags) and finishes the database.")
+		log.Printf("The database may be given instead of with the -db flag.")
 		fs.PrintDefaults()
 	}
 	fs.Parse(args)
-	initdb()
+	if fs.NArg() > 0 {
+		*dbname = fs.Arg(0)
+	}
+	if !opendb() {
+		return
+	}
+
+	var ids [][]byte
+	var err error
+	if *manifest == "" {
+		ids, err = post.GetSimIds(db)
+	} else {
+		var f *os.File
+		f, err = os.Open(*manifest)
+		fatalif(err)
+		ids, err = post.ReadManifest(f)
+		f.Close()
+	}
+	fatalif(err)
+
+	if *stale {
+		ids, err = staleIds(ids)
+		fatalif(err)
+	}
+
+	// the first interrupt rolls back the simids being walked and stops; a
+	// second one kills the process immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		signal.Stop(sigs)
+		log.Print("interrupted, rolling back the current simids...")
+		cancel()
+	}()
+
+	opts := post.ProcessOptions{
+		Log:       log.New(os.Stderr, "", log.LstdFlags),
+		Verbosity: post.Verbosity(*verbosity),
+		Ctx:       ctx,
+		Jobs:      *jobs,
+		Workers:   *workers,
+		Force:     *force,
+		AttachDB:  *derived,
+	}
+	if *progress {
+		opts.Verbosity = post.Silent
+		opts.ProgressFunc = progressBar(os.Stderr)
+	}
+	err = post.ProcessIdsWith(db, ids, opts)
+	if err == context.Canceled {
+		log.Fatal("interrupted: inventories of unfinished simids were not built")
+	}
+	fatalif(err)
+
+	if *pqpath != "" {
+		for _, id := range ids {
+			dumpParquet(id, *pqpath, len(ids) > 1, *shards)
+		}
+	}
+}
+
+// dumpParquet writes the inventories of simid to a Parquet file at path,
+// with the simid inserted before the file extension if perSim is true.  If
+// shards is more than one, the inventories are split by agent into that
+// many files (see post.DumpParquetShards) with the shard number inserted
+// before the extension.
+func dumpParquet(simid []byte, path string, perSim bool, shards int) {
+	ext := filepath.Ext(path)
+	if perSim {
+		path = strings.TrimSuffix(path, ext) + "-" + uuid.UUID(simid).String() + ext
+	}
+	if shards <= 1 {
+		f, err := os.Create(path)
+		fatalif(err)
+		defer f.Close()
+		fatalif(post.DumpParquet(db, simid, f))
+		fatalif(f.Close())
+		return
+	}
+
+	files := make([]*os.File, shards)
+	ws := make([]io.Writer, shards)
+	for i := range files {
+		f, err := os.Create(fmt.Sprintf("%v-%v%v", strings.TrimSuffix(path, ext), i, ext))
+		fatalif(err)
+		defer f.Close()
+		files[i], ws[i] = f, f
+	}
+	fatalif(post.DumpParquetShards(db, simid, ws))
+	for _, f := range files {
+		fatalif(f.Close())
+	}
+}
+
+// progressBar returns a ProgressFunc drawing a bar of the roots walked for a
+// simid on w, redrawn at most every tenth of a second.
+func progressBar(w io.Writer) func(post.Progress) {
+	const width = 40
+	var mu sync.Mutex
+	var last time.Time
+	return func(p post.Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		done := p.RootsDone == p.Roots
+		if !done && time.Since(last) < 100*time.Millisecond {
+			return
+		}
+		last = time.Now()
+
+		n := width * p.RootsDone / p.Roots
+		fmt.Fprintf(w, "\r%x [%v%v] %3d%% %v/%v roots, %v resources, ETA %v ",
+			p.Simid, strings.Repeat("=", n), strings.Repeat(" ", width-n), 100*p.RootsDone/p.Roots,
+			p.RootsDone, p.Roots, p.Resources, p.ETA.Round(time.Second))
+		if done {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// staleIds returns the simids in ids without inventories built yet.
+func staleIds(ids [][]byte) ([][]byte, error) {
+	stale, err := post.StaleSimIds(db, post.DefaultInvTable)
+	if err != nil {
+		return nil, err
+	}
+	var keep [][]byte
+	for _, id := range ids {
+		for _, s := range stale {
+			if bytes.Equal(id, s) {
+				keep = append(keep, id)
+				break
+			}
+		}
+	}
+	return keep, nil
 }
 
 func doInfile(cmd string, args []string) {
@@ -250,16 +450,18 @@ SELECT data FROM inputfiles WHERE simid=?;
 `
 	var buf bytes.Buffer
 	customSql[cmd] = s
-	doCustom(&buf, cmd, simid)
+	doCustomAs(&buf, tableFormat, cmd, simid)
 	data := buf.String()
 	data = strings.Replace(data, "Data", "", 1)
 	data = strings.TrimLeft(data, "\r\n\t ")
-	fmt.Print(data)
+	fmt.Fprint(out, data)
 }
 
 func doAgents(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 	proto := fs.String("proto", "", "filter by prototype (default is all prototypes)")
+	tree := fs.Bool("tree", false, "show the region/institution/facility hierarchy (with -proto only the branches holding the prototype)")
+	gantt := fs.String("gantt", "", "write a gantt chart of the agents' lifetimes grouped by prototype to this `file`: an SVG image if it ends in .svg, a plotly JSON figure if it ends in .json, and a self-contained HTML page showing the image otherwise")
 	fs.Usage = func() {
 		log.Printf("Usage: %v", cmd)
 		log.Printf("%v\n", cmds.Help(cmd))
@@ -268,9 +470,26 @@ func doAgents(cmd string, args []string) {
 	fs.Parse(args)
 	initdb()
 
+	if *gantt != "" {
+		noQuery(cmd + " -gantt")
+		ags, err := query.AllAgents(compat, simid, *proto)
+		fatalif(err)
+		si, err := query.SimStat(compat, simid)
+		fatalif(err)
+		fatalif(writeGantt(*gantt, ags, si.Duration, "Agent Lifetimes"))
+		return
+	}
+
+	if *tree && !*showquery {
+		ags, err := query.AllAgents(compat, simid, "")
+		fatalif(err)
+		agentTree(ags, *proto)
+		return
+	}
+
 	iargs := []interface{}{simid}
 	s := `
-SELECT AgentId,Kind,Prototype,ParentId,EnterTime,ExitTime,Lifetime
+SELECT AgentId,Kind,Prototype,Spec,ParentId,EnterTime,ExitTime,Lifetime
 FROM Agents
 WHERE SimId = ?
 `
@@ -279,7 +498,84 @@ WHERE SimId = ?
 		iargs = append(iargs, *proto)
 	}
 	customSql[cmd] = s
-	doCustom(os.Stdout, cmd, iargs...)
+	doCustom(out, cmd, iargs...)
+}
+
+// agentTree writes the agents under their parents, depth first in id order,
+// keeping only branches holding agents of prototype proto if it isn't
+// empty.  The table format indents agents by their depth and other formats
+// list them with a Depth column.
+func agentTree(ags []query.AgentInfo, proto string) {
+	byid := map[int]bool{}
+	for _, a := range ags {
+		byid[a.Id] = true
+	}
+	kids := map[int][]query.AgentInfo{}
+	var roots []query.AgentInfo
+	for _, a := range ags {
+		if byid[a.Parent] && a.Parent != a.Id {
+			kids[a.Parent] = append(kids[a.Parent], a)
+		} else {
+			roots = append(roots, a)
+		}
+	}
+
+	keep := map[int]bool{}
+	var mark func(a query.AgentInfo) bool
+	mark = func(a query.AgentInfo) bool {
+		k := proto == "" || a.Proto == proto
+		for _, kid := range kids[a.Id] {
+			k = mark(kid) || k
+		}
+		keep[a.Id] = k
+		return k
+	}
+	for _, a := range roots {
+		mark(a)
+	}
+
+	type node struct {
+		query.AgentInfo
+		depth int
+	}
+	var nodes []node
+	var walk func(as []query.AgentInfo, depth int)
+	walk = func(as []query.AgentInfo, depth int) {
+		sort.Slice(as, func(i, j int) bool { return as[i].Id < as[j].Id })
+		for _, a := range as {
+			if keep[a.Id] {
+				nodes = append(nodes, node{a, depth})
+				walk(kids[a.Id], depth+1)
+			}
+		}
+	}
+	walk(roots, 0)
+
+	if *format != tableFormat {
+		cols := []string{"AgentId", "Depth", "Kind", "Prototype", "Spec", "ParentId", "EnterTime", "ExitTime", "Lifetime"}
+		var recs [][]interface{}
+		for _, n := range nodes {
+			var exit interface{}
+			if n.Exit >= 0 {
+				exit = int64(n.Exit)
+			}
+			recs = append(recs, []interface{}{int64(n.Id), int64(n.depth), n.Kind, n.Proto, n.Impl,
+				int64(n.Parent), int64(n.Enter), exit, int64(n.Lifetime)})
+		}
+		writeRecords(out, *format, cols, recs)
+		return
+	}
+
+	tw := tabwriter.NewWriter(out, 4, 4, 1, ' ', 0)
+	for _, n := range nodes {
+		exit := "-"
+		if n.Exit >= 0 {
+			exit = strconv.Itoa(n.Exit)
+		}
+		fmt.Fprintf(tw, "%v%v %v (%v)\t%v\tenter=%v\texit=%v\n", strings.Repeat("    ", n.depth),
+			n.Proto, n.Id, n.Kind, n.Impl, n.Enter, exit)
+	}
+	fatalif(tw.Flush())
 }
 
 func doAges(cmd string, args []string) {
@@ -311,7 +607,7 @@ AND (a.exittime >= ? OR a.exittime ISNULL)
 	}
 
 	customSql[cmd] = s
-	doCustom(os.Stdout, cmd, iargs...)
+	doCustom(out, cmd, iargs...)
 }
 
 func doTable(cmd string, args []string) {
@@ -328,12 +624,56 @@ func doTable(cmd string, args []string) {
 	if fs.NArg() > 0 {
 		s := "SELECT * FROM " + fs.Arg(0) + " WHERE SimId = ?"
 		customSql[cmd] = s
-		doCustom(os.Stdout, cmd, simid)
+		doCustom(out, cmd, simid)
 	} else {
 		s := "SELECT name FROM sqlite_master WHERE type='table';"
 		customSql[cmd] = s
-		doCustom(os.Stdout, cmd)
+		doCustom(out, cmd)
+	}
+}
+
+// sqlArgs collects the values bound to the parameters of a custom query.
+type sqlArgs []interface{}
+
+func (a *sqlArgs) String() string { return fmt.Sprint(*a) }
+
+// Set adds v as an integer or real number if it parses as one and as text
+// otherwise.
+func (a *sqlArgs) Set(v string) error {
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+		*a = append(*a, i)
+	} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+		*a = append(*a, f)
+	} else {
+		*a = append(*a, v)
+	}
+	return nil
+}
+
+func doSql(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	var qargs sqlArgs
+	fs.Var(&qargs, "arg", "`value` bound to the query's next ? parameter (repeatable)")
+	bysim := fs.Bool("sim", false, "only show rows of the -simid simulation (the query must select a SimId column)")
+	fs.Usage = func() {
+		log.Printf("Usage: %v [-arg value...] <query>", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		log.Printf("Values are bound as integers or real numbers if they parse as one and as text otherwise.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("must specify a single query")
+	}
+	initdb()
+
+	s := fs.Arg(0)
+	if *bysim {
+		s = "SELECT * FROM (" + strings.TrimRight(strings.TrimSpace(s), ";") + ") WHERE SimId = ?"
+		qargs = append(qargs, simid)
 	}
+	customSql[cmd] = s
+	doCustom(out, cmd, qargs...)
 }
 
 func doTimeSeries(cmd string, args []string) {
@@ -353,12 +693,12 @@ func doTimeSeries(cmd string, args []string) {
 		s := "SELECT name as foobarbaz FROM sqlite_master WHERE type='table' AND instr(name,'TimeSeries');"
 		customSql[cmd] = s
 		var buf bytes.Buffer
-		doCustom(&buf, cmd)
+		doCustomAs(&buf, tableFormat, cmd)
 		data := buf.String()
 		data = strings.Replace(data, "foobarbaz", "", -1)
 		data = strings.Replace(data, "TimeSeries", "", -1)
 		data = strings.TrimLeft(data, "\r\n\t ")
-		fmt.Print(string(data))
+		fmt.Fprint(out, string(data))
 	} else {
 		tsname := fs.Arg(0)
 		filter := ""
@@ -382,11 +722,11 @@ FROM timelist as tl LEFT JOIN (
 		customSql[cmd] = buf.String()
 
 		var buff bytes.Buffer
-		doCustom(&buff, cmd, simid)
+		doCustomAs(&buff, plotFormat(*plotit), cmd, simid)
 		if *plotit {
 			plot(&buff, "linespoints", "Time (Months)", "Power (MWe)", "Total Power Produced")
 		} else {
-			fmt.Print(buff.String())
+			fmt.Fprint(out, buff.String())
 		}
 	}
 }
@@ -394,6 +734,7 @@ FROM timelist as tl LEFT JOIN (
 func doPower(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 	proto := fs.String("proto", "", "filter by prototype (default is all prototypes)")
+	by := fs.String("by", "", "list the power of each `proto`type, inst(itution), or region in its own column instead of the total")
 	plotit := fs.Bool("p", false, "plot the data")
 	fs.Usage = func() {
 		log.Printf("Usage: %v", cmd)
@@ -403,6 +744,21 @@ func doPower(cmd string, args []string) {
 	fs.Parse(args)
 	initdb()
 
+	if *by != "" {
+		noQuery(cmd + " -by")
+		groups, _ := powerGroups(*by, "Power", *proto)
+		ps, err := query.PowerSeries(compat, simid)
+		fatalif(err)
+		sums := map[string]map[int]float64{}
+		for _, p := range ps {
+			if g, ok := groups[p.Agent]; ok {
+				addGroup(sums, g, p.Time, p.Power)
+			}
+		}
+		writeGroups(sums, *plotit, "Power (MWe)", "Power Produced")
+		return
+	}
+
 	s := `
 SELECT tl.Time AS Time,IFNULL(sub.Power,0) AS Power
 FROM timelist as tl LEFT JOIN (
@@ -424,12 +780,303 @@ FROM timelist as tl LEFT JOIN (
 	customSql[cmd] = buf.String()
 
 	var buff bytes.Buffer
-	doCustom(&buff, cmd, simid)
+	doCustomAs(&buff, plotFormat(*plotit), cmd, simid)
 	if *plotit {
 		plot(&buff, "linespoints", "Time (Months)", "Power (MWe)", "Total Power Produced")
 	} else {
-		fmt.Print(buff.String())
+		fmt.Fprint(out, buff.String())
+	}
+}
+
+// doCapacity writes the nameplate electric capacity of the power producing
+// agents deployed at every timestep (see query.PowerCapacities).
+func doCapacity(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	proto := fs.String("proto", "", "filter by prototype (default is all prototypes)")
+	by := fs.String("by", "", "list the capacity of each `proto`type, inst(itution), or region in its own column instead of the total")
+	plotit := fs.Bool("p", false, "plot the data")
+	fs.Usage = func() {
+		log.Printf("Usage: %v", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	noQuery(cmd)
+	initdb()
+
+	groups, agents := powerGroups(*by, "Capacity", *proto)
+	caps, err := query.PowerCapacities(compat, simid)
+	fatalif(err)
+	times, err := query.SimTimeList(compat, simid)
+	fatalif(err)
+
+	sums := map[string]map[int]float64{}
+	if *by == "" {
+		sums["Capacity"] = map[int]float64{}
+	}
+	for _, t := range times {
+		for id, g := range groups {
+			if c, ok := caps[id]; ok && agents[id].Alive(t) {
+				addGroup(sums, g, t, c)
+			}
+		}
+	}
+	writeGroups(sums, *plotit, "Capacity (MWe)", "Installed Capacity")
+}
+
+// doCapFactor writes the capacity factor of every power producing agent over
+// an interval or the fleet average over successive windows of it (see
+// query.PowerFactors).
+func doCapFactor(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	t0 := fs.Int("t1", 0, "beginning of time interval (default is beginning of simulation)")
+	t1 := fs.Int("t2", -1, "end of time interval (default if end of simulation)")
+	proto := fs.String("proto", "", "filter by prototype (default is all prototypes)")
+	fleet := fs.Bool("fleet", false, "write the capacity weighted fleet average instead of each agent's factor")
+	window := fs.Int("window", 0, "average the fleet over successive windows of this many `timesteps` (default is the whole interval)")
+	plotit := fs.Bool("p", false, "plot the fleet average over each window")
+	fs.Usage = func() {
+		log.Printf("Usage: %v", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	noQuery(cmd)
+	initdb()
+
+	if *t1 == -1 {
+		si, err := query.SimStat(compat, simid)
+		fatalif(err)
+		*t1 = si.Duration
+	}
+	protos, err := query.PrototypeMap(compat, simid)
+	fatalif(err)
+	if !*fleet && !*plotit {
+		*window = 0
+	}
+	series, err := query.PowerFactorSeries(compat, simid, *t0, *t1, *window)
+	fatalif(err)
+	for i, pfs := range series {
+		sel := pfs[:0]
+		for _, pf := range pfs {
+			if *proto == "" || protos[pf.Agent] == *proto {
+				sel = append(sel, pf)
+			}
+		}
+		series[i] = sel
+	}
+
+	if !*fleet && !*plotit {
+		var recs [][]interface{}
+		if len(series) > 0 {
+			for _, pf := range series[0] {
+				recs = append(recs, []interface{}{int64(pf.Agent), protos[pf.Agent], pf.Capacity, int64(pf.Steps), pf.Energy, pf.Factor})
+			}
+		}
+		writeRecords(out, *format, []string{"AgentId", "Prototype", "Capacity", "Steps", "Energy", "CapFactor"}, recs)
+		return
+	}
+
+	if *window <= 0 {
+		*window = *t1 - *t0
+	}
+	var recs [][]interface{}
+	for i, pfs := range series {
+		recs = append(recs, []interface{}{int64(*t0 + i*(*window)), query.FleetFactor(pfs)})
+	}
+	var buf bytes.Buffer
+	writeRecords(&buf, plotFormat(*plotit), []string{"Time", "CapFactor"}, recs)
+	if *plotit {
+		plot(&buf, "linespoints", "Time (Months)", "Capacity factor", "Fleet Capacity Factor")
+	} else {
+		fmt.Fprint(out, buf.String())
+	}
+}
+
+// econs returns the economics (see query.Economics) of every agent keyed by
+// AgentId and Prototype, or of every prototype keyed by Prototype if
+// byproto is true, in the returned order.  Only agents of prototype proto
+// are included unless it is empty.
+func econs(rate float64, byproto bool, proto string) (keys [][]interface{}, es []query.AgentEcon) {
+	all, err := query.Economics(compat, simid, rate)
+	fatalif(err)
+	protos, err := query.PrototypeMap(compat, simid)
+	fatalif(err)
+
+	index := map[string]int{}
+	for _, e := range all {
+		p := protos[e.Agent]
+		if proto != "" && p != proto {
+			continue
+		} else if !byproto {
+			keys = append(keys, []interface{}{int64(e.Agent), p})
+			es = append(es, e)
+			continue
+		}
+		i, ok := index[p]
+		if !ok {
+			i = len(es)
+			index[p] = i
+			keys = append(keys, []interface{}{p})
+			es = append(es, query.AgentEcon{})
+		}
+		es[i].Add(e)
+	}
+	return keys, es
+}
+
+// doCosts writes the cash flows of every agent or prototype in the cost
+// tables of economic archetypes.
+func doCosts(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	rate := fs.Float64("rate", 0.07, "annual discount `rate`")
+	byproto := fs.Bool("byproto", false, "sum the costs of each prototype instead of listing each agent")
+	proto := fs.String("proto", "", "filter by prototype (default is all prototypes)")
+	fs.Usage = func() {
+		log.Printf("Usage: %v", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		log.Printf("Costs are read from the %v tables.", strings.Join(query.CostTables, ", "))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	noQuery(cmd)
+	initdb()
+
+	keys, es := econs(*rate, *byproto, *proto)
+	cols := []string{"AgentId", "Prototype"}
+	if *byproto {
+		cols = cols[1:]
+	}
+	cols = append(append(cols, query.CostTables...), "Total", "Discounted")
+	var recs [][]interface{}
+	for i, e := range es {
+		rec := keys[i]
+		for _, table := range query.CostTables {
+			rec = append(rec, e.Costs[table])
+		}
+		recs = append(recs, append(rec, e.Cost, e.DiscCost))
+	}
+	writeRecords(out, *format, cols, recs)
+}
+
+// doLCOE writes the levelized cost of electricity of every power producing
+// agent or prototype.
+func doLCOE(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	rate := fs.Float64("rate", 0.07, "annual discount `rate`")
+	byproto := fs.Bool("byproto", false, "levelize the costs and electricity of each prototype instead of each agent")
+	proto := fs.String("proto", "", "filter by prototype (default is all prototypes)")
+	fs.Usage = func() {
+		log.Printf("Usage: %v", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	noQuery(cmd)
+	initdb()
+
+	keys, es := econs(*rate, *byproto, *proto)
+	cols := []string{"AgentId", "Prototype"}
+	if *byproto {
+		cols = cols[1:]
+	}
+	cols = append(cols, "DiscCost", "DiscEnergy", "LCOE")
+	var recs [][]interface{}
+	for i, e := range es {
+		if lcoe, ok := e.LCOE(); ok {
+			recs = append(recs, append(keys[i], e.DiscCost, e.DiscEnergy, lcoe))
+		}
+	}
+	writeRecords(out, *format, cols, recs)
+}
+
+// powerGroups returns the column the power or capacity of each agent is
+// summed into: its prototype, institution, or region for by "proto",
+// "inst", or "region", or total if by is empty.  Only agents of prototype
+// proto are included unless it is empty.  Every agent is also returned by
+// id.
+func powerGroups(by, total, proto string) (groups map[int]string, agents map[int]query.AgentInfo) {
+	kind := ""
+	switch by {
+	case "", "proto":
+	case "inst":
+		kind = "Inst"
+	case "region":
+		kind = "Region"
+	default:
+		log.Fatalf("can't group by '%v': want proto, inst, or region", by)
+	}
+
+	ags, err := query.AllAgents(compat, simid, "")
+	fatalif(err)
+	agents = map[int]query.AgentInfo{}
+	for _, a := range ags {
+		agents[a.Id] = a
+	}
+
+	groups = map[int]string{}
+	for _, a := range ags {
+		if proto != "" && a.Proto != proto {
+			continue
+		}
+		switch {
+		case by == "":
+			groups[a.Id] = total
+		case kind == "":
+			groups[a.Id] = a.Proto
+		default:
+			groups[a.Id] = "none"
+			for p, ok := a, true; ok; p, ok = agents[p.Parent] {
+				if p.Kind == kind {
+					groups[a.Id] = p.Proto
+					break
+				}
+			}
+		}
+	}
+	return groups, agents
+}
+
+// addGroup adds v to the total of group g at time t.
+func addGroup(sums map[string]map[int]float64, g string, t int, v float64) {
+	if sums[g] == nil {
+		sums[g] = map[int]float64{}
+	}
+	sums[g][t] += v
+}
+
+// writeGroups writes the per timestep totals of each group in its own
+// column, at every timestep of the simulation, or plots them if there is
+// only one group.
+func writeGroups(sums map[string]map[int]float64, plotit bool, ylabel, title string) {
+	if plotit && len(sums) > 1 {
+		log.Fatal("can only plot one group: use -proto to select fewer agents")
+	}
+	times, err := query.SimTimeList(compat, simid)
+	fatalif(err)
+
+	cols := []string{}
+	for g := range sums {
+		cols = append(cols, g)
+	}
+	sort.Strings(cols)
+	var recs [][]interface{}
+	for _, t := range times {
+		rec := []interface{}{int64(t)}
+		for _, g := range cols {
+			rec = append(rec, sums[g][t])
+		}
+		recs = append(recs, rec)
+	}
+	cols = append([]string{"Time"}, cols...)
+
+	if plotit {
+		var buf bytes.Buffer
+		writeRecords(&buf, tableFormat, cols, recs)
+		plot(&buf, "linespoints", "Time (Months)", ylabel, title)
+		return
 	}
+	writeRecords(out, *format, cols, recs)
 }
 
 func doDeployed(cmd string, args []string) {
@@ -461,11 +1108,11 @@ WHERE tl.simid=?
 `
 	customSql[cmd] = s
 	var buf bytes.Buffer
-	doCustom(&buf, cmd, simid, proto, simid)
+	doCustomAs(&buf, plotFormat(*plotit), cmd, simid, proto, simid)
 	if *plotit {
 		plot(&buf, "linespoints", "Time (Months)", "Number "+proto+" Deployed", "Deployed Facilities")
 	} else {
-		fmt.Print(buf.String())
+		fmt.Fprint(out, buf.String())
 	}
 }
 
@@ -499,11 +1146,11 @@ WHERE tl.simid=?
 
 	customSql[cmd] = s
 	var buf bytes.Buffer
-	doCustom(&buf, cmd, simid, proto, simid)
+	doCustomAs(&buf, plotFormat(*plotit), cmd, simid, proto, simid)
 	if *plotit {
 		plot(&buf, "impulses", "Time (Months)", "Number "+proto+" Built", "New Facilities Built")
 	} else {
-		fmt.Print(buf.String())
+		fmt.Fprint(out, buf.String())
 	}
 }
 
@@ -537,37 +1184,93 @@ WHERE tl.simid=?
 
 	customSql[cmd] = s
 	var buf bytes.Buffer
-	doCustom(&buf, cmd, simid, proto, simid)
+	doCustomAs(&buf, plotFormat(*plotit), cmd, simid, proto, simid)
 	if *plotit {
 		plot(&buf, "impulses", "Time (Months)", "Number "+proto+" Decommissioned", "Facilities Decommissioned")
 	} else {
-		fmt.Print(buf.String())
+		fmt.Fprint(out, buf.String())
 	}
 }
 
-func doProtos(cmd string, args []string) {
+// doDeploy writes the number of agents of each prototype built,
+// decommissioned, and deployed at every timestep.
+func doDeploy(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	kind := fs.String("kind", "Facility", "only count agents of this `kind` (empty for all kinds)")
+	plotit := fs.Bool("p", false, "plot the deployed fleet of a single prototype")
 	fs.Usage = func() {
-		log.Printf("Usage: %v", cmd)
+		log.Printf("Usage: %v [prototype...]\nZero prototypes uses all prototypes", cmd)
 		log.Printf("%v\n", cmds.Help(cmd))
+		log.Printf("Agents count as deployed through their exit timestep - cyclus")
+		log.Printf("decommissions them at its end - as with the deployed command.")
 		fs.PrintDefaults()
 	}
 	fs.Parse(args)
-	initdb()
-
-	s := "SELECT DISTINCT Prototype FROM Prototypes WHERE simid=?;"
-	customSql[cmd] = s
-	doCustom(os.Stdout, cmd, simid)
-}
-
-func doCommods(cmd string, args []string) {
-	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-	fs.Usage = func() {
-		log.Printf("Usage: %v", cmd)
-		log.Printf("%v\n", cmds.Help(cmd))
-		fs.PrintDefaults()
+	if *plotit && fs.NArg() != 1 {
+		log.Fatal("must specify a single prototype to plot")
 	}
-	fs.Parse(args)
+	noQuery(cmd)
+	initdb()
+
+	deps, err := query.DeploySeries(compat, simid, *kind)
+	fatalif(err)
+	protos := fs.Args()
+	if len(protos) == 0 {
+		for p := range deps {
+			protos = append(protos, p)
+		}
+		sort.Strings(protos)
+	}
+	for _, p := range protos {
+		if deps[p] == nil {
+			log.Fatalf("no agents of prototype '%v' and kind '%v'", p, *kind)
+		}
+	}
+
+	if *plotit {
+		var recs [][]interface{}
+		for _, d := range deps[protos[0]] {
+			recs = append(recs, []interface{}{int64(d.Time), int64(d.Deployed)})
+		}
+		var buf bytes.Buffer
+		writeRecords(&buf, tableFormat, []string{"Time", "Deployed"}, recs)
+		plot(&buf, "linespoints", "Time (Months)", "Number "+protos[0]+" Deployed", "Deployed Fleet")
+		return
+	}
+
+	var recs [][]interface{}
+	for i := 0; len(protos) > 0 && i < len(deps[protos[0]]); i++ {
+		for _, p := range protos {
+			d := deps[p][i]
+			recs = append(recs, []interface{}{int64(d.Time), p, int64(d.Built), int64(d.Decommissioned), int64(d.Deployed)})
+		}
+	}
+	writeRecords(out, *format, []string{"Time", "Prototype", "Built", "Decommissioned", "Deployed"}, recs)
+}
+
+func doProtos(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		log.Printf("Usage: %v", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	initdb()
+
+	s := "SELECT DISTINCT Prototype FROM Prototypes WHERE simid=?;"
+	customSql[cmd] = s
+	doCustom(out, cmd, simid)
+}
+
+func doCommods(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		log.Printf("Usage: %v", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
 	initdb()
 
 	s := `
@@ -579,7 +1282,7 @@ GROUP BY commodity;
 `
 
 	customSql[cmd] = s
-	doCustom(os.Stdout, cmd, simid)
+	doCustom(out, cmd, simid)
 }
 
 func doTrans(cmd string, args []string) {
@@ -591,68 +1294,97 @@ func doTrans(cmd string, args []string) {
 	}
 	from := fs.String("from", "", "filter by supplying prototype")
 	to := fs.String("to", "", "filter by receiving prototype")
+	proto := fs.String("proto", "", "filter by a prototype supplying or receiving")
 	byagent := fs.Bool("byagent", false, "switch to/from filters to be agent IDs")
 	nucs := fs.String("nucs", "", "filter by comma separated `nuclide`s")
+	bynuc := fs.Bool("bynuc", false, "list the mass of each nuclide transacted instead of the total")
 	commod := fs.String("commod", "", "filter by a commodity")
+	t1 := fs.Int("t1", 0, "first timestep of transactions listed (default is beginning of simulation)")
+	t2 := fs.Int("t2", -1, "last timestep of transactions listed (default is end of simulation)")
 	fs.Parse(args)
 	initdb()
 
 	s := `
-SELECT t.time AS Time,t.SenderId AS SenderId,send.Prototype AS SenderProto,t.ReceiverId AS ReceiverId,recv.Prototype AS ReceiverProto,t.Commodity AS Commodity,SUM(r.Quantity*c.MassFrac) AS Quantity,r.ResourceId AS ResourceId
+SELECT t.time AS Time,t.SenderId AS SenderId,send.Prototype AS SenderProto,t.ReceiverId AS ReceiverId,recv.Prototype AS ReceiverProto,t.Commodity AS Commodity,{{.Cols}},r.ResourceId AS ResourceId
 FROM transactions AS t
 JOIN resources AS r ON t.resourceid=r.resourceid AND r.simid=t.simid
 JOIN agents AS send ON t.senderid=send.agentid AND send.simid=t.simid
 JOIN agents AS recv ON t.receiverid=recv.agentid AND recv.simid=t.simid
 JOIN compositions AS c ON c.qualid=r.qualid AND c.simid=t.simid
-WHERE t.simid=? {{index . 0}} {{index . 1}} {{index . 2}} {{index . 3}}
-GROUP BY t.transactionid
+WHERE t.simid=? {{range .Filters}}{{.}} {{end}}
+GROUP BY {{.Group}}
 `
 
-	filters := make([]string, 4)
+	var filters []string
 	iargs := []interface{}{simid}
 	if *from != "" {
 		if *byagent {
-			filters[0] = "AND t.senderid=?"
+			filters = append(filters, "AND t.senderid=?")
 			fromid, err := strconv.Atoi(*from)
 			if err != nil {
 				log.Fatalf("invalid agent ID (-from=%v)", *from)
 			}
 			iargs = append(iargs, fromid)
 		} else {
-			filters[0] = "AND send.prototype=?"
+			filters = append(filters, "AND send.prototype=?")
 			iargs = append(iargs, *from)
 		}
 	}
 	if *to != "" {
 		if *byagent {
-			filters[1] = "AND t.receiverid=?"
+			filters = append(filters, "AND t.receiverid=?")
 			toid, err := strconv.Atoi(*to)
 			if err != nil {
 				log.Fatalf("invalid agent ID (-to=%v)", *to)
 			}
 			iargs = append(iargs, toid)
 		} else {
-			filters[1] = "AND recv.prototype=?"
+			filters = append(filters, "AND recv.prototype=?")
 			iargs = append(iargs, *to)
 		}
 	}
+	if *proto != "" {
+		filters = append(filters, "AND (send.prototype=? OR recv.prototype=?)")
+		iargs = append(iargs, *proto, *proto)
+	}
 	if *commod != "" {
-		filters[2] = "AND t.commodity=?"
+		filters = append(filters, "AND t.commodity=?")
 		iargs = append(iargs, *commod)
 	}
-	filters[3] = nuclidefilter(*nucs)
+	if *t1 > 0 {
+		filters = append(filters, "AND t.time>=?")
+		iargs = append(iargs, *t1)
+	}
+	if *t2 >= 0 {
+		filters = append(filters, "AND t.time<=?")
+		iargs = append(iargs, *t2)
+	}
+	filters = append(filters, nuclidefilter(*nucs))
+
+	q := struct {
+		Cols, Group string
+		Filters     []string
+	}{"SUM(r.Quantity*c.MassFrac) AS Quantity", "t.transactionid", filters}
+	if *bynuc {
+		q.Cols = "c.NucId AS NucId,SUM(r.Quantity*c.MassFrac) AS Quantity"
+		q.Group = "t.transactionid,c.NucId"
+	}
 
 	tmpl := template.Must(template.New("sql").Parse(s))
 	var buf bytes.Buffer
-	tmpl.Execute(&buf, filters)
+	tmpl.Execute(&buf, q)
 	customSql[cmd] = buf.String()
-	doCustom(os.Stdout, cmd, iargs...)
+	doCustom(out, cmd, iargs...)
 }
 
 func doInv(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 	plotit := fs.Bool("p", false, "plot the data")
 	nucs := fs.String("nucs", "", "filter by comma separated `nuclide`s")
+	nuclide := fs.String("nuclide", "", "list the mass of each of these comma separated `nuclide`s in its own column instead of the total")
+	group := fs.String("group", "", "list the mass of each of these comma separated elements or nuclide `group`s in its own column instead of the total")
+	byagent := fs.Bool("byagent", false, "select an agent by ID instead of a prototype")
+	decay := fs.Bool("decay", false, "decay every resource from its creation to each timestep (see -nucdata)")
 	fs.Usage = func() {
 		log.Printf("Usage: %v <prototype>", cmd)
 		log.Printf("%v\n", cmds.Help(cmd))
@@ -662,36 +1394,102 @@ func doInv(cmd string, args []string) {
 	if fs.NArg() < 1 {
 		log.Fatal("must specify a prototype")
 	}
+	percol := nucColumns(*nuclide, *group)
+	if *plotit && len(percol) > 1 {
+		log.Fatal("can only plot one nuclide or group: use -nucs to plot their total")
+	}
 	initdb()
 
 	proto := fs.Arg(0)
+	var agent interface{} = proto
+	q := struct{ Qty, Agent, Filter string }{Agent: "a.prototype=?", Filter: nuclidefilter(*nucs)}
+	if *byagent {
+		id, err := strconv.Atoi(proto)
+		if err != nil {
+			log.Fatalf("invalid agent ID '%v'", proto)
+		}
+		agent, q.Agent = id, "a.agentid=?"
+	}
+	if *decay {
+		noQuery(cmd + " -decay")
+		doDecayedInv(proto, *byagent, *nucs, percol, *plotit)
+		return
+	}
 
-	filter := nuclidefilter(*nucs)
+	if q.Filter == "" {
+		q.Filter = columnsfilter(percol)
+	}
+	bynuc := q.Filter != ""
+	table := ""
+	if !*showquery {
+		// without a database to probe, -query shows the walked inventory SQL
+		var err error
+		table, err = query.ExplicitInvTable(compat, simid, bynuc)
+		fatalif(err)
+	}
+	mass := "inv.Quantity*c.MassFrac"
+	if table != "" {
+		mass = "c.Quantity"
+	} else if !bynuc {
+		mass = "inv.Quantity"
+	}
+	cols := "IFNULL(sub.qty, 0) AS Quantity"
+	q.Qty = "SUM(" + mass + ") AS qty"
+	if len(percol) > 0 {
+		cols, q.Qty = "", ""
+		for i, col := range percol {
+			if i > 0 {
+				cols += ","
+				q.Qty += ","
+			}
+			cols += fmt.Sprintf("IFNULL(sub.qty%v, 0) AS \"%v\"", i, col.Name)
+			q.Qty += fmt.Sprintf("SUM(CASE WHEN %v THEN %v ELSE 0 END) AS qty%v", col.Cond, mass, i)
+		}
+	}
+
+	qargs := []interface{}{simid, agent, simid}
 	s := ""
-	if filter != "" {
+	if table != "" {
+		// cyclus recorded the inventories itself - no walk needed
+		qargs = []interface{}{simid, simid, agent}
 		s = `
-SELECT tl.Time AS Time,IFNULL(sub.qty, 0) AS Quantity FROM timelist as tl
+WITH RECURSIVE tl(Time) AS (
+	SELECT 0 UNION ALL SELECT Time+1 FROM tl
+	WHERE Time+1 < (SELECT Duration FROM Info WHERE SimId=?)
+)
+SELECT tl.Time AS Time,` + cols + ` FROM tl
 LEFT JOIN (
-	SELECT tl.Time as time,SUM(inv.Quantity*c.MassFrac) AS qty
+	SELECT c.Time AS time,{{.Qty}}
+	FROM ` + table + ` AS c
+	JOIN AgentEntry AS a ON a.AgentId=c.AgentId AND a.SimId=c.SimId
+	WHERE c.SimId=? AND {{.Agent}} {{.Filter}}
+	GROUP BY c.Time
+) AS sub ON sub.time=tl.Time
+`
+	} else if bynuc {
+		s = `
+SELECT tl.Time AS Time,` + cols + ` FROM timelist as tl
+LEFT JOIN (
+	SELECT tl.Time as time,{{.Qty}}
 	FROM inventories as inv
 	JOIN timelist as tl ON UNLIKELY(inv.starttime <= tl.time) AND inv.endtime > tl.time AND tl.simid=inv.simid
 	JOIN agents as a on a.agentid=inv.agentid AND a.simid=inv.simid
 	JOIN compositions as c on c.qualid=inv.qualid AND c.simid=inv.simid
-	WHERE a.simid=? AND a.prototype=? {{.}}
+	WHERE a.simid=? AND {{.Agent}} {{.Filter}}
 	GROUP BY tl.Time
 ) AS sub ON sub.time=tl.time
 WHERE tl.simid=?
 `
 	} else {
 		s = `
-SELECT tl.Time AS Time,IFNULL(sub.qty, 0) AS Quantity
+SELECT tl.Time AS Time,` + cols + `
 FROM timelist as tl
 LEFT JOIN (
-	SELECT tl.Time as time,SUM(inv.Quantity) AS qty
+	SELECT tl.Time as time,{{.Qty}}
 	FROM inventories as inv
 	JOIN timelist as tl ON UNLIKELY(inv.starttime <= tl.time) AND inv.endtime > tl.time AND tl.simid=inv.simid
 	JOIN agents as a on a.agentid=inv.agentid AND a.simid=inv.simid
-	WHERE a.simid=? AND a.prototype=? {{.}}
+	WHERE a.simid=? AND {{.Agent}} {{.Filter}}
 	GROUP BY tl.Time
 ) AS sub ON sub.time=tl.time
 WHERE tl.simid=?
@@ -700,14 +1498,407 @@ WHERE tl.simid=?
 
 	tmpl := template.Must(template.New("sql").Parse(s))
 	var buf bytes.Buffer
-	tmpl.Execute(&buf, filter)
+	tmpl.Execute(&buf, q)
 	customSql[cmd] = buf.String()
 	var buff bytes.Buffer
-	doCustom(&buff, cmd, simid, proto, simid)
+	doCustomAs(&buff, plotFormat(*plotit), cmd, qargs...)
 	if *plotit {
-		plot(&buff, "linespoints", "Time (Months)", proto+" inventory ( kg "+*nucs+")", "Inventory")
+		label := *nucs
+		if len(percol) > 0 {
+			label = percol[0].Name
+		}
+		plot(&buff, "linespoints", "Time (Months)", proto+" inventory ( kg "+label+")", "Inventory")
 	} else {
-		fmt.Print(buff.String())
+		fmt.Fprint(out, buff.String())
+	}
+}
+
+// agentIds returns the IDs of the agents of a prototype, or the agent with
+// the given ID if byagent is true.
+func agentIds(proto string, byagent bool) []int {
+	if byagent {
+		id, err := strconv.Atoi(proto)
+		if err != nil {
+			log.Fatalf("invalid agent ID '%v'", proto)
+		}
+		return []int{id}
+	}
+	ags, err := query.AllAgents(compat, simid, proto)
+	fatalif(err)
+	if len(ags) == 0 {
+		log.Fatalf("no agents of prototype '%v'", proto)
+	}
+	var ids []int
+	for _, a := range ags {
+		ids = append(ids, a.Id)
+	}
+	return ids
+}
+
+// doDecayedInv writes the inventory series of inv -decay for the agents of
+// a prototype (see agentIds).
+func doDecayedInv(proto string, byagent bool, nucs string, percol []nucColumn, plotit bool) {
+	data := readNucData()
+	agents := agentIds(proto, byagent)
+
+	cols := []string{"Time"}
+	var masses []func(nuc.Material) nuc.Mass
+	for _, col := range percol {
+		cols = append(cols, col.Name)
+		masses = append(masses, col.Group.Mass)
+	}
+	if len(percol) == 0 {
+		cols = append(cols, "Quantity")
+		masses = append(masses, nuc.Material.Mass)
+		if filt := parseNucs(nucs); len(filt) > 0 {
+			masses[0] = nuc.Group{Nucs: filt}.Mass
+		}
+	}
+
+	times, mats, err := query.DecayedInvSeries(compat, simid, data, agents...)
+	fatalif(err)
+	var recs [][]interface{}
+	for i, m := range mats {
+		rec := []interface{}{int64(times[i])}
+		for _, mass := range masses {
+			rec = append(rec, float64(mass(m)))
+		}
+		recs = append(recs, rec)
+	}
+
+	var buf bytes.Buffer
+	writeRecords(&buf, plotFormat(plotit), cols, recs)
+	if plotit {
+		label := cols[1]
+		if len(percol) == 0 {
+			label = nucs
+		}
+		plot(&buf, "linespoints", "Time (Months)", fmt.Sprintf("%v decayed inventory (kg %v)", proto, label), "Inventory")
+	} else {
+		fmt.Fprint(out, buf.String())
+	}
+}
+
+// doSWU writes the separative work and feed of the enrichment done by a
+// prototype's agents and their cumulative totals at every timestep.
+func doSWU(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	plotit := fs.Bool("p", false, "plot the cumulative separative work")
+	byagent := fs.Bool("byagent", false, "select an agent by ID instead of a prototype")
+	feed := fs.Float64("feed", 0, "U235 `assay` of the feed (default measured from the material received, or natural uranium)")
+	tails := fs.Float64("tails", 0, "U235 `assay` of the tails (default measured from the tails sent, or 0.003)")
+	commod := fs.String("commod", "", "only count material received of this `commodity` as feed when measuring the feed and tails assays (default is all material received)")
+	fs.Usage = func() {
+		log.Printf("Usage: %v <enrichment-prototype>", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		log.Printf("Material sent above the feed assay is product.  Every product transaction is costed at")
+		log.Printf("a single feed and tails assay - given by -feed and -tails or averaged over the whole")
+		log.Printf("simulation - rather than reconstructed from the feed and tails transactions around it.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		log.Fatal("must specify an enrichment prototype")
+	}
+	noQuery(cmd)
+	initdb()
+
+	agents := agentIds(fs.Arg(0), *byagent)
+	var feedqty, feediso, tailsqty, tailsiso float64
+	for _, a := range agents {
+		ef, err := query.EnrichmentFlows(compat, simid, a, nuc.U235, *commod)
+		if err == query.ErrNoFeed {
+			continue
+		}
+		fatalif(err)
+		feedqty += ef.Feed
+		feediso += ef.Feed * ef.FeedAssay
+		tailsqty += ef.Tails
+		tailsiso += ef.Tails * ef.TailsAssay
+	}
+	if *feed == 0 {
+		*feed = nuc.NatUAssay
+		if feedqty > 0 {
+			*feed = feediso / feedqty
+		}
+	}
+	if *tails == 0 {
+		*tails = 0.003
+		if tailsqty > 0 {
+			*tails = tailsiso / tailsqty
+		}
+	}
+
+	steps, err := query.EnrichmentSeries(compat, simid, agents, nuc.U235, *feed, *tails)
+	fatalif(err)
+	var recs [][]interface{}
+	var cumswu, cumfeed float64
+	for _, st := range steps {
+		cumswu += st.SWU
+		cumfeed += st.Feed
+		recs = append(recs, []interface{}{int64(st.Time), st.Product, st.SWU, st.Feed, cumswu, cumfeed})
+	}
+
+	var buf bytes.Buffer
+	if *plotit {
+		for i := range recs {
+			recs[i] = []interface{}{recs[i][0], recs[i][4]}
+		}
+		writeRecords(&buf, tableFormat, []string{"Time", "CumSWU"}, recs)
+		plot(&buf, "linespoints", "Time (Months)", "Cumulative separative work (kg-SWU)", fs.Arg(0))
+		return
+	}
+	writeRecords(out, *format, []string{"Time", "Product", "SWU", "Feed", "CumSWU", "CumFeed"}, recs)
+}
+
+// readNucData returns the -nucdata nuclear data or the built in
+// nuc.StdDecay data if it wasn't given.
+func readNucData() query.NuclearData {
+	if *nucdata == "" {
+		return nuc.StdDecay
+	}
+	f, err := os.Open(*nucdata)
+	fatalif(err)
+	defer f.Close()
+	data, err := query.ReadNuclearData(f)
+	fatalif(err)
+	return data
+}
+
+// doHeat writes the thermal power of the decay of the inventory or
+// discharges of a prototype's agents at every timestep.
+func doHeat(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	plotit := fs.Bool("p", false, "plot the data")
+	byagent := fs.Bool("byagent", false, "select an agent by ID instead of a prototype")
+	discharged := fs.Bool("discharged", false, "show the heat of all material sent to other agents up to each timestep instead of the inventory")
+	commod := fs.String("commod", "", "only include discharged material of this commodity")
+	extend := fs.Int("extend", 0, "follow the cooling of discharged material for this many timesteps past the end of the simulation")
+	fs.Usage = func() {
+		log.Printf("Usage: %v <prototype>", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		log.Fatal("must specify a prototype")
+	} else if !*discharged && (*commod != "" || *extend != 0) {
+		log.Fatal("-commod and -extend can only be used with -discharged")
+	}
+	noQuery(cmd)
+	initdb()
+
+	data := readNucData()
+	agents := agentIds(fs.Arg(0), *byagent)
+	var times []int
+	var mats []nuc.Material
+	var err error
+	if *discharged {
+		var si query.SimInfo
+		si, err = query.SimStat(compat, simid)
+		fatalif(err)
+		times, mats, err = query.DecayedDischarges(compat, simid, si.Duration+*extend, data, *commod, agents...)
+	} else {
+		times, mats, err = query.DecayedInvSeries(compat, simid, data, agents...)
+	}
+	fatalif(err)
+
+	cols, recs := metricRecs(times, mats, "Heat", false, func(n nuc.Nuc, qty nuc.Mass) float64 {
+		return float64(qty) * data.DecayHeatCoeff(n)
+	})
+
+	var buf bytes.Buffer
+	writeRecords(&buf, plotFormat(*plotit), cols, recs)
+	if *plotit {
+		what := "inventory"
+		if *discharged {
+			what = "discharges"
+		}
+		plot(&buf, "linespoints", "Time (Months)", "Decay heat (W)", fs.Arg(0)+" "+what)
+	} else {
+		fmt.Fprint(out, buf.String())
+	}
+}
+
+// decaySource holds the flags of decay metric commands selecting their
+// material: the decayed inventory of a prototype's agents or the material
+// transacted between agents at each timestep.
+type decaySource struct {
+	byagent          *bool
+	from, to, commod *string
+}
+
+func newDecaySource(fs *flag.FlagSet, metric string) *decaySource {
+	return &decaySource{
+		byagent: fs.Bool("byagent", false, "select agents by ID instead of prototype"),
+		from:    fs.String("from", "", "show the "+metric+" of material transacted by this supplying prototype instead of an inventory"),
+		to:      fs.String("to", "", "show the "+metric+" of material transacted to this receiving prototype instead of an inventory"),
+		commod:  fs.String("commod", "", "only include transacted material of this commodity"),
+	}
+}
+
+func (d *decaySource) stream() bool { return *d.from != "" || *d.to != "" || *d.commod != "" }
+
+// check exits if the parsed flags and arguments of fs don't select any
+// material or select both an inventory and transactions.
+func (d *decaySource) check(fs *flag.FlagSet) {
+	if d.stream() && fs.NArg() > 0 {
+		log.Fatal("specify either a prototype's inventory or -from, -to, and -commod transactions")
+	} else if !d.stream() && fs.NArg() < 1 {
+		log.Fatal("must specify a prototype or transactions (-from, -to, -commod)")
+	}
+}
+
+// materials returns the selected material at every timestep decayed with
+// data, and a title for plots of it.
+func (d *decaySource) materials(fs *flag.FlagSet, data nuc.DecayData) (times []int, mats []nuc.Material, title string) {
+	var err error
+	if d.stream() {
+		var fromIds, toIds []int
+		if *d.from != "" {
+			fromIds = agentIds(*d.from, *d.byagent)
+		}
+		if *d.to != "" {
+			toIds = agentIds(*d.to, *d.byagent)
+		}
+		times, mats, err = query.DecayedTransfers(compat, simid, data, *d.commod, fromIds, toIds)
+		title = "transacted material"
+	} else {
+		times, mats, err = query.DecayedInvSeries(compat, simid, data, agentIds(fs.Arg(0), *d.byagent)...)
+		title = fs.Arg(0) + " inventory"
+	}
+	fatalif(err)
+	return times, mats, title
+}
+
+// metricRecs returns the Time and col records of a metric summed over the
+// nuclides of each material - or of each nuclide with a non-zero value with
+// a NucId column if bynuc is true.
+func metricRecs(times []int, mats []nuc.Material, col string, bynuc bool, metric func(nuc.Nuc, nuc.Mass) float64) (cols []string, recs [][]interface{}) {
+	if !bynuc {
+		for i, m := range mats {
+			v := 0.0
+			for n, qty := range m {
+				v += metric(n, qty)
+			}
+			recs = append(recs, []interface{}{int64(times[i]), v})
+		}
+		return []string{"Time", col}, recs
+	}
+
+	for i, m := range mats {
+		var nucs []int
+		for n := range m {
+			nucs = append(nucs, int(n))
+		}
+		sort.Ints(nucs)
+		for _, n := range nucs {
+			if v := metric(nuc.Nuc(n), m[nuc.Nuc(n)]); v != 0 {
+				recs = append(recs, []interface{}{int64(times[i]), int64(n), v})
+			}
+		}
+	}
+	return []string{"Time", "NucId", col}, recs
+}
+
+// activityUnits are the units of activity selectable with -units, in Bq.
+var activityUnits = map[string]float64{
+	"Bq":  1,
+	"GBq": 1e9,
+	"TBq": 1e12,
+	"Ci":  3.7e10,
+}
+
+// doActivity writes the radioactivity of the decayed inventory of a
+// prototype's agents or of the material transacted between agents at every
+// timestep.
+func doActivity(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	plotit := fs.Bool("p", false, "plot the data")
+	src := newDecaySource(fs, "activity")
+	bynuc := fs.Bool("bynuc", false, "break the activity down by nuclide")
+	units := fs.String("units", "Bq", "`unit`s of activity: Bq, GBq, TBq, or Ci")
+	fs.Usage = func() {
+		log.Printf("Usage: %v [prototype]", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	src.check(fs)
+	if *plotit && *bynuc {
+		log.Fatal("can't plot the activity broken down by nuclide")
+	}
+	unit := 0.0
+	for name, bq := range activityUnits {
+		if strings.EqualFold(name, *units) {
+			*units, unit = name, bq
+		}
+	}
+	if unit == 0 {
+		log.Fatalf("unknown activity unit '%v'", *units)
+	}
+	noQuery(cmd)
+	initdb()
+
+	data := readNucData()
+	times, mats, title := src.materials(fs, data)
+	cols, recs := metricRecs(times, mats, "Activity", *bynuc, func(n nuc.Nuc, qty nuc.Mass) float64 {
+		return nuc.Activity(n, qty, data) / unit
+	})
+
+	var buf bytes.Buffer
+	writeRecords(&buf, plotFormat(*plotit), cols, recs)
+	if *plotit {
+		plot(&buf, "linespoints", "Time (Months)", "Activity ("+*units+")", title)
+	} else {
+		fmt.Fprint(out, buf.String())
+	}
+}
+
+// doTox writes the radiotoxicity of the decayed inventory of a prototype's
+// agents or of the material transacted between agents at every timestep.
+func doTox(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	plotit := fs.Bool("p", false, "plot the data")
+	src := newDecaySource(fs, "radiotoxicity")
+	bynuc := fs.Bool("bynuc", false, "break the radiotoxicity down by nuclide")
+	route := fs.String("route", "ingestion", "intake `route` of the dose coefficients: ingestion or inhalation")
+	coeffs := fs.String("coeffs", "", "CSV `file` of dose coefficients (Sv/Bq) replacing the built in ICRP 72 ones, with columns nuclide, ingestion, and inhalation")
+	fs.Usage = func() {
+		log.Printf("Usage: %v [prototype]", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	src.check(fs)
+	if *plotit && *bynuc {
+		log.Fatal("can't plot the radiotoxicity broken down by nuclide")
+	}
+	intake, err := nuc.ParseIntake(*route)
+	fatalif(err)
+	doses := nuc.ICRP72
+	if *coeffs != "" {
+		f, err := os.Open(*coeffs)
+		fatalif(err)
+		doses, err = query.ReadDoseCoeffs(f)
+		f.Close()
+		fatalif(err)
+	}
+	noQuery(cmd)
+	initdb()
+
+	data := readNucData()
+	times, mats, title := src.materials(fs, data)
+	cols, recs := metricRecs(times, mats, "Radiotoxicity", *bynuc, func(n nuc.Nuc, qty nuc.Mass) float64 {
+		return nuc.Radiotoxicity(nuc.Material{n: qty}, data, doses, intake)
+	})
+
+	var buf bytes.Buffer
+	writeRecords(&buf, plotFormat(*plotit), cols, recs)
+	if *plotit {
+		plot(&buf, "linespoints", "Time (Months)", intake.String()+" radiotoxicity (Sv)", title)
+	} else {
+		fmt.Fprint(out, buf.String())
 	}
 }
 
@@ -719,77 +1910,173 @@ func doFlow(cmd string, args []string) {
 	to := fs.String("to", "", "filter by receiving prototype")
 	byagent := fs.Bool("byagent", false, "switch to/from filters to be agent IDs")
 	nucs := fs.String("nucs", "", "filter by comma separated `nuclide`s")
+	cumulative := fs.Bool("cumulative", false, "show the total transacted up to each timestep instead of the amount per timestep")
+	bynuc := fs.Bool("bynuc", false, "break the series down by nuclide")
+	group := fs.String("group", "", "list the mass of each of these comma separated elements or nuclide `group`s in its own column instead of the total")
+	t0 := fs.Int("t1", 0, "beginning of time interval (default is beginning of simulation)")
+	t1 := fs.Int("t2", -1, "end of time interval (default is end of simulation)")
+	sankey := fs.String("sankey", "", "write a sankey diagram of the flows between prototypes (agents with -byagent) to this `file`: an SVG image if it ends in .svg, a plotly JSON figure if it ends in .json, and a self-contained HTML page otherwise")
 	fs.Usage = func() {
 		log.Printf("Usage: %v", cmd)
 		log.Printf("%v\n", cmds.Help(cmd))
 		fs.PrintDefaults()
 	}
 	fs.Parse(args)
+	if *plotit && *bynuc {
+		log.Fatal("can't plot a series broken down by nuclide")
+	}
+	groups := nucColumns("", *group)
+	if *plotit && len(groups) > 1 {
+		log.Fatal("can only plot one group")
+	} else if *bynuc && len(groups) > 0 {
+		log.Fatal("-group and -bynuc can't be used together")
+	}
 	initdb()
 
+	if *sankey != "" {
+		noQuery(cmd + " -sankey")
+		if *nucs != "" || *bynuc || len(groups) > 0 {
+			log.Fatal("sankey diagrams show total masses: -nucs, -bynuc, and -group can't be used with -sankey")
+		}
+		arcs, err := query.FlowGraph(compat, simid, *t0, *t1, !*byagent)
+		fatalif(err)
+		var sel []query.FlowArc
+		for _, arc := range arcs {
+			src, dst := arc.SrcProto, arc.DstProto
+			if *byagent {
+				src, dst = strconv.Itoa(arc.SrcId), strconv.Itoa(arc.DstId)
+			}
+			if (*from == "" || *from == src) && (*to == "" || *to == dst) && (*commod == "" || *commod == arc.Commod) {
+				sel = append(sel, arc)
+			}
+		}
+		title := fmt.Sprintf("Material flows (kg), timesteps %v to %v", *t0, *t1)
+		if *t1 < 0 {
+			title = fmt.Sprintf("Material flows (kg), timesteps %v to end", *t0)
+		}
+		fatalif(writeSankey(*sankey, sel, *byagent, title))
+		return
+	}
+
 	s := `
-SELECT tl.Time AS Time,TOTAL(sub.qty) AS Quantity
-FROM timelist as tl
-LEFT JOIN (
-	SELECT t.simid AS simid,t.time as time,SUM(c.massfrac*r.quantity) as qty
+WITH flows AS (
+	SELECT t.time AS time{{if .ByNuc}},c.nucid AS nucid{{end}},{{if .Groups}}{{range $i, $g := .Groups}}{{if $i}},{{end}}SUM(CASE WHEN {{$g.Cond}} THEN c.massfrac*r.quantity ELSE 0 END) AS qty{{$i}}{{end}}{{else}}SUM(c.massfrac*r.quantity) AS qty{{end}}
 	FROM transactions AS t
 	JOIN resources as r ON t.resourceid=r.resourceid AND r.simid=t.simid
 	JOIN agents as send ON t.senderid=send.agentid AND send.simid=t.simid
 	JOIN agents as recv ON t.receiverid=recv.agentid AND recv.simid=t.simid
 	JOIN compositions as c ON c.qualid=r.qualid AND c.simid=r.simid
-	WHERE t.simid=? {{index . 0}} {{index . 1}} {{index . 2}} {{index . 3}}
-	GROUP BY t.time
-) AS sub ON tl.time=sub.time AND tl.simid=sub.simid
-WHERE tl.simid=?
-GROUP BY tl.Time;
+	WHERE t.simid=? {{range .Filters}}{{.}} {{end}}
+	GROUP BY t.time{{if .ByNuc}},c.nucid{{end}}
+), series AS (
+	SELECT tl.Time AS Time{{if .ByNuc}},n.nucid AS NucId{{end}},{{if .Groups}}{{range $i, $g := .Groups}}{{if $i}},{{end}}TOTAL(f.qty{{$i}}) AS "{{$g.Name}}"{{end}}{{else}}TOTAL(f.qty) AS Quantity{{end}}
+	FROM timelist AS tl{{if .ByNuc}}
+	CROSS JOIN (SELECT DISTINCT nucid FROM flows) AS n{{end}}
+	LEFT JOIN flows AS f ON f.time=tl.time{{if .ByNuc}} AND f.nucid=n.nucid{{end}}
+	WHERE tl.simid=?
+	GROUP BY tl.Time{{if .ByNuc}},n.nucid{{end}}
+)
+{{if .Cumulative -}}
+SELECT a.Time AS Time{{if .ByNuc}},a.NucId AS NucId{{end}},{{if .Groups}}{{range $i, $g := .Groups}}{{if $i}},{{end}}TOTAL(b."{{$g.Name}}") AS "{{$g.Name}}"{{end}}{{else}}TOTAL(b.Quantity) AS Quantity{{end}}
+FROM series AS a
+JOIN series AS b ON b.Time<=a.Time{{if .ByNuc}} AND b.NucId=a.NucId{{end}}
+GROUP BY a.Time{{if .ByNuc}},a.NucId{{end}}
+ORDER BY a.Time{{if .ByNuc}},a.NucId{{end}};
+{{- else -}}
+SELECT * FROM series ORDER BY Time{{if .ByNuc}},NucId{{end}};
+{{- end}}
 `
 
-	filters := make([]string, 4)
+	var filters []string
 	iargs := []interface{}{simid}
 	if *from != "" {
 		if *byagent {
-			filters[0] = "AND t.senderid=?"
+			filters = append(filters, "AND t.senderid=?")
 			fromid, err := strconv.Atoi(*from)
 			if err != nil {
 				log.Fatalf("invalid agent ID (-from=%v)", *from)
 			}
 			iargs = append(iargs, fromid)
 		} else {
-			filters[0] = "AND send.prototype=?"
+			filters = append(filters, "AND send.prototype=?")
 			iargs = append(iargs, *from)
 		}
 	}
 	if *to != "" {
 		if *byagent {
-			filters[1] = "AND t.receiverid=?"
+			filters = append(filters, "AND t.receiverid=?")
 			toid, err := strconv.Atoi(*to)
 			if err != nil {
 				log.Fatalf("invalid agent ID (-to=%v)", *to)
 			}
 			iargs = append(iargs, toid)
 		} else {
-			filters[1] = "AND recv.prototype=?"
+			filters = append(filters, "AND recv.prototype=?")
 			iargs = append(iargs, *to)
 		}
 	}
 	if *commod != "" {
-		filters[2] = "AND t.commodity=?"
+		filters = append(filters, "AND t.commodity=?")
 		iargs = append(iargs, *commod)
 	}
-	filters[3] = " " + nuclidefilter(*nucs)
+	if *t0 > 0 {
+		filters = append(filters, "AND t.time>=?")
+		iargs = append(iargs, *t0)
+	}
+	if *t1 >= 0 {
+		filters = append(filters, "AND t.time<?")
+		iargs = append(iargs, *t1)
+	}
+	filters = append(filters, nuclidefilter(*nucs), columnsfilter(groups))
 	iargs = append(iargs, simid)
 
 	tmpl := template.Must(template.New("sql").Parse(s))
 	var buf bytes.Buffer
-	tmpl.Execute(&buf, filters)
+	tmpl.Execute(&buf, struct {
+		Filters           []string
+		Groups            []nucColumn
+		ByNuc, Cumulative bool
+	}{filters, groups, *bynuc, *cumulative})
 	customSql[cmd] = buf.String()
 	var buff bytes.Buffer
-	doCustom(&buff, cmd, iargs...)
+	doCustomAs(&buff, plotFormat(*plotit), cmd, iargs...)
 	if *plotit {
-		plot(&buff, "impulses", "Time (Months)", "Quantity Transacted ( kg "+*nucs+")", "Flow")
+		style := "impulses"
+		if *cumulative {
+			style = "linespoints"
+		}
+		plot(&buff, style, "Time (Months)", "Quantity Transacted ( kg "+*nucs+")", "Flow")
 	} else {
-		fmt.Print(buff.String())
+		fmt.Fprint(out, buff.String())
+	}
+}
+
+func doFlowMatrix(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		log.Printf("Usage: %v", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		log.Printf("Rows are sending prototypes and columns receiving ones, in kg.")
+		fs.PrintDefaults()
+	}
+	t0 := fs.Int("t1", 0, "beginning of time interval (default is beginning of simulation)")
+	t1 := fs.Int("t2", -1, "end of time interval (default is end of simulation)")
+	fs.Parse(args)
+	noQuery(cmd)
+	initdb()
+
+	protos, m, err := query.FlowMatrix(compat, simid, *t0, *t1)
+	fatalif(err)
+	cols := append([]string{"From"}, protos...)
+	var recs [][]interface{}
+	for _, from := range protos {
+		rec := []interface{}{from}
+		for _, to := range protos {
+			rec = append(rec, m[query.ProtoPair{From: from, To: to}])
+		}
+		recs = append(recs, rec)
 	}
+	writeRecords(out, *format, cols, recs)
 }
 
 func doFlowGraph(cmd string, args []string) {
@@ -805,13 +2092,13 @@ func doFlowGraph(cmd string, args []string) {
 	fs.Parse(args)
 	initdb()
 
-	arcs, err := query.FlowGraph(db, simid, *t0, *t1, *proto)
+	arcs, err := query.FlowGraph(compat, simid, *t0, *t1, *proto)
 	fatalif(err)
 
-	fmt.Println("digraph ResourceFlows {")
-	fmt.Println("    overlap = false;")
-	fmt.Println("    nodesep=1.0;")
-	fmt.Println("    edge [fontsize=9];")
+	fmt.Fprintln(out, "digraph ResourceFlows {")
+	fmt.Fprintln(out, "    overlap = false;")
+	fmt.Fprintln(out, "    nodesep=1.0;")
+	fmt.Fprintln(out, "    edge [fontsize=9];")
 	for _, arc := range arcs {
 		srcname := arc.SrcProto
 		dstname := arc.DstProto
@@ -819,9 +2106,9 @@ func doFlowGraph(cmd string, args []string) {
 			srcname = fmt.Sprintf("%v %v", arc.SrcProto, arc.SrcId)
 			dstname = fmt.Sprintf("%v %v", arc.DstProto, arc.DstId)
 		}
-		fmt.Printf("    \"%v\" -> \"%v\" [label=\"%v\\n(%.3g kg)\"];\n", srcname, dstname, arc.Commod, arc.Quantity)
+		fmt.Fprintf(out, "    \"%v\" -> \"%v\" [label=\"%v\\n(%.3g kg)\"];\n", srcname, dstname, arc.Commod, arc.Quantity)
 	}
-	fmt.Println("}")
+	fmt.Fprintln(out, "}")
 }
 
 func doCreated(cmd string, args []string) {
@@ -844,9 +2131,22 @@ func doCreated(cmd string, args []string) {
 		agents = append(agents, id)
 	}
 
-	m, err := query.MatCreated(db, simid, *t0, *t1, agents...)
+	m, err := query.MatCreated(compat, simid, *t0, *t1, agents...)
 	fatalif(err)
-	fmt.Printf("%+v\n", m)
+	if *format == tableFormat {
+		fmt.Fprintf(out, "%+v\n", m)
+		return
+	}
+	var nucs []int
+	for n := range m {
+		nucs = append(nucs, int(n))
+	}
+	sort.Ints(nucs)
+	var recs [][]interface{}
+	for _, n := range nucs {
+		recs = append(recs, []interface{}{int64(n), float64(m[nuc.Nuc(n)])})
+	}
+	writeRecords(out, *format, []string{"NucId", "Mass"}, recs)
 }
 
 func doEnergy(cmd string, args []string) {
@@ -861,17 +2161,56 @@ func doEnergy(cmd string, args []string) {
 	fs.Parse(args)
 	initdb()
 
-	e, err := query.EnergyProduced(db, simid, *t0, *t1)
+	e, err := query.EnergyProduced(compat, simid, *t0, *t1)
 	fatalif(err)
-	fmt.Println(e)
+	if *format == tableFormat {
+		fmt.Fprintln(out, e)
+		return
+	}
+	writeRecords(out, *format, []string{"Energy"}, [][]interface{}{{e}})
 }
 
 func fatalif(err error) {
-	if err != nil {
+	if err != nil && missingPost(err) {
+		log.Fatalf("%v\nThe simulation hasn't been post processed yet: run 'cyan%v post' first or drop -auto-post=false.", err, dbFlags())
+	} else if err != nil {
 		log.Fatal(err)
 	}
 }
 
+// noQuery exits if the -query flag is set, for commands (or the options of
+// cmd) that compute their results in Go instead of with an SQL query.
+func noQuery(cmd string) {
+	if *showquery {
+		log.Fatalf("%v computes its results in Go: no SQL to show", cmd)
+	}
+}
+
+// missingPost returns true if err is caused by a query reading one of the
+// tables built by post processing before it was run.
+func missingPost(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, tbl := range []string{post.DefaultInvTable, "Agents", "TimeList"} {
+		if strings.Contains(msg, "no such table: "+strings.ToLower(tbl)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dbFlags returns the -db and -derived flags selecting the databases
+// queried, for suggesting commands to run on them.
+func dbFlags() string {
+	s := ""
+	if *dbname != "" {
+		s += " -db " + *dbname
+	}
+	if *derived != "" {
+		s += " -derived " + *derived
+	}
+	return s
+}
+
 type CmdSet struct {
 	funcs map[string]func(string, []string) // map[cmdname]func(cmdname, args)
 	Names []string
@@ -916,23 +2255,95 @@ func (cs *CmdSet) Execute(args []string) {
 			blankargs[i] = arg
 		}
 		initdb()
-		doCustom(os.Stdout, cmd, blankargs...)
+		doCustom(out, cmd, blankargs...)
 		return
 	}
 	f(cmd, args[1:])
 }
 
+// parseNucs returns the nuclides of a comma separated list, exiting if any
+// are invalid.
+func parseNucs(nucs string) []nuc.Nuc {
+	nnucs, err := nuc.ParseList(nucs)
+	fatalif(err)
+	return nnucs
+}
+
+// nucColumn is a column of results holding the mass of the nuclides of
+// Group, which match the condition Cond on c.nucid.
+type nucColumn struct {
+	Name, Cond string
+	Group      nuc.Group
+}
+
+// nucColumns returns a column for each of a comma separated list of
+// nuclides followed by one for each of a list of elements or nuclide groups
+// (see nuc.LookupGroup), exiting if any are invalid.
+func nucColumns(nucs, groups string) []nucColumn {
+	var cols []nucColumn
+	for _, n := range parseNucs(nucs) {
+		name := nuc.Format(n, nucStyle)
+		cols = append(cols, nucColumn{name, fmt.Sprintf("c.nucid=%v", int(n)), nuc.Group{Name: name, Nucs: []nuc.Nuc{n}}})
+	}
+	if strings.TrimSpace(groups) == "" {
+		return cols
+	}
+
+	var custom map[string]nuc.Group
+	if *nucgroups != "" {
+		var err error
+		custom, err = nuc.LoadGroups(*nucgroups)
+		fatalif(err)
+	}
+	for _, name := range strings.Split(groups, ",") {
+		g, err := nuc.LookupGroup(strings.TrimSpace(name), custom)
+		fatalif(err)
+		cols = append(cols, nucColumn{g.Name, groupcond(g), g})
+	}
+	return cols
+}
+
+// groupcond returns the condition on c.nucid selecting the group's nuclides.
+func groupcond(g nuc.Group) string {
+	var conds []string
+	if len(g.Elems) > 0 {
+		zs := make([]string, len(g.Elems))
+		for i, z := range g.Elems {
+			zs[i] = strconv.Itoa(z)
+		}
+		conds = append(conds, "c.nucid/10000000 IN ("+strings.Join(zs, ",")+")")
+	}
+	if len(g.Nucs) > 0 {
+		ids := make([]string, len(g.Nucs))
+		for i, n := range g.Nucs {
+			ids[i] = strconv.Itoa(int(n))
+		}
+		conds = append(conds, "c.nucid IN ("+strings.Join(ids, ",")+")")
+	}
+	if len(conds) == 0 {
+		return "0"
+	}
+	return "(" + strings.Join(conds, " OR ") + ")"
+}
+
+// columnsfilter returns a filter selecting the nuclides of any of cols.
+func columnsfilter(cols []nucColumn) string {
+	if len(cols) == 0 {
+		return ""
+	}
+	conds := make([]string, len(cols))
+	for i, col := range cols {
+		conds[i] = col.Cond
+	}
+	return " AND (" + strings.Join(conds, " OR ") + ") "
+}
+
 func nuclidefilter(nucs string) string {
 	if len(nucs) == 0 {
 		return ""
 	}
 
-	nnucs := []nuc.Nuc{}
-	for _, n := range strings.Split(nucs, ",") {
-		nuc, err := nuc.Id(strings.TrimSpace(n))
-		fatalif(err)
-		nnucs = append(nnucs, nuc)
-	}
+	nnucs := parseNucs(nucs)
 
 	if len(nnucs) == 1 {
 		return fmt.Sprintf(" AND c.nucid = %v", int(nnucs[0]))
@@ -945,6 +2356,66 @@ func nuclidefilter(nucs string) string {
 	return filter + ") "
 }
 
+func doAncestry(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	depth := fs.Int("depth", 0, "generations to follow parents up and children down (default is all)")
+	fs.Usage = func() {
+		log.Printf("Usage: %v <resource-id>", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		log.Printf("Edges are labeled with the time, quantity, and owner of the resource made.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("must specify a resource id")
+	}
+	resid, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("invalid resource id '%v'", fs.Arg(0))
+	}
+	noQuery(cmd)
+	initdb()
+
+	nodes, err := query.Heritage(compat, simid, resid, *depth)
+	fatalif(err)
+	protos, err := query.PrototypeMap(compat, simid)
+	if err != nil && !missingPost(err) {
+		fatalif(err)
+	}
+	owner := func(id int) string {
+		if id < 0 {
+			return "unknown owner"
+		} else if p, ok := protos[id]; ok {
+			return fmt.Sprintf("%v %v", p, id)
+		}
+		return fmt.Sprintf("agent %v", id)
+	}
+
+	in := map[int]bool{}
+	for _, n := range nodes {
+		in[n.ResId] = true
+	}
+
+	fmt.Fprintln(out, "digraph ResourceHeritage {")
+	fmt.Fprintln(out, "    nodesep=1.0;")
+	fmt.Fprintln(out, "    edge [fontsize=9];")
+	for _, n := range nodes {
+		style := ""
+		if n.ResId == resid {
+			style = ", style=filled, fillcolor=\"#FFCC66\""
+		}
+		fmt.Fprintf(out, "    \"%v\" [label=\"resource %v\\n%.4g kg\\nt=%v\\n%v\"%v];\n", n.ResId, n.ResId, n.Quantity, n.Time, owner(n.Owner), style)
+	}
+	for _, n := range nodes {
+		for _, p := range []int{n.Parent1, n.Parent2} {
+			if p != 0 && in[p] {
+				fmt.Fprintf(out, "    \"%v\" -> \"%v\" [label=\"t=%v\\n%.4g kg\\n%v\"];\n", p, n.ResId, n.Time, n.Quantity, owner(n.Owner))
+			}
+		}
+	}
+	fmt.Fprintln(out, "}")
+}
+
 func doTaint(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 	fs.Usage = func() {
@@ -973,20 +2444,20 @@ func doTaint(cmd string, args []string) {
 		log.Fatalf("couldn't find resource id %v in graph", *res)
 	}
 
-	si, err := query.SimStat(db, simid)
+	si, err := query.SimStat(compat, simid)
 	fatalif(err)
 	taints := base.Taint(si.Duration)
 
 	// print graph dot file
 	byproto := false
 	t1, t2 := 0, -1
-	arcs, err := query.FlowGraph(db, simid, t1, t2, byproto)
+	arcs, err := query.FlowGraph(compat, simid, t1, t2, byproto)
 	fatalif(err)
 
-	fmt.Println("digraph ResourceFlows {")
-	fmt.Println("    overlap = false;")
-	fmt.Println("    nodesep=1.0;")
-	fmt.Println("    edge [fontsize=9];")
+	fmt.Fprintln(out, "digraph ResourceFlows {")
+	fmt.Fprintln(out, "    overlap = false;")
+	fmt.Fprintln(out, "    nodesep=1.0;")
+	fmt.Fprintln(out, "    edge [fontsize=9];")
 	for _, arc := range arcs {
 		var srctaint taint.TaintVal
 		if ts := taints[arc.SrcId]; *t < len(ts) {
@@ -1006,27 +2477,53 @@ func doTaint(cmd string, args []string) {
 		srcname := fmt.Sprintf("%v %v\\n(%.3e kg of %.4f taint)", arc.SrcProto, arc.SrcId, srctaint.Quantity, srctaint.Taint)
 		dstname := fmt.Sprintf("%v %v\\n(%.3e kg of %.4f taint)", arc.DstProto, arc.DstId, dsttaint.Quantity, dsttaint.Taint)
 
-		fmt.Printf("    \"%v\" [style=filled, fillcolor=\"#FF%.2X%.2X\"];\n", srcname, srccolor, srccolor)
-		fmt.Printf("    \"%v\" [style=filled, fillcolor=\"#FF%.2X%.2X\"];\n", dstname, dstcolor, dstcolor)
-		fmt.Printf("    \"%v\" -> \"%v\" [label=\"%v\"];\n", srcname, dstname, arc.Commod)
+		fmt.Fprintf(out, "    \"%v\" [style=filled, fillcolor=\"#FF%.2X%.2X\"];\n", srcname, srccolor, srccolor)
+		fmt.Fprintf(out, "    \"%v\" [style=filled, fillcolor=\"#FF%.2X%.2X\"];\n", dstname, dstcolor, dstcolor)
+		fmt.Fprintf(out, "    \"%v\" -> \"%v\" [label=\"%v\"];\n", srcname, dstname, arc.Commod)
 	}
-	fmt.Println("}")
+	fmt.Fprintln(out, "}")
 }
 
-func initdb() {
+// opendb opens the database given by the -db flag.  It returns false if no
+// database is needed because only queries are being shown.
+func opendb() bool {
 	if *showquery {
 		// don't need a database for printing queries
-		return
+		return false
 	} else if *dbname == "" {
 		log.Fatal("must specify database with -db flag")
 	}
 
+	dsn := *dbname
+	if *derived != "" {
+		dsn = "file:" + dsn + "?mode=ro"
+	}
 	var err error
-	db, err = sql.Open("sqlite3", *dbname)
+	db, err = sql.Open("sqlite3", dsn)
 	fatalif(err)
+	if *derived != "" {
+		fatalif(post.Attach(db, *derived))
+	}
+	sch, err := query.ReadSchema(db)
+	fatalif(err)
+	if sch.Legacy() {
+		var cols []string
+		for _, a := range sch.Stale {
+			cols = append(cols, a.Table+"."+a.Old+" as "+a.Col)
+		}
+		log.Printf("detected %v cyclus output schema: reading %v", post.LegacySchema, strings.Join(cols, ", "))
+	}
+	compat = query.WithSchema(db, sch)
+	return true
+}
+
+func initdb() {
+	if !opendb() {
+		return
+	}
 
 	if *simidstr == "" {
-		ids, err := query.SimIds(db)
+		ids, err := query.SimIds(compat)
 		fatalif(err)
 		simid = ids[0]
 	} else {
@@ -1036,7 +2533,9 @@ func initdb() {
 		}
 	}
 
-	post.Process(db)
+	if *autopost {
+		fatalif(post.ProcessIdsWith(db, [][]byte{simid}, post.ProcessOptions{AttachDB: *derived}))
+	}
 }
 
 func plot(data *bytes.Buffer, style string, xlabel, ylabel, title string) {
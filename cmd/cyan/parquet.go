@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+
+	"github.com/rwcarlsen/cyan/parquet"
+)
+
+// parquetWriter writes results as an uncompressed Parquet file with one
+// optional column per result column.  Column types are inferred from the
+// first row group and widened if more rows follow it (see parquet.NewWriter),
+// since the sqlite driver doesn't report declared column types and a column
+// can hold integers early and reals later.  Row groups are written as they
+// fill.
+type parquetWriter struct {
+	w  io.Writer
+	pw *parquet.Writer
+}
+
+func (p *parquetWriter) Header(cols []string) error {
+	p.pw = parquet.NewWriter(p.w, cols, nil)
+	return nil
+}
+
+func (p *parquetWriter) Row(vals []interface{}) error { return p.pw.Write(vals) }
+
+func (p *parquetWriter) Flush() error { return p.pw.Close() }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"math"
+
+	"github.com/rwcarlsen/cyan/query"
+)
+
+// sankeySpec is a plotly figure with a single sankey trace.
+type sankeySpec struct {
+	Data   []sankeyTrace          `json:"data"`
+	Layout map[string]interface{} `json:"layout"`
+}
+
+type sankeyTrace struct {
+	Type string `json:"type"`
+	Node struct {
+		Label []string `json:"label"`
+	} `json:"node"`
+	Link struct {
+		Source []int     `json:"source"`
+		Target []int     `json:"target"`
+		Value  []float64 `json:"value"`
+		Label  []string  `json:"label"`
+	} `json:"link"`
+}
+
+// newSankey returns the plotly figure of arcs, with a node for each agent
+// if byagent is true and for each prototype otherwise.
+func newSankey(arcs []query.FlowArc, byagent bool, title string) *sankeySpec {
+	tr := sankeyTrace{Type: "sankey"}
+	nodes := map[string]int{}
+	node := func(proto string, id int) int {
+		name := proto
+		if byagent {
+			name = fmt.Sprintf("%v %v", proto, id)
+		}
+		i, ok := nodes[name]
+		if !ok {
+			i = len(tr.Node.Label)
+			nodes[name] = i
+			tr.Node.Label = append(tr.Node.Label, name)
+		}
+		return i
+	}
+	for _, arc := range arcs {
+		tr.Link.Source = append(tr.Link.Source, node(arc.SrcProto, arc.SrcId))
+		tr.Link.Target = append(tr.Link.Target, node(arc.DstProto, arc.DstId))
+		tr.Link.Value = append(tr.Link.Value, arc.Quantity)
+		tr.Link.Label = append(tr.Link.Label, arc.Commod)
+	}
+	return &sankeySpec{
+		Data:   []sankeyTrace{tr},
+		Layout: map[string]interface{}{"title": title},
+	}
+}
+
+// sankeyNode is the position of a node in a sankey diagram.
+type sankeyNode struct {
+	Col     int
+	Value   float64
+	X, Y, H float64
+	in, out float64 // offsets of the next link entering and leaving
+}
+
+// Dimensions of sankey diagram SVG images.
+const (
+	sankeyWidth  = 900
+	sankeyHeight = 500
+	sankeyTop    = 40
+	sankeyMargin = 20
+	sankeyNodeW  = 16
+	sankeyPad    = 12
+)
+
+// sankeyLayout positions the nodes of tr in columns: nodes that receive
+// nothing are in the first column and every other node is one column right
+// of the nearest node feeding it, so links that close a cycle flow
+// backwards.  Node heights are proportional to the larger of their total
+// inflow and outflow, scaled so the fullest column fits the image.
+func sankeyLayout(tr sankeyTrace) []sankeyNode {
+	nodes := make([]sankeyNode, len(tr.Node.Label))
+	ins, outs := make([]float64, len(nodes)), make([]float64, len(nodes))
+	fed := make([]bool, len(nodes))
+	for i := range tr.Link.Value {
+		src, dst := tr.Link.Source[i], tr.Link.Target[i]
+		outs[src] += tr.Link.Value[i]
+		ins[dst] += tr.Link.Value[i]
+		fed[dst] = fed[dst] || src != dst
+	}
+	for i := range nodes {
+		nodes[i].Value = math.Max(ins[i], outs[i])
+		nodes[i].Col = -1
+	}
+
+	// breadth first from the sources - or the first unplaced node if the
+	// remaining nodes only feed each other
+	var queue []int
+	for i := range nodes {
+		if !fed[i] {
+			nodes[i].Col = 0
+			queue = append(queue, i)
+		}
+	}
+	for placed := 0; placed < len(nodes); {
+		if len(queue) == 0 {
+			for i := range nodes {
+				if nodes[i].Col < 0 {
+					nodes[i].Col = 0
+					queue = append(queue, i)
+					break
+				}
+			}
+		}
+		n := queue[0]
+		queue = queue[1:]
+		placed++
+		for i := range tr.Link.Value {
+			if dst := tr.Link.Target[i]; tr.Link.Source[i] == n && nodes[dst].Col < 0 {
+				nodes[dst].Col = nodes[n].Col + 1
+				queue = append(queue, dst)
+			}
+		}
+	}
+
+	ncols := 1
+	for _, n := range nodes {
+		if n.Col+1 > ncols {
+			ncols = n.Col + 1
+		}
+	}
+	sums, counts := make([]float64, ncols), make([]int, ncols)
+	for _, n := range nodes {
+		sums[n.Col] += n.Value
+		counts[n.Col]++
+	}
+	height := float64(sankeyHeight - sankeyTop - sankeyMargin)
+	scale := math.Inf(1)
+	for c := range sums {
+		if sums[c] > 0 {
+			scale = math.Min(scale, (height-sankeyPad*float64(counts[c]-1))/sums[c])
+		}
+	}
+	if math.IsInf(scale, 1) {
+		scale = 0
+	}
+
+	colw := 0.0
+	if ncols > 1 {
+		colw = float64(sankeyWidth-2*sankeyMargin-sankeyNodeW) / float64(ncols-1)
+	}
+	ys := make([]float64, ncols)
+	for i := range nodes {
+		n := &nodes[i]
+		n.X = sankeyMargin + float64(n.Col)*colw
+		n.Y = sankeyTop + ys[n.Col]
+		n.H = n.Value * scale
+		ys[n.Col] += n.H + sankeyPad
+	}
+	return nodes
+}
+
+// sankeySVG draws the sankey diagram of spec as an SVG image with a band
+// for each link as wide as the mass it carries.
+func sankeySVG(w io.Writer, spec *sankeySpec, title string) error {
+	var buf bytes.Buffer
+	tr := spec.Data[0]
+	nodes := sankeyLayout(tr)
+	scale := 0.0
+	for _, n := range nodes {
+		if n.Value > 0 {
+			scale = n.H / n.Value
+			break
+		}
+	}
+
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%v" height="%v" font-family="sans-serif" font-size="11">`+"\n", sankeyWidth, sankeyHeight)
+	fmt.Fprintf(&buf, `<text x="%v" y="20" font-size="14" text-anchor="middle">%v</text>`+"\n", sankeyWidth/2, html.EscapeString(title))
+	for i, v := range tr.Link.Value {
+		src, dst := &nodes[tr.Link.Source[i]], &nodes[tr.Link.Target[i]]
+		thick := v * scale
+		x0, y0 := src.X+sankeyNodeW, src.Y+src.out+thick/2
+		x1, y1 := dst.X, dst.Y+dst.in+thick/2
+		src.out += thick
+		dst.in += thick
+		dx := (x1 - x0) / 2
+		if dx < 40 {
+			dx = 40 // backward links loop around
+		}
+		fmt.Fprintf(&buf, `<path d="M%.1f,%.1f C%.1f,%.1f %.1f,%.1f %.1f,%.1f" fill="none" stroke="%v" stroke-opacity="0.4" stroke-width="%.1f"><title>%v to %v (%v): %.4g kg</title></path>`+"\n",
+			x0, y0, x0+dx, y0, x1-dx, y1, x1, y1, figureColors[tr.Link.Source[i]%len(figureColors)], math.Max(thick, 1),
+			html.EscapeString(tr.Node.Label[tr.Link.Source[i]]), html.EscapeString(tr.Node.Label[tr.Link.Target[i]]), html.EscapeString(tr.Link.Label[i]), v)
+	}
+	for i, n := range nodes {
+		label := html.EscapeString(tr.Node.Label[i])
+		fmt.Fprintf(&buf, `<rect x="%.1f" y="%.1f" width="%v" height="%.1f" fill="%v"><title>%v: %.4g kg</title></rect>`+"\n",
+			n.X, n.Y, sankeyNodeW, math.Max(n.H, 1), figureColors[i%len(figureColors)], label, n.Value)
+		x, anchor := n.X+sankeyNodeW+4, "start"
+		if n.X+sankeyNodeW+sankeyMargin >= sankeyWidth {
+			x, anchor = n.X-4, "end"
+		}
+		fmt.Fprintf(&buf, `<text x="%.1f" y="%.1f" dominant-baseline="middle" text-anchor="%v">%v</text>`+"\n", x, n.Y+n.H/2, anchor, label)
+	}
+	fmt.Fprintln(&buf, "</svg>")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeSankey writes the sankey diagram of arcs to path with writeFigure:
+// an SVG image, the plotly JSON figure, or an HTML page showing the image.
+func writeSankey(path string, arcs []query.FlowArc, byagent bool, title string) error {
+	spec := newSankey(arcs, byagent, title)
+	return writeFigure(path, title, spec, func(w io.Writer) error { return sankeySVG(w, spec, title) })
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/query"
+)
+
+func TestSankeyLayout(t *testing.T) {
+	// fuel is recycled: reprocess feeds fab, closing a cycle
+	arcs := []query.FlowArc{
+		{SrcProto: "mine", DstProto: "fab", Commod: "u", Quantity: 100},
+		{SrcProto: "fab", DstProto: "reactor", Commod: "fuel", Quantity: 120},
+		{SrcProto: "reactor", DstProto: "reprocess", Commod: "spent", Quantity: 120},
+		{SrcProto: "reprocess", DstProto: "fab", Commod: "pu", Quantity: 20},
+	}
+	spec := newSankey(arcs, false, "flows")
+	tr := spec.Data[0]
+	if want := []string{"mine", "fab", "reactor", "reprocess"}; strings.Join(tr.Node.Label, ",") != strings.Join(want, ",") {
+		t.Fatalf("want nodes %v, got %v", want, tr.Node.Label)
+	}
+
+	nodes := sankeyLayout(tr)
+	for i, want := range []struct {
+		Col   int
+		Value float64
+	}{{0, 100}, {1, 120}, {2, 120}, {3, 120}} {
+		if n := nodes[i]; n.Col != want.Col || n.Value != want.Value {
+			t.Errorf("%v: want column %v and value %v, got %v and %v", tr.Node.Label[i], want.Col, want.Value, n.Col, n.Value)
+		}
+	}
+	// heights are proportional to value and fit the image
+	if r := nodes[1].H / nodes[0].H; math.Abs(r-1.2) > 1e-9 {
+		t.Errorf("want fab 1.2 times as tall as mine, got %v", r)
+	}
+	for _, n := range nodes {
+		if n.Y < sankeyTop || n.Y+n.H > sankeyHeight-sankeyMargin+1e-9 || n.X+sankeyNodeW > sankeyWidth {
+			t.Errorf("node %+v lies outside the image", n)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := sankeySVG(&buf, spec, "a <b> flow"); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	if n := strings.Count(svg, "<path "); n != len(arcs) {
+		t.Errorf("want %v links, got %v", len(arcs), n)
+	} else if n := strings.Count(svg, "<rect "); n != len(nodes) {
+		t.Errorf("want %v nodes, got %v", len(nodes), n)
+	} else if !strings.Contains(svg, "a &lt;b&gt; flow") {
+		t.Errorf("title isn't escaped:\n%v", svg)
+	}
+}
+
+func TestSankeyLayoutCycle(t *testing.T) {
+	// every node is fed, so the first is placed in the first column
+	arcs := []query.FlowArc{
+		{SrcProto: "a", DstProto: "b", Quantity: 1},
+		{SrcProto: "b", DstProto: "a", Quantity: 1},
+	}
+	nodes := sankeyLayout(newSankey(arcs, false, "").Data[0])
+	if nodes[0].Col != 0 || nodes[1].Col != 1 {
+		t.Errorf("want columns 0 and 1, got %v and %v", nodes[0].Col, nodes[1].Col)
+	}
+}
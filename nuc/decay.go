@@ -0,0 +1,191 @@
+package nuc
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Branch is the decay of a nuclide to Daughter in a fraction Frac of its
+// decays.
+type Branch struct {
+	Daughter Nuc
+	Frac     float64
+}
+
+// DecayData provides the decay properties of nuclides needed by Decay.
+type DecayData interface {
+	// HalfLife returns the half-life of n in seconds - math.Inf(1) for
+	// stable nuclides.
+	HalfLife(n Nuc) float64
+	// Daughters returns the decay branches of n.  Branch fractions may sum
+	// to less than one for decays to nuclides that aren't tracked.
+	Daughters(n Nuc) []Branch
+}
+
+// Activity returns the number of decays per second (Bq) of mass m of n -
+// zero for stable nuclides and elements.
+func Activity(n Nuc, m Mass, data DecayData) float64 {
+	hl := data.HalfLife(n)
+	if n.A() == 0 || math.IsInf(hl, 1) {
+		return 0
+	}
+	return math.Ln2 / hl * Atoms(n, m)
+}
+
+// Decay returns material m decayed for secs seconds.  The decay chains of
+// every nuclide in m are solved analytically with the Bateman equations,
+// writing the number of atoms of each nuclide as a sum of exponentials in
+// the decay constants of its ancestors (times powers of t for ancestors
+// with the same decay constant).  Decays conserve atoms rather than mass, so
+// the decayed material is lighter by the alpha particles and other decay
+// products not tracked by data.  Elements (nuclides with no mass
+// number) are left as they are.  An error is returned if a decay chain loops
+// back on itself or a half-life isn't positive.
+func Decay(m Material, secs float64, data DecayData) (Material, error) {
+	order, err := decayOrder(m, data)
+	if err != nil {
+		return nil, err
+	}
+
+	decayed := Material{}
+	for n, qty := range m {
+		if n.A() == 0 || secs == 0 {
+			decayed[n] += qty
+		}
+	}
+	if secs == 0 {
+		return decayed, nil
+	}
+
+	index := make(map[Nuc]int, len(order))
+	lambda := make([]float64, len(order))
+	for i, n := range order {
+		index[n] = i
+		if hl := data.HalfLife(n); !math.IsInf(hl, 1) {
+			lambda[i] = math.Ln2 / hl
+		}
+	}
+
+	// coeffs[i][term{k, j}] is the coefficient of t^j*exp(-lambda[k]*t) in
+	// the number of atoms of nuclide i - powers of t appear when a nuclide
+	// and one of its ancestors have equal decay constants.  Parents come
+	// before their daughters in order, so the coefficients of every parent
+	// are known before its daughters'.
+	coeffs := make([]map[term]float64, len(order))
+	for i := range coeffs {
+		coeffs[i] = map[term]float64{}
+	}
+	for p, n := range order {
+		cp := coeffs[p]
+		own := term{p, 0}
+		cp[own] = Atoms(n, m[n])
+		for k, c := range cp {
+			if k != own && k.pow == 0 {
+				cp[own] -= c
+			}
+		}
+		if lambda[p] == 0 {
+			continue
+		}
+		for _, b := range data.Daughters(n) {
+			i, ok := index[b.Daughter]
+			if !ok {
+				continue
+			}
+			rate := b.Frac * lambda[p]
+			for k, c := range cp {
+				d := lambda[i] - lambda[k.k]
+				if math.Abs(d) <= degenerate*lambda[k.k] {
+					// the daughter is fed at its own decay rate: integrate
+					// t^j to t^(j+1)/(j+1)
+					coeffs[i][term{k.k, k.pow + 1}] += rate * c / float64(k.pow+1)
+					continue
+				}
+				// t^j*exp(-lambda[k]*t) feeds a polynomial of degree j in t
+				// times the exponential
+				a := rate * c / d
+				for pow := k.pow; pow >= 0; pow-- {
+					coeffs[i][term{k.k, pow}] += a
+					a *= -float64(pow) / d
+				}
+			}
+		}
+	}
+
+	for i, n := range order {
+		atoms := 0.0
+		for k, c := range coeffs[i] {
+			atoms += c * math.Pow(secs, float64(k.pow)) * math.Exp(-lambda[k.k]*secs)
+		}
+		if atoms > 0 {
+			decayed[n] += Mass(atoms * float64(n.A()) * g / Mol)
+		}
+	}
+	return decayed, nil
+}
+
+// term identifies the function t^pow*exp(-lambda[k]*t) in the Bateman
+// solutions of Decay.
+type term struct {
+	k, pow int
+}
+
+// degenerate is the relative difference below which Decay treats the decay
+// constants of a nuclide and one of its ancestors as equal.
+const degenerate = 1e-8
+
+// decayOrder returns the nuclides of m and all of their descendants with
+// every parent before its daughters.
+func decayOrder(m Material, data DecayData) ([]Nuc, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[Nuc]int{}
+	var order []Nuc
+	var visit func(n Nuc) error
+	visit = func(n Nuc) error {
+		switch state[n] {
+		case visiting:
+			return fmt.Errorf("decay chain of %v loops back on itself", n.Name())
+		case done:
+			return nil
+		}
+		state[n] = visiting
+		hl := data.HalfLife(n)
+		if !(hl > 0) {
+			return fmt.Errorf("%v has invalid half-life %v", n.Name(), hl)
+		} else if !math.IsInf(hl, 1) {
+			for _, b := range data.Daughters(n) {
+				if b.Daughter.A() == 0 {
+					continue
+				} else if err := visit(b.Daughter); err != nil {
+					return err
+				}
+			}
+		}
+		state[n] = done
+		order = append(order, n)
+		return nil
+	}
+
+	var nucs []int
+	for n := range m {
+		if n.A() > 0 {
+			nucs = append(nucs, int(n))
+		}
+	}
+	sort.Ints(nucs)
+	for _, n := range nucs {
+		if err := visit(Nuc(n)); err != nil {
+			return nil, err
+		}
+	}
+
+	// visit appends daughters first
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order, nil
+}
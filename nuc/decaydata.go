@@ -0,0 +1,122 @@
+package nuc
+
+import "math"
+
+const (
+	Second = 1
+	Minute = 60 * Second
+	Hour   = 60 * Minute
+	Day    = 24 * Hour
+	Year   = 365.25 * Day
+)
+
+// DecayProps holds the decay properties of a nuclide.
+type DecayProps struct {
+	// HalfLife is in seconds.
+	HalfLife float64
+	// Energy is the recoverable energy in Joules released as heat by each
+	// decay: alpha and beta particles, recoils, and gammas, but not
+	// neutrinos.
+	Energy float64
+	// Daughters are the decay branches of the nuclide.
+	Daughters []Branch
+}
+
+// DecayTable is a DecayData backed by a map.  Nuclides missing from the
+// table are treated as stable.
+type DecayTable map[Nuc]DecayProps
+
+func (d DecayTable) HalfLife(n Nuc) float64 {
+	if p, ok := d[n]; ok {
+		return p.HalfLife
+	}
+	return math.Inf(1)
+}
+
+func (d DecayTable) Daughters(n Nuc) []Branch { return d[n].Daughters }
+
+// Activity returns the number of decays per second (Bq) of mass m of n.
+func (d DecayTable) Activity(n Nuc, m Mass) float64 { return Activity(n, m, d) }
+
+// DecayHeatCoeff returns the thermal power in Watts released by the decay
+// of one kg of n.
+func (d DecayTable) DecayHeatCoeff(n Nuc) float64 {
+	return d.Activity(n, 1*Kg) * d[n].Energy
+}
+
+func branch(daughter Nuc) []Branch { return []Branch{{daughter, 1}} }
+
+// StdDecay is a built in table of the half-lives, decay energies, and decay
+// branches of the actinides and long lived or high heat fission and
+// activation products dominating the decay heat, activity, and radiotoxicity
+// of nuclear fuel and waste.  Values are rounded from ENSDF evaluations and
+// meant for scoping analyses; short lived members of the uranium and thorium
+// series below radium are left out.
+var StdDecay = DecayTable{
+	// actinides
+	902280000: {1.912 * Year, 5.52 * MeV, branch(882240000)},
+	902290000: {7932 * Year, 5.17 * MeV, branch(882250000)},
+	902300000: {7.538e4 * Year, 4.77 * MeV, branch(882260000)},
+	902310000: {25.52 * Hour, 0.17 * MeV, branch(912310000)},
+	902320000: {1.405e10 * Year, 4.08 * MeV, branch(882280000)},
+	902340000: {24.10 * Day, 0.07 * MeV, branch(912340001)},
+	912310000: {3.276e4 * Year, 5.15 * MeV, branch(892270000)},
+	912330000: {26.98 * Day, 0.27 * MeV, branch(922330000)},
+	912340001: {1.159 * Minute, 0.83 * MeV, branch(922340000)},
+	922320000: {68.9 * Year, 5.41 * MeV, branch(902280000)},
+	922330000: {1.592e5 * Year, 4.91 * MeV, branch(902290000)},
+	922340000: {2.455e5 * Year, 4.86 * MeV, branch(902300000)},
+	922350000: {7.04e8 * Year, 4.68 * MeV, branch(902310000)},
+	922360000: {2.342e7 * Year, 4.57 * MeV, branch(902320000)},
+	922370000: {6.75 * Day, 0.32 * MeV, branch(932370000)},
+	922380000: {4.468e9 * Year, 4.27 * MeV, branch(902340000)},
+	922390000: {23.45 * Minute, 0.48 * MeV, branch(932390000)},
+	932370000: {2.144e6 * Year, 4.96 * MeV, branch(912330000)},
+	932390000: {2.356 * Day, 0.40 * MeV, branch(942390000)},
+	942360000: {2.858 * Year, 5.87 * MeV, branch(922320000)},
+	942380000: {87.7 * Year, 5.59 * MeV, branch(922340000)},
+	942390000: {2.411e4 * Year, 5.24 * MeV, branch(922350000)},
+	942400000: {6561 * Year, 5.26 * MeV, branch(922360000)},
+	942410000: {14.29 * Year, 0.0052 * MeV, []Branch{{952410000, 0.999975}, {922370000, 2.5e-5}}},
+	942420000: {3.75e5 * Year, 4.98 * MeV, branch(922380000)},
+	942440000: {8.0e7 * Year, 4.67 * MeV, nil},
+	952410000: {432.6 * Year, 5.64 * MeV, branch(932370000)},
+	952420000: {16.02 * Hour, 0.19 * MeV, []Branch{{962420000, 0.827}, {942420000, 0.173}}},
+	952420001: {141 * Year, 0.05 * MeV, []Branch{{952420000, 0.9955}, {932380000, 0.0045}}},
+	952430000: {7370 * Year, 5.44 * MeV, branch(932390000)},
+	962420000: {162.8 * Day, 6.22 * MeV, branch(942380000)},
+	962430000: {29.1 * Year, 6.17 * MeV, []Branch{{942390000, 0.9971}, {952430000, 0.0029}}},
+	962440000: {18.1 * Year, 5.90 * MeV, branch(942400000)},
+	962450000: {8423 * Year, 5.62 * MeV, branch(942410000)},
+	962460000: {4706 * Year, 5.48 * MeV, branch(942420000)},
+	882260000: {1600 * Year, 4.87 * MeV, nil},
+
+	// fission and activation products
+	10030000:  {12.32 * Year, 0.0057 * MeV, branch(20030000)},
+	60140000:  {5730 * Year, 0.049 * MeV, branch(70140000)},
+	270600000: {5.271 * Year, 2.60 * MeV, branch(280600000)},
+	280630000: {101.2 * Year, 0.017 * MeV, branch(290630000)},
+	340790000: {3.27e5 * Year, 0.053 * MeV, branch(350790000)},
+	360850000: {10.76 * Year, 0.253 * MeV, branch(370850000)},
+	380900000: {28.79 * Year, 0.196 * MeV, branch(390900000)},
+	390900000: {64.0 * Hour, 0.934 * MeV, branch(400900000)},
+	400930000: {1.61e6 * Year, 0.019 * MeV, branch(410930000)},
+	410940000: {2.03e4 * Year, 1.74 * MeV, branch(420940000)},
+	430990000: {2.111e5 * Year, 0.085 * MeV, branch(440990000)},
+	441060000: {373.6 * Day, 0.010 * MeV, branch(451060000)},
+	451060000: {30.1 * Second, 1.62 * MeV, branch(461060000)},
+	461070000: {6.5e6 * Year, 0.0093 * MeV, branch(471070000)},
+	501260000: {2.3e5 * Year, 0.17 * MeV, nil},
+	511250000: {2.758 * Year, 0.53 * MeV, branch(521250000)},
+	531290000: {1.57e7 * Year, 0.064 * MeV, branch(541290000)},
+	551340000: {2.065 * Year, 1.71 * MeV, branch(561340000)},
+	551350000: {2.3e6 * Year, 0.067 * MeV, branch(561350000)},
+	551370000: {30.08 * Year, 0.188 * MeV, []Branch{{561370001, 0.944}, {561370000, 0.056}}},
+	561370001: {2.552 * Minute, 0.661 * MeV, branch(561370000)},
+	581440000: {284.9 * Day, 0.11 * MeV, branch(591440000)},
+	591440000: {17.28 * Minute, 1.24 * MeV, branch(601440000)},
+	611470000: {2.62 * Year, 0.062 * MeV, branch(621470000)},
+	621510000: {90 * Year, 0.0197 * MeV, branch(631510000)},
+	631540000: {8.6 * Year, 1.52 * MeV, branch(641540000)},
+	631550000: {4.76 * Year, 0.105 * MeV, branch(641550000)},
+}
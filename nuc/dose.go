@@ -0,0 +1,118 @@
+package nuc
+
+import "fmt"
+
+// Intake is a route by which radionuclides are taken into the body.
+type Intake int
+
+const (
+	Ingestion Intake = iota
+	Inhalation
+)
+
+// ParseIntake returns the intake route named "ingestion" or "inhalation".
+func ParseIntake(s string) (Intake, error) {
+	switch s {
+	case "ingestion":
+		return Ingestion, nil
+	case "inhalation":
+		return Inhalation, nil
+	}
+	return 0, fmt.Errorf("unknown intake route '%v'", s)
+}
+
+func (i Intake) String() string {
+	if i == Inhalation {
+		return "inhalation"
+	}
+	return "ingestion"
+}
+
+// DoseCoeffs are the committed effective doses in Sv per Bq of a nuclide
+// taken in by each intake route.
+type DoseCoeffs struct {
+	Ingestion, Inhalation float64
+}
+
+// DoseTable holds the dose coefficients of nuclides.  Nuclides missing from
+// the table contribute no dose.
+type DoseTable map[Nuc]DoseCoeffs
+
+// Coeff returns the dose in Sv per Bq of n taken in by route.
+func (d DoseTable) Coeff(n Nuc, route Intake) float64 {
+	if route == Inhalation {
+		return d[n].Inhalation
+	}
+	return d[n].Ingestion
+}
+
+// Radiotoxicity returns the committed effective dose in Sv from taking in
+// all of material m by route, with the activity of each nuclide given by
+// data.
+func Radiotoxicity(m Material, data DecayData, doses DoseTable, route Intake) (sv float64) {
+	for n, qty := range m {
+		sv += Activity(n, qty, data) * doses.Coeff(n, route)
+	}
+	return sv
+}
+
+// ICRP72 holds the dose coefficients of ICRP Publication 72 for adult
+// members of the public, rounded to two figures, for the nuclides of
+// StdDecay.  Inhalation coefficients are for 1 micron particles of the lung
+// absorption type recommended when the chemical form is unknown; noble gases
+// are left out.
+var ICRP72 = DoseTable{
+	10030000:  {1.8e-11, 1.8e-11},
+	60140000:  {5.8e-10, 2.0e-9},
+	270600000: {3.4e-9, 1.0e-8},
+	280630000: {1.5e-10, 5.2e-10},
+	340790000: {2.9e-9, 6.8e-9},
+	380900000: {2.8e-8, 3.6e-8},
+	390900000: {2.7e-9, 1.5e-9},
+	400930000: {1.1e-9, 2.5e-8},
+	410940000: {1.7e-9, 1.1e-8},
+	430990000: {6.4e-10, 1.3e-8},
+	441060000: {7.0e-9, 2.8e-8},
+	461070000: {3.7e-11, 8.5e-11},
+	501260000: {4.7e-9, 2.8e-8},
+	511250000: {1.1e-9, 4.8e-9},
+	531290000: {1.1e-7, 3.6e-8},
+	551340000: {1.9e-8, 6.6e-9},
+	551350000: {2.0e-9, 6.9e-10},
+	551370000: {1.3e-8, 4.6e-9},
+	581440000: {5.2e-9, 3.6e-8},
+	611470000: {2.6e-10, 3.5e-9},
+	621510000: {9.8e-11, 4.0e-9},
+	631540000: {2.0e-9, 5.3e-8},
+	631550000: {3.2e-10, 6.9e-9},
+	882260000: {2.8e-7, 9.5e-6},
+	902280000: {7.2e-8, 4.0e-5},
+	902290000: {4.9e-7, 7.1e-5},
+	902300000: {2.1e-7, 1.4e-5},
+	902320000: {2.3e-7, 2.5e-5},
+	912310000: {7.1e-7, 1.4e-4},
+	912330000: {8.7e-10, 3.7e-9},
+	922320000: {3.3e-7, 7.8e-6},
+	922330000: {5.1e-8, 3.6e-6},
+	922340000: {4.9e-8, 3.5e-6},
+	922350000: {4.7e-8, 3.1e-6},
+	922360000: {4.7e-8, 3.2e-6},
+	922380000: {4.5e-8, 2.9e-6},
+	932370000: {1.1e-7, 2.3e-5},
+	932390000: {8.0e-10, 1.0e-9},
+	942360000: {8.7e-8, 1.8e-5},
+	942380000: {2.3e-7, 4.6e-5},
+	942390000: {2.5e-7, 5.0e-5},
+	942400000: {2.5e-7, 5.0e-5},
+	942410000: {4.8e-9, 9.0e-7},
+	942420000: {2.4e-7, 4.8e-5},
+	942440000: {2.4e-7, 4.7e-5},
+	952410000: {2.0e-7, 4.2e-5},
+	952420001: {1.9e-7, 3.7e-5},
+	952430000: {2.0e-7, 4.1e-5},
+	962420000: {1.2e-8, 5.2e-6},
+	962430000: {1.5e-7, 3.1e-5},
+	962440000: {1.2e-7, 2.7e-5},
+	962450000: {2.1e-7, 4.2e-5},
+	962460000: {2.1e-7, 4.2e-5},
+}
@@ -0,0 +1,25 @@
+package nuc
+
+import "math"
+
+// NatUAssay is the U235 mass fraction of natural uranium.
+const NatUAssay = 0.00711
+
+// SepPotential returns the value function V(x) = (2x-1) ln(x/(1-x)) of
+// separative work for assay x.
+func SepPotential(x float64) float64 {
+	return (2*x - 1) * math.Log(x/(1-x))
+}
+
+// FeedMass returns the mass of feed of assay xf needed to produce a mass p
+// of product of assay xp leaving tails of assay xt.
+func FeedMass(p, xp, xf, xt float64) float64 {
+	return p * (xp - xt) / (xf - xt)
+}
+
+// SWU returns the separative work in kg-SWU needed to produce a mass p (kg)
+// of product of assay xp from feed of assay xf leaving tails of assay xt.
+func SWU(p, xp, xf, xt float64) float64 {
+	f := FeedMass(p, xp, xf, xt)
+	return p*SepPotential(xp) + (f-p)*SepPotential(xt) - f*SepPotential(xf)
+}
@@ -0,0 +1,178 @@
+package nuc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Group is a named set of nuclides: every isotope of its elements (by
+// atomic number) and its individual nuclides.
+type Group struct {
+	Name  string
+	Elems []int
+	Nucs  []Nuc
+}
+
+// Has returns true if n is in the group.
+func (g Group) Has(n Nuc) bool {
+	for _, z := range g.Elems {
+		if n.Z() == z {
+			return true
+		}
+	}
+	for _, gn := range g.Nucs {
+		if n == gn {
+			return true
+		}
+	}
+	return false
+}
+
+// Mass returns the total mass of the group's nuclides in m.
+func (g Group) Mass(m Material) (tot Mass) {
+	for n, qty := range m {
+		if g.Has(n) {
+			tot += qty
+		}
+	}
+	return tot
+}
+
+// zrange returns the atomic numbers from lo to hi inclusive.
+func zrange(lo, hi int) []int {
+	var zs []int
+	for z := lo; z <= hi; z++ {
+		zs = append(zs, z)
+	}
+	return zs
+}
+
+// Groups holds the built in nuclide groups.  Fission products are taken to
+// be the elements from zinc to lutetium, which covers the yields of
+// actinide fission.
+var Groups = map[string]Group{
+	"actinides":       {Name: "actinides", Elems: zrange(89, 103)},
+	"transuranics":    {Name: "transuranics", Elems: zrange(93, 103)},
+	"minoractinides":  {Name: "minoractinides", Elems: []int{93, 95, 96}},
+	"fissionproducts": {Name: "fissionproducts", Elems: zrange(30, 71)},
+	"lanthanides":     {Name: "lanthanides", Elems: zrange(57, 71)},
+	"fissile":         {Name: "fissile", Nucs: FissNuc},
+}
+
+var elemSymbols = []string{"",
+	"H", "He", "Li", "Be", "B", "C", "N", "O", "F", "Ne",
+	"Na", "Mg", "Al", "Si", "P", "S", "Cl", "Ar", "K", "Ca",
+	"Sc", "Ti", "V", "Cr", "Mn", "Fe", "Co", "Ni", "Cu", "Zn",
+	"Ga", "Ge", "As", "Se", "Br", "Kr", "Rb", "Sr", "Y", "Zr",
+	"Nb", "Mo", "Tc", "Ru", "Rh", "Pd", "Ag", "Cd", "In", "Sn",
+	"Sb", "Te", "I", "Xe", "Cs", "Ba", "La", "Ce", "Pr", "Nd",
+	"Pm", "Sm", "Eu", "Gd", "Tb", "Dy", "Ho", "Er", "Tm", "Yb",
+	"Lu", "Hf", "Ta", "W", "Re", "Os", "Ir", "Pt", "Au", "Hg",
+	"Tl", "Pb", "Bi", "Po", "At", "Rn", "Fr", "Ra", "Ac", "Th",
+	"Pa", "U", "Np", "Pu", "Am", "Cm", "Bk", "Cf", "Es", "Fm",
+	"Md", "No", "Lr", "Rf", "Db", "Sg", "Bh", "Hs", "Mt", "Ds",
+	"Rg", "Cn", "Nh", "Fl", "Mc", "Lv", "Ts", "Og",
+}
+
+// Element returns the atomic number of the element with the given symbol,
+// ignoring case.
+func Element(sym string) (z int, ok bool) {
+	for z, s := range elemSymbols {
+		if z > 0 && strings.EqualFold(s, sym) {
+			return z, true
+		}
+	}
+	return 0, false
+}
+
+// Symbol returns the symbol of the element with atomic number z.
+func Symbol(z int) string {
+	if z < 1 || z >= len(elemSymbols) {
+		return fmt.Sprintf("Z%v", z)
+	}
+	return elemSymbols[z]
+}
+
+// LookupGroup returns the group with the given name: a group of custom (see
+// LoadGroups), a built in group, or an element symbol selecting all of its
+// isotopes.  Names are case insensitive.
+func LookupGroup(name string, custom map[string]Group) (Group, error) {
+	key := strings.ToLower(name)
+	if g, ok := custom[key]; ok {
+		return g, nil
+	} else if g, ok := Groups[key]; ok {
+		return g, nil
+	} else if z, ok := Element(name); ok {
+		return Group{Name: Symbol(z), Elems: []int{z}}, nil
+	}
+	return Group{}, fmt.Errorf("'%v' is not a nuclide group or element", name)
+}
+
+// LoadGroups reads custom nuclide groups from a JSON file mapping each
+// group's name to its members: element symbols, nuclides, and the names of
+// other groups, e.g.
+//
+//	{"recycled": ["Pu", "Am241", "minoractinides"]}
+//
+// Group names are case insensitive.
+func LoadGroups(path string) (map[string]Group, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defs map[string][]string
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	lower := map[string][]string{}
+	for name, members := range defs {
+		lower[strings.ToLower(name)] = members
+	}
+	groups := map[string]Group{}
+	var resolve func(name string, seen map[string]bool) (Group, error)
+	resolve = func(name string, seen map[string]bool) (Group, error) {
+		if g, ok := groups[name]; ok {
+			return g, nil
+		} else if seen[name] {
+			return Group{}, fmt.Errorf("%v: group '%v' contains itself", path, name)
+		}
+		seen[name] = true
+		g := Group{Name: name}
+		for _, m := range lower[name] {
+			m = strings.TrimSpace(m)
+			if _, ok := lower[strings.ToLower(m)]; ok {
+				sub, err := resolve(strings.ToLower(m), seen)
+				if err != nil {
+					return Group{}, err
+				}
+				g.Elems = append(g.Elems, sub.Elems...)
+				g.Nucs = append(g.Nucs, sub.Nucs...)
+			} else if sub, err := LookupGroup(m, nil); err == nil {
+				g.Elems = append(g.Elems, sub.Elems...)
+				g.Nucs = append(g.Nucs, sub.Nucs...)
+			} else if n, err := Parse(m); err == nil {
+				g.Nucs = append(g.Nucs, n)
+			} else {
+				return Group{}, fmt.Errorf("%v: group '%v': '%v' is not a nuclide, element, or group", path, name, m)
+			}
+		}
+		groups[name] = g
+		return g, nil
+	}
+
+	names := make([]string, 0, len(lower))
+	for name := range lower {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := resolve(name, map[string]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,93 @@
+package nuc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse returns the nuclide named by s in any of the forms accepted across
+// cyan - "U235", "u235", "U-235", "235U", "Am242m", the ZZAAA integer 92235,
+// or the cyclus ZZZAAASSSS id 922350000.  Surrounding whitespace is ignored.
+// Bare element symbols are rejected since they name no single nuclide; use
+// LookupGroup to select all of an element's isotopes.
+func Parse(s string) (Nuc, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty nuclide name")
+	}
+
+	var n Nuc
+	var err error
+	if v, perr := strconv.Atoi(s); perr == nil {
+		n, err = IdFromInt(v)
+	} else {
+		n, err = Id(s)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("'%v' is not a valid nuclide", s)
+	} else if n.A() == 0 {
+		return 0, fmt.Errorf("'%v' is an element, not a nuclide", s)
+	}
+	return n, nil
+}
+
+// ParseList parses a comma separated list of nuclides with Parse.  An empty
+// or blank list holds no nuclides.
+func ParseList(s string) ([]Nuc, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var nucs []Nuc
+	for _, name := range strings.Split(s, ",") {
+		n, err := Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		nucs = append(nucs, n)
+	}
+	return nucs, nil
+}
+
+// NameStyle is a way of writing nuclide names.
+type NameStyle int
+
+const (
+	StyleName   NameStyle = iota // e.g. U235 and Am242M
+	StyleHyphen                  // e.g. U-235 and Am-242m
+	StyleId                      // the cyclus id, e.g. 922350000
+)
+
+// ParseStyle returns the style named "name", "hyphen", or "id".
+func ParseStyle(s string) (NameStyle, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "name":
+		return StyleName, nil
+	case "hyphen":
+		return StyleHyphen, nil
+	case "id":
+		return StyleId, nil
+	}
+	return 0, fmt.Errorf("unknown nuclide name style '%v'", s)
+}
+
+// Format writes nuclide n in the given style.  Every style is read back by
+// Parse.
+func Format(n Nuc, style NameStyle) string {
+	switch style {
+	case StyleHyphen:
+		s := Symbol(n.Z())
+		if n.A() > 0 {
+			s += "-" + strconv.Itoa(n.A())
+		}
+		if state := int(n) % 10000; state == 1 {
+			s += "m"
+		} else if state > 1 {
+			s += "m" + strconv.Itoa(state)
+		}
+		return s
+	case StyleId:
+		return strconv.Itoa(int(n))
+	}
+	return n.Name()
+}
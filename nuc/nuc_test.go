@@ -2,7 +2,9 @@ package nuc
 
 import (
 	"fmt"
+	"io/ioutil"
 	"math"
+	"os"
 	"testing"
 )
 
@@ -78,3 +80,243 @@ func TestFPE(t *testing.T) {
 	fmt.Printf("fpe spent u fuel: %v\n", fpe2)
 	fmt.Printf("fpe fresh mox fuel: %v\n", fpe3)
 }
+
+func TestGroups(t *testing.T) {
+	m := Material{
+		922350000: 1,
+		942390000: 2,
+		942400000: 3,
+		952410000: 4,
+		551370000: 5,
+	}
+	for _, c := range []struct {
+		Name string
+		Want Mass
+	}{
+		{"Pu", 5},
+		{"pu", 5},
+		{"actinides", 10},
+		{"minoractinides", 4},
+		{"fissionproducts", 5},
+		{"fissile", 3},
+	} {
+		g, err := LookupGroup(c.Name, nil)
+		if err != nil {
+			t.Errorf("%v: %v", c.Name, err)
+		} else if got := g.Mass(m); got != c.Want {
+			t.Errorf("%v: want %v kg, got %v kg", c.Name, c.Want, got)
+		}
+	}
+	if _, err := LookupGroup("nonsense", nil); err == nil {
+		t.Errorf("nonsense group: want error")
+	}
+}
+
+func TestLoadGroups(t *testing.T) {
+	f, err := ioutil.TempFile("", "cyan-groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprint(f, `{"Recycled": ["Pu", "Am241", "minoractinides"], "mox": ["recycled", "U238"]}`)
+	f.Close()
+
+	groups, err := LoadGroups(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := LookupGroup("MOX", groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, want := range map[Nuc]bool{942390000: true, 952410000: true, 932370000: true, 922380000: true, 922350000: false} {
+		if g.Has(n) != want {
+			t.Errorf("mox has %v: want %v", n, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{"U-235", "U235", "u235", " U235 ", "235U", "922350000", "92235"} {
+		if got, err := Parse(s); err != nil {
+			t.Errorf("Parse(%q): %v", s, err)
+		} else if got != 922350000 {
+			t.Errorf("Parse(%q): want 922350000, got %v", s, got)
+		}
+	}
+	for _, s := range []string{"", "U", "Xx235", "U-23x"} {
+		if n, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q): want error, got %v", s, n)
+		}
+	}
+
+	nucs, err := ParseList("U235, Pu-239,942400000")
+	if err != nil {
+		t.Fatal(err)
+	} else if want := []Nuc{922350000, 942390000, 942400000}; fmt.Sprint(nucs) != fmt.Sprint(want) {
+		t.Errorf("ParseList: want %v, got %v", want, nucs)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		n     Nuc
+		style NameStyle
+		want  string
+	}{
+		{922350000, StyleName, "U235"},
+		{922350000, StyleHyphen, "U-235"},
+		{922350000, StyleId, "922350000"},
+		{952420001, StyleHyphen, "Am-242m"},
+	}
+	for _, test := range tests {
+		got := Format(test.n, test.style)
+		if got != test.want {
+			t.Errorf("Format(%v, %v): want %v, got %v", test.n, test.style, test.want, got)
+		}
+		if n, err := Parse(got); err != nil || n != test.n {
+			t.Errorf("Parse(%q): want %v, got %v (%v)", got, test.n, n, err)
+		}
+	}
+}
+
+type testDecay map[Nuc]struct {
+	hl        float64
+	daughters []Branch
+}
+
+func (d testDecay) HalfLife(n Nuc) float64 {
+	if p, ok := d[n]; ok {
+		return p.hl
+	}
+	return math.Inf(1)
+}
+
+func (d testDecay) Daughters(n Nuc) []Branch { return d[n].daughters }
+
+func TestDecay(t *testing.T) {
+	const parent, daughter, stable, other = Nuc(942410000), Nuc(952410000), Nuc(932370000), Nuc(922370000)
+	data := testDecay{
+		parent:   {100, []Branch{{daughter, 0.75}, {other, 0.25}}},
+		daughter: {1000, []Branch{{stable, 1}}},
+		other:    {1e-3, []Branch{{stable, 1}}},
+	}
+	l1, l2, l3 := math.Ln2/100, math.Ln2/1000, math.Ln2/1e-3
+
+	for _, secs := range []float64{0, 1, 50, 100, 1e3, 1e4, 1e6} {
+		m, err := Decay(Material{parent: 241}, secs, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n1 := math.Exp(-l1 * secs)
+		n2 := 0.75 * l1 / (l2 - l1) * (math.Exp(-l1*secs) - math.Exp(-l2*secs))
+		n3 := 0.25 * l1 / (l3 - l1) * (math.Exp(-l1*secs) - math.Exp(-l3*secs))
+		want := Material{
+			parent:   Mass(241 * n1),
+			daughter: Mass(241 * n2),
+			other:    Mass(237 * n3),
+			stable:   Mass(237 * (1 - n1 - n2 - n3)),
+		}
+		for n, w := range want {
+			if diff := math.Abs(float64(m[n] - w)); diff > 1e-9 {
+				t.Errorf("%v s: %v: want %v kg, got %v kg", secs, n.Name(), w, m[n])
+			}
+		}
+	}
+
+	loop := testDecay{parent: {1, []Branch{{daughter, 1}}}, daughter: {1, []Branch{{parent, 1}}}}
+	if _, err := Decay(Material{parent: 1}, 1, loop); err == nil {
+		t.Errorf("want decay loop error")
+	}
+}
+
+func TestDecayEqualHalfLives(t *testing.T) {
+	// parent and daughter decay at the same rate l, so the daughter grows as
+	// l*t*exp(-l*t) and a second daughter with the rate too as
+	// (l*t)^2/2*exp(-l*t)
+	const parent, daughter, granddaughter, stable = Nuc(942410000), Nuc(952410000), Nuc(962410000), Nuc(972410000)
+	data := testDecay{
+		parent:        {100, []Branch{{daughter, 1}}},
+		daughter:      {100, []Branch{{granddaughter, 1}}},
+		granddaughter: {100, []Branch{{stable, 1}}},
+	}
+	l := math.Ln2 / 100
+
+	for _, secs := range []float64{1, 50, 100, 144.27, 1e3} {
+		m, err := Decay(Material{parent: 241}, secs, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x := l * secs
+		want := Material{
+			parent:        Mass(241 * math.Exp(-x)),
+			daughter:      Mass(241 * x * math.Exp(-x)),
+			granddaughter: Mass(241 * x * x / 2 * math.Exp(-x)),
+			stable:        Mass(241 * (1 - (1+x+x*x/2)*math.Exp(-x))),
+		}
+		total := 0.0
+		for n, w := range want {
+			if diff := math.Abs(float64(m[n] - w)); diff > 1e-9 {
+				t.Errorf("%v s: %v: want %v kg, got %v kg", secs, n.Name(), w, m[n])
+			}
+			total += float64(m[n])
+		}
+		if math.Abs(total-241) > 1e-9 {
+			t.Errorf("%v s: want 241 kg in the chain, got %v kg", secs, total)
+		}
+	}
+}
+
+func TestStdDecay(t *testing.T) {
+	// Pu238 heat sources are rated at about 0.57 W/g
+	if got := StdDecay.DecayHeatCoeff(Pu238); math.Abs(got-567) > 2 {
+		t.Errorf("Pu238 decay heat: want about 567 W/kg, got %v", got)
+	}
+	if got := StdDecay.DecayHeatCoeff(U235 + 1); got != 0 {
+		t.Errorf("untabulated nuclide: want no decay heat, got %v", got)
+	}
+
+	// a 30.08 year Cs137 half-life later its Ba137m daughter is in secular
+	// equilibrium
+	cs137, ba137m := Nuc(551370000), Nuc(561370001)
+	m, err := Decay(Material{cs137: 1}, 30.08*Year, StdDecay)
+	if err != nil {
+		t.Fatal(err)
+	} else if math.Abs(float64(m[cs137])-0.5) > 1e-9 {
+		t.Errorf("Cs137: want 0.5 kg, got %v", m[cs137])
+	}
+	want := 0.944 * StdDecay.Activity(cs137, m[cs137])
+	if got := StdDecay.Activity(ba137m, m[ba137m]); math.Abs(got-want)/want > 1e-6 {
+		t.Errorf("Ba137m activity: want %v Bq, got %v", want, got)
+	}
+}
+
+func TestRadiotoxicity(t *testing.T) {
+	m := Material{Pu239: 1e-3, U235: 1}
+	want := StdDecay.Activity(Pu239, 1e-3)*2.5e-7 + StdDecay.Activity(U235, 1)*4.7e-8
+	if got := Radiotoxicity(m, StdDecay, ICRP72, Ingestion); math.Abs(got-want)/want > 1e-12 {
+		t.Errorf("ingestion: want %v Sv, got %v", want, got)
+	}
+	want = StdDecay.Activity(Pu239, 1e-3)*5.0e-5 + StdDecay.Activity(U235, 1)*3.1e-6
+	if got := Radiotoxicity(m, StdDecay, ICRP72, Inhalation); math.Abs(got-want)/want > 1e-12 {
+		t.Errorf("inhalation: want %v Sv, got %v", want, got)
+	}
+	if got := Radiotoxicity(Material{260560000: 1}, StdDecay, ICRP72, Ingestion); got != 0 {
+		t.Errorf("stable Fe56: want 0 Sv, got %v", got)
+	}
+
+	if _, err := ParseIntake("injection"); err == nil {
+		t.Errorf("want unknown intake route error")
+	}
+}
+
+func TestSWU(t *testing.T) {
+	// 1 kg of 4.5% LEU from natural uranium with 0.25% tails takes about
+	// 9.22 kg of feed and 6.87 kg-SWU
+	if got := FeedMass(1, 0.045, NatUAssay, 0.0025); math.Abs(got-9.219) > 1e-3 {
+		t.Errorf("feed: want 9.219 kg, got %v", got)
+	}
+	if got := SWU(1, 0.045, NatUAssay, 0.0025); math.Abs(got-6.87) > 0.01 {
+		t.Errorf("SWU: want about 6.87 kg-SWU, got %v", got)
+	}
+}
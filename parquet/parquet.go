@@ -0,0 +1,648 @@
+// Package parquet writes tables as uncompressed Parquet files without any
+// dependencies outside the standard library.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// DefaultGroupRows is the number of rows in each row group of a file unless
+// Writer.GroupRows is set.
+const DefaultGroupRows = 1 << 20
+
+// Type is the type of a column's values.
+type Type int
+
+const (
+	// Int64 columns hold int64 values.
+	Int64 Type = iota
+	// Double columns hold float64 (or int64) values.
+	Double
+	// String columns hold UTF8 text.  Values of other types are written as
+	// their text.
+	String
+	// Bytes columns hold binary data such as []byte values.
+	Bytes
+)
+
+// Parquet physical and converted types, repetitions, encodings, and page
+// types.
+const (
+	pqInt64     = 2
+	pqDouble    = 5
+	pqByteArray = 6
+
+	pqUTF8 = 0
+
+	pqOptional = 1
+
+	pqPlain = 0
+	pqRLE   = 3
+
+	pqDataPage = 0
+)
+
+func (t Type) physical() int32 {
+	switch t {
+	case Double:
+		return pqDouble
+	case String, Bytes:
+		return pqByteArray
+	}
+	return pqInt64
+}
+
+// Writer writes rows to a Parquet file with one optional (nullable) column
+// per column name.  Rows are buffered until a row group is full and then
+// written, so memory is bounded by the size of a row group.  Close must be
+// called to write the last row group and the file footer.
+type Writer struct {
+	// GroupRows is the number of rows in each row group.  It defaults to
+	// DefaultGroupRows and must be set before the first row is written.
+	GroupRows int
+
+	w      *countWriter
+	cols   []string
+	types  []Type
+	vals   [][]interface{} // buffered rows by column
+	groups []rowGroup
+	nrows  int64
+}
+
+type rowGroup struct {
+	nrows  int
+	chunks []chunk
+}
+
+// chunk is the location of a column chunk in the file.
+type chunk struct {
+	offset, size int64
+}
+
+// NewWriter returns a writer of a Parquet file with the named columns to w.
+// If types is nil, the type of each column is inferred from the values in
+// the first row group: String if it holds any values other than int64 or
+// float64, Double if it holds any float64 values, and Int64 otherwise.  If
+// the first row group fills before Close, later groups may hold wider
+// values - e.g. reals in a column of integers - so columns of only int64
+// values are promoted to Double and columns of only NULLs to String.
+func NewWriter(w io.Writer, cols []string, types []Type) *Writer {
+	return &Writer{
+		w:     &countWriter{w: w},
+		cols:  cols,
+		types: types,
+		vals:  make([][]interface{}, len(cols)),
+	}
+}
+
+// Write adds a row of values, one per column, to the file.  Values are nil
+// (NULL), int64, float64, string, []byte, or bool.
+func (p *Writer) Write(vals []interface{}) error {
+	if len(vals) != len(p.cols) {
+		return fmt.Errorf("parquet: row has %v values for %v columns", len(vals), len(p.cols))
+	}
+	for i, v := range vals {
+		p.vals[i] = append(p.vals[i], v)
+	}
+	if p.buffered() >= p.groupRows() {
+		return p.flushGroup(false)
+	}
+	return nil
+}
+
+// Close writes any buffered rows and the file footer.  It does not close the
+// underlying writer.
+func (p *Writer) Close() error {
+	if p.buffered() > 0 {
+		if err := p.flushGroup(true); err != nil {
+			return err
+		}
+	}
+	if err := p.begin(true); err != nil {
+		return err
+	}
+
+	meta := &thriftWriter{}
+	meta.i32(1, 1) // version
+	meta.listBegin(2, thriftStruct, len(p.cols)+1)
+	meta.structBegin()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(p.cols)))
+	meta.structEnd()
+	for i, c := range p.cols {
+		meta.structBegin()
+		meta.i32(1, p.types[i].physical())
+		meta.i32(3, pqOptional)
+		meta.binary(4, c)
+		if p.types[i] == String {
+			meta.i32(6, pqUTF8)
+		}
+		meta.structEnd()
+	}
+	meta.i64(3, p.nrows)
+
+	meta.listBegin(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		meta.structBegin()
+		meta.listBegin(1, thriftStruct, len(p.cols))
+		var groupSize int64
+		for i, c := range p.cols {
+			ch := g.chunks[i]
+			groupSize += ch.size
+			meta.structBegin()
+			meta.i64(2, ch.offset)
+			meta.structField(3)
+			meta.i32(1, p.types[i].physical())
+			meta.listBegin(2, thriftI32, 2)
+			meta.varint(pqPlain)
+			meta.varint(pqRLE)
+			meta.listBegin(3, thriftBinary, 1)
+			meta.str(c)
+			meta.i32(4, 0) // uncompressed
+			meta.i64(5, int64(g.nrows))
+			meta.i64(6, ch.size)
+			meta.i64(7, ch.size)
+			meta.i64(9, ch.offset)
+			meta.structEnd()
+			meta.structEnd()
+		}
+		meta.i64(2, groupSize)
+		meta.i64(3, int64(g.nrows))
+		meta.structEnd()
+	}
+	meta.binary(6, "cyan")
+	meta.structEnd()
+
+	footer := meta.buf.Bytes()
+	if _, err := p.w.Write(footer); err != nil {
+		return err
+	}
+	if err := binary.Write(p.w, binary.LittleEndian, uint32(len(footer))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(p.w, "PAR1")
+	return err
+}
+
+func (p *Writer) groupRows() int {
+	if p.GroupRows > 0 {
+		return p.GroupRows
+	}
+	return DefaultGroupRows
+}
+
+func (p *Writer) buffered() int {
+	if len(p.vals) == 0 {
+		return 0
+	}
+	return len(p.vals[0])
+}
+
+// begin fixes the column types and writes the file's leading magic number
+// if they haven't been yet.  final is true if no rows follow those buffered.
+func (p *Writer) begin(final bool) error {
+	if p.types != nil {
+		if p.w.n == 0 {
+			_, err := io.WriteString(p.w, "PAR1")
+			return err
+		}
+		return nil
+	}
+	p.types = make([]Type, len(p.cols))
+	for i, vals := range p.vals {
+		p.types[i] = inferType(vals, final)
+	}
+	_, err := io.WriteString(p.w, "PAR1")
+	return err
+}
+
+// flushGroup writes the buffered rows as a row group.  final is true if no
+// rows follow them.
+func (p *Writer) flushGroup(final bool) error {
+	if err := p.begin(final); err != nil {
+		return err
+	}
+	g := rowGroup{nrows: p.buffered(), chunks: make([]chunk, len(p.cols))}
+	for i, c := range p.cols {
+		page, err := encodePage(p.types[i], p.vals[i])
+		if err != nil {
+			return fmt.Errorf("parquet: column %v: %v", c, err)
+		}
+		head := &thriftWriter{}
+		head.i32(1, pqDataPage)
+		head.i32(2, int32(len(page)))
+		head.i32(3, int32(len(page)))
+		head.structField(5)
+		head.i32(1, int32(g.nrows))
+		head.i32(2, pqPlain)
+		head.i32(3, pqRLE)
+		head.i32(4, pqRLE)
+		head.structEnd()
+		head.structEnd()
+
+		offset := p.w.n
+		if _, err := p.w.Write(head.buf.Bytes()); err != nil {
+			return err
+		} else if _, err := p.w.Write(page); err != nil {
+			return err
+		}
+		g.chunks[i] = chunk{offset: offset, size: p.w.n - offset}
+		p.vals[i] = p.vals[i][:0]
+	}
+	p.groups = append(p.groups, g)
+	p.nrows += int64(g.nrows)
+	return nil
+}
+
+// inferType returns the type of a column holding vals, promoted to hold
+// wider values unless final is true (see NewWriter).
+func inferType(vals []interface{}, final bool) Type {
+	typ, null := Int64, true
+	for _, v := range vals {
+		switch v.(type) {
+		case nil:
+			continue
+		case float64:
+			typ = Double
+		case int64:
+		default:
+			return String
+		}
+		null = false
+	}
+	switch {
+	case final:
+		return typ
+	case null:
+		return String
+	}
+	return Double
+}
+
+// encodePage returns the data of a page holding vals: RLE encoded
+// definition levels (0 for NULL) followed by the PLAIN encoded values.
+func encodePage(typ Type, vals []interface{}) ([]byte, error) {
+	var levels bytes.Buffer
+	for i := 0; i < len(vals); {
+		def := vals[i] != nil
+		n := 1
+		for i+n < len(vals) && (vals[i+n] != nil) == def {
+			n++
+		}
+		putUvarint(&levels, uint64(n)<<1)
+		if def {
+			levels.WriteByte(1)
+		} else {
+			levels.WriteByte(0)
+		}
+		i += n
+	}
+
+	var page bytes.Buffer
+	binary.Write(&page, binary.LittleEndian, uint32(levels.Len()))
+	page.Write(levels.Bytes())
+	for _, v := range vals {
+		if v == nil {
+			continue
+		}
+		switch typ {
+		case Int64:
+			n, ok := v.(int64)
+			if !ok {
+				return nil, fmt.Errorf("%T value %v in INT64 column", v, v)
+			}
+			binary.Write(&page, binary.LittleEndian, n)
+		case Double:
+			var f float64
+			switch v := v.(type) {
+			case float64:
+				f = v
+			case int64:
+				f = float64(v)
+			default:
+				return nil, fmt.Errorf("%T value %v in DOUBLE column", v, v)
+			}
+			binary.Write(&page, binary.LittleEndian, math.Float64bits(f))
+		case String, Bytes:
+			s := text(v)
+			binary.Write(&page, binary.LittleEndian, uint32(len(s)))
+			page.WriteString(s)
+		}
+	}
+	return page.Bytes(), nil
+}
+
+// text returns the contents of a BYTE_ARRAY value.
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// Meta summarizes the metadata in the footer of a Parquet file.
+type Meta struct {
+	NumRows int64
+	// GroupRows is the number of rows in each row group.
+	GroupRows []int64
+}
+
+// ReadMeta reads the row counts from the footer of the Parquet file of the
+// given size in r.
+func ReadMeta(r io.ReaderAt, size int64) (*Meta, error) {
+	f, err := readFooter(r, size)
+	if err != nil {
+		return nil, err
+	}
+	m := &Meta{NumRows: f.nrows}
+	for _, g := range f.groups {
+		m.GroupRows = append(m.GroupRows, int64(g.nrows))
+	}
+	return m, nil
+}
+
+// ReadRows reads the column names and rows of the Parquet file of the given
+// size in r.  Only files like those written by Writer - uncompressed, with
+// a PLAIN encoded page per column chunk - can be read.  Values are nil,
+// int64, float64, string, or []byte as for Write.
+func ReadRows(r io.ReaderAt, size int64) (cols []string, rows [][]interface{}, err error) {
+	f, err := readFooter(r, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, g := range f.groups {
+		if len(g.chunks) != len(f.cols) {
+			return nil, nil, errors.New("parquet: row group doesn't match the schema")
+		}
+		start := len(rows)
+		for i := 0; i < g.nrows; i++ {
+			rows = append(rows, make([]interface{}, len(f.cols)))
+		}
+		for i, ch := range g.chunks {
+			vals, err := readChunk(r, ch, f.types[i], g.nrows)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parquet: column %v: %v", f.cols[i], err)
+			}
+			for j, v := range vals {
+				rows[start+j][i] = v
+			}
+		}
+	}
+	return f.cols, rows, nil
+}
+
+// footer is the file metadata of a Parquet file.
+type footer struct {
+	cols   []string
+	types  []Type
+	nrows  int64
+	groups []rowGroup
+}
+
+// readFooter reads and decodes the footer of the Parquet file of the given
+// size in r.
+func readFooter(r io.ReaderAt, size int64) (*footer, error) {
+	if size < 12 {
+		return nil, errors.New("parquet: file too short")
+	}
+	var tail [8]byte
+	if _, err := r.ReadAt(tail[:], size-8); err != nil {
+		return nil, err
+	} else if string(tail[4:]) != "PAR1" {
+		return nil, errors.New("parquet: missing magic number")
+	}
+	n := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if n > size-12 {
+		return nil, errors.New("parquet: invalid footer length")
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, size-8-n); err != nil {
+		return nil, err
+	}
+
+	f := &footer{}
+	t := &thriftReader{r: bytes.NewReader(buf)}
+	err := t.readStruct(func(id int16, typ byte) error {
+		switch {
+		case id == 2 && typ == thriftList:
+			return t.readList(func(i int) error { return f.readSchema(t, i) })
+		case id == 3 && typ == thriftI64:
+			v, err := t.varint()
+			f.nrows = v
+			return err
+		case id == 4 && typ == thriftList:
+			return t.readList(func(int) error { return f.readGroup(t) })
+		}
+		return t.skip(typ)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parquet: invalid footer: %v", err)
+	}
+	return f, nil
+}
+
+// readSchema reads the i'th schema element - the root for i=0 and
+// otherwise a column.
+func (f *footer) readSchema(t *thriftReader, i int) error {
+	var name string
+	var phys int64
+	utf8 := false
+	err := t.readStruct(func(id int16, typ byte) (err error) {
+		switch {
+		case id == 1 && typ == thriftI32:
+			phys, err = t.varint()
+		case id == 4 && typ == thriftBinary:
+			name, err = t.binary()
+		case id == 6 && typ == thriftI32:
+			var conv int64
+			conv, err = t.varint()
+			utf8 = conv == pqUTF8
+		default:
+			err = t.skip(typ)
+		}
+		return err
+	})
+	if err != nil || i == 0 {
+		return err
+	}
+	ct := Int64
+	switch {
+	case phys == pqDouble:
+		ct = Double
+	case phys == pqByteArray && utf8:
+		ct = String
+	case phys == pqByteArray:
+		ct = Bytes
+	case phys != pqInt64:
+		return fmt.Errorf("unsupported physical type %v", phys)
+	}
+	f.cols = append(f.cols, name)
+	f.types = append(f.types, ct)
+	return nil
+}
+
+// readGroup reads a row group's row count and the location of each of its
+// column chunks.
+func (f *footer) readGroup(t *thriftReader) error {
+	var g rowGroup
+	err := t.readStruct(func(id int16, typ byte) error {
+		switch {
+		case id == 1 && typ == thriftList:
+			return t.readList(func(int) error {
+				var ch chunk
+				err := t.readStruct(func(id int16, typ byte) error {
+					if id != 3 || typ != thriftStruct {
+						return t.skip(typ)
+					}
+					return t.readStruct(func(id int16, typ byte) (err error) {
+						switch {
+						case id == 4 && typ == thriftI32:
+							var codec int64
+							if codec, err = t.varint(); err == nil && codec != 0 {
+								err = errors.New("compressed column chunk")
+							}
+						case id == 7 && typ == thriftI64:
+							ch.size, err = t.varint()
+						case id == 9 && typ == thriftI64:
+							ch.offset, err = t.varint()
+						default:
+							err = t.skip(typ)
+						}
+						return err
+					})
+				})
+				g.chunks = append(g.chunks, ch)
+				return err
+			})
+		case id == 3 && typ == thriftI64:
+			v, err := t.varint()
+			g.nrows = int(v)
+			return err
+		}
+		return t.skip(typ)
+	})
+	f.groups = append(f.groups, g)
+	return err
+}
+
+// readChunk reads the n values of type typ in the column chunk ch, which
+// must hold a single data page.
+func readChunk(r io.ReaderAt, ch chunk, typ Type, n int) ([]interface{}, error) {
+	buf := make([]byte, ch.size)
+	if _, err := r.ReadAt(buf, ch.offset); err != nil {
+		return nil, err
+	}
+	br := bytes.NewReader(buf)
+	t := &thriftReader{r: br}
+	var pageType, pageSize int64 = -1, -1
+	err := t.readStruct(func(id int16, typ byte) (err error) {
+		switch {
+		case id == 1 && typ == thriftI32:
+			pageType, err = t.varint()
+		case id == 3 && typ == thriftI32:
+			pageSize, err = t.varint()
+		default:
+			err = t.skip(typ)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	} else if pageType != pqDataPage || pageSize < 0 || pageSize > int64(br.Len()) {
+		return nil, errors.New("unsupported page")
+	}
+	page := buf[len(buf)-br.Len():]
+	return decodePage(typ, page[:pageSize], n)
+}
+
+// decodePage returns the n values of a page encoded by encodePage.
+func decodePage(typ Type, page []byte, n int) ([]interface{}, error) {
+	if len(page) < 4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	nlevels := int(binary.LittleEndian.Uint32(page))
+	if nlevels > len(page)-4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	levels := bytes.NewReader(page[4 : 4+nlevels])
+	var defs []bool
+	for len(defs) < n {
+		h, err := binary.ReadUvarint(levels)
+		if err != nil {
+			return nil, err
+		} else if h&1 == 1 {
+			return nil, errors.New("unsupported bit-packed definition levels")
+		}
+		def, err := levels.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < h>>1; i++ {
+			defs = append(defs, def == 1)
+		}
+	}
+
+	data := page[4+nlevels:]
+	vals := make([]interface{}, n)
+	for i := range vals {
+		if !defs[i] {
+			continue
+		}
+		switch typ {
+		case Int64, Double:
+			if len(data) < 8 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			u := binary.LittleEndian.Uint64(data)
+			if typ == Int64 {
+				vals[i] = int64(u)
+			} else {
+				vals[i] = math.Float64frombits(u)
+			}
+			data = data[8:]
+		case String, Bytes:
+			if len(data) < 4 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			l := int(binary.LittleEndian.Uint32(data))
+			if l > len(data)-4 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			b := data[4 : 4+l]
+			if typ == String {
+				vals[i] = string(b)
+			} else {
+				vals[i] = append([]byte{}, b...)
+			}
+			data = data[4+l:]
+		}
+	}
+	return vals, nil
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
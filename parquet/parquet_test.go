@@ -0,0 +1,148 @@
+package parquet
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRowGroups(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewWriter(&buf, []string{"Time", "Name", "Quantity"}, nil)
+	pw.GroupRows = 2
+	for i := 0; i < 5; i++ {
+		if err := pw.Write([]interface{}{int64(i), "reactor", float64(i) / 2}); err != nil {
+			t.Fatal(err)
+		}
+		// full row groups are written immediately
+		if want := (i + 1) / 2; len(pw.groups) != want {
+			t.Errorf("row %v: want %v row groups written, got %v", i, want, len(pw.groups))
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Time is promoted since more rows followed the first row group
+	if want := []Type{Double, String, Double}; !reflect.DeepEqual(pw.types, want) {
+		t.Errorf("want column types %v, got %v", want, pw.types)
+	}
+
+	m, err := ReadMeta(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Meta{NumRows: 5, GroupRows: []int64{2, 2, 1}}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("want %+v, got %+v", want, m)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewWriter(&buf, []string{"Time"}, []Type{Int64})
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadMeta(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	} else if m.NumRows != 0 || len(m.GroupRows) != 0 {
+		t.Errorf("want no rows, got %+v", m)
+	}
+}
+
+func TestInferTypes(t *testing.T) {
+	// a single row group is typed exactly
+	var buf bytes.Buffer
+	pw := NewWriter(&buf, []string{"Id", "Qty", "Name", "Null"}, nil)
+	pw.Write([]interface{}{int64(1), int64(0), "a", nil})
+	pw.Write([]interface{}{nil, 1.5, nil, nil})
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []Type{Int64, Double, String, Int64}; !reflect.DeepEqual(pw.types, want) {
+		t.Errorf("want column types %v, got %v", want, pw.types)
+	}
+}
+
+func TestTypePromotion(t *testing.T) {
+	// e.g. IFNULL(qty, 0) gives integers until the first real quantity
+	rows := [][]interface{}{
+		{int64(1), int64(0), nil},
+		{int64(2), int64(0), nil},
+		{int64(3), 1.5, "x"},
+		{int64(4), nil, int64(7)},
+	}
+	var buf bytes.Buffer
+	pw := NewWriter(&buf, []string{"Time", "Qty", "Extra"}, nil)
+	pw.GroupRows = 2
+	for _, row := range rows {
+		if err := pw.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, got, err := ReadRows(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{
+		{1.0, 0.0, nil},
+		{2.0, 0.0, nil},
+		{3.0, 1.5, "x"},
+		{4.0, nil, "7"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want rows %v, got %v", want, got)
+	}
+}
+
+func TestTypeMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewWriter(&buf, []string{"Time"}, nil)
+	pw.GroupRows = 1
+	if err := pw.Write([]interface{}{int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+	err := pw.Write([]interface{}{"reactor"})
+	if err == nil || !strings.Contains(err.Error(), "DOUBLE column") {
+		t.Errorf("want DOUBLE column error, got %v", err)
+	}
+}
+
+func TestReadRows(t *testing.T) {
+	cols := []string{"Id", "Name", "Quantity", "Blob"}
+	want := [][]interface{}{
+		{int64(1), "reactor", 1.5, []byte{0, 1}},
+		{nil, nil, nil, nil},
+		{int64(-3), "sink", 2.0, []byte{}},
+		{int64(4), "", nil, []byte{0xff}},
+		{int64(5), "mine", 0.25, nil},
+	}
+	var buf bytes.Buffer
+	pw := NewWriter(&buf, cols, []Type{Int64, String, Double, Bytes})
+	pw.GroupRows = 2
+	for _, row := range want {
+		if err := pw.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gotCols, got, err := ReadRows(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotCols, cols) {
+		t.Errorf("want columns %v, got %v", cols, gotCols)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want rows %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,250 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Thrift compact protocol types.
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Parquet file metadata and page headers with the
+// thrift compact protocol.  The top level struct is begun implicitly and
+// must be ended with structEnd.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // last field id of each open struct
+	id   int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if d := id - t.id; d > 0 && d <= 15 {
+		t.buf.WriteByte(byte(d)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		putUvarint(&t.buf, zigzag(int64(id)))
+	}
+	t.id = id
+}
+
+func (t *thriftWriter) varint(v int64) { putUvarint(&t.buf, zigzag(v)) }
+
+func (t *thriftWriter) str(s string) {
+	putUvarint(&t.buf, uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.str(s)
+}
+
+// listBegin writes the header of a list field of n elements of type elem.
+func (t *thriftWriter) listBegin(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xf0 | elem)
+		putUvarint(&t.buf, uint64(n))
+	}
+}
+
+// structField begins a struct valued field.
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.structBegin()
+}
+
+// structBegin begins a struct, e.g. a list element.
+func (t *thriftWriter) structBegin() {
+	t.last = append(t.last, t.id)
+	t.id = 0
+}
+
+func (t *thriftWriter) structEnd() {
+	t.buf.WriteByte(0)
+	if n := len(t.last); n > 0 {
+		t.id = t.last[n-1]
+		t.last = t.last[:n-1]
+	}
+}
+
+func zigzag(v int64) uint64 { return uint64(v<<1) ^ uint64(v>>63) }
+
+func putUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+// thriftReader decodes thrift compact protocol structs, such as the Parquet
+// file metadata.
+type thriftReader struct {
+	r *bytes.Reader
+}
+
+func (t *thriftReader) varint() (int64, error) {
+	u, err := binary.ReadUvarint(t.r)
+	return int64(u>>1) ^ -int64(u&1), err
+}
+
+// readStruct reads the fields of a struct, calling f with the id and type
+// of each field to read or skip its value.
+func (t *thriftReader) readStruct(f func(id int16, typ byte) error) error {
+	var id int16
+	for {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		typ := b & 0x0f
+		if typ == 0 {
+			return nil
+		}
+		if d := int16(b >> 4); d > 0 {
+			id += d
+		} else {
+			v, err := t.varint()
+			if err != nil {
+				return err
+			}
+			id = int16(v)
+		}
+		if typ == thriftTrue || typ == thriftFalse {
+			continue // boolean fields have no value after the header
+		}
+		if err := f(id, typ); err != nil {
+			return err
+		}
+	}
+}
+
+// binary reads a binary or string value.
+func (t *thriftReader) binary() (string, error) {
+	n, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		return "", err
+	} else if n > uint64(t.r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(t.r, b)
+	return string(b), err
+}
+
+// readList reads a list of structs, calling f with the index of each to
+// read it.
+func (t *thriftReader) readList(f func(i int) error) error {
+	elem, n, err := t.listHeader()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if elem != thriftStruct {
+			err = t.skip(elem)
+		} else {
+			err = f(i)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listHeader reads the element type and length of a list or set.
+func (t *thriftReader) listHeader() (elem byte, n int, err error) {
+	b, err := t.r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	elem, n = b&0x0f, int(b>>4)
+	if n == 15 {
+		u, err := binary.ReadUvarint(t.r)
+		if err != nil {
+			return 0, 0, err
+		}
+		n = int(u)
+	}
+	return elem, n, nil
+}
+
+// skip reads past a value of type typ.
+func (t *thriftReader) skip(typ byte) error {
+	switch typ {
+	case thriftTrue, thriftFalse, thriftByte:
+		_, err := t.r.ReadByte()
+		return err
+	case thriftI16, thriftI32, thriftI64:
+		_, err := t.varint()
+		return err
+	case thriftDouble:
+		_, err := t.r.Seek(8, io.SeekCurrent)
+		return err
+	case thriftBinary:
+		n, err := binary.ReadUvarint(t.r)
+		if err != nil {
+			return err
+		} else if n > uint64(t.r.Len()) {
+			return io.ErrUnexpectedEOF
+		}
+		_, err = t.r.Seek(int64(n), io.SeekCurrent)
+		return err
+	case thriftList, thriftSet:
+		elem, n, err := t.listHeader()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := t.skip(elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	case thriftMap:
+		n, err := binary.ReadUvarint(t.r)
+		if err != nil || n == 0 {
+			return err
+		}
+		kv, err := t.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := t.skip(kv >> 4); err != nil {
+				return err
+			} else if err := t.skip(kv & 0x0f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case thriftStruct:
+		return t.readStruct(func(id int16, typ byte) error { return t.skip(typ) })
+	}
+	return errors.New("unknown thrift type")
+}
@@ -0,0 +1,164 @@
+package post
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Dialect describes the differences in SQL between the database backends
+// inventories can be built in.  The walker's statements are written for
+// sqlite and rendered for a Context's dialect before they are executed.
+// Sqlite specific steps - pragmas and probing for legacy cyclus column names
+// - are skipped for other dialects.  Finish, BoundEndTimes, and
+// Context.AttachDB remain sqlite only.
+type Dialect interface {
+	// Placeholder returns the parameter marker for the n'th argument
+	// (starting at 1) of a statement.
+	Placeholder(n int) string
+	// Type returns the backend's name for the sqlite column type t (one of
+	// INTEGER, REAL, TEXT, or BLOB).
+	Type(t string) string
+}
+
+// SQLite is the default Dialect.
+type SQLite struct{}
+
+func (SQLite) Placeholder(n int) string { return "?" }
+func (SQLite) Type(t string) string     { return t }
+
+// isSQLite reports whether d is the SQLite dialect, which a nil Dialect
+// defaults to.
+func isSQLite(d Dialect) bool {
+	_, ok := d.(SQLite)
+	return ok || d == nil
+}
+
+// Postgres is the Dialect for PostgreSQL databases.
+type Postgres struct{}
+
+var pgTypes = map[string]string{
+	"BLOB": "BYTEA",
+	"REAL": "DOUBLE PRECISION",
+}
+
+func (Postgres) Placeholder(n int) string { return "$" + strconv.Itoa(n) }
+
+func (Postgres) Type(t string) string {
+	if pt, ok := pgTypes[t]; ok {
+		return pt
+	}
+	return t
+}
+
+// Column is a table column name and its sqlite type.
+type Column struct {
+	Name string
+	Type string
+}
+
+// invCols are the columns of an inventories table.
+var invCols = []Column{
+	{"SimId", "BLOB"},
+	{"ResourceId", "INTEGER"},
+	{"AgentId", "INTEGER"},
+	{"StartTime", "INTEGER"},
+	{"EndTime", "INTEGER"},
+	{"QualId", "INTEGER"},
+	{"Quantity", "REAL"},
+}
+
+// agentCols are the columns of the Agents table.
+var agentCols = []Column{
+	{"SimId", "BLOB"},
+	{"AgentId", "INTEGER"},
+	{"Kind", "TEXT"},
+	{"Spec", "TEXT"},
+	{"Prototype", "TEXT"},
+	{"ParentId", "INTEGER"},
+	{"Lifetime", "INTEGER"},
+	{"EnterTime", "INTEGER"},
+	{"ExitTime", "INTEGER"},
+}
+
+// rawTables are the cyclus output tables read by the walk with their
+// columns.  Prepare creates any that are missing, e.g. for simulations that
+// never wrote them.
+var rawTables = []struct {
+	Name string
+	Cols []Column
+}{
+	{"TimeSeriesPower", []Column{{"SimId", "BLOB"}, {"AgentId", "INTEGER"}, {"Time", "INTEGER"}, {"Value", "REAL"}}},
+	{"AgentExit", []Column{{"SimId", "BLOB"}, {"AgentId", "INTEGER"}, {"ExitTime", "INTEGER"}}},
+	{"Compositions", []Column{{"SimId", "BLOB"}, {"QualId", "INTEGER"}, {"NucId", "INTEGER"}, {"MassFrac", "REAL"}}},
+	{"Products", []Column{{"SimId", "BLOB"}, {"QualId", "INTEGER"}, {"Quality", "TEXT"}}},
+	{"Resources", []Column{{"SimId", "INTEGER"}, {"ResourceId", "INTEGER"}, {"ObjId", "INTEGER"}, {"Type", "TEXT"},
+		{"TimeCreated", "INTEGER"}, {"Quantity", "REAL"}, {"Units", "TEXT"}, {"QualId", "INTEGER"},
+		{"Parent1", "INTEGER"}, {"Parent2", "INTEGER"}}},
+	{"ResCreators", []Column{{"SimId", "INTEGER"}, {"ResourceId", "INTEGER"}, {"AgentId", "INTEGER"}}},
+	{"Agents", agentCols},
+	{DefaultInvTable, invCols},
+	{"TimeList", timeCols},
+	{"Transactions", []Column{{"SimId", "BLOB"}, {"TransactionId", "INTEGER"}, {"SenderId", "INTEGER"}, {"ReceiverId", "INTEGER"},
+		{"ResourceId", "INTEGER"}, {"Commodity", "TEXT"}, {"Time", "INTEGER"}}},
+}
+
+// timeCols are the columns of the TimeList table.
+var timeCols = []Column{
+	{"SimId", "BLOB"},
+	{"Time", "INTEGER"},
+}
+
+// metaCols are the columns of the InventoriesMeta table.
+var metaCols = []Column{
+	{"SimId", "BLOB"},
+	{"InvTable", "TEXT"},
+	{"BuiltAt", "TEXT"},
+	{"Version", "TEXT"},
+	{"DumpFreq", "INTEGER"},
+	{"Options", "TEXT"},
+	{"Rows", "INTEGER"},
+}
+
+// CreateTable builds a statement for creating the named table with cols
+// (if it doesn't already exist) for dialect d.
+func CreateTable(d Dialect, table string, cols ...Column) string {
+	var buf bytes.Buffer
+	buf.WriteString("CREATE TABLE IF NOT EXISTS " + table + " (")
+	for i, c := range cols {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(c.Name + " " + d.Type(c.Type))
+	}
+	buf.WriteString(");")
+	return buf.String()
+}
+
+// Bind rewrites the sqlite '?' parameter markers in statement s for dialect
+// d.  Question marks inside quoted strings and identifiers are left alone.
+func Bind(d Dialect, s string) string {
+	if isSQLite(d) || !strings.Contains(s, "?") {
+		return s
+	}
+
+	var buf bytes.Buffer
+	n := 0
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			buf.WriteString(d.Placeholder(n))
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
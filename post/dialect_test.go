@@ -0,0 +1,96 @@
+package post
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var bindcases = []struct {
+	Sql      string
+	SQLite   string
+	Postgres string
+}{
+	{
+		Sql:      "SELECT ResourceId FROM Resources WHERE SimId = ? LIMIT 1",
+		SQLite:   "SELECT ResourceId FROM Resources WHERE SimId = ? LIMIT 1",
+		Postgres: "SELECT ResourceId FROM Resources WHERE SimId = $1 LIMIT 1",
+	}, {
+		Sql:      resSql("tmp", defaultParentCols),
+		SQLite:   "SELECT ResourceId,TimeCreated,QualId,Quantity,Parent1,(COALESCE(Parent2,0) != 0) FROM tmp WHERE Parent1 = ? OR Parent2 = ?;",
+		Postgres: "SELECT ResourceId,TimeCreated,QualId,Quantity,Parent1,(COALESCE(Parent2,0) != 0) FROM tmp WHERE Parent1 = $1 OR Parent2 = $2;",
+	}, {
+		Sql:      fmt.Sprintf(dumpSql, "Inventories"),
+		SQLite:   "INSERT INTO Inventories VALUES (?,?,?,?,?,?,?);",
+		Postgres: "INSERT INTO Inventories VALUES ($1,$2,$3,$4,$5,$6,$7);",
+	}, {
+		Sql:      "SELECT '?', \"a?\" FROM t WHERE x = ?",
+		SQLite:   "SELECT '?', \"a?\" FROM t WHERE x = ?",
+		Postgres: "SELECT '?', \"a?\" FROM t WHERE x = $1",
+	},
+}
+
+func TestBind(t *testing.T) {
+	for _, test := range bindcases {
+		if got := Bind(SQLite{}, test.Sql); got != test.SQLite {
+			t.Errorf("sqlite:\n    want %v\n    got  %v", test.SQLite, got)
+		}
+		if got := Bind(Postgres{}, test.Sql); got != test.Postgres {
+			t.Errorf("postgres:\n    want %v\n    got  %v", test.Postgres, got)
+		}
+	}
+
+	for _, s := range []string{rootsSql, ownerSql} {
+		if got := Bind(Postgres{}, s); strings.Contains(got, "?") || !strings.Contains(got, "$2") {
+			t.Errorf("postgres: placeholders not rewritten in %v", got)
+		}
+	}
+}
+
+func TestCreateTable(t *testing.T) {
+	want := "CREATE TABLE IF NOT EXISTS Inventories (SimId BLOB,ResourceId INTEGER,AgentId INTEGER,StartTime INTEGER,EndTime INTEGER,QualId INTEGER,Quantity REAL);"
+	if got := CreateTable(SQLite{}, "Inventories", invCols...); got != want {
+		t.Errorf("sqlite:\n    want %v\n    got  %v", want, got)
+	}
+
+	want = "CREATE TABLE IF NOT EXISTS Inventories (SimId BYTEA,ResourceId INTEGER,AgentId INTEGER,StartTime INTEGER,EndTime INTEGER,QualId INTEGER,Quantity DOUBLE PRECISION);"
+	if got := CreateTable(Postgres{}, "Inventories", invCols...); got != want {
+		t.Errorf("postgres:\n    want %v\n    got  %v", want, got)
+	}
+}
+
+func TestPostgresContext(t *testing.T) {
+	// sqlite accepts postgres' numbered parameters and type names, so a
+	// walk rendered for postgres can run against it - skipping the pragmas
+	// and legacy column probe postgres lacks.
+	db, _ := openTestDb(t)
+	if err := PrepareWith(db, PrepareOptions{Dialect: Postgres{}}); err != nil {
+		t.Fatal(err)
+	}
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode;").Scan(&mode); err != nil {
+		t.Fatal(err)
+	} else if mode == "off" {
+		t.Errorf("sqlite pragmas were run for postgres")
+	}
+	var create string
+	if err := db.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'TimeSeriesPower';").Scan(&create); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(create, "SimId BYTEA") {
+		t.Errorf("want a BYTEA SimId column, got %v", create)
+	}
+
+	want := testDb(t, simpleSim...)
+	walk(t, want, 1)
+
+	db = testDb(t, simpleSim...)
+	ctx := NewContext(db, testSimId)
+	ctx.Dialect = Postgres{}
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inventories(t, db), inventories(t, want); len(want) == 0 || !reflect.DeepEqual(got, want) {
+		t.Errorf("postgres walk differs from sqlite walk:\n    want %+v\n    got  %+v", want, got)
+	}
+}
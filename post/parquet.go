@@ -0,0 +1,156 @@
+package post
+
+import (
+	"database/sql"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/rwcarlsen/cyan/parquet"
+)
+
+// parquetTypes are the Parquet column types of the sqlite column types.
+var parquetTypes = map[string]parquet.Type{
+	"INTEGER": parquet.Int64,
+	"REAL":    parquet.Double,
+	"TEXT":    parquet.String,
+	"BLOB":    parquet.Bytes,
+}
+
+// DumpParquet writes the inventory entries of the specified simid to w as a
+// Parquet file with the same columns as the inventories table, ordered by
+// resource id and start time.  Rows are streamed from the database and
+// written in row groups of parquet.DefaultGroupRows, so memory use doesn't
+// grow with the size of the table.
+func DumpParquet(db *sql.DB, simid []byte, w io.Writer) error {
+	return dumpParquet(db, simid, w, parquet.DefaultGroupRows)
+}
+
+func dumpParquet(db *sql.DB, simid []byte, w io.Writer, groupRows int) error {
+	s := "SELECT " + invColNames() + " FROM " + DefaultInvTable + " WHERE SimId = ? ORDER BY ResourceId,StartTime;"
+	return writeParquet(db, w, groupRows, s, simid)
+}
+
+// DumpParquetShards is like DumpParquet but splits the inventory entries by
+// agent into a Parquet file per writer of ws, which are written
+// concurrently.  The shards hold contiguous ranges of agent ids with about
+// equal numbers of entries, each ordered by agent id, resource id, and start
+// time, so the shards' rows concatenated in order are every entry ordered by
+// agent.  Shards are left empty if there are fewer agents than writers.
+func DumpParquetShards(db *sql.DB, simid []byte, ws []io.Writer) error {
+	return dumpParquetShards(db, simid, ws, parquet.DefaultGroupRows)
+}
+
+func dumpParquetShards(db *sql.DB, simid []byte, ws []io.Writer, groupRows int) error {
+	ranges, err := agentShards(db, simid, len(ws))
+	if err != nil {
+		return err
+	}
+
+	s := "SELECT " + invColNames() + " FROM " + DefaultInvTable + " WHERE SimId = ? AND AgentId >= ? AND AgentId <= ? ORDER BY AgentId,ResourceId,StartTime;"
+	errs := make([]error, len(ws))
+	var wg sync.WaitGroup
+	for i, w := range ws {
+		wg.Add(1)
+		go func(i int, w io.Writer) {
+			defer wg.Done()
+			errs[i] = writeParquet(db, w, groupRows, s, simid, ranges[i][0], ranges[i][1])
+		}(i, w)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// agentShards returns the first and last agent id of each of n shards of
+// the inventory entries of simid.  Agents are assigned in order, moving on
+// to the next shard once the entries so far reach its share of the total or
+// when every remaining shard needs one of the remaining agents.  Empty
+// shards have a first id greater than their last.
+func agentShards(db *sql.DB, simid []byte, n int) ([][2]int, error) {
+	rows, err := db.Query("SELECT AgentId,COUNT(*) FROM "+DefaultInvTable+" WHERE SimId = ? GROUP BY AgentId ORDER BY AgentId;", simid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids, counts []int
+	total := 0
+	for rows.Next() {
+		var id, count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		counts = append(counts, count)
+		total += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ranges := make([][2]int, n)
+	for k := range ranges {
+		ranges[k] = [2]int{1, 0}
+	}
+	k, sum := 0, 0
+	for i, id := range ids {
+		if ranges[k][0] > ranges[k][1] {
+			ranges[k][0] = id
+		}
+		ranges[k][1] = id
+		sum += counts[i]
+		if k < n-1 && (sum*n >= total*(k+1) || len(ids)-i-1 <= n-1-k) {
+			k++
+		}
+	}
+	return ranges, nil
+}
+
+// invColNames returns the comma separated names of the inventory columns.
+func invColNames() string {
+	names := make([]string, len(invCols))
+	for i, c := range invCols {
+		names[i] = c.Name
+	}
+	return strings.Join(names, ",")
+}
+
+// writeParquet writes the rows of inventory columns selected by query s to w
+// as a Parquet file in row groups of groupRows.
+func writeParquet(db *sql.DB, w io.Writer, groupRows int, s string, args ...interface{}) error {
+	names := make([]string, len(invCols))
+	types := make([]parquet.Type, len(invCols))
+	for i, c := range invCols {
+		names[i], types[i] = c.Name, parquetTypes[c.Type]
+	}
+
+	rows, err := db.Query(s, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	pw := parquet.NewWriter(w, names, types)
+	pw.GroupRows = groupRows
+	vals := make([]interface{}, len(names))
+	ptrs := make([]interface{}, len(names))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		} else if err := pw.Write(vals); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return pw.Close()
+}
@@ -1,10 +1,16 @@
 package post
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/rwcarlsen/cyan/query"
 )
@@ -12,49 +18,78 @@ import (
 // The number of sql commands to buffer before dumping to the output database.
 const DumpFreq = 100000
 
+// Version is the cyan version recorded in InventoriesMeta.  Release builds
+// set it with -ldflags "-X github.com/rwcarlsen/cyan/post.Version=...".
+var Version = "dev"
+
+// MetaTable is the table recording how each simulation's inventories were
+// built: when, by which Version, the DumpFreq and Context options used, and
+// the number of entries written.
+const MetaTable = "InventoriesMeta"
+
+// DefaultMaxBufferedNodes is the MaxBufferedNodes of new Contexts.
+const DefaultMaxBufferedNodes = 4 * DumpFreq
+
+// DefaultInvTable is the name of the table inventories are built into unless
+// a Context specifies otherwise.
+const DefaultInvTable = "Inventories"
+
 var (
-	preExecStmts = []string{
+	// pragmas speed up sqlite databases for bulk writes.  They are run by
+	// Prepare before the tables of rawTables are created.
+	pragmas = []string{
 		"PRAGMA synchronous = OFF;",
 		"PRAGMA journal_mode = OFF;",
-		"CREATE TABLE IF NOT EXISTS TimeSeriesPower (SimId BLOB,AgentId INTEGER,Time INTEGER, Value REAL);",
-		"CREATE TABLE IF NOT EXISTS AgentExit (SimId BLOB,AgentId INTEGER,ExitTime INTEGER);",
-		"CREATE TABLE IF NOT EXISTS Compositions (SimId BLOB,QualId INTEGER,NucId INTEGER, MassFrac REAL);",
-		"CREATE TABLE IF NOT EXISTS Products (SimId BLOB,QualId INTEGER,Quality TEXT);",
-		"CREATE TABLE IF NOT EXISTS Resources (SimId INTEGER,ResourceId INTEGER,ObjId INTEGER,Type TEXT,TimeCreated INTEGER,Quantity REAL,Units TEXT,QualId INTEGER,Parent1 INTEGER,Parent2 INTEGER);",
-		"CREATE TABLE IF NOT EXISTS ResCreators (SimId INTEGER,ResourceId INTEGER,AgentId INTEGER);",
-		"CREATE TABLE IF NOT EXISTS Agents (SimId BLOB,AgentId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER,ExitTime INTEGER);",
-		"CREATE TABLE IF NOT EXISTS Inventories (SimId BLOB,ResourceId INTEGER,AgentId INTEGER,StartTime INTEGER,EndTime INTEGER,QualId INTEGER,Quantity REAL);",
-		"CREATE TABLE IF NOT EXISTS TimeList (SimId BLOB, Time INTEGER);",
-		"CREATE TABLE IF NOT EXISTS Transactions (SimId BLOB, TransactionId INTEGER, SenderId INTEGER, ReceiverId INTEGER, ResourceId INTEGER, Commodity TEXT, Time INTEGER);",
-		query.Index("TimeSeriesPower", "SimId", "AgentId", "Time", "Value"),
-		query.Index("TimeList", "Time"),
-		query.Index("TimeList", "SimId", "Time"),
-		query.Index("Resources", "SimId", "ResourceId", "QualId"),
-		query.Index("Compositions", "SimId", "QualId", "NucId"),
-		query.Index("Transactions", "SimId", "ResourceId"),
-		query.Index("Transactions", "TransactionId"),
-		query.Index("ResCreators", "SimId", "ResourceId"),
+	}
+	// preIndexes are the tables and columns indexed by Prepare.
+	preIndexes = [][]string{
+		{"TimeSeriesPower", "SimId", "AgentId", "Time", "Value"},
+		{"TimeList", "Time"},
+		{"TimeList", "SimId", "Time"},
+		{"Resources", "SimId", "ResourceId", "QualId"},
+		{"Compositions", "SimId", "QualId", "NucId"},
+		{"Transactions", "SimId", "ResourceId"},
+		{"Transactions", "TransactionId"},
+		{"ResCreators", "SimId", "ResourceId"},
 	}
 	postExecStmts = []string{
 		query.Index("Agents", "SimId", "Prototype"),
 		query.Index("Agents", "SimId", "AgentId", "Prototype"),
-		query.Index("Inventories", "SimId", "AgentId", "StartTime", "EndTime", "Quantity"),
-		query.Index("Inventories", "SimId", "ResourceId", "StartTime"),
-		query.Index("Inventories", "SimId", "StartTime", "EndTime", "ResourceId", "Quantity"),
-		"ANALYZE;",
 	}
-	dumpSql    = "INSERT INTO Inventories VALUES (?,?,?,?,?,?,?);"
-	resSqlHead = "SELECT ResourceId,TimeCreated,QualId,Quantity FROM "
-	resSqlTail = " WHERE Parent1 = ? OR Parent2 = ?;"
+	dumpSql    = "INSERT INTO %v VALUES (?,?,?,?,?,?,?);"
+	resSqlHead = "SELECT ResourceId,TimeCreated,QualId,Quantity,"
+
+	// defaultParentCols are the Resources columns holding the ids of a
+	// resource's parents in cyclus output.
+	defaultParentCols = []string{"Parent1", "Parent2"}
 
 	ownerSql = `SELECT tr.ReceiverId, tr.Time FROM Transactions AS tr
-				  WHERE tr.ResourceId = ? AND tr.SimId = ?
+				  WHERE tr.ResourceId = ? AND tr.SimId = ? AND tr.Time >= ? AND tr.Time < ?
 				  ORDER BY tr.Time ASC;`
 	rootsSql = `SELECT res.ResourceId,res.TimeCreated,rc.AgentId,res.QualId,Quantity FROM Resources AS res
 				  INNER JOIN ResCreators AS rc ON res.ResourceId = rc.ResourceId
-				  WHERE res.SimId = ? AND rc.SimId = ?;`
+				  WHERE res.SimId = ? AND rc.SimId = ? AND res.TimeCreated >= ? AND res.TimeCreated < ?;`
+
+	// boundarySql selects the resources created before a walk's start time
+	// that are still held at it (i.e. have no children created by then) and
+	// the resources created at or after it (but before its stop time) that
+	// have a parent created before it.  {{parents}} and {{kids}} are
+	// replaced with a clause per parent column.
+	boundarySql = `SELECT res.ResourceId,res.TimeCreated,res.QualId,res.Quantity FROM Resources AS res
+				  WHERE res.SimId = ? AND res.TimeCreated < ? AND (
+					(res.TimeCreated < ? AND res.ResourceId NOT IN ({{parents}}))
+					OR (res.TimeCreated >= ? AND ({{kids}}))
+				  ) ORDER BY res.TimeCreated,res.ResourceId;`
 )
 
+func invIndexStmts(tbl string) []string {
+	return []string{
+		query.Index(tbl, "SimId", "AgentId", "StartTime", "EndTime", "Quantity"),
+		query.Index(tbl, "SimId", "ResourceId", "StartTime"),
+		query.Index(tbl, "SimId", "StartTime", "EndTime", "ResourceId", "Quantity"),
+	}
+}
+
 func Process(db *sql.DB) (simids [][]byte, err error) {
 	err = Prepare(db)
 	if err != nil {
@@ -66,29 +101,171 @@ func Process(db *sql.DB) (simids [][]byte, err error) {
 		return nil, err
 	}
 
-	nprocessed := 0
-	for _, id := range simids {
-		ctx := NewContext(db, id)
-		if err2 := ctx.WalkAll(); err2 != nil {
-			if IsAlreadyPostErr(err2) {
-			} else {
-				err = err2
+	walkIds(db, simids, ProcessOptions{})
+	return simids, nil
+}
+
+// ProcessIds is like Process but builds inventories for only the listed
+// simulation ids in order instead of every simulation in the database.  It
+// returns the first error walking a simulation (in the order listed),
+// ignoring simulations that are already post processed.
+func ProcessIds(db *sql.DB, simids [][]byte) error {
+	return ProcessIdsWith(db, simids, ProcessOptions{})
+}
+
+// ProcessOptions configures ProcessIdsWith.
+type ProcessOptions struct {
+	// Log receives the progress of each simulation's walk at the given
+	// Verbosity.  Nothing is logged if it is nil.
+	Log       *log.Logger
+	Verbosity Verbosity
+	// Ctx optionally cancels processing.  The simulations being walked when
+	// it is cancelled are rolled back (see Context.Ctx), later simulations
+	// are skipped, and the database isn't finished.
+	Ctx context.Context
+	// Jobs is the number of simulations walked concurrently, each by its
+	// own Context.  Their writes to the database are serialized.  It
+	// defaults to 1.
+	Jobs int
+	// Workers is the Context.Workers of each simulation's walk.
+	Workers int
+	// AttachDB names a separate database file to write the inventories and
+	// other derived tables to, leaving db unmodified (see
+	// PrepareOptions.AttachDB and Context.AttachDB).  It is left attached
+	// to db, which is limited to a single connection.
+	AttachDB string
+	// Force rebuilds the inventories of simulations already post processed
+	// (see Context.Force) instead of skipping them.
+	Force bool
+	// ProgressFunc is the Context.ProgressFunc of each simulation's walk.
+	// Walks of different simulations may call it concurrently if Jobs is
+	// more than 1.
+	ProgressFunc func(Progress)
+}
+
+// ProcessIdsWith is like ProcessIds but configured by opts.
+func ProcessIdsWith(db *sql.DB, simids [][]byte, opts ProcessOptions) error {
+	if err := PrepareWith(db, PrepareOptions{AttachDB: opts.AttachDB}); err != nil {
+		return err
+	}
+	return walkIds(db, simids, opts)
+}
+
+// walkIds walks each of the simids, opts.Jobs at a time, and finishes the
+// database if any of them were processed.
+func walkIds(db *sql.DB, simids [][]byte, opts ProcessOptions) (err error) {
+	jobs := opts.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	dbmu := &sync.RWMutex{}
+	errs := make([]error, len(simids))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for j := 0; j < jobs; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if opts.Ctx != nil && opts.Ctx.Err() != nil {
+					continue
+				}
+				ctx := NewContext(db, simids[i])
+				if opts.Log != nil {
+					ctx.Log = opts.Log
+				}
+				ctx.Verbosity = opts.Verbosity
+				ctx.Ctx = opts.Ctx
+				ctx.Force = opts.Force
+				ctx.Workers = opts.Workers
+				ctx.AttachDB = opts.AttachDB
+				ctx.ProgressFunc = opts.ProgressFunc
+				ctx.dbmu = dbmu
+				errs[i] = ctx.WalkAll()
 			}
-		} else {
+		}()
+	}
+	for i := range simids {
+		if opts.Ctx != nil && opts.Ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	if opts.Ctx != nil && opts.Ctx.Err() != nil {
+		return opts.Ctx.Err()
+	}
+	nprocessed := 0
+	for _, err2 := range errs {
+		if err2 == nil {
 			nprocessed++
+		} else if !IsAlreadyPostErr(err2) && err == nil {
+			err = err2
 		}
 	}
 	if nprocessed > 0 {
-		Finish(db)
+		fopts := FinishOptions{AttachDB: opts.AttachDB}
+		if opts.Verbosity >= Verbose {
+			fopts.Log = opts.Log
+		}
+		FinishWith(db, fopts)
 	}
-	return simids, nil
+	return err
 }
 
 // Prepare creates necessary indexes and tables required for efficient
 // calculation of cyclus simulation inventory information.  Should be called
 // once before walking begins.
 func Prepare(db *sql.DB) (err error) {
-	for _, s := range preExecStmts {
+	return PrepareWith(db, PrepareOptions{})
+}
+
+// PrepareOptions configures PrepareWith.
+type PrepareOptions struct {
+	// AttachDB names a separate database file (see Context.AttachDB) to
+	// create the tables in instead of db, which isn't modified.  Only the
+	// tables missing from db are created there, and db's raw tables aren't
+	// indexed since indexes must be in the same file as their table.
+	AttachDB string
+	// Dialect is the database backend's Dialect, which defaults to SQLite.
+	// The sqlite pragmas and legacy column probe are skipped for others.
+	Dialect Dialect
+}
+
+// PrepareWith is like Prepare but configured by opts.
+func PrepareWith(db *sql.DB, opts PrepareOptions) (err error) {
+	if opts.AttachDB != "" {
+		return prepareAttached(db, opts.AttachDB)
+	}
+	d := opts.Dialect
+	if d == nil {
+		d = SQLite{}
+	}
+	var stmts []string
+	if isSQLite(d) {
+		stmts = append(stmts, pragmas...)
+	}
+	for _, t := range rawTables {
+		stmts = append(stmts, CreateTable(d, t.Name, t.Cols...))
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			log.Println("    ", err)
+		}
+	}
+
+	// legacy tables are indexed on their old column names
+	var stale []query.ColAlias
+	if isSQLite(d) {
+		if stale, err = query.StaleCols(db); err != nil {
+			return err
+		}
+	}
+	for _, idx := range preIndexes {
+		s := query.Index(idx[0], staleIndexCols(stale, idx[0], idx[1:])...)
 		if _, err := db.Exec(s); err != nil {
 			log.Println("    ", err)
 		}
@@ -96,14 +273,141 @@ func Prepare(db *sql.DB) (err error) {
 	return nil
 }
 
+// prepareAttached attaches path to db and creates the tables of rawTables
+// missing from db in it.
+func prepareAttached(db *sql.DB, path string) error {
+	if err := Attach(db, path); err != nil {
+		return err
+	}
+	for _, t := range rawTables {
+		if ok, err := query.HasTable(db, t.Name); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+		if _, err := db.Exec(CreateTable(SQLite{}, outSchema+"."+t.Name, t.Cols...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Attach attaches the separate database file at path that derived tables
+// were written to (see Context.AttachDB) to db unless it already is, so they
+// can be queried through db.  db is limited to a single connection since
+// attachments are per connection.
+func Attach(db *sql.DB, path string) error {
+	db.SetMaxOpenConns(1)
+	if ok, err := isAttached(db); err != nil || ok {
+		return err
+	}
+	_, err := db.Exec("ATTACH DATABASE ? AS "+outSchema+";", path)
+	return err
+}
+
+// isAttached returns true if a database is attached to db's connection as
+// outSchema.
+func isAttached(db *sql.DB) (attached bool, err error) {
+	rows, err := db.Query("PRAGMA database_list;")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var seq int
+		var name, file string
+		if err := rows.Scan(&seq, &name, &file); err != nil {
+			return false, err
+		}
+		attached = attached || name == outSchema
+	}
+	return attached, rows.Err()
+}
+
 // Finish should be called for a cyclus database after all walkers have
 // completed processing inventory data. It creates final indexes and other
-// finishing tasks.
-func Finish(db *sql.DB) (err error) {
-	for _, s := range postExecStmts {
+// finishing tasks.  The inventory tables to index may be listed and default
+// to just DefaultInvTable.
+func Finish(db *sql.DB, tables ...string) (err error) {
+	return FinishWith(db, FinishOptions{}, tables...)
+}
+
+// FinishOptions configures FinishWith.
+type FinishOptions struct {
+	// Log receives the duration of each finishing statement.  Nothing is
+	// logged if it is nil.
+	Log *log.Logger
+	// SkipAnalyze skips gathering query planner statistics with ANALYZE -
+	// usually the slowest step for large databases.
+	SkipAnalyze bool
+	// AttachDB names the separate database file the walks wrote to (see
+	// Context.AttachDB), which is attached to db if it isn't already and
+	// indexed instead of db.
+	AttachDB string
+}
+
+// FinishWith is like Finish but configured by opts.
+func FinishWith(db *sql.DB, opts FinishOptions, tables ...string) (err error) {
+	if len(tables) == 0 {
+		tables = []string{DefaultInvTable}
+	}
+	if opts.Log == nil {
+		opts.Log = log.New(NullWriter{}, "", 0)
+	}
+
+	stmts := append([]string{}, postExecStmts...)
+	for _, tbl := range tables {
+		stmts = append(stmts, invIndexStmts(tbl)...)
+	}
+	if opts.AttachDB != "" {
+		if err := Attach(db, opts.AttachDB); err != nil {
+			return err
+		}
+		for i, s := range stmts {
+			stmts[i] = strings.Replace(s, "IF NOT EXISTS ", "IF NOT EXISTS "+outSchema+".", 1)
+		}
+	}
+	if !opts.SkipAnalyze && opts.AttachDB != "" {
+		stmts = append(stmts, "ANALYZE "+outSchema+";")
+	} else if !opts.SkipAnalyze {
+		stmts = append(stmts, "ANALYZE;")
+	}
+
+	for _, s := range stmts {
+		start := time.Now()
 		if _, err := db.Exec(s); err != nil {
 			return err
 		}
+		opts.Log.Printf("%v (%v)\n", s, time.Since(start))
+	}
+	return nil
+}
+
+// BoundEndTimes replaces the math.MaxInt32 EndTime sentinel of inventory
+// entries still held at the end of a simulation with that simulation's real
+// end time: one past the last timestep in TimeList, or one past the owning
+// agent's ExitTime if it exited earlier.  Since EndTime is exclusive, bounded
+// entries are still counted as held at the final timestep.  This is optional
+// and should be run after Finish - consumers that rely on the sentinel
+// should not call it.  The inventory tables to update may be listed and
+// default to just DefaultInvTable.
+func BoundEndTimes(db *sql.DB, tables ...string) error {
+	if len(tables) == 0 {
+		tables = []string{DefaultInvTable}
+	}
+
+	for _, tbl := range tables {
+		s := `UPDATE {{.}} SET EndTime = MIN(
+					(SELECT MAX(tl.Time)+1 FROM TimeList AS tl WHERE tl.SimId = {{.}}.SimId),
+					IFNULL((SELECT ag.ExitTime+1 FROM Agents AS ag
+							WHERE ag.SimId = {{.}}.SimId AND ag.AgentId = {{.}}.AgentId), ?)
+				)
+				WHERE EndTime = ?
+					AND EXISTS (SELECT * FROM TimeList AS tl WHERE tl.SimId = {{.}}.SimId);`
+		s = strings.Replace(s, "{{.}}", tbl, -1)
+		if _, err := db.Exec(s, math.MaxInt32, math.MaxInt32); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -117,31 +421,197 @@ type Node struct {
 	Quantity  float64
 }
 
+// WalkStats summarizes the work done building a simulation's inventories.
+type WalkStats struct {
+	// Roots is the number of root resources walked from.
+	Roots int
+	// Resources is the number of distinct resources walked.
+	Resources int
+	// SplitNodes is the number of extra inventory entries created for
+	// resources changing owners.
+	SplitNodes int
+	// RowsWritten is the number of entries dumped to the inventory table.
+	RowsWritten int
+	// Dumps is the number of batches entries were dumped in.
+	Dumps int
+	// Phases holds the total time spent in each phase of the walk (init,
+	// roots, walk, and dump).  Dump time is also included in walk time.
+	Phases map[string]time.Duration
+}
+
+// Progress reports how far a walk has got.
+type Progress struct {
+	Simid []byte
+	// Roots is the number of root resources walked from and RootsDone the
+	// number whose genealogies have been walked so far.
+	Roots, RootsDone int
+	// Resources is the number of distinct resources walked so far.
+	Resources int
+	// Elapsed is the time spent walking roots so far and ETA the estimated
+	// time remaining, extrapolated from the rate roots have been walked at.
+	Elapsed, ETA time.Duration
+}
+
 // Context encapsulates the logic for building a fast, queryable inventories
 // table for a specific simulation from raw cyclus output database.
 type Context struct {
 	*sql.DB
 	// Simid is the cyclus simulation id targeted by this context.  Must be
 	// set.
-	Simid       []byte
-	Log         *log.Logger
-	mappednodes map[int32]struct{}
-	tmpResTbl   string
-	tmpResStmt  *sql.Stmt
-	dumpStmt    *sql.Stmt
-	ownerStmt   *sql.Stmt
-	resCount    int
-	nodes       []*Node
+	Simid []byte
+	// TableName is the table inventories are built into.  It is created if
+	// it doesn't exist and defaults to DefaultInvTable.
+	TableName string
+	// Dialect renders the context's SQL statements for the database backend.
+	// It defaults to SQLite.
+	Dialect Dialect
+	// Log receives progress messages at or below Verbosity.
+	Log       *log.Logger
+	Verbosity Verbosity
+	// RetryAttempts is the number of times a statement failing because the
+	// database is busy or locked (e.g. by another process) is retried with
+	// exponential backoff before giving up.  Transaction commits are never
+	// retried.
+	RetryAttempts int
+	// DryRun walks the simulation's resources and records Stats without
+	// creating or writing to the inventory, Agents, or TimeList tables.
+	DryRun bool
+	// Stats holds statistics for the most recent WalkAll.
+	Stats WalkStats
+	// Force rebuilds the simid's inventories if they were already built
+	// instead of failing with AlreadyPostErr.  The existing entries are
+	// deleted when the walk starts, so they are lost if it then fails.
+	Force bool
+	// ProgressFunc, if not nil, is called with the walk's Progress after
+	// each root's genealogy is walked.  Calls are serialized even with
+	// several Workers, and delay the walk until they return.
+	ProgressFunc func(Progress)
+	// Workers is the number of goroutines used to walk root resource
+	// genealogies concurrently.  Values less than 2 walk serially.  Walks
+	// build the same inventories whatever the number of workers.
+	Workers int
+	// ParentCols lists the Resources columns holding the ids of each
+	// resource's parents, for schemas allowing more than cyclus' two.  It
+	// defaults to Parent1 and Parent2.  The first column is the parent
+	// whose owner a child inherits when walking from StartTime.
+	ParentCols []string
+	// StartTime restricts the walk to the window of the simulation at and
+	// after the given timestep, so inventories for earlier times can be
+	// reused from a previous build.  Only resources created in the window
+	// are scanned as children, and ownership changes before it are ignored.
+	// Resources created before the window but still held at its start are
+	// walked too, with entries starting at StartTime and owned by whoever
+	// held them then.  Every entry of a windowed build matches the full
+	// build's entry with its StartTime clamped to the window.
+	StartTime int
+	// StopTime, if positive, ends the window of the simulation walked before
+	// the given timestep.  Resources created at or after it are left out of
+	// the temporary resource table and walk, and entries still held at
+	// StopTime end there instead of at math.MaxInt32.  It composes with
+	// StartTime: entries of a build windowed by both match the full build's
+	// entries clamped to [StartTime, StopTime).
+	StopTime int
+	// Debug enables internal consistency checks during the walk.  WalkAll
+	// fails if the walk produces an inventory entry ending before it
+	// starts instead of silently dropping it.
+	Debug bool
+	// RootsSQL optionally replaces the query selecting the root resources
+	// walks begin from, e.g. to build partial inventories for resources
+	// created by a subset of agents.  It must select the columns ResourceId,
+	// TimeCreated, OwnerId, QualId, and Quantity in that order, and every
+	// '?' placeholder in it is bound to Simid.  StartTime does not apply to
+	// custom roots.
+	RootsSQL string
+	// Ctx optionally cancels building the context's inventories.  The
+	// walk's statements are run with it, so cancellation is observed before
+	// each statement and while reading query results, though the database
+	// driver can't interrupt a statement already executing (e.g. building
+	// the temporary resource table).  WalkAll then fails with Ctx's error,
+	// the open transaction is rolled back, and any entries already dumped
+	// for the simulation are deleted.
+	Ctx context.Context
+	// SortDumps sorts each batch of inventory entries by resource id and
+	// start time before writing it so repeated builds of a database produce
+	// identical tables.  Batches (of up to DumpFreq resources per walker) are
+	// sorted independently, and the final batches of all Workers are merged
+	// into one, so the table is completely ordered only if the simulation
+	// has fewer than DumpFreq resources.
+	SortDumps bool
+	// MaxBufferedNodes bounds the number of inventory entries each walker
+	// buffers before dumping them, in addition to dumping every DumpFreq
+	// resources walked.  Resources changing owners many times produce an
+	// entry per owner, so without it a simulation with heavy ownership
+	// churn can buffer far more than DumpFreq entries.  Values less than 1
+	// disable the bound.
+	MaxBufferedNodes int
+	// AttachDB optionally names a separate database file that the
+	// temporary resource table and the inventory, Agents, and TimeList
+	// tables are written to instead of the context's database, which is
+	// then only read from - e.g. when it is opened read-only with a
+	// "file:<path>?mode=ro" dsn.  The file is attached to the context's
+	// database (created if needed), which is limited to a single
+	// connection since attachments are per connection.  The database's
+	// previous connection limit is restored by Close.  The source database
+	// must not contain the output tables itself, and Finish should be run on
+	// a connection to the attached file.
+	AttachDB string
+	// prevMaxOpen is the connection limit of DB before attach, -1 if it was
+	// unlimited or 0 if it hasn't been changed.
+	prevMaxOpen int
+	// stale holds the columns of the raw tables named as in a legacy
+	// schema, which are read under their current names.
+	stale []query.ColAlias
+	// visited tracks the resources walked by a serial walk and shared
+	// those walked by parallel workers.
+	visited    visitSet
+	shared     *VisitedSet
+	tmpResTbl  string
+	tmpResStmt *sql.Stmt
+	dumpStmt   *sql.Stmt
+	ownerStmt  *sql.Stmt
+	resCount   int
+	timer      *Timer
+	// progmu serializes ProgressFunc calls counting rootsDone roots walked
+	// since walkStart.
+	progmu    sync.Mutex
+	rootsDone int
+	walkStart time.Time
+	// dbmu keeps walkers' reads from contending with writes for the
+	// database lock.  It is shared by the contexts of simulations walked
+	// concurrently by ProcessIdsWith so that they take turns writing.
+	dbmu *sync.RWMutex
 }
 
 func NewContext(db *sql.DB, simid []byte) *Context {
 	return &Context{
-		DB:    db,
-		Simid: simid,
-		Log:   log.New(NullWriter{}, "", 0),
+		DB:               db,
+		Simid:            simid,
+		TableName:        DefaultInvTable,
+		Dialect:          SQLite{},
+		Log:              log.New(NullWriter{}, "", 0),
+		MaxBufferedNodes: DefaultMaxBufferedNodes,
+		dbmu:             &sync.RWMutex{},
 	}
 }
 
+// Verbosity is the level of detail of the progress messages logged while
+// building inventories.
+type Verbosity int
+
+const (
+	// Silent logs nothing.
+	Silent Verbosity = iota - 1
+	// Normal logs the start of each phase of a walk and each dump of
+	// inventory entries.  It is the default.
+	Normal
+	// Verbose also logs each root walked from and the time taken by each
+	// statement building the temporary resource table.
+	Verbose
+)
+
+// ErrNoSimid is returned by WalkAll for contexts without a Simid.
+var ErrNoSimid = errors.New("Context.Simid must be set")
+
 type AlreadyPostErr []byte
 
 func (s AlreadyPostErr) Error() string {
@@ -149,211 +619,1071 @@ func (s AlreadyPostErr) Error() string {
 }
 
 func IsAlreadyPostErr(err error) bool {
-	_, ok := err.(AlreadyPostErr)
-	return ok
+	var e AlreadyPostErr
+	return errors.As(err, &e)
 }
 
-func (c *Context) init() {
-	// skip if the post processing already exists for this simid in the db
-	dummy := 0
-	err := c.QueryRow("SELECT AgentId FROM Agents WHERE SimId = ? LIMIT 1", c.Simid).Scan(&dummy)
+// WalkError describes a failure building a simulation's inventories.  Op is
+// the function that failed, e.g. "post.(*walker).walkDown", and ResId the
+// resource it was working on, or 0 if the failure wasn't specific to one.
+// Cancellation and AlreadyPostErr errors are returned as is instead.
+type WalkError struct {
+	Simid []byte
+	ResId int
+	Op    string
+	Err   error
+}
+
+func (e *WalkError) Error() string {
+	if e.ResId == 0 {
+		return fmt.Sprintf("%v: simid %x: %v", e.Op, e.Simid, e.Err)
+	}
+	return fmt.Sprintf("%v: simid %x resource %v: %v", e.Op, e.Simid, e.ResId, e.Err)
+}
+
+func (e *WalkError) Unwrap() error { return e.Err }
+
+// walkErr returns err as a WalkError for resource id (0 for none) failing in
+// the function calling walkErr, or nil if err is nil.
+func (c *Context) walkErr(err error, id int) error {
+	return c.opErr(caller(2), err, id)
+}
+
+// opErr returns err as a WalkError for resource id failing in function op,
+// or nil if err is nil.  Statements failing because c.Ctx is done return
+// its error as is.
+func (c *Context) opErr(op string, err error, id int) error {
 	if err == nil {
-		panic(AlreadyPostErr(c.Simid))
-	} else if err != sql.ErrNoRows {
-		panicif(err)
+		return nil
+	} else if c.Ctx != nil && c.Ctx.Err() != nil && errors.Is(err, c.Ctx.Err()) {
+		return c.Ctx.Err()
 	}
+	return &WalkError{Simid: c.Simid, ResId: id, Op: op, Err: err}
+}
 
-	tx, err := c.Begin()
-	panicif(err)
+// init prepares the context for walking.  It returns false if the simid has
+// no resources to walk, in which case no temporary tables are created.
+// Legacy cyclus column names are only probed for in sqlite databases.
+func (c *Context) init() (ok bool, err error) {
+	if isSQLite(c.Dialect) {
+		err = c.retry(func() (err error) {
+			c.stale, err = query.StaleCols(c.DB)
+			return err
+		})
+		if err != nil {
+			return false, c.walkErr(err, 0)
+		}
+	}
+	if c.AttachDB != "" {
+		if err := c.attach(); err != nil {
+			return false, err
+		}
+	}
+	if c.RootsSQL != "" {
+		if err := c.checkRoots(); err != nil {
+			return false, err
+		}
+	}
 
-	// build Agents table
-	sql := `INSERT INTO Agents
-				SELECT n.SimId,n.AgentId,n.Kind,n.Spec,n.Prototype,n.ParentId,n.Lifetime,n.EnterTime,x.ExitTime
-				FROM
-					AgentEntry AS n
-					LEFT JOIN AgentExit AS x ON n.AgentId = x.AgentId AND n.SimId = x.SimId
-					WHERE n.SimId = ?;`
-	_, err = tx.Exec(sql, c.Simid)
-	panicif(err)
+	dummy := 0
+	rebuild := false
+	if !c.DryRun {
+		if err := c.exec(c.DB, CreateTable(c.Dialect, c.out(c.TableName), invCols...)); err != nil {
+			return false, err
+		}
 
-	c.nodes = make([]*Node, 0, 10000)
-	c.mappednodes = map[int32]struct{}{}
+		// skip if the post processing already exists for this simid in the db
+		err := c.retry(func() error {
+			return c.QueryRowContext(c.ctx(), c.sql("SELECT ResourceId FROM "+c.out(c.TableName)+" WHERE SimId = ? LIMIT 1"), c.Simid).Scan(&dummy)
+		})
+		if err == nil && c.Force {
+			rebuild = true
+		} else if err == nil {
+			return false, AlreadyPostErr(c.Simid)
+		} else if err != sql.ErrNoRows {
+			return false, c.walkErr(err, 0)
+		}
+	}
 
-	// build TimeList table
-	sql = "SELECT Duration FROM Info WHERE SimId = ?;"
-	rows, err := tx.Query(sql, c.Simid)
-	panicif(err)
-	defer rows.Close()
-	for rows.Next() {
-		var dur int
-		panicif(rows.Scan(&dur))
-		for i := 0; i < dur; i++ {
-			_, err := tx.Exec("INSERT INTO TimeList VALUES (?, ?);", c.Simid, i)
-			panicif(err)
+	var tx *sql.Tx
+	err = c.retry(func() (err error) {
+		tx, err = c.BeginTx(c.ctx(), nil)
+		return err
+	})
+	if err != nil {
+		return false, c.walkErr(err, 0)
+	}
+	defer tx.Rollback() // no-op once committed
+
+	if rebuild {
+		c.logf(Normal, "Deleting existing inventories for simid %x...\n", c.Simid)
+		if err := c.exec(tx, c.sql("DELETE FROM "+c.out(c.TableName)+" WHERE SimId = ?"), c.Simid); err != nil {
+			return false, err
 		}
 	}
-	panicif(rows.Err())
+
+	// Agents and TimeList are shared by all inventory tables of a simid
+	built := false
+	if !c.DryRun {
+		err = c.retry(func() error {
+			return tx.QueryRowContext(c.ctx(), c.sql("SELECT AgentId FROM "+c.out("Agents")+" WHERE SimId = ? LIMIT 1"), c.Simid).Scan(&dummy)
+		})
+		if err == sql.ErrNoRows {
+			if err := c.checkCancel(); err != nil {
+				return false, err
+			}
+			if err := c.buildAgents(tx); err != nil {
+				return false, err
+			}
+			built = true
+		} else if err != nil {
+			return false, c.walkErr(err, 0)
+		}
+	}
+
+	err = c.retry(func() error {
+		return tx.QueryRowContext(c.ctx(), c.sql("SELECT ResourceId FROM Resources WHERE SimId = ? LIMIT 1"), c.Simid).Scan(&dummy)
+	})
+	if err == sql.ErrNoRows {
+		if err := tx.Commit(); err != nil {
+			return false, c.walkErr(err, 0)
+		}
+		if !built && !c.DryRun {
+			// nothing to walk and agents were already built by a prior walk
+			return false, AlreadyPostErr(c.Simid)
+		}
+		return false, nil
+	} else if err != nil {
+		return false, c.walkErr(err, 0)
+	}
 
 	// create temp res table without simid
-	c.Log.Println("Creating temporary resource table...")
+	if err := c.checkCancel(); err != nil {
+		return false, err
+	}
+	c.logf(Normal, "Creating temporary resource table...\n")
+	start := time.Now()
 	c.tmpResTbl = "tmp_restbl_" + fmt.Sprintf("%x", c.Simid)
-	_, err = tx.Exec("DROP TABLE IF EXISTS " + c.tmpResTbl)
-	panicif(err)
+	if err := c.exec(tx, "DROP TABLE IF EXISTS "+c.out(c.tmpResTbl)); err != nil {
+		return false, err
+	}
 
-	sql = "CREATE TABLE " + c.tmpResTbl + " AS SELECT ResourceId,TimeCreated,Parent1,Parent2,QualId,Quantity FROM Resources WHERE SimId = ?;"
-	_, err = tx.Exec(sql, c.Simid)
-	panicif(err)
+	cols := c.parentCols()
+	s := "CREATE TABLE " + c.out(c.tmpResTbl) + " AS SELECT ResourceId,TimeCreated," + strings.Join(cols, ",") + ",QualId,Quantity FROM Resources WHERE SimId = ? AND TimeCreated >= ? AND TimeCreated < ?;"
+	if err := c.exec(tx, c.sql(s), c.Simid, c.StartTime, c.stopTime()); err != nil {
+		return false, err
+	}
+	c.logf(Verbose, "Created temporary resource table (%v)\n", time.Since(start))
 
-	c.Log.Println("Indexing temporary resource table...")
-	_, err = tx.Exec(query.Index(c.tmpResTbl, "Parent1"))
-	panicif(err)
+	for _, col := range cols {
+		if err := c.checkCancel(); err != nil {
+			return false, err
+		}
+		c.logf(Normal, "Indexing temporary resource table on %v...\n", col)
+		start = time.Now()
+		if err := c.exec(tx, c.index(c.tmpResTbl, col)); err != nil {
+			return false, err
+		}
+		c.logf(Verbose, "Indexed temporary resource table on %v (%v)\n", col, time.Since(start))
+	}
 
-	_, err = tx.Exec(query.Index(c.tmpResTbl, "Parent2"))
-	panicif(err)
+	var min, max, count int
+	err = tx.QueryRowContext(c.ctx(), "SELECT COALESCE(MIN(ResourceId),0),COALESCE(MAX(ResourceId),0),COUNT(*) FROM "+c.out(c.tmpResTbl)).Scan(&min, &max, &count)
+	if err != nil {
+		return false, c.walkErr(err, 0)
+	}
+	if c.Workers > 1 {
+		c.shared = NewVisitedSet(min, max, count)
+	} else {
+		c.visited = newVisitSet(min, max, count)
+	}
 
-	tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return false, c.walkErr(err, 0)
+	}
 
 	// create prepared statements
-	c.tmpResStmt, err = c.Prepare(resSqlHead + c.tmpResTbl + resSqlTail)
-	panicif(err)
+	if c.tmpResStmt, err = c.prepare(resSql(c.tmpResTbl, cols)); err != nil {
+		return false, err
+	}
+	if !c.DryRun {
+		if c.dumpStmt, err = c.prepare(fmt.Sprintf(dumpSql, c.out(c.TableName))); err != nil {
+			return false, err
+		}
+	}
+	if c.ownerStmt, err = c.prepare(ownerSql); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// logf logs a progress message if c.Verbosity is at least v.
+func (c *Context) logf(v Verbosity, format string, args ...interface{}) {
+	if c.Verbosity >= v {
+		c.Log.Printf(format, args...)
+	}
+}
+
+// parentCols returns the Resources columns holding resources' parents.
+func (c *Context) parentCols() []string {
+	if len(c.ParentCols) == 0 {
+		return defaultParentCols
+	}
+	return c.ParentCols
+}
+
+// resSql returns a statement selecting the children of a resource, their
+// first parents, and whether they have other parents (i.e. were combined)
+// from table, with a placeholder for the resource's id per parent column.
+func resSql(table string, cols []string) string {
+	combined := "0"
+	if len(cols) > 1 {
+		combined = "(COALESCE(" + strings.Join(cols[1:], ",0) != 0 OR COALESCE(") + ",0) != 0)"
+	}
+	return resSqlHead + cols[0] + "," + combined + " FROM " + table + " WHERE " + strings.Join(cols, " = ? OR ") + " = ?;"
+}
+
+// stopTime returns the (exclusive) end of the window walked.
+func (c *Context) stopTime() int {
+	if c.StopTime > 0 {
+		return c.StopTime
+	}
+	return math.MaxInt32
+}
 
-	c.dumpStmt, err = c.Prepare(dumpSql)
-	panicif(err)
+// rootCols is the number of columns a roots query must select.
+const rootCols = 5
 
-	c.ownerStmt, err = c.Prepare(ownerSql)
-	panicif(err)
+// rootsQuery returns the statement and arguments for selecting root
+// resources.
+func (c *Context) rootsQuery() (s string, args []interface{}) {
+	if c.RootsSQL == "" {
+		return rootsSql, []interface{}{c.Simid, c.Simid, c.StartTime, c.stopTime()}
+	}
+	for i := 0; i < strings.Count(c.RootsSQL, "?"); i++ {
+		args = append(args, c.Simid)
+	}
+	return c.RootsSQL, args
+}
+
+// checkRoots returns an error if c.RootsSQL is invalid or doesn't select the
+// expected number of columns.  The query is wrapped to select no rows so
+// validating it doesn't scan the database.
+func (c *Context) checkRoots() error {
+	s, args := c.rootsQuery()
+	s = "SELECT * FROM (" + strings.TrimRight(strings.TrimSpace(s), ";") + ") AS roots LIMIT 0"
+	var rows *sql.Rows
+	err := c.retry(func() (err error) {
+		rows, err = c.QueryContext(c.ctx(), c.sql(s), args...)
+		return err
+	})
+	if err != nil {
+		return c.walkErr(fmt.Errorf("invalid RootsSQL: %w", err), 0)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return c.walkErr(err, 0)
+	} else if len(cols) != rootCols {
+		return c.walkErr(fmt.Errorf("RootsSQL must select %v columns (ResourceId, TimeCreated, OwnerId, QualId, Quantity), got %v %v", rootCols, len(cols), cols), 0)
+	}
+	return nil
+}
+
+// outSchema is the schema name Context.AttachDB is attached as.
+const outSchema = "cyan_out"
+
+// attach attaches c.AttachDB to the context's database if it isn't already
+// and creates the Agents and TimeList tables in it.
+func (c *Context) attach() error {
+	if c.prevMaxOpen == 0 {
+		c.prevMaxOpen = c.DB.Stats().MaxOpenConnections
+		if c.prevMaxOpen == 0 {
+			c.prevMaxOpen = -1 // unlimited
+		}
+	}
+	c.DB.SetMaxOpenConns(1)
+
+	var attached bool
+	err := c.retry(func() (err error) {
+		attached, err = isAttached(c.DB)
+		return err
+	})
+	if err != nil {
+		return c.walkErr(err, 0)
+	}
+	if !attached {
+		if err := c.exec(c.DB, "ATTACH DATABASE ? AS "+outSchema+";", c.AttachDB); err != nil {
+			return err
+		}
+	}
+	if !c.DryRun {
+		if err := c.exec(c.DB, CreateTable(c.Dialect, c.out("Agents"), agentCols...)); err != nil {
+			return err
+		}
+		return c.exec(c.DB, CreateTable(c.Dialect, c.out("TimeList"), timeCols...))
+	}
+	return nil
+}
+
+// out returns the name of a table written by the walk qualified with the
+// attached output schema if there is one.
+func (c *Context) out(table string) string {
+	if c.AttachDB == "" {
+		return table
+	}
+	return outSchema + "." + table
+}
+
+// index returns a statement creating an index on cols of a table written by
+// the walk.
+func (c *Context) index(table string, cols ...string) string {
+	s := query.Index(table, cols...)
+	if c.AttachDB == "" {
+		return s
+	}
+	return strings.Replace(s, "IF NOT EXISTS ", "IF NOT EXISTS "+outSchema+".", 1)
+}
+
+// ctx returns the context the walk's statements run with: c.Ctx, or the
+// background context if it is nil.
+func (c *Context) ctx() context.Context {
+	if c.Ctx == nil {
+		return context.Background()
+	}
+	return c.Ctx
+}
+
+// checkCancel returns the cancellation error if c.Ctx is done.
+func (c *Context) checkCancel() error {
+	if c.Ctx == nil {
+		return nil
+	}
+	select {
+	case <-c.Ctx.Done():
+		return c.Ctx.Err()
+	default:
+		return nil
+	}
+}
+
+// sql renders statement s for the context's dialect.
+func (c *Context) sql(s string) string { return Bind(c.Dialect, query.AliasSql(s, c.stale)) }
+
+// retryWait is how long the first retry of a statement failing because the
+// database is busy waits.  Each subsequent retry waits twice as long.
+var retryWait = 10 * time.Millisecond
+
+// retry calls f until it succeeds, fails for a reason other than the
+// database being busy or locked, or c.RetryAttempts retries have been made.
+// Transaction commits can't be retried and should not be run by f.
+func (c *Context) retry(f func() error) error {
+	wait := retryWait
+	for i := 0; ; i++ {
+		err := f()
+		if err == nil || i >= c.RetryAttempts || !isBusy(err) {
+			return err
+		}
+		c.logf(Normal, "    Database busy (%v), retrying in %v...\n", err, wait)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// exec runs statement s on e, retrying while the database is busy.  Errors
+// are WalkErrors of the function calling exec.
+func (c *Context) exec(e execer, s string, args ...interface{}) error {
+	err := c.retry(func() error {
+		_, err := e.ExecContext(c.ctx(), s, args...)
+		return err
+	})
+	return c.opErr(caller(2), err, 0)
+}
+
+// prepare creates a prepared statement from s rendered for the context's
+// dialect.  Errors are WalkErrors of the function calling prepare.
+func (c *Context) prepare(s string) (stmt *sql.Stmt, err error) {
+	err = c.retry(func() (err error) {
+		stmt, err = c.PrepareContext(c.ctx(), c.sql(s))
+		return err
+	})
+	return stmt, c.opErr(caller(2), err, 0)
+}
+
+// buildAgents fills the Agents and TimeList tables for the simid.
+func (c *Context) buildAgents(tx *sql.Tx) error {
+	s := `INSERT INTO ` + c.out("Agents") + `
+				SELECT n.SimId,n.AgentId,n.Kind,n.Spec,n.Prototype,n.ParentId,n.Lifetime,n.EnterTime,x.ExitTime
+				FROM
+					AgentEntry AS n
+					LEFT JOIN AgentExit AS x ON n.AgentId = x.AgentId AND n.SimId = x.SimId
+					WHERE n.SimId = ?;`
+	if err := c.exec(tx, c.sql(s), c.Simid); err != nil {
+		return err
+	}
+
+	s = "SELECT Duration FROM Info WHERE SimId = ?;"
+	var rows *sql.Rows
+	err := c.retry(func() (err error) {
+		rows, err = tx.QueryContext(c.ctx(), c.sql(s), c.Simid)
+		return err
+	})
+	if err != nil {
+		return c.walkErr(err, 0)
+	}
+	var durs []int
+	for rows.Next() {
+		var dur int
+		if err := rows.Scan(&dur); err != nil {
+			rows.Close()
+			return c.walkErr(err, 0)
+		}
+		durs = append(durs, dur)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return c.walkErr(err, 0)
+	}
+
+	for _, dur := range durs {
+		for i := 0; i < dur; i++ {
+			if err := c.exec(tx, c.sql("INSERT INTO "+c.out("TimeList")+" VALUES (?, ?);"), c.Simid, i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // WalkAll constructs the inventories table in the cyclus database alongside
 // other tables. Creates several indexes in the process.  Finish should be
 // called on the database connection after all simulation id's have been
-// walked.
+// walked.  Failures are returned as WalkErrors unless the walk was
+// cancelled or the simulation was already post processed.
 func (c *Context) WalkAll() (err error) {
+	return c.walk(c.getRoots)
+}
+
+// WalkFrom is like WalkAll but walks only the genealogies of the resources
+// with the given ids (e.g. flagged by an earlier analysis) instead of those of
+// every root resource.  Each is walked from its creation, owned by whoever
+// held it then.  Descendants created outside the window set by StartTime and
+// StopTime are still left out.
+func (c *Context) WalkFrom(ids []int) (err error) {
+	return c.walk(func() ([]*Node, error) { return c.seedRoots(ids) })
+}
+
+// walk builds the simid's inventories by walking the genealogies of the
+// resources returned by roots.
+func (c *Context) walk(getRoots func() ([]*Node, error)) (err error) {
+	if len(c.Simid) == 0 {
+		return ErrNoSimid
+	}
+	// deferred calls run in reverse: delete partial output of a failed
+	// walk, then close the context
 	defer func() {
-		if r := recover(); r != nil {
-			if er, ok := r.(error); ok {
-				err = er
-			} else {
-				err = fmt.Errorf("%v", r)
-			}
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	defer func() {
+		if err != nil && c.Stats.Dumps > 0 && !c.DryRun {
+			c.writeLocked(func() error { c.deletePartial(); return nil })
 		}
 	}()
 
-	c.Log.Printf("--- Building inventories for simid %x ---\n", c.Simid)
-	c.init()
+	c.Stats = WalkStats{}
+	c.timer = NewTimer()
+	defer func() {
+		c.Stats.Resources = c.walked()
+		c.Stats.Phases = c.timer.Totals
+	}()
 
-	c.Log.Println("Retrieving root resource nodes...")
-	roots := c.getRoots()
+	c.logf(Normal, "--- Building inventories for simid %x ---\n", c.Simid)
+	c.timer.Start("init")
+	var ok bool
+	err = c.writeLocked(func() (err error) {
+		ok, err = c.init()
+		return err
+	})
+	c.timer.Stop("init")
+	if err != nil {
+		return err
+	} else if !ok {
+		c.logf(Normal, "SimId %x has no resources, skipping\n", c.Simid)
+		return nil
+	}
 
-	c.Log.Printf("Found %v root nodes\n", len(roots))
-	for i, n := range roots {
-		c.Log.Printf("    Processing root %d...\n", i)
-		c.walkDown(n)
+	c.logf(Normal, "Retrieving root resource nodes...\n")
+	c.timer.Start("roots")
+	roots, err := getRoots()
+	c.timer.Stop("roots")
+	if err != nil {
+		return err
 	}
+	c.Stats.Roots = len(roots)
 
-	c.Log.Println("Dropping temporary resource table...")
-	_, err = c.Exec("DROP TABLE " + c.tmpResTbl)
-	panicif(err)
+	c.logf(Normal, "Found %v root nodes\n", len(roots))
+	c.timer.Start("walk")
+	c.rootsDone, c.walkStart = 0, time.Now()
+	walkers, err := c.walkRoots(roots)
+	if err != nil {
+		return err
+	}
 
-	c.dumpNodes()
+	c.logf(Normal, "Dropping temporary resource table...\n")
+	if err := c.writeLocked(c.dropTmp); err != nil {
+		return c.walkErr(err, 0)
+	}
 
+	if c.SortDumps {
+		for _, w := range walkers[1:] {
+			walkers[0].nodes = append(walkers[0].nodes, w.nodes...)
+			w.nodes = w.nodes[:0]
+		}
+	}
+	for _, w := range walkers {
+		if err := w.dumpNodes(); err != nil {
+			return err
+		}
+		c.Stats.SplitNodes += w.splits
+	}
+	c.timer.Stop("walk")
+
+	if !c.DryRun {
+		return c.writeLocked(c.writeMeta)
+	}
 	return nil
 }
 
-func (c *Context) getRoots() (roots []*Node) {
-	sql := "SELECT COUNT(*) FROM ResCreators WHERE SimId = ?"
-	row := c.QueryRow(sql, c.Simid)
+// writeLocked calls f holding dbmu exclusively and returns its error.
+func (c *Context) writeLocked(f func() error) error {
+	c.dbmu.Lock()
+	defer c.dbmu.Unlock()
+	return f()
+}
+
+// writeMeta records the walk in MetaTable, replacing any earlier record for
+// the simid and inventory table.  The caller must hold dbmu.
+func (c *Context) writeMeta() error {
+	if err := c.exec(c.DB, CreateTable(c.Dialect, c.out(MetaTable), metaCols...)); err != nil {
+		return err
+	}
+	opts := fmt.Sprintf("StartTime=%v StopTime=%v ParentCols=%v RootsSQL=%q Workers=%v SortDumps=%v MaxBufferedNodes=%v",
+		c.StartTime, c.StopTime, strings.Join(c.parentCols(), ","), c.RootsSQL, c.Workers, c.SortDumps, c.MaxBufferedNodes)
+
+	var tx *sql.Tx
+	err := c.retry(func() (err error) {
+		tx, err = c.BeginTx(c.ctx(), nil)
+		return err
+	})
+	if err != nil {
+		return c.walkErr(err, 0)
+	}
+	defer tx.Rollback() // no-op once committed
+	if err := c.exec(tx, c.sql("DELETE FROM "+c.out(MetaTable)+" WHERE SimId = ? AND InvTable = ?;"), c.Simid, c.TableName); err != nil {
+		return err
+	}
+	err = c.exec(tx, c.sql("INSERT INTO "+c.out(MetaTable)+" VALUES (?,?,?,?,?,?,?);"),
+		c.Simid, c.TableName, time.Now().UTC().Format(time.RFC3339), Version, DumpFreq, opts, c.Stats.RowsWritten)
+	if err != nil {
+		return err
+	}
+	return c.walkErr(tx.Commit(), 0)
+}
+
+// Close finalizes the context's prepared statements and drops its temporary
+// resource table if it exists, and restores the database's connection limit
+// if AttachDB changed it.  WalkAll calls Close when it returns, so it
+// is only needed to clean up after a walk interrupted in another way.  It is
+// safe to call Close more than once.
+func (c *Context) Close() error {
+	for _, stmt := range []**sql.Stmt{&c.tmpResStmt, &c.dumpStmt, &c.ownerStmt} {
+		if *stmt != nil {
+			(*stmt).Close()
+			*stmt = nil
+		}
+	}
+	c.dbmu.Lock()
+	defer c.dbmu.Unlock()
+	err := c.dropTmp()
+	if c.prevMaxOpen != 0 {
+		c.DB.SetMaxOpenConns(c.prevMaxOpen)
+		c.prevMaxOpen = 0
+	}
+	return err
+}
+
+// deletePartial deletes the entries dumped by a failed walk so the
+// simulation isn't mistaken for one already post processed.  The caller must
+// hold dbmu.
+func (c *Context) deletePartial() {
+	err := c.retry(func() error {
+		_, err := c.Exec(c.sql("DELETE FROM "+c.out(c.TableName)+" WHERE SimId = ?"), c.Simid)
+		return err
+	})
+	if err != nil {
+		c.logf(Normal, "Failed to delete partial inventories for simid %x: %v\n", c.Simid, err)
+	}
+}
+
+// dropTmp drops the temporary resource table if it exists.  The caller must
+// hold dbmu.
+func (c *Context) dropTmp() error {
+	if c.tmpResTbl == "" {
+		return nil
+	}
+	err := c.retry(func() error {
+		_, err := c.Exec("DROP TABLE IF EXISTS " + c.out(c.tmpResTbl))
+		return err
+	})
+	if err == nil {
+		c.tmpResTbl = ""
+	}
+	return err
+}
+
+// walkRoots walks the genealogy of every root across c.Workers goroutines
+// and returns the walkers used so their remaining buffered nodes can be
+// dumped.  Resources reachable from more than one root are still walked only
+// once.
+func (c *Context) walkRoots(roots []*Node) ([]*walker, error) {
+	if c.Workers < 2 {
+		w := c.newWalker()
+		for i, n := range roots {
+			if err := c.checkCancel(); err != nil {
+				return nil, err
+			}
+			c.logf(Verbose, "    Processing root %d...\n", i)
+			if err := w.walkDown(n); err != nil {
+				return nil, err
+			}
+			c.progress()
+		}
+		return []*walker{w}, nil
+	}
+
+	rootch := make(chan *Node)
+	walkers := make([]*walker, c.Workers)
+	var wg sync.WaitGroup
+	for i := range walkers {
+		w := c.newWalker()
+		walkers[i] = w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range rootch {
+				if w.err == nil {
+					if w.err = w.checkCancel(); w.err == nil {
+						if w.err = w.walkDown(n); w.err == nil {
+							c.progress()
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	for i, n := range roots {
+		c.logf(Verbose, "    Processing root %d...\n", i)
+		rootch <- n
+	}
+	close(rootch)
+	wg.Wait()
+
+	for _, w := range walkers {
+		if w.err != nil {
+			return nil, w.err
+		}
+	}
+	return walkers, nil
+}
+
+// progress counts another root walked and reports it to c.ProgressFunc.
+func (c *Context) progress() {
+	if c.ProgressFunc == nil {
+		return
+	}
+	c.progmu.Lock()
+	defer c.progmu.Unlock()
+	c.rootsDone++
+	p := Progress{
+		Simid:     c.Simid,
+		Roots:     c.Stats.Roots,
+		RootsDone: c.rootsDone,
+		Resources: c.walked(),
+		Elapsed:   time.Since(c.walkStart),
+	}
+	if p.RootsDone < p.Roots {
+		p.ETA = time.Duration(float64(p.Elapsed) * float64(p.Roots-p.RootsDone) / float64(p.RootsDone))
+	}
+	c.ProgressFunc(p)
+}
 
+func (c *Context) getRoots() (roots []*Node, err error) {
+	s := "SELECT COUNT(*) FROM ResCreators WHERE SimId = ?"
 	n := 0
-	err := row.Scan(&n)
-	panicif(err)
+	err = c.retry(func() error { return c.QueryRowContext(c.ctx(), c.sql(s), c.Simid).Scan(&n) })
+	if err != nil {
+		return nil, c.walkErr(err, 0)
+	}
 
 	roots = make([]*Node, 0, n)
-	rows, err := c.Query(rootsSql, c.Simid, c.Simid)
-	panicif(err)
+	var rows *sql.Rows
+	rs, args := c.rootsQuery()
+	err = c.retry(func() (err error) {
+		rows, err = c.QueryContext(c.ctx(), c.sql(rs), args...)
+		return err
+	})
+	if err != nil {
+		return nil, c.walkErr(err, 0)
+	}
 	defer rows.Close()
 	for rows.Next() {
 		node := &Node{EndTime: math.MaxInt32}
 		err := rows.Scan(&node.ResId, &node.StartTime, &node.OwnerId, &node.QualId, &node.Quantity)
-		panicif(err)
+		if err != nil {
+			return nil, c.walkErr(err, 0)
+		}
+
+		roots = append(roots, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, c.walkErr(err, 0)
+	}
 
+	if c.StartTime > 0 && c.RootsSQL == "" {
+		bounds, err := c.boundaryRoots()
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, bounds...)
+	}
+	return roots, nil
+}
+
+// seedRoots returns root nodes for the resources with the given ids.
+func (c *Context) seedRoots(ids []int) (roots []*Node, err error) {
+	s := "SELECT TimeCreated,QualId,Quantity FROM Resources WHERE SimId = ? AND ResourceId = ?;"
+	for _, id := range ids {
+		node := &Node{ResId: id, EndTime: math.MaxInt32}
+		err := c.retry(func() error {
+			return c.QueryRowContext(c.ctx(), c.sql(s), c.Simid, id).Scan(&node.StartTime, &node.QualId, &node.Quantity)
+		})
+		if err == sql.ErrNoRows {
+			return nil, c.walkErr(fmt.Errorf("no resource with id %v", id), id)
+		} else if err != nil {
+			return nil, c.walkErr(err, id)
+		}
+
+		owner, ok, err := c.ownerAt(id, node.StartTime)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, c.walkErr(errors.New("can't trace the owner back to a creator"), id)
+		}
+		node.OwnerId = owner
 		roots = append(roots, node)
 	}
-	panicif(rows.Err())
-	return roots
+	return roots, nil
 }
 
-func (c *Context) walkDown(node *Node) {
-	if _, ok := c.mappednodes[int32(node.ResId)]; ok {
-		return
+// boundaryRoots returns the resources that must be walked from in addition
+// to those created in the window because their ancestors weren't: resources
+// still held at c.StartTime and those created in the window from a parent
+// that wasn't.  Resources held at the start of the window begin there.
+// Resources whose ownership can't be traced back to a creator are skipped
+// as they would be in a full walk.
+func (c *Context) boundaryRoots() (roots []*Node, err error) {
+	st := c.StartTime
+	var parents, kids []string
+	var parentArgs, kidArgs []interface{}
+	for _, col := range c.parentCols() {
+		parents = append(parents, "SELECT "+col+" FROM Resources WHERE SimId = ? AND TimeCreated <= ?")
+		parentArgs = append(parentArgs, c.Simid, st)
+		kids = append(kids, "res."+col+" IN (SELECT ResourceId FROM Resources WHERE SimId = ? AND TimeCreated < ?)")
+		kidArgs = append(kidArgs, c.Simid, st)
+	}
+	s := strings.Replace(boundarySql, "{{parents}}", strings.Join(parents, " UNION "), 1)
+	s = strings.Replace(s, "{{kids}}", strings.Join(kids, " OR "), 1)
+	args := append([]interface{}{c.Simid, c.stopTime(), st}, parentArgs...)
+	args = append(append(args, st), kidArgs...)
+
+	var rows *sql.Rows
+	err = c.retry(func() (err error) {
+		rows, err = c.QueryContext(c.ctx(), c.sql(s), args...)
+		return err
+	})
+	if err != nil {
+		return nil, c.walkErr(err, 0)
 	}
-	c.mappednodes[int32(node.ResId)] = struct{}{}
 
-	// dump if necessary
+	var nodes []*Node
+	for rows.Next() {
+		node := &Node{EndTime: math.MaxInt32}
+		if err := rows.Scan(&node.ResId, &node.StartTime, &node.QualId, &node.Quantity); err != nil {
+			rows.Close()
+			return nil, c.walkErr(err, 0)
+		}
+		if node.StartTime < st {
+			node.StartTime = st
+		}
+		nodes = append(nodes, node)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, c.walkErr(err, 0)
+	}
+
+	for _, node := range nodes {
+		owner, ok, err := c.ownerAt(node.ResId, node.StartTime)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			node.OwnerId = owner
+			roots = append(roots, node)
+		}
+	}
+	return roots, nil
+}
+
+// ownerAt returns the agent holding resource id at time t: the receiver of
+// its last transaction by t, or else its creator, or else the owner of its
+// first parent when it was created.
+func (c *Context) ownerAt(id, t int) (owner int, ok bool, err error) {
+	for id != 0 {
+		res := id
+		s := "SELECT ReceiverId FROM Transactions WHERE SimId = ? AND ResourceId = ? AND Time <= ? ORDER BY Time DESC LIMIT 1;"
+		err := c.retry(func() error { return c.QueryRowContext(c.ctx(), c.sql(s), c.Simid, id, t).Scan(&owner) })
+		if err == nil {
+			return owner, true, nil
+		} else if err != sql.ErrNoRows {
+			return 0, false, c.walkErr(err, res)
+		}
+
+		s = "SELECT AgentId FROM ResCreators WHERE SimId = ? AND ResourceId = ?;"
+		err = c.retry(func() error { return c.QueryRowContext(c.ctx(), c.sql(s), c.Simid, id).Scan(&owner) })
+		if err == nil {
+			return owner, true, nil
+		} else if err != sql.ErrNoRows {
+			return 0, false, c.walkErr(err, res)
+		}
+
+		s = "SELECT TimeCreated," + c.parentCols()[0] + " FROM Resources WHERE SimId = ? AND ResourceId = ?;"
+		err = c.retry(func() error { return c.QueryRowContext(c.ctx(), c.sql(s), c.Simid, res).Scan(&t, &id) })
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		} else if err != nil {
+			return 0, false, c.walkErr(err, res)
+		}
+	}
+	return 0, false, nil
+}
+
+// walker holds the per-goroutine state for walking resource genealogies.
+type walker struct {
+	*Context
+	nodes []*Node
+	// count is the number of resources visited by this walker.
+	count int
+	// splits is the number of owner-split nodes created by this walker.
+	splits int
+	err    error
+}
+
+func (c *Context) newWalker() *walker {
+	return &walker{Context: c, nodes: make([]*Node, 0, 10000)}
+}
+
+// visit marks id as walked and reports whether it hadn't been already.
+func (c *Context) visit(id int) bool {
+	if c.shared != nil {
+		return c.shared.Add(id)
+	} else if !c.visited.add(id) {
+		return false
+	}
 	c.resCount++
-	if c.resCount%DumpFreq == 0 {
-		c.dumpNodes()
+	return true
+}
+
+// walked returns the number of resources walked so far.
+func (c *Context) walked() int {
+	if c.shared != nil {
+		return c.shared.Len()
 	}
+	return c.resCount
+}
 
-	// find resource's children
-	kids := make([]*Node, 0, 2)
-	func() { // this helps keep the stack size reasonable despite heavy recursion
-		rows, err := c.tmpResStmt.Query(node.ResId, node.ResId)
-		panicif(err)
-		defer rows.Close()
+// walkDown walks the genealogy of root, adding inventory nodes for every
+// resource descended from it not yet visited.  Descendants are walked depth
+// first from an explicit stack, so arbitrarily deep genealogies don't grow
+// the goroutine's stack.
+func (w *walker) walkDown(root *Node) error {
+	stack := []*Node{root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !w.visit(node.ResId) {
+			continue
+		}
 
-		for rows.Next() {
-			child := &Node{EndTime: math.MaxInt32}
-			err := rows.Scan(&child.ResId, &child.StartTime, &child.QualId, &child.Quantity)
-			panicif(err)
-			node.EndTime = child.StartTime
-			kids = append(kids, child)
+		// dump if necessary
+		w.count++
+		if w.count%DumpFreq == 0 {
+			if err := w.dumpNodes(); err != nil {
+				return err
+			}
+		}
+
+		// find resource's children
+		kids, firsts, err := w.children(node)
+		if err != nil {
+			return err
+		}
+
+		// find resources owner changes (that occurred before children)
+		owners, times, err := w.getNewOwners(node.OwnerId, node.ResId, node.StartTime)
+		if err != nil {
+			return err
+		}
+
+		childOwner := node.OwnerId
+		w.splits += len(owners)
+		if len(owners) > 0 {
+			node.EndTime = times[0]
+			childOwner = owners[len(owners)-1]
+
+			lastend := math.MaxInt32
+			if len(kids) > 0 {
+				lastend = kids[0].StartTime
+			}
+			times = append(times, lastend)
+			for i := range owners {
+				n := &Node{ResId: node.ResId,
+					OwnerId:   owners[i],
+					StartTime: times[i],
+					EndTime:   times[i+1],
+					QualId:    node.QualId,
+					Quantity:  node.Quantity,
+				}
+				if err := w.addNode(n); err != nil {
+					return err
+				}
+			}
 		}
-		panicif(rows.Err())
-	}()
 
-	// find resources owner changes (that occurred before children)
-	owners, times := c.getNewOwners(node.OwnerId, node.ResId)
-
-	childOwner := node.OwnerId
-	if len(owners) > 0 {
-		node.EndTime = times[0]
-		childOwner = owners[len(owners)-1]
-
-		lastend := math.MaxInt32
-		if len(kids) > 0 {
-			lastend = kids[0].StartTime
-		}
-		times = append(times, lastend)
-		for i := range owners {
-			n := &Node{ResId: node.ResId,
-				OwnerId:   owners[i],
-				StartTime: times[i],
-				EndTime:   times[i+1],
-				QualId:    node.QualId,
-				Quantity:  node.Quantity,
+		if err := w.addNode(node); err != nil {
+			return err
+		}
+
+		// walk down resource's children (pushed in reverse so they are
+		// walked in order) - a combined resource belongs to the owner of
+		// its first parent when it is created, looked up the same way
+		// whichever parent it is reached from, so walks visiting roots in
+		// any order agree.
+		for i := len(kids) - 1; i >= 0; i-- {
+			child := kids[i]
+			child.OwnerId = childOwner
+			if first := firsts[i]; first != 0 {
+				owner, ok, err := w.ownerAt(first, child.StartTime)
+				if err != nil {
+					return err
+				} else if ok {
+					child.OwnerId = owner
+				}
 			}
-			c.nodes = append(c.nodes, n)
+			stack = append(stack, child)
 		}
 	}
+	return nil
+}
 
-	c.nodes = append(c.nodes, node)
+// children returns the children of node, ending node when the first of them
+// is created, and the first parent of each combined child (zero for children
+// of node alone).
+func (w *walker) children(node *Node) (kids []*Node, firsts []int, err error) {
+	w.dbmu.RLock()
+	defer w.dbmu.RUnlock()
+	var rows *sql.Rows
+	err = w.retry(func() (err error) {
+		rows, err = w.tmpResStmt.QueryContext(w.ctx(), w.parentArgs(node.ResId)...)
+		return err
+	})
+	if err != nil {
+		return nil, nil, w.walkErr(err, node.ResId)
+	}
+	defer rows.Close()
 
-	// walk down resource's children
-	for _, child := range kids {
-		child.OwnerId = childOwner
-		c.walkDown(child)
+	kids = make([]*Node, 0, 2)
+	firsts = make([]int, 0, 2)
+	for rows.Next() {
+		child := &Node{EndTime: math.MaxInt32}
+		var first int
+		var combined bool
+		err := rows.Scan(&child.ResId, &child.StartTime, &child.QualId, &child.Quantity, &first, &combined)
+		if err != nil {
+			return nil, nil, w.walkErr(err, node.ResId)
+		} else if !combined {
+			first = 0
+		}
+		node.EndTime = child.StartTime
+		kids = append(kids, child)
+		firsts = append(firsts, first)
 	}
+	return kids, firsts, w.walkErr(rows.Err(), node.ResId)
 }
 
-func (c *Context) getNewOwners(currowner, id int) (owners, times []int) {
+// parentArgs returns the arguments for selecting the children of resource
+// id with tmpResStmt.
+func (w *walker) parentArgs(id int) []interface{} {
+	args := make([]interface{}, len(w.parentCols()))
+	for i := range args {
+		args[i] = id
+	}
+	return args
+}
+
+// addNode buffers n for dumping, clamping it to the walked window and
+// checking its interval in debug mode.  The buffer is dumped once it holds
+// MaxBufferedNodes nodes.
+func (w *walker) addNode(n *Node) error {
+	if stop := w.stopTime(); n.EndTime > stop {
+		n.EndTime = stop
+	}
+	if w.Debug && n.EndTime < n.StartTime {
+		return w.walkErr(fmt.Errorf("inventory entry ending before it starts: %+v", *n), n.ResId)
+	}
+	w.nodes = append(w.nodes, n)
+	if w.MaxBufferedNodes > 0 && len(w.nodes) >= w.MaxBufferedNodes {
+		return w.dumpNodes()
+	}
+	return nil
+}
+
+// getNewOwners returns the agents other than currowner that resource id was
+// transferred to at or after time start (and before the window's stop time)
+// and when.
+func (w *walker) getNewOwners(currowner, id, start int) (owners, times []int, err error) {
+	w.dbmu.RLock()
+	defer w.dbmu.RUnlock()
 	var owner, t int
-	rows, err := c.ownerStmt.Query(id, c.Simid)
-	panicif(err)
+	var rows *sql.Rows
+	err = w.retry(func() (err error) {
+		rows, err = w.ownerStmt.QueryContext(w.ctx(), id, w.Simid, start, w.stopTime())
+		return err
+	})
+	if err != nil {
+		return nil, nil, w.walkErr(err, id)
+	}
 	defer rows.Close()
 	for rows.Next() {
-		err := rows.Scan(&owner, &t)
-		panicif(err)
+		if err := rows.Scan(&owner, &t); err != nil {
+			return nil, nil, w.walkErr(err, id)
+		}
 
 		if currowner == owner {
 			continue
@@ -361,24 +1691,68 @@ func (c *Context) getNewOwners(currowner, id int) (owners, times []int) {
 		owners = append(owners, owner)
 		times = append(times, t)
 	}
-	panicif(rows.Err())
-	return owners, times
+	if err := rows.Err(); err != nil {
+		return nil, nil, w.walkErr(err, id)
+	}
+	return owners, times, nil
 }
 
-func (c *Context) dumpNodes() {
-	c.Log.Printf("    Dumping inventories (%d resources done)...\n", c.resCount)
-	tx, err := c.Begin()
-	panicif(err)
-	stmt := tx.Stmt(c.dumpStmt)
+func (w *walker) dumpNodes() error {
+	if err := w.checkCancel(); err != nil {
+		return err
+	}
+	w.dbmu.Lock()
+	defer w.dbmu.Unlock()
+	w.timer.Start("dump")
+	defer w.timer.Stop("dump")
+	w.logf(Normal, "    Dumping inventories (%d resources done)...\n", w.walked())
 
-	for _, n := range c.nodes {
+	if w.SortDumps {
+		sort.Slice(w.nodes, func(i, j int) bool {
+			a, b := w.nodes[i], w.nodes[j]
+			return a.ResId < b.ResId || a.ResId == b.ResId && a.StartTime < b.StartTime
+		})
+	}
+
+	if w.DryRun {
+		for _, n := range w.nodes {
+			if n.EndTime > n.StartTime {
+				w.Stats.RowsWritten++
+			}
+		}
+		w.Stats.Dumps++
+		w.nodes = w.nodes[:0]
+		return nil
+	}
+
+	var tx *sql.Tx
+	err := w.retry(func() (err error) {
+		tx, err = w.BeginTx(w.ctx(), nil)
+		return err
+	})
+	if err != nil {
+		return w.walkErr(err, 0)
+	}
+	defer tx.Rollback() // no-op once committed
+	stmt := tx.StmtContext(w.ctx(), w.dumpStmt)
+
+	for _, n := range w.nodes {
 		if n.EndTime > n.StartTime {
-			_, err = stmt.Exec(c.Simid, n.ResId, n.OwnerId, n.StartTime, n.EndTime, n.QualId, n.Quantity)
-			panicif(err)
+			err := w.retry(func() error {
+				_, err := stmt.ExecContext(w.ctx(), w.Simid, n.ResId, n.OwnerId, n.StartTime, n.EndTime, n.QualId, n.Quantity)
+				return err
+			})
+			if err != nil {
+				return w.walkErr(err, n.ResId)
+			}
+			w.Stats.RowsWritten++
 		}
 	}
 
-	err = tx.Commit()
-	panicif(err)
-	c.nodes = c.nodes[:0]
+	if err := tx.Commit(); err != nil {
+		return w.walkErr(err, 0)
+	}
+	w.Stats.Dumps++
+	w.nodes = w.nodes[:0]
+	return nil
 }
@@ -0,0 +1,1584 @@
+package post
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rwcarlsen/cyan/parquet"
+	"github.com/rwcarlsen/cyan/query"
+	_ "github.com/rwcarlsen/go-sqlite3"
+)
+
+var testSimId = []byte("sim1")
+
+// rawSchema holds the raw cyclus output tables that Prepare doesn't create.
+var rawSchema = []string{
+	"CREATE TABLE Info (SimId BLOB,Handle TEXT,InitialYear INTEGER,InitialMonth INTEGER,Duration INTEGER);",
+	"CREATE TABLE AgentEntry (SimId BLOB,AgentId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER);",
+}
+
+// simpleSim is a 10 timestep simulation where a source creates a resource
+// that is sent to a reactor, split in two, and has one of its halves sent on
+// to a sink that is decommissioned at t=7:
+//
+//	res 1 (10 kg): agent 1 t=1..3, agent 2 t=3..5
+//	res 2 (4 kg):  agent 2 t=5..end
+//	res 3 (6 kg):  agent 2 t=5..6, agent 3 t=6..end
+var simpleSim = []string{
+	"INSERT INTO Info VALUES (?,'',2000,1,10);",
+	"INSERT INTO AgentEntry VALUES (?,1,'Facility',':agents:Source','source',0,-1,0);",
+	"INSERT INTO AgentEntry VALUES (?,2,'Facility',':agents:Reactor','reactor',0,-1,0);",
+	"INSERT INTO AgentEntry VALUES (?,3,'Facility',':agents:Sink','sink',0,-1,0);",
+	"INSERT INTO AgentExit VALUES (?,3,7);",
+	"INSERT INTO Resources VALUES (?,1,1,'Material',1,10,'kg',1,0,0);",
+	"INSERT INTO Resources VALUES (?,2,1,'Material',5,4,'kg',1,1,0);",
+	"INSERT INTO Resources VALUES (?,3,1,'Material',5,6,'kg',1,1,0);",
+	"INSERT INTO ResCreators VALUES (?,1,1);",
+	"INSERT INTO Transactions VALUES (?,1,1,2,1,'fuel',3);",
+	"INSERT INTO Transactions VALUES (?,2,2,3,3,'waste',6);",
+	"INSERT INTO Compositions VALUES (?,1,922350000,0.05);",
+	"INSERT INTO Compositions VALUES (?,1,922380000,0.95);",
+}
+
+// testDb creates a fresh database holding raw cyclus output tables and runs
+// the given statements against it.  Every '?' placeholder in a statement is
+// bound to the test simid.
+func testDb(t testing.TB, stmts ...string) *sql.DB {
+	db, _ := testDbFile(t, stmts...)
+	return db
+}
+
+// testDbFile is like testDb but also returns the path of the database file.
+func testDbFile(t testing.TB, stmts ...string) (*sql.DB, string) {
+	db, path := openTestDb(t)
+	for _, s := range rawSchema {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatalf("%v: %v", s, err)
+		}
+	}
+	if err := Prepare(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s, simArgs(s)...); err != nil {
+			t.Fatalf("%v: %v", s, err)
+		}
+	}
+	return db, path
+}
+
+// openTestDb opens a new empty database file that is removed when the test
+// completes.
+func openTestDb(t testing.TB) (*sql.DB, string) {
+	dir, err := ioutil.TempDir("", "cyan-post-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.sqlite")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	return db, path
+}
+
+// genSim returns statements for a simulation with nroots independent root
+// resources.  Each root is shipped from agent 1 to agent 2 and split in two,
+// with one half shipped on to agent 3.  The first halves of each pair of
+// neighboring roots are then combined, making the combined resource
+// reachable from both roots.
+func genSim(nroots int) []string {
+	stmts := []string{
+		"INSERT INTO Info VALUES (?,'',2000,1,20);",
+		"INSERT INTO AgentEntry VALUES (?,1,'Facility',':agents:Source','source',0,-1,0);",
+		"INSERT INTO AgentEntry VALUES (?,2,'Facility',':agents:Reactor','reactor',0,-1,0);",
+		"INSERT INTO AgentEntry VALUES (?,3,'Facility',':agents:Sink','sink',0,-1,0);",
+		"INSERT INTO Compositions VALUES (?,1,922350000,1.0);",
+	}
+	tx := 0
+	for i := 0; i < nroots; i++ {
+		root := 4*i + 1
+		stmts = append(stmts,
+			fmt.Sprintf("INSERT INTO Resources VALUES (?,%v,%v,'Material',1,10,'kg',1,0,0);", root, root),
+			fmt.Sprintf("INSERT INTO Resources VALUES (?,%v,%v,'Material',3,4,'kg',1,%v,0);", root+1, root, root),
+			fmt.Sprintf("INSERT INTO Resources VALUES (?,%v,%v,'Material',3,6,'kg',1,%v,0);", root+2, root, root),
+			fmt.Sprintf("INSERT INTO ResCreators VALUES (?,%v,1);", root),
+			fmt.Sprintf("INSERT INTO Transactions VALUES (?,%v,1,2,%v,'fuel',2);", tx, root),
+			fmt.Sprintf("INSERT INTO Transactions VALUES (?,%v,2,3,%v,'waste',4);", tx+1, root+2),
+		)
+		tx += 2
+		if i%2 == 1 {
+			stmts = append(stmts, fmt.Sprintf("INSERT INTO Resources VALUES (?,%v,%v,'Material',5,8,'kg',1,%v,%v);",
+				root+3, root+3, root-3, root+1))
+		}
+	}
+	return stmts
+}
+
+// genMixedSim returns genSim's statements with the first half of every
+// other root also shipped on to agent 3 at time t - before it is combined at
+// time 5, or in the same timestep for t=5.  The two parents of each combined
+// resource then have different owners and are reached from different roots.
+func genMixedSim(nroots, t int) []string {
+	stmts := genSim(nroots)
+	for i := 1; i < nroots; i += 2 {
+		stmts = append(stmts, fmt.Sprintf("INSERT INTO Transactions VALUES (?,%v,2,3,%v,'waste',%v);", 2*nroots+i, 4*i-2, t))
+	}
+	return stmts
+}
+
+// walk builds inventories for the test simid with the given number
+// of workers.
+func walk(t testing.TB, db *sql.DB, workers int) {
+	ctx := NewContext(db, testSimId)
+	ctx.Workers = workers
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Finish(db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func simArgs(s string) []interface{} {
+	args := []interface{}{}
+	for _, c := range s {
+		if c == '?' {
+			args = append(args, testSimId)
+		}
+	}
+	return args
+}
+
+func inventories(t testing.TB, db *sql.DB) []Node {
+	return inventoriesIn(t, db, DefaultInvTable)
+}
+
+func inventoriesIn(t testing.TB, db *sql.DB, tbl string) []Node {
+	return queryNodes(t, db, "SELECT ResourceId,AgentId,StartTime,EndTime,QualId,Quantity FROM "+tbl+" ORDER BY ResourceId,StartTime")
+}
+
+// queryNodes returns the inventory entries selected by s.
+func queryNodes(t testing.TB, db *sql.DB, s string) []Node {
+	rows, err := db.Query(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var nodes []Node
+	for rows.Next() {
+		n := Node{}
+		if err := rows.Scan(&n.ResId, &n.OwnerId, &n.StartTime, &n.EndTime, &n.QualId, &n.Quantity); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, n)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return nodes
+}
+
+func TestBoundEndTimes(t *testing.T) {
+	db := testDb(t, simpleSim...)
+	if _, err := Process(db); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Node{
+		{ResId: 1, OwnerId: 1, StartTime: 1, EndTime: 3, QualId: 1, Quantity: 10},
+		{ResId: 1, OwnerId: 2, StartTime: 3, EndTime: 5, QualId: 1, Quantity: 10},
+		{ResId: 2, OwnerId: 2, StartTime: 5, EndTime: math.MaxInt32, QualId: 1, Quantity: 4},
+		{ResId: 3, OwnerId: 2, StartTime: 5, EndTime: 6, QualId: 1, Quantity: 6},
+		{ResId: 3, OwnerId: 3, StartTime: 6, EndTime: math.MaxInt32, QualId: 1, Quantity: 6},
+	}
+	if got := inventories(t, db); !reflect.DeepEqual(got, want) {
+		t.Fatalf("before bounding:\n    want %+v\n    got  %+v", want, got)
+	}
+
+	if err := BoundEndTimes(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// agent 2 never exits, agent 3 exits at t=7 and the sim ends after t=9
+	want[2].EndTime = 10
+	want[4].EndTime = 8
+	if got := inventories(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("after bounding:\n    want %+v\n    got  %+v", want, got)
+	}
+}
+
+func TestWalkAllParallel(t *testing.T) {
+	serial := testDb(t, genSim(50)...)
+	walk(t, serial, 1)
+	want := inventories(t, serial)
+
+	parallel := testDb(t, genSim(50)...)
+	walk(t, parallel, 4)
+	got := inventories(t, parallel)
+
+	if len(want) == 0 {
+		t.Fatal("serial walk produced no inventories")
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("parallel walk differs from serial walk:\n    want %+v\n    got  %+v", want, got)
+	}
+}
+
+func TestWalkAllParallelOwners(t *testing.T) {
+	// the first parents are traded before or in the timestep they are
+	// combined
+	for _, tm := range []int{4, 5} {
+		serial := testDb(t, genMixedSim(50, tm)...)
+		walk(t, serial, 1)
+		want := inventories(t, serial)
+
+		// combined resources are owned by the owner of their first parent
+		combined := queryNodes(t, serial, "SELECT ResourceId,AgentId,StartTime,EndTime,QualId,Quantity FROM Inventories WHERE Quantity = 8")
+		if len(combined) != 25 {
+			t.Fatalf("t=%v: want 25 combined resources, got %v", tm, len(combined))
+		}
+		for _, n := range combined {
+			if n.OwnerId != 3 {
+				t.Errorf("t=%v: combined resource %v: want owner 3, got %v", tm, n.ResId, n.OwnerId)
+			}
+		}
+
+		for i := 0; i < 5; i++ {
+			parallel := testDb(t, genMixedSim(50, tm)...)
+			walk(t, parallel, 4)
+			if got := inventories(t, parallel); !reflect.DeepEqual(got, want) {
+				t.Fatalf("t=%v: parallel walk differs from serial walk:\n    want %+v\n    got  %+v", tm, want, got)
+			}
+		}
+	}
+}
+
+func TestWalkDeepChain(t *testing.T) {
+	// each resource is split off of the one before it, one per time step
+	const n = 20000
+	db := testDb(t,
+		fmt.Sprintf("INSERT INTO Info VALUES (?,'',2000,1,%v);", n+1),
+		"INSERT INTO AgentEntry VALUES (?,1,'Facility',':agents:Source','source',0,-1,0);",
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,1,'kg',1,0,0);",
+		"INSERT INTO ResCreators VALUES (?,1,1);",
+		fmt.Sprintf(`INSERT INTO Resources WITH RECURSIVE c(id) AS (SELECT 2 UNION ALL SELECT id+1 FROM c WHERE id < %v)
+			SELECT ?,id,1,'Material',id-1,1,'kg',1,id-1,0 FROM c;`, n),
+	)
+
+	// a recursive walk needs several times this much stack
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+	walk(t, db, 1)
+
+	var count, end int
+	err := db.QueryRow("SELECT COUNT(*),MAX(StartTime) FROM Inventories WHERE SimId = ?;", testSimId).Scan(&count, &end)
+	if err != nil {
+		t.Fatal(err)
+	} else if count != n || end != n-1 {
+		t.Errorf("want %v resources ending at t=%v, got %v ending at t=%v", n, n-1, count, end)
+	}
+}
+
+func TestProgressFunc(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		db := testDb(t, genSim(20)...)
+		ctx := NewContext(db, testSimId)
+		ctx.Workers = workers
+		var got []Progress
+		ctx.ProgressFunc = func(p Progress) { got = append(got, p) }
+		if err := ctx.WalkAll(); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != 20 {
+			t.Fatalf("workers=%v: want 20 progress reports, got %v", workers, len(got))
+		}
+		for i, p := range got {
+			if p.Roots != 20 || p.RootsDone != i+1 || string(p.Simid) != "sim1" {
+				t.Errorf("workers=%v: report %v: got %+v", workers, i, p)
+			} else if i > 0 && p.Resources < got[i-1].Resources {
+				t.Errorf("workers=%v: report %v: resources walked decreased from %v to %v", workers, i, got[i-1].Resources, p.Resources)
+			}
+		}
+		if last := got[len(got)-1]; last.Resources != ctx.Stats.Resources || last.ETA != 0 {
+			t.Errorf("workers=%v: want %v resources and no time remaining, got %+v", workers, ctx.Stats.Resources, last)
+		}
+	}
+}
+
+func benchmarkWalkAll(b *testing.B, workers int) {
+	stmts := genSim(500)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db := testDb(b, stmts...)
+		b.StartTimer()
+		walk(b, db, workers)
+	}
+}
+
+func BenchmarkWalkAllSerial(b *testing.B)   { benchmarkWalkAll(b, 1) }
+func BenchmarkWalkAllParallel(b *testing.B) { benchmarkWalkAll(b, 4) }
+
+func TestWalkSynth(t *testing.T) {
+	db := testDb(t)
+	opts := query.SynthOpts{Roots: 4, Branching: 3, Depth: 2, Churn: 2, Seed: 1}
+	if err := query.GenSyntheticSim(db, testSimId, opts); err != nil {
+		t.Fatal(err)
+	}
+	walk(t, db, 1)
+
+	// every root and all its descendants are owned by exactly one agent at
+	// the end of the simulation
+	var n int
+	var qty float64
+	err := db.QueryRow("SELECT COUNT(*),SUM(Quantity) FROM Inventories WHERE SimId = ? AND EndTime >= 9;", testSimId).Scan(&n, &qty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4*9 || math.Abs(qty-400) > 1e-9 {
+		t.Errorf("want 36 resources with 400 kg at end, got %v with %v kg", n, qty)
+	}
+}
+
+func benchmarkSynth(b *testing.B, opts query.SynthOpts) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db := testDb(b)
+		if err := query.GenSyntheticSim(db, testSimId, opts); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		walk(b, db, 1)
+	}
+}
+
+func BenchmarkWalkAllSynthSmall(b *testing.B) {
+	benchmarkSynth(b, query.SynthOpts{Roots: 10, Branching: 2, Depth: 3, Churn: 2})
+}
+
+func BenchmarkWalkAllSynthMedium(b *testing.B) {
+	benchmarkSynth(b, query.SynthOpts{Roots: 100, Branching: 2, Depth: 5, Churn: 4})
+}
+
+func BenchmarkWalkAllSynthLarge(b *testing.B) {
+	benchmarkSynth(b, query.SynthOpts{Roots: 500, Branching: 3, Depth: 4, Churn: 8, Agents: 50})
+}
+
+func TestTableName(t *testing.T) {
+	db := testDb(t, simpleSim...)
+
+	for _, tbl := range []string{DefaultInvTable, "RawInventories"} {
+		ctx := NewContext(db, testSimId)
+		ctx.TableName = tbl
+		if err := ctx.WalkAll(); err != nil {
+			t.Fatalf("%v: %v", tbl, err)
+		}
+	}
+	if err := Finish(db, DefaultInvTable, "RawInventories"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := inventories(t, db)
+	if got := inventoriesIn(t, db, "RawInventories"); len(want) == 0 || !reflect.DeepEqual(got, want) {
+		t.Errorf("RawInventories differs from Inventories:\n    want %+v\n    got  %+v", want, got)
+	}
+
+	n := 0
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='index' AND tbl_name='RawInventories'").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != len(invIndexStmts("RawInventories")) {
+		t.Errorf("want %v RawInventories indexes, got %v", len(invIndexStmts("RawInventories")), n)
+	}
+
+	nagents := 0
+	if err := db.QueryRow("SELECT COUNT(*) FROM Agents").Scan(&nagents); err != nil {
+		t.Fatal(err)
+	} else if nagents != 3 {
+		t.Errorf("want Agents built once (3 rows), got %v rows", nagents)
+	}
+}
+
+func TestWalkAllEmptySim(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Info VALUES (?,'',2000,1,10);",
+		"INSERT INTO AgentEntry VALUES (?,1,'Facility',':agents:Source','source',0,-1,0);",
+	)
+
+	ctx := NewContext(db, testSimId)
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'tmp_restbl_%'").Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("want no temporary resource tables, got %v", n)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM Agents").Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Errorf("want 1 agent built, got %v", n)
+	}
+
+	if err := NewContext(db, testSimId).WalkAll(); !IsAlreadyPostErr(err) {
+		t.Errorf("rewalking empty sim: want AlreadyPostErr, got %v", err)
+	}
+}
+
+func TestForce(t *testing.T) {
+	db := testDb(t, simpleSim...)
+	if err := NewContext(db, testSimId).WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	want := inventories(t, db)
+
+	if err := NewContext(db, testSimId).WalkAll(); !IsAlreadyPostErr(err) {
+		t.Fatalf("rewalking: want AlreadyPostErr, got %v", err)
+	}
+	err := ProcessIdsWith(db, [][]byte{testSimId}, ProcessOptions{Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := inventories(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("forced rebuild:\n    want %+v\n    got  %+v", want, got)
+	}
+
+	n := 0
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + MetaTable).Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Errorf("want 1 meta record after rebuild, got %v", n)
+	}
+}
+
+func TestWalkAllNoSimid(t *testing.T) {
+	db := testDb(t, simpleSim...)
+	for _, id := range [][]byte{nil, {}} {
+		if err := NewContext(db, id).WalkAll(); err != ErrNoSimid {
+			t.Errorf("simid %q: want ErrNoSimid, got %v", id, err)
+		}
+	}
+	if ok, err := query.HasTable(db, "tmp_restbl_"); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Errorf("walk without simid created a temporary table")
+	}
+}
+
+func TestClose(t *testing.T) {
+	db := testDb(t, simpleSim...)
+	db.SetMaxOpenConns(1)
+	for i := 0; i < 200; i++ {
+		ctx := NewContext(db, testSimId)
+		ctx.TableName = fmt.Sprintf("Inventories%v", i)
+		if err := ctx.WalkAll(); err != nil {
+			t.Fatalf("walk %v: %v", i, err)
+		}
+		if ctx.tmpResStmt != nil || ctx.dumpStmt != nil || ctx.ownerStmt != nil {
+			t.Fatalf("walk %v: prepared statements not finalized", i)
+		}
+		if err := ctx.Close(); err != nil {
+			t.Fatalf("closing twice: %v", err)
+		}
+	}
+
+	// a failed walk cleans up after itself too
+	ctx := NewContext(db, testSimId)
+	ctx.TableName = "Failed"
+	ctx.RootsSQL = "SELECT ResourceId,TimeCreated,'bogus',QualId,Quantity FROM Resources WHERE SimId = ?"
+	if err := ctx.WalkAll(); err == nil {
+		t.Fatal("walk with invalid roots succeeded")
+	}
+	n := 0
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'tmp_restbl_%'").Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("want temporary table dropped after failed walk, found %v", n)
+	}
+}
+
+func TestWalkStats(t *testing.T) {
+	db := testDb(t, simpleSim...)
+	ctx := NewContext(db, testSimId)
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	var nrows, nres int
+	err := db.QueryRow("SELECT COUNT(*),COUNT(DISTINCT ResourceId) FROM Inventories").Scan(&nrows, &nres)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := ctx.Stats
+	if st.Resources != nres {
+		t.Errorf("Resources: want %v, got %v", nres, st.Resources)
+	}
+	if st.RowsWritten != nrows {
+		t.Errorf("RowsWritten: want %v, got %v", nrows, st.RowsWritten)
+	}
+	if st.Roots != 1 || st.SplitNodes != 2 || st.Dumps != 1 {
+		t.Errorf("want 1 root, 2 split nodes, and 1 dump, got %+v", st)
+	}
+	for _, phase := range []string{"init", "roots", "walk", "dump"} {
+		if _, ok := st.Phases[phase]; !ok {
+			t.Errorf("missing %v phase timing", phase)
+		}
+	}
+}
+
+func TestMeta(t *testing.T) {
+	db := testDb(t, simpleSim...)
+	ctx := NewContext(db, testSimId)
+	ctx.SortDumps = true
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	var tbl, built, version, opts string
+	var dumpFreq, rows, nentries int
+	err := db.QueryRow("SELECT InvTable,BuiltAt,Version,DumpFreq,Options,Rows FROM "+MetaTable+" WHERE SimId = ?", testSimId).Scan(&tbl, &built, &version, &dumpFreq, &opts, &rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM Inventories").Scan(&nentries); err != nil {
+		t.Fatal(err)
+	}
+	if tbl != DefaultInvTable || version != Version || dumpFreq != DumpFreq || rows != nentries {
+		t.Errorf("want %v, %v, %v, and %v rows, got %v, %v, %v, and %v rows", DefaultInvTable, Version, DumpFreq, nentries, tbl, version, dumpFreq, rows)
+	}
+	if _, err := time.Parse(time.RFC3339, built); err != nil {
+		t.Errorf("bad build time: %v", err)
+	}
+	if !strings.Contains(opts, "SortDumps=true") {
+		t.Errorf("options not recorded: %v", opts)
+	}
+
+	// a build into another table is recorded separately
+	ctx = NewContext(db, testSimId)
+	ctx.TableName = "Inv2"
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + MetaTable).Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Errorf("want 2 meta rows, got %v", n)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	db := testDb(t, simpleSim...)
+	full := NewContext(db, testSimId)
+	full.TableName = "RealInventories"
+	if err := full.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := NewContext(db, testSimId)
+	ctx.TableName = "DryInventories"
+	ctx.DryRun = true
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := query.HasTable(db, "DryInventories"); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Errorf("dry run created its inventory table")
+	}
+
+	got, want := ctx.Stats, full.Stats
+	got.Phases, want.Phases = nil, nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dry run stats differ from real walk:\n    want %+v\n    got  %+v", want, got)
+	}
+}
+
+// window returns the entries of a full build overlapping [start, stop)
+// clamped to that window.  A non-positive stop leaves the window unbounded.
+func window(full []Node, start, stop int) []Node {
+	if stop <= 0 {
+		stop = math.MaxInt32
+	}
+	var nodes []Node
+	for _, n := range full {
+		if n.StartTime < start {
+			n.StartTime = start
+		}
+		if n.EndTime > stop {
+			n.EndTime = stop
+		}
+		if n.EndTime > n.StartTime {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// testWindows checks builds of the simulations windowed by each of the
+// start and stop times against the full build.
+func testWindows(t *testing.T, starts, stops []int) {
+	sims := map[string][]string{"simple": simpleSim, "generated": genSim(6)}
+	for name, stmts := range sims {
+		db := testDb(t, stmts...)
+		walk(t, db, 1)
+		full := inventories(t, db)
+
+		for _, start := range starts {
+			for _, stop := range stops {
+				tbl := fmt.Sprintf("Inventories%v_%v", start, stop)
+				ctx := NewContext(db, testSimId)
+				ctx.TableName = tbl
+				ctx.StartTime = start
+				ctx.StopTime = stop
+				if err := ctx.WalkAll(); err != nil {
+					t.Fatalf("%v [%v,%v): %v", name, start, stop, err)
+				}
+
+				want := window(full, start, stop)
+				if got := inventoriesIn(t, db, tbl); !reflect.DeepEqual(got, want) {
+					t.Errorf("%v [%v,%v): windowed build differs from full build:\n    want %+v\n    got  %+v", name, start, stop, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestStartTime(t *testing.T) {
+	// include windows starting on timesteps with transfers and splits
+	testWindows(t, []int{1, 2, 3, 4, 5, 6, 7, 8}, []int{0})
+}
+
+func TestStopTime(t *testing.T) {
+	testWindows(t, []int{0, 2, 4, 5}, []int{2, 3, 4, 5, 6, 7, 9})
+
+	// resources created after the window never make it into the walk
+	db := testDb(t, genSim(6)...)
+	ctx := NewContext(db, testSimId)
+	ctx.StopTime = 5
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	if err := db.QueryRow("SELECT COUNT(*) FROM Resources WHERE TimeCreated < 5").Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if ctx.Stats.Resources != n {
+		t.Errorf("want %v resources walked, got %v", n, ctx.Stats.Resources)
+	}
+}
+
+// chainSim returns statements for a simulation where a single resource is
+// split once per timestep for n timesteps.
+func chainSim(n int) []string {
+	stmts := []string{
+		fmt.Sprintf("INSERT INTO Info VALUES (?,'',2000,1,%v);", n+1),
+		"INSERT INTO AgentEntry VALUES (?,1,'Facility',':agents:Source','source',0,-1,0);",
+		"INSERT INTO AgentEntry VALUES (?,2,'Facility',':agents:Sink','sink',0,-1,0);",
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,1000,'kg',1,0,0);",
+		"INSERT INTO ResCreators VALUES (?,1,1);",
+	}
+	for i := 1; i < n; i++ {
+		stmts = append(stmts,
+			fmt.Sprintf("INSERT INTO Resources VALUES (?,%v,%v,'Material',%v,1,'kg',1,%v,0);", 2*i, 2*i, i, 2*i-1),
+			fmt.Sprintf("INSERT INTO Resources VALUES (?,%v,%v,'Material',%v,999,'kg',1,%v,0);", 2*i+1, 2*i+1, i, 2*i-1),
+			fmt.Sprintf("INSERT INTO Transactions VALUES (?,%v,1,2,%v,'waste',%v);", i, 2*i, i),
+		)
+	}
+	return stmts
+}
+
+func TestMaxBufferedNodes(t *testing.T) {
+	// a single resource passed on to a new agent every timestep produces an
+	// entry per trade from just one resource walked
+	const ntrades = 50
+	sim := []string{
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,10,'kg',1,0,0);",
+		"INSERT INTO ResCreators VALUES (?,1,1);",
+	}
+	for i := 1; i <= ntrades+1; i++ {
+		sim = append(sim, fmt.Sprintf("INSERT INTO AgentEntry VALUES (?,%v,'Facility',':agents:Trader','trader',0,-1,0);", i))
+		if i <= ntrades {
+			sim = append(sim, fmt.Sprintf("INSERT INTO Transactions VALUES (?,%v,%v,%v,1,'stuff',%v);", i, i, i+1, i))
+		}
+	}
+
+	db := testDb(t, sim...)
+	walk(t, db, 1)
+	want := inventories(t, db)
+	if len(want) != ntrades+1 {
+		t.Fatalf("want %v entries, got %v", ntrades+1, len(want))
+	}
+
+	db = testDb(t, sim...)
+	ctx := NewContext(db, testSimId)
+	ctx.MaxBufferedNodes = 10
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ctx.Stats.Dumps; got != 6 {
+		t.Errorf("want 6 dumps, got %v", got)
+	}
+	if got := inventories(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("\n    want %+v\n    got  %+v", want, got)
+	}
+}
+
+func benchmarkWindow(b *testing.B, stop int) {
+	stmts := chainSim(2000)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db := testDb(b, stmts...)
+		b.StartTimer()
+		ctx := NewContext(db, testSimId)
+		ctx.StopTime = stop
+		if err := ctx.WalkAll(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWindowFull(b *testing.B)   { benchmarkWindow(b, 0) }
+func BenchmarkWindowTenPct(b *testing.B) { benchmarkWindow(b, 200) }
+
+func TestWalkError(t *testing.T) {
+	// res 3's quantity can't be scanned when looking up res 1's children
+	sim := append(simpleSim[:len(simpleSim):len(simpleSim)],
+		"UPDATE Resources SET Quantity = 'lots' WHERE ResourceId = 3;")
+	db := testDb(t, sim...)
+	err := NewContext(db, testSimId).WalkAll()
+	var werr *WalkError
+	if !errors.As(err, &werr) {
+		t.Fatalf("want WalkError, got %v", err)
+	}
+	if werr.Op != "post.(*walker).children" || string(werr.Simid) != "sim1" || werr.ResId != 1 {
+		t.Errorf("want children of resource 1 of sim1 to fail, got %+v", *werr)
+	}
+	for _, want := range []string{"post.(*walker).children: simid 73696d31 resource 1", "lots"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("want error containing %q, got %v", want, err)
+		}
+	}
+	if got := inventories(t, db); len(got) != 0 {
+		t.Errorf("want no inventories after failed walk, got %+v", got)
+	}
+
+	if got := caller(1); got != "post.TestWalkError" {
+		t.Errorf("caller: want post.TestWalkError, got %v", got)
+	}
+	if got := func() string { return caller(1) }(); got != "post.TestWalkError" {
+		t.Errorf("caller in closure: want post.TestWalkError, got %v", got)
+	}
+}
+
+func TestWalkFrom(t *testing.T) {
+	// simpleSim plus an unrelated 3 kg resource from the source at t=2 that
+	// is split at t=4
+	sim := append([]string{
+		"INSERT INTO Resources VALUES (?,4,4,'Material',2,3,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,5,4,'Material',4,1,'kg',1,4,0);",
+		"INSERT INTO Resources VALUES (?,6,4,'Material',4,2,'kg',1,4,0);",
+		"INSERT INTO ResCreators VALUES (?,4,1);",
+	}, simpleSim...)
+	full := testDb(t, sim...)
+	walk(t, full, 1)
+	var want []Node
+	for _, n := range inventories(t, full) {
+		if n.ResId >= 3 {
+			want = append(want, n)
+		}
+	}
+
+	// res 3 is held by agent 2 when created, then sent on to agent 3
+	db := testDb(t, sim...)
+	if err := NewContext(db, testSimId).WalkFrom([]int{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if got := inventories(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("\n    want %+v\n    got  %+v", want, got)
+	}
+
+	err := NewContext(testDb(t, sim...), testSimId).WalkFrom([]int{42})
+	if err == nil || !strings.Contains(err.Error(), "no resource with id 42") {
+		t.Errorf("want missing resource error, got %v", err)
+	}
+}
+
+func TestDebugIntervals(t *testing.T) {
+	// res 1 is sent to agent 2 at the same timestep it is split
+	sameTime := []string{
+		"INSERT INTO Info VALUES (?,'',2000,1,10);",
+		"INSERT INTO AgentEntry VALUES (?,1,'Facility',':agents:Source','source',0,-1,0);",
+		"INSERT INTO AgentEntry VALUES (?,2,'Facility',':agents:Reactor','reactor',0,-1,0);",
+		"INSERT INTO Resources VALUES (?,1,1,'Material',1,10,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,1,'Material',4,4,'kg',1,1,0);",
+		"INSERT INTO Resources VALUES (?,3,1,'Material',4,6,'kg',1,1,0);",
+		"INSERT INTO ResCreators VALUES (?,1,1);",
+		"INSERT INTO Transactions VALUES (?,1,1,2,1,'fuel',4);",
+	}
+	db := testDb(t, sameTime...)
+	ctx := NewContext(db, testSimId)
+	ctx.Debug = true
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	want := []Node{
+		{ResId: 1, OwnerId: 1, StartTime: 1, EndTime: 4, QualId: 1, Quantity: 10},
+		{ResId: 2, OwnerId: 2, StartTime: 4, EndTime: math.MaxInt32, QualId: 1, Quantity: 4},
+		{ResId: 3, OwnerId: 2, StartTime: 4, EndTime: math.MaxInt32, QualId: 1, Quantity: 6},
+	}
+	if got := inventories(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("transfer at split:\n    want %+v\n    got  %+v", want, got)
+	}
+
+	// a transfer recorded after res 1 was split gives its first owner an
+	// entry ending after its second owner's entry starts
+	late := append(sameTime[:len(sameTime)-1:len(sameTime)-1], "INSERT INTO Transactions VALUES (?,1,1,2,1,'fuel',6);")
+	db = testDb(t, late...)
+	ctx = NewContext(db, testSimId)
+	ctx.Debug = true
+	if err := ctx.WalkAll(); err == nil || !strings.Contains(err.Error(), "ending before it starts") {
+		t.Errorf("transfer after split: want negative interval error, got %v", err)
+	}
+	if err := NewContext(testDb(t, late...), testSimId).WalkAll(); err != nil {
+		t.Errorf("transfer after split without debug: %v", err)
+	}
+}
+
+func TestRootsSQL(t *testing.T) {
+	// only walk the half of res 1 sent on to the sink
+	db := testDb(t, simpleSim...)
+	ctx := NewContext(db, testSimId)
+	ctx.RootsSQL = `SELECT ResourceId,TimeCreated,2,QualId,Quantity FROM Resources
+					WHERE SimId = ? AND ResourceId = 3`
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	want := []Node{
+		{ResId: 3, OwnerId: 2, StartTime: 5, EndTime: 6, QualId: 1, Quantity: 6},
+		{ResId: 3, OwnerId: 3, StartTime: 6, EndTime: math.MaxInt32, QualId: 1, Quantity: 6},
+	}
+	if got := inventories(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("\n    want %+v\n    got  %+v", want, got)
+	}
+
+	ctx = NewContext(testDb(t, simpleSim...), testSimId)
+	ctx.RootsSQL = "SELECT ResourceId,TimeCreated FROM Resources WHERE SimId = ?"
+	if err := ctx.WalkAll(); err == nil || !strings.Contains(err.Error(), "must select 5 columns") {
+		t.Errorf("want column count error, got %v", err)
+	}
+
+	ctx = NewContext(testDb(t, simpleSim...), testSimId)
+	ctx.RootsSQL = "SELECT ResourceId,TimeCreated,2,QualId,Quantity FROM Resources WHERE SimId = ? AND ResourceId = 3;"
+	if err := ctx.WalkAll(); err != nil {
+		t.Errorf("RootsSQL with a trailing semicolon: %v", err)
+	}
+}
+
+func TestParentCols(t *testing.T) {
+	// res 4 is created at t=4 by combining the three resources made at t=1
+	sim := []string{
+		"ALTER TABLE Resources ADD COLUMN Parent3 INTEGER;",
+		"INSERT INTO AgentEntry VALUES (?,1,'Facility',':agents:Mixer','mixer',0,-1,0);",
+		"INSERT INTO Resources VALUES (?,1,1,'Material',1,1,'kg',1,0,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',1,2,'kg',1,0,0,0);",
+		"INSERT INTO Resources VALUES (?,3,3,'Material',1,3,'kg',1,0,0,0);",
+		"INSERT INTO Resources VALUES (?,4,4,'Material',4,6,'kg',1,1,2,3);",
+		"INSERT INTO ResCreators VALUES (?,1,1);",
+		"INSERT INTO ResCreators VALUES (?,2,1);",
+		"INSERT INTO ResCreators VALUES (?,3,1);",
+	}
+	want := []Node{
+		{ResId: 1, OwnerId: 1, StartTime: 1, EndTime: 4, QualId: 1, Quantity: 1},
+		{ResId: 2, OwnerId: 1, StartTime: 1, EndTime: 4, QualId: 1, Quantity: 2},
+		{ResId: 3, OwnerId: 1, StartTime: 1, EndTime: 4, QualId: 1, Quantity: 3},
+		{ResId: 4, OwnerId: 1, StartTime: 4, EndTime: math.MaxInt32, QualId: 1, Quantity: 6},
+	}
+
+	db := testDb(t, sim...)
+	ctx := NewContext(db, testSimId)
+	ctx.ParentCols = []string{"Parent1", "Parent2", "Parent3"}
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if got := inventories(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("\n    want %+v\n    got  %+v", want, got)
+	}
+
+	// walking from part way through finds the boundary roots through all
+	// three columns too
+	db = testDb(t, sim...)
+	ctx = NewContext(db, testSimId)
+	ctx.ParentCols = []string{"Parent1", "Parent2", "Parent3"}
+	ctx.StartTime = 2
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if got, w := inventories(t, db), window(want, 2, 0); !reflect.DeepEqual(got, w) {
+		t.Errorf("start time:\n    want %+v\n    got  %+v", w, got)
+	}
+
+	// the default columns don't see res 3 being consumed
+	db = testDb(t, sim...)
+	if err := NewContext(db, testSimId).WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	want[2].EndTime = math.MaxInt32
+	if got := inventories(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("default columns:\n    want %+v\n    got  %+v", want, got)
+	}
+}
+
+// logFunc is an io.Writer calling f with every line logged to it.
+type logFunc func(line string)
+
+func (f logFunc) Write(p []byte) (int, error) {
+	f(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+func TestInitProgress(t *testing.T) {
+	db := testDb(t, simpleSim...)
+	ctx := NewContext(db, testSimId)
+	ctx.Verbosity = Verbose
+	var lines []string
+	ctx.Log = log.New(logFunc(func(line string) { lines = append(lines, line) }), "", 0)
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"Creating temporary resource table...",
+		"Created temporary resource table",
+		"Indexing temporary resource table on Parent1...",
+		"Indexed temporary resource table on Parent1",
+		"Indexing temporary resource table on Parent2...",
+		"Indexed temporary resource table on Parent2",
+		"Retrieving root resource nodes...",
+	}
+	i := 0
+	for _, line := range lines {
+		if i < len(want) && strings.HasPrefix(line, want[i]) {
+			i++
+		}
+	}
+	if i < len(want) {
+		t.Errorf("missing progress event %q in:\n%v", want[i], strings.Join(lines, "\n"))
+	}
+}
+
+func TestVerbosity(t *testing.T) {
+	cases := []struct {
+		v     Verbosity
+		lines int
+	}{
+		{Silent, 0},
+		// banner, create, index x2, roots, found, drop, and dump
+		{Normal, 8},
+		// plus create and index timings and the one root
+		{Verbose, 12},
+	}
+	for _, test := range cases {
+		ctx := NewContext(testDb(t, simpleSim...), testSimId)
+		ctx.Verbosity = test.v
+		var lines []string
+		ctx.Log = log.New(logFunc(func(line string) { lines = append(lines, line) }), "", 0)
+		if err := ctx.WalkAll(); err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) != test.lines {
+			t.Errorf("verbosity %v: want %v lines, got %v:\n%v", test.v, test.lines, len(lines), strings.Join(lines, "\n"))
+		}
+	}
+}
+
+func TestInitCancel(t *testing.T) {
+	db := testDb(t, simpleSim...)
+
+	// cancel while the temporary table is being indexed
+	cctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx := NewContext(db, testSimId)
+	ctx.Ctx = cctx
+	ctx.Log = log.New(logFunc(func(line string) {
+		if strings.HasPrefix(line, "Indexing temporary resource table") {
+			cancel()
+		}
+	}), "", 0)
+	if err := ctx.WalkAll(); err != context.Canceled {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+
+	var ntmp, nagents int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'tmp_restbl_%'").Scan(&ntmp); err != nil {
+		t.Fatal(err)
+	} else if err := db.QueryRow("SELECT COUNT(*) FROM Agents").Scan(&nagents); err != nil {
+		t.Fatal(err)
+	} else if ntmp != 0 || nagents != 0 {
+		t.Errorf("cancelled init not rolled back: %v temporary tables, %v agents", ntmp, nagents)
+	}
+
+	// the cancelled build can be redone from scratch
+	walk(t, db, 1)
+	if got := inventories(t, db); len(got) != 5 {
+		t.Errorf("want 5 inventory entries after rewalking, got %+v", got)
+	}
+}
+
+func TestWalkCancel(t *testing.T) {
+	// cancel during the first of several dumps so its transaction is
+	// rolled back
+	db := testDb(t, chainSim(50)...)
+	cctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx := NewContext(db, testSimId)
+	ctx.Ctx = cctx
+	ctx.MaxBufferedNodes = 10
+	ctx.Log = log.New(logFunc(func(line string) {
+		if strings.Contains(line, "Dumping inventories") {
+			cancel()
+		}
+	}), "", 0)
+	if err := ctx.WalkAll(); err != context.Canceled {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+
+	var ntmp, ninv int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'tmp_restbl_%'").Scan(&ntmp); err != nil {
+		t.Fatal(err)
+	} else if err := db.QueryRow("SELECT COUNT(*) FROM Inventories").Scan(&ninv); err != nil {
+		t.Fatal(err)
+	} else if ntmp != 0 || ninv != 0 {
+		t.Errorf("cancelled walk not cleaned up: %v temporary tables, %v inventory entries", ntmp, ninv)
+	}
+	if ctx.Stats.Dumps != 0 {
+		t.Errorf("want the cancelled dump rolled back, got %v dumps", ctx.Stats.Dumps)
+	}
+
+	// the cancelled build isn't mistaken for a finished one
+	walk(t, db, 1)
+	if got := inventories(t, db); len(got) != 99 {
+		t.Errorf("want 99 inventory entries after rewalking, got %v", len(got))
+	}
+}
+
+func TestProcessCancel(t *testing.T) {
+	stmts := append([]string{}, simpleSim...)
+	for _, s := range simpleSim {
+		stmts = append(stmts, strings.Replace(s, "(?,", "(X'73696d32',", 1))
+	}
+	db := testDb(t, stmts...)
+
+	// cancel while sim1's inventories are being dumped
+	cctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := ProcessOptions{
+		Ctx: cctx,
+		Log: log.New(logFunc(func(line string) {
+			if strings.Contains(line, "Dumping inventories") {
+				cancel()
+			}
+		}), "", 0),
+	}
+	err := ProcessIdsWith(db, [][]byte{testSimId, []byte("sim2")}, opts)
+	if err != context.Canceled {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+
+	// sim1's in-flight dump is rolled back and sim2 is never started
+	for _, c := range []struct {
+		id   []byte
+		want int
+	}{{testSimId, 0}, {[]byte("sim2"), 0}} {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM Inventories WHERE SimId = ?", c.id).Scan(&n); err != nil {
+			t.Fatal(err)
+		} else if n != c.want {
+			t.Errorf("want %v %s entries, got %v", c.want, c.id, n)
+		}
+	}
+}
+
+func TestProcessJobs(t *testing.T) {
+	simids := [][]byte{[]byte("sim1"), []byte("sim2"), []byte("sim3"), []byte("sim4"), []byte("sim5")}
+	build := func(jobs int) []string {
+		db := testDb(t)
+		for i, id := range simids {
+			opts := query.SynthOpts{Roots: 10, Branching: 2, Depth: 3, Churn: 2, Seed: int64(i)}
+			if err := query.GenSyntheticSim(db, id, opts); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ProcessIdsWith(db, simids, ProcessOptions{Jobs: jobs}); err != nil {
+			t.Fatalf("%v jobs: %v", jobs, err)
+		}
+
+		rows, err := db.Query("SELECT SimId,ResourceId,AgentId,StartTime,EndTime FROM Inventories ORDER BY SimId,ResourceId,StartTime")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var entries []string
+		for rows.Next() {
+			var id []byte
+			var res, agent, start, end int
+			if err := rows.Scan(&id, &res, &agent, &start, &end); err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, fmt.Sprintf("%s %v %v %v %v", id, res, agent, start, end))
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	want := build(1)
+	if len(want) == 0 {
+		t.Fatal("serial processing built no inventories")
+	}
+	if got := build(3); !reflect.DeepEqual(got, want) {
+		t.Errorf("concurrent processing differs from serial: want %v entries, got %v", len(want), len(got))
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	manifest := `# sims to build
+73696d32
+
+  73696d31 , 2a7f7854-cae5-4bb7-b6f5-6f1a1c2c9a10
+# 73696d33
+`
+	got, err := ReadManifest(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{
+		[]byte("sim2"),
+		[]byte("sim1"),
+		{0x2a, 0x7f, 0x78, 0x54, 0xca, 0xe5, 0x4b, 0xb7, 0xb6, 0xf5, 0x6f, 0x1a, 0x1c, 0x2c, 0x9a, 0x10},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %x, got %x", want, got)
+	}
+
+	if _, err := ReadManifest(strings.NewReader("73696d31\nnot-a-simid\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("want invalid simid error on line 2, got %v", err)
+	}
+}
+
+func TestProcessIds(t *testing.T) {
+	// a second copy of simpleSim under simid "sim2"
+	stmts := append([]string{}, simpleSim...)
+	for _, s := range simpleSim {
+		stmts = append(stmts, strings.Replace(s, "(?,", "(X'73696d32',", 1))
+	}
+	db := testDb(t, stmts...)
+
+	ids, err := ReadManifest(strings.NewReader("# only the second sim\n73696d32\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ProcessIds(db, ids); err != nil {
+		t.Fatal(err)
+	}
+
+	var sim1, sim2 int
+	err = db.QueryRow("SELECT COUNT(*) FROM Inventories WHERE SimId = ?", testSimId).Scan(&sim1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow("SELECT COUNT(*) FROM Inventories WHERE SimId = ?", []byte("sim2")).Scan(&sim2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim1 != 0 || sim2 != 5 {
+		t.Errorf("want only sim2 walked (5 entries), got %v sim1 and %v sim2 entries", sim1, sim2)
+	}
+}
+
+func TestStaleSimIds(t *testing.T) {
+	// sim2 is appended to a database with sim1's inventories already built
+	db := testDb(t, simpleSim...)
+	walk(t, db, 1)
+	for _, s := range simpleSim {
+		if _, err := db.Exec(strings.Replace(s, "(?,", "(X'73696d32',", 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, err := StaleSimIds(db, DefaultInvTable)
+	if err != nil {
+		t.Fatal(err)
+	} else if want := [][]byte{[]byte("sim2")}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("want stale %s, got %s", want, ids)
+	}
+	if err := ProcessIds(db, ids); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range [][]byte{testSimId, []byte("sim2")} {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM Inventories WHERE SimId = ?", id).Scan(&n); err != nil {
+			t.Fatal(err)
+		} else if n != 5 {
+			t.Errorf("want 5 %s entries, got %v", id, n)
+		}
+	}
+
+	if ids, err := StaleSimIds(db, DefaultInvTable); err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 0 {
+		t.Errorf("want no stale sims after building, got %s", ids)
+	}
+	if ids, err := StaleSimIds(db, "NoSuchTable"); err != nil {
+		t.Fatal(err)
+	} else if want := [][]byte{testSimId, []byte("sim2")}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("want both sims %s stale without an inventory table, got %s", want, ids)
+	}
+}
+
+func TestFinishWith(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		db := testDb(t, simpleSim...)
+		if err := NewContext(db, testSimId).WalkAll(); err != nil {
+			t.Fatal(err)
+		}
+
+		var lines []string
+		opts := FinishOptions{
+			Log:         log.New(logFunc(func(line string) { lines = append(lines, line) }), "", 0),
+			SkipAnalyze: skip,
+		}
+		if err := FinishWith(db, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		n := 0
+		err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='index' AND tbl_name='Inventories'").Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		} else if n != len(invIndexStmts(DefaultInvTable)) {
+			t.Errorf("skip=%v: want %v Inventories indexes, got %v", skip, len(invIndexStmts(DefaultInvTable)), n)
+		}
+
+		analyzed, err := query.HasTable(db, "sqlite_stat1")
+		if err != nil {
+			t.Fatal(err)
+		} else if analyzed == skip {
+			t.Errorf("skip=%v: ANALYZE run = %v", skip, analyzed)
+		}
+
+		nstmts := len(postExecStmts) + len(invIndexStmts(DefaultInvTable))
+		if !skip {
+			nstmts++
+		}
+		if len(lines) != nstmts {
+			t.Errorf("skip=%v: want %v timing lines, got %q", skip, nstmts, lines)
+		}
+	}
+}
+
+// rawInventories returns the inventory entries in the order they were
+// written.
+func rawInventories(t testing.TB, db *sql.DB) []Node {
+	return queryNodes(t, db, "SELECT ResourceId,AgentId,StartTime,EndTime,QualId,Quantity FROM Inventories ORDER BY rowid")
+}
+
+func TestSortDumps(t *testing.T) {
+	var runs [][]Node
+	for i := 0; i < 2; i++ {
+		db := testDb(t, genSim(50)...)
+		ctx := NewContext(db, testSimId)
+		ctx.Workers = 4
+		ctx.SortDumps = true
+		if err := ctx.WalkAll(); err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, rawInventories(t, db))
+	}
+
+	if len(runs[0]) == 0 {
+		t.Fatal("build produced no inventories")
+	} else if !reflect.DeepEqual(runs[0], runs[1]) {
+		t.Errorf("builds wrote entries in different orders:\n    %+v\n    %+v", runs[0], runs[1])
+	}
+	for i := 1; i < len(runs[0]); i++ {
+		a, b := runs[0][i-1], runs[0][i]
+		if a.ResId > b.ResId || a.ResId == b.ResId && a.StartTime > b.StartTime {
+			t.Fatalf("entries %v and %v out of order: %+v, %+v", i-1, i, a, b)
+		}
+	}
+}
+
+func TestAttachDB(t *testing.T) {
+	// a raw cyclus database without any of the walk's output tables
+	src, path := testDbFile(t, simpleSim...)
+	for _, tbl := range []string{"Inventories", "Agents", "TimeList"} {
+		if _, err := src.Exec("DROP TABLE " + tbl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.Close()
+	before, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE Scratch (x INTEGER)"); err == nil {
+		t.Fatal("source database opened writable")
+	}
+
+	outpath := filepath.Join(filepath.Dir(path), "out.sqlite")
+	db.SetMaxOpenConns(3)
+	ctx := NewContext(db, testSimId)
+	ctx.AttachDB = outpath
+	ctx.Workers = 4
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.Stats().MaxOpenConnections; n != 3 {
+		t.Errorf("want connection limit of 3 restored, got %v", n)
+	}
+
+	if after, err := ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(before, after) {
+		t.Errorf("source database modified")
+	}
+
+	out, err := sql.Open("sqlite3", outpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err := Finish(out); err != nil {
+		t.Fatal(err)
+	}
+	if got := inventories(t, out); len(got) != 5 {
+		t.Errorf("want 5 inventory entries in attached database, got %+v", got)
+	}
+	var nagents, ntimes, ntmp int
+	if err := out.QueryRow("SELECT COUNT(*) FROM Agents").Scan(&nagents); err != nil {
+		t.Fatal(err)
+	} else if err := out.QueryRow("SELECT COUNT(*) FROM TimeList").Scan(&ntimes); err != nil {
+		t.Fatal(err)
+	} else if err := out.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'tmp_restbl_%'").Scan(&ntmp); err != nil {
+		t.Fatal(err)
+	} else if nagents != 3 || ntimes != 10 || ntmp != 0 {
+		t.Errorf("want 3 agents, 10 times, and no temporary tables, got %v, %v, %v", nagents, ntimes, ntmp)
+	}
+}
+
+func TestProcessAttachDB(t *testing.T) {
+	// a read-only raw cyclus database without the walk's output tables
+	src, path := testDbFile(t, simpleSim...)
+	for _, tbl := range []string{"Inventories", "Agents", "TimeList", "AgentExit"} {
+		if _, err := src.Exec("DROP TABLE " + tbl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.Close()
+	before, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	outpath := filepath.Join(filepath.Dir(path), "derived.sqlite")
+	opts := ProcessOptions{AttachDB: outpath}
+	if err := ProcessIdsWith(db, [][]byte{testSimId}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(before, after) {
+		t.Errorf("source database modified")
+	}
+
+	// the derived tables are read through the source database's connection
+	if got := inventories(t, db); len(got) != 5 {
+		t.Errorf("want 5 inventory entries, got %+v", got)
+	}
+	n := 0
+	s := "SELECT COUNT(*) FROM " + outSchema + ".sqlite_master WHERE type = 'index' AND tbl_name = 'Inventories'"
+	if err := db.QueryRow(s).Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if n != len(invIndexStmts(DefaultInvTable)) {
+		t.Errorf("want %v inventory indexes in derived database, got %v", len(invIndexStmts(DefaultInvTable)), n)
+	}
+}
+
+// lockDb holds an exclusive lock on the database file at path for d.  The
+// returned channel is closed once the lock is released.
+func lockDb(t *testing.T, path string, d time.Duration) <-chan struct{} {
+	locker, err := sql.Open("sqlite3", path+"?_txlock=exclusive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := make(chan bool)
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		defer locker.Close()
+		tx, err := locker.Begin()
+		locked <- err == nil
+		if err == nil {
+			time.Sleep(d)
+			tx.Rollback()
+		}
+	}()
+	if !<-locked {
+		t.Fatal("failed to lock database")
+	}
+	return released
+}
+
+func TestRetryBusy(t *testing.T) {
+	_, path := testDbFile(t, simpleSim...)
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	released := lockDb(t, path, 100*time.Millisecond)
+	if err := NewContext(db, testSimId).WalkAll(); err == nil {
+		t.Fatal("walk without retries succeeded despite locked database")
+	}
+	<-released
+
+	lockDb(t, path, 100*time.Millisecond)
+	ctx := NewContext(db, testSimId)
+	ctx.RetryAttempts = 10
+	if err := ctx.WalkAll(); err != nil {
+		t.Fatalf("walk with retries failed: %v", err)
+	}
+	if got := inventories(t, db); len(got) != 5 {
+		t.Errorf("want 5 inventory entries, got %+v", got)
+	}
+}
+
+func TestIsBusy(t *testing.T) {
+	for _, c := range []struct {
+		Err  error
+		Want bool
+	}{
+		{nil, false},
+		{errors.New("database is locked"), true},
+		{errors.New("database table is locked: Inventories"), true},
+		{errors.New("no such table: Inventories"), false},
+	} {
+		if got := isBusy(c.Err); got != c.Want {
+			t.Errorf("isBusy(%v): want %v, got %v", c.Err, c.Want, got)
+		}
+	}
+}
+
+func TestDumpParquet(t *testing.T) {
+	db := testDb(t, simpleSim...)
+	if err := NewContext(db, testSimId).WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+	n := len(inventories(t, db))
+
+	var buf bytes.Buffer
+	if err := dumpParquet(db, testSimId, &buf, 2); err != nil {
+		t.Fatal(err)
+	}
+	m, err := parquet.ReadMeta(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NumRows != int64(n) {
+		t.Errorf("want %v rows, got %v", n, m.NumRows)
+	}
+	if want := (n + 1) / 2; len(m.GroupRows) != want {
+		t.Errorf("want %v row groups, got %v", want, m.GroupRows)
+	}
+}
+
+func TestDumpParquetShards(t *testing.T) {
+	// without the shipment to the sink only agents 1 and 2 hold resources
+	var stmts []string
+	for _, s := range simpleSim {
+		if !strings.Contains(s, "'waste'") {
+			stmts = append(stmts, s)
+		}
+	}
+	db := testDb(t, stmts...)
+	if err := NewContext(db, testSimId).WalkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := DumpParquet(db, testSimId, &buf); err != nil {
+		t.Fatal(err)
+	}
+	_, want, err := parquet.ReadRows(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.SliceStable(want, func(i, j int) bool { return want[i][2].(int64) < want[j][2].(int64) })
+
+	// a third shard is left empty
+	for _, n := range []int{2, 3} {
+		bufs := make([]bytes.Buffer, n)
+		ws := make([]io.Writer, n)
+		for i := range ws {
+			ws[i] = &bufs[i]
+		}
+		if err := dumpParquetShards(db, testSimId, ws, 1); err != nil {
+			t.Fatal(err)
+		}
+		var got [][]interface{}
+		for i, b := range bufs {
+			_, rows, err := parquet.ReadRows(bytes.NewReader(b.Bytes()), int64(b.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			agents := map[interface{}]bool{}
+			for _, row := range rows {
+				agents[row[2]] = true
+			}
+			if i < 2 && len(agents) != 1 || i >= 2 && len(agents) != 0 {
+				t.Errorf("%v shards: want shard %v to hold one agent, got %v", n, i, agents)
+			}
+			got = append(got, rows...)
+		}
+		if len(want) == 0 || !reflect.DeepEqual(got, want) {
+			t.Errorf("%v shards: concatenated shards differ from the single file:\n    want %v\n    got  %v", n, want, got)
+		}
+	}
+}
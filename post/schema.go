@@ -0,0 +1,74 @@
+package post
+
+import (
+	"database/sql"
+
+	"github.com/rwcarlsen/cyan/query"
+)
+
+// SchemaVersion identifies the column naming of a cyclus output database.
+type SchemaVersion string
+
+const (
+	// CurrentSchema names agents AgentId and resource states QualId.
+	CurrentSchema SchemaVersion = "current"
+	// LegacySchema is the naming of older cyclus versions, which called
+	// agents models (ModelId) and resource qualities states (StateId).
+	LegacySchema SchemaVersion = "legacy"
+)
+
+// DetectSchema returns the column naming used by the cyclus output tables in
+// db.  Legacy databases are read as is: walks select the old columns under
+// their current names (see query.AliasSql).  Databases migrated by
+// MigrateSchema are current.
+func DetectSchema(db *sql.DB) (SchemaVersion, error) {
+	stale, err := query.StaleCols(db)
+	if err != nil {
+		return "", err
+	} else if len(stale) > 0 {
+		return LegacySchema, nil
+	}
+	return CurrentSchema, nil
+}
+
+// MigrateSchema adds the current names of any columns of a legacy schema
+// database (see DetectSchema) as copies of the old ones, which are left in
+// place.  It returns the columns migrated as "Table.Old -> Col".  Migrating
+// isn't needed to walk a legacy database, but it rewrites the raw output
+// tables so other tools can read them with current column names.
+func MigrateSchema(db *sql.DB) (migrated []string, err error) {
+	stale, err := query.StaleCols(db)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() // no-op once committed
+	for _, a := range stale {
+		if _, err := tx.Exec("ALTER TABLE " + a.Table + " ADD COLUMN " + a.Col + " INTEGER;"); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("UPDATE " + a.Table + " SET " + a.Col + " = " + a.Old + ";"); err != nil {
+			return nil, err
+		}
+		migrated = append(migrated, a.Table+"."+a.Old+" -> "+a.Col)
+	}
+	return migrated, tx.Commit()
+}
+
+// staleIndexCols returns cols, naming any stale columns of table by their
+// old names so the columns can be indexed.
+func staleIndexCols(stale []query.ColAlias, table string, cols []string) []string {
+	out := append([]string{}, cols...)
+	for i, col := range out {
+		for _, a := range stale {
+			if a.Table == table && a.Col == col {
+				out[i] = a.Old
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,75 @@
+package post
+
+import (
+	"reflect"
+	"testing"
+)
+
+// legacySchema holds the raw output tables of older cyclus versions, which
+// name agents ModelId and resource states StateId.
+var legacySchema = []string{
+	"CREATE TABLE Info (SimId BLOB,Handle TEXT,InitialYear INTEGER,InitialMonth INTEGER,Duration INTEGER);",
+	"CREATE TABLE AgentEntry (SimId BLOB,ModelId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER);",
+	"CREATE TABLE AgentExit (SimId BLOB,ModelId INTEGER,ExitTime INTEGER);",
+	"CREATE TABLE Resources (SimId INTEGER,ResourceId INTEGER,ObjId INTEGER,Type TEXT,TimeCreated INTEGER,Quantity REAL,Units TEXT,StateId INTEGER,Parent1 INTEGER,Parent2 INTEGER);",
+	"CREATE TABLE ResCreators (SimId INTEGER,ResourceId INTEGER,ModelId INTEGER);",
+	"CREATE TABLE Compositions (SimId BLOB,StateId INTEGER,NucId INTEGER, MassFrac REAL);",
+	"CREATE TABLE Transactions (SimId BLOB, TransactionId INTEGER, SenderId INTEGER, ReceiverId INTEGER, ResourceId INTEGER, Commodity TEXT, Time INTEGER);",
+}
+
+func TestMigrateSchema(t *testing.T) {
+	current := testDb(t, simpleSim...)
+	if v, err := DetectSchema(current); err != nil {
+		t.Fatal(err)
+	} else if v != CurrentSchema {
+		t.Errorf("current fixture: want %v schema, got %v", CurrentSchema, v)
+	}
+	walk(t, current, 1)
+
+	legacy, _ := openTestDb(t)
+	for _, s := range append(legacySchema, simpleSim...) {
+		if _, err := legacy.Exec(s, simArgs(s)...); err != nil {
+			t.Fatalf("%v: %v", s, err)
+		}
+	}
+	if v, err := DetectSchema(legacy); err != nil {
+		t.Fatal(err)
+	} else if v != LegacySchema {
+		t.Errorf("legacy fixture: want %v schema, got %v", LegacySchema, v)
+	}
+
+	// legacy databases are walked without rewriting their raw tables
+	if err := Prepare(legacy); err != nil {
+		t.Fatal(err)
+	}
+	walk(t, legacy, 1)
+	if v, err := DetectSchema(legacy); err != nil {
+		t.Fatal(err)
+	} else if v != LegacySchema {
+		t.Errorf("walked fixture: want %v schema, got %v", LegacySchema, v)
+	}
+
+	want := inventories(t, current)
+	if got := inventories(t, legacy); !reflect.DeepEqual(got, want) {
+		t.Errorf("legacy inventories:\n    want %+v\n    got  %+v", want, got)
+	}
+
+	// migrating is an explicit step
+	if migrated, err := MigrateSchema(legacy); err != nil {
+		t.Fatal(err)
+	} else if len(migrated) != 5 {
+		t.Errorf("want 5 columns migrated, got %v", migrated)
+	}
+	if v, err := DetectSchema(legacy); err != nil {
+		t.Fatal(err)
+	} else if v != CurrentSchema {
+		t.Errorf("migrated fixture: want %v schema, got %v", CurrentSchema, v)
+	}
+
+	// migrating again is a no-op
+	if migrated, err := MigrateSchema(legacy); err != nil {
+		t.Fatal(err)
+	} else if len(migrated) != 0 {
+		t.Errorf("want nothing left to migrate, got %v", migrated)
+	}
+}
@@ -1,8 +1,17 @@
 package post
 
 import (
+	"bufio"
 	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
 	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/rwcarlsen/cyan/query"
 )
 
 // GetSimIds returns a list of all simulation ids in the cyclus database for
@@ -28,10 +37,121 @@ func GetSimIds(db *sql.DB) (ids [][]byte, err error) {
 	return ids, nil
 }
 
-func panicif(err error) {
+// StaleSimIds returns the ids of simulations with resources in the cyclus
+// database for conn but no entries in the named inventory table (e.g.
+// simulations appended to an already post processed database), ordered by
+// simid.
+func StaleSimIds(db *sql.DB, table string) (ids [][]byte, err error) {
+	sql := "SELECT DISTINCT SimId FROM Resources"
+	if ok, err := query.HasTable(db, table); err != nil {
+		return nil, err
+	} else if ok {
+		sql += " WHERE SimId NOT IN (SELECT DISTINCT SimId FROM " + table + ")"
+	}
+	sql += " ORDER BY SimId"
+	rows, err := db.Query(sql)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s []byte
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		ids = append(ids, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ReadManifest parses a list of simulation ids to process from r.  Ids are
+// separated by newlines or commas and may be written as uuids or plain hex.
+// Blank lines and lines starting with '#' are ignored.
+func ReadManifest(r io.Reader) (ids [][]byte, err error) {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, field := range strings.Split(line, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			id := parseSimId(field)
+			if id == nil {
+				return nil, fmt.Errorf("manifest line %v: invalid simid '%v'", n, field)
+			}
+			ids = append(ids, id)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// parseSimId returns the simid written as a uuid or plain hex in s or nil
+// if s is neither.
+func parseSimId(s string) []byte {
+	if id := uuid.Parse(s); id != nil {
+		return []byte(id)
+	}
+	id, err := hex.DecodeString(s)
+	if err != nil || len(id) == 0 {
+		return nil
+	}
+	return id
+}
+
+// isBusy returns true if err is an sqlite error caused by another
+// connection holding a lock on the database (SQLITE_BUSY or SQLITE_LOCKED).
+// It matches sqlite's error messages rather than a driver's error type so
+// the package isn't tied to a particular sqlite driver.
+func isBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked")
+}
+
+// caller returns the name of the function skip frames up the stack without
+// its package path or closure suffixes, e.g. "post.(*walker).walkDown".
+func caller(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip)
+	if !ok {
+		return "unknown"
+	}
+	name := runtime.FuncForPC(pc).Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	for {
+		i := strings.LastIndex(name, ".")
+		if i < 0 || !isClosure(name[i+1:]) {
+			return name
+		}
+		name = name[:i]
+	}
+}
+
+// isClosure returns true if seg is a compiler generated closure name such as
+// "func1" or "2".
+func isClosure(seg string) bool {
+	seg = strings.TrimPrefix(seg, "func")
+	if seg == "" {
+		return false
+	}
+	for _, c := range seg {
+		if c < '0' || c > '9' {
+			return false
+		}
 	}
+	return true
 }
 
 type Timer struct {
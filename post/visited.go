@@ -0,0 +1,110 @@
+package post
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// denseFactor is the maximum ratio of resource id range to resource count
+// for which a bitSet is used to track walked resources instead of a mapSet.
+// At this ratio a bitSet uses around 4 bytes per resource - an order of
+// magnitude less than a map.
+const denseFactor = 32
+
+// visitSet tracks which resources have been walked.
+type visitSet interface {
+	// add marks id as visited and returns true if it wasn't already.
+	add(id int) bool
+}
+
+// newVisitSet returns a visitSet suited to count resources with ids in the
+// range [min, max].
+func newVisitSet(min, max, count int) visitSet {
+	if count > 0 && max >= min && max-min+1 <= denseFactor*count {
+		return newBitSet(min, max)
+	}
+	return mapSet{}
+}
+
+type mapSet map[int32]struct{}
+
+func (s mapSet) add(id int) bool {
+	if _, ok := s[int32(id)]; ok {
+		return false
+	}
+	s[int32(id)] = struct{}{}
+	return true
+}
+
+// bitSet is a visitSet for densely numbered resources that uses one bit per
+// id in its range.  Ids outside the range are tracked in an overflow map.
+type bitSet struct {
+	min      int
+	bits     []uint64
+	overflow mapSet
+}
+
+func newBitSet(min, max int) *bitSet {
+	return &bitSet{
+		min:      min,
+		bits:     make([]uint64, (max-min)/64+1),
+		overflow: mapSet{},
+	}
+}
+
+func (s *bitSet) add(id int) bool {
+	i := id - s.min
+	if i < 0 || i/64 >= len(s.bits) {
+		return s.overflow.add(id)
+	}
+	mask := uint64(1) << uint(i%64)
+	if s.bits[i/64]&mask != 0 {
+		return false
+	}
+	s.bits[i/64] |= mask
+	return true
+}
+
+// visitShards is the number of independently locked parts of a VisitedSet.
+const visitShards = 64
+
+// VisitedSet is a set of resource ids that is safe for concurrent use.  Ids
+// are spread over shards that are locked independently so that goroutines
+// adding different ids rarely contend.
+type VisitedSet struct {
+	shards [visitShards]struct {
+		sync.Mutex
+		set visitSet
+	}
+	n int64
+}
+
+// NewVisitedSet returns an empty VisitedSet suited to count resources with
+// ids in the range [min, max].  Ids outside the range may still be added.
+func NewVisitedSet(min, max, count int) *VisitedSet {
+	s := &VisitedSet{}
+	for i := range s.shards {
+		s.shards[i].set = newVisitSet(min/visitShards, max/visitShards, count/visitShards)
+	}
+	return s
+}
+
+// Add marks id as visited and returns true if it wasn't already.  Of any
+// number of concurrent calls adding the same id, exactly one returns true.
+func (s *VisitedSet) Add(id int) (added bool) {
+	q := id / visitShards
+	if id%visitShards < 0 {
+		q--
+	}
+	shard := &s.shards[id-q*visitShards]
+	shard.Lock()
+	added = shard.set.add(q)
+	shard.Unlock()
+	if added {
+		atomic.AddInt64(&s.n, 1)
+	}
+	return added
+}
+
+// Len returns the number of ids in the set.
+func (s *VisitedSet) Len() int { return int(atomic.LoadInt64(&s.n)) }
@@ -0,0 +1,88 @@
+package post
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBitSet(t *testing.T) {
+	// include ids outside the bitset's range to exercise the overflow map
+	const min, max = 100, 1000
+	bs := newBitSet(min, max)
+	ms := mapSet{}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		id := r.Intn(max+200) - 50
+		if got, want := bs.add(id), ms.add(id); got != want {
+			t.Fatalf("add(%v) #%v: bitSet returned %v, mapSet returned %v", id, i, got, want)
+		}
+	}
+}
+
+func TestNewVisitSet(t *testing.T) {
+	if _, ok := newVisitSet(1, 1000, 900).(*bitSet); !ok {
+		t.Errorf("dense ids: want a bitSet")
+	}
+	if _, ok := newVisitSet(1, 1e9, 10).(mapSet); !ok {
+		t.Errorf("sparse ids: want a mapSet")
+	}
+	if _, ok := newVisitSet(0, 0, 0).(mapSet); !ok {
+		t.Errorf("no ids: want a mapSet")
+	}
+}
+
+func TestVisitedSet(t *testing.T) {
+	// dense ids plus sparse and negative ones outside the expected range
+	ids := []int{-1e9, -65, -64, -1}
+	for id := 0; id < 5000; id++ {
+		ids = append(ids, id)
+	}
+	ids = append(ids, 1e9, 1e9+64)
+
+	for _, s := range []*VisitedSet{NewVisitedSet(0, 4999, 5000), NewVisitedSet(0, 0, 0)} {
+		adds := make([]int32, len(ids))
+		var wg sync.WaitGroup
+		for g := 0; g < 16; g++ {
+			wg.Add(1)
+			go func(seed int64) {
+				defer wg.Done()
+				for _, i := range rand.New(rand.NewSource(seed)).Perm(len(ids)) {
+					if s.Add(ids[i]) {
+						atomic.AddInt32(&adds[i], 1)
+					}
+				}
+			}(int64(g))
+		}
+		wg.Wait()
+
+		for i, n := range adds {
+			if n != 1 {
+				t.Errorf("id %v added %v times, want exactly once", ids[i], n)
+			}
+		}
+		if s.Len() != len(ids) {
+			t.Errorf("want Len %v, got %v", len(ids), s.Len())
+		}
+	}
+}
+
+func benchmarkVisitSet(b *testing.B, newSet func(n int) visitSet) {
+	const n = 1000000
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := newSet(n)
+		for id := 0; id < n; id++ {
+			s.add(id)
+		}
+	}
+}
+
+func BenchmarkVisitSetMap(b *testing.B) {
+	benchmarkVisitSet(b, func(n int) visitSet { return mapSet{} })
+}
+
+func BenchmarkVisitSetBits(b *testing.B) {
+	benchmarkVisitSet(b, func(n int) visitSet { return newBitSet(0, n-1) })
+}
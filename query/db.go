@@ -0,0 +1,42 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DB is the database read by the query functions: an *sql.DB or *sql.Tx, or
+// a database bound to a context by WithContext or to a schema by WithSchema.
+type DB interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ContextDB is a database whose statements can be run with a context, e.g.
+// an *sql.DB or *sql.Tx.
+type ContextDB interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// WithContext returns db with its statements run with ctx.  Queries through
+// it fail with ctx's error once ctx is done, and rows being read are closed,
+// so cancelling ctx stops even a query returning millions of rows.  The
+// database driver can't interrupt the execution of a statement itself, so a
+// query computing a single large aggregate only stops once it returns.
+func WithContext(ctx context.Context, db ContextDB) DB {
+	return ctxDB{ctx, db}
+}
+
+type ctxDB struct {
+	ctx context.Context
+	db  ContextDB
+}
+
+func (c ctxDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.QueryContext(c.ctx, query, args...)
+}
+
+func (c ctxDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
@@ -0,0 +1,46 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithContext(t *testing.T) {
+	db := testDb(t)
+	if err := GenSyntheticSim(db, testSimId, SynthOpts{Roots: 10, Branching: 2, Depth: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cdb := WithContext(ctx, db)
+	if ids, err := SimIds(cdb); err != nil || len(ids) != 1 {
+		t.Fatalf("want 1 simid before cancelling, got %v (%v)", len(ids), err)
+	}
+
+	// reading rows stops once the context is cancelled (the rows are closed
+	// in the background)
+	rows, err := cdb.Query("SELECT ResourceId FROM Resources WHERE SimId = ?;", testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		if n++; n == 1 {
+			cancel()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	if err := rows.Err(); err != context.Canceled {
+		t.Errorf("want context.Canceled reading rows, got %v", err)
+	}
+	if n != 1 {
+		t.Errorf("want rows to stop after cancelling, read %v", n)
+	}
+
+	if _, err := SimIds(cdb); err != context.Canceled {
+		t.Errorf("want context.Canceled after cancelling, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package query
+
+import (
+	"math"
+	"sort"
+
+	"github.com/rwcarlsen/cyan/nuc"
+)
+
+// CostTables are the cash flow tables read by Economics - those written by
+// cyclus economic archetypes and cymetric's economic metrics, each holding
+// the Payment an agent made at a Time.  Tables missing from the database are
+// skipped.
+var CostTables = []string{"CapitalCost", "OperationMaintenance", "FuelCost", "DecommissioningCost"}
+
+// Discount returns the present value of one unit of money paid secs seconds
+// from now at an annual discount rate.
+func Discount(rate, secs float64) float64 {
+	return math.Pow(1+rate, -secs/nuc.Year)
+}
+
+// AgentEcon is the cost of an agent and the electricity it generated over a
+// simulation, both undiscounted and discounted to the start of the
+// simulation.
+type AgentEcon struct {
+	Agent int
+	// Costs holds the undiscounted payments in each of CostTables.
+	Costs map[string]float64
+	// Cost is the total of Costs.
+	Cost     float64
+	DiscCost float64
+	// Energy is the electricity generated in MWh.
+	Energy     float64
+	DiscEnergy float64
+}
+
+// LCOE returns the levelized cost of electricity in money per MWh - the
+// discounted cost over the discounted electricity generated.  ok is false if
+// no electricity was generated, leaving the cost undefined.
+func (e AgentEcon) LCOE() (lcoe float64, ok bool) {
+	if e.DiscEnergy <= 0 {
+		return 0, false
+	}
+	return e.DiscCost / e.DiscEnergy, true
+}
+
+// Add adds the costs and electricity of other to e.
+func (e *AgentEcon) Add(other AgentEcon) {
+	if e.Costs == nil {
+		e.Costs = map[string]float64{}
+	}
+	for table, c := range other.Costs {
+		e.Costs[table] += c
+	}
+	e.Cost += other.Cost
+	e.DiscCost += other.DiscCost
+	e.Energy += other.Energy
+	e.DiscEnergy += other.DiscEnergy
+}
+
+// Economics returns the costs in CostTables and the electricity in
+// TimeSeriesPower of every agent of the specified simulation with either,
+// ordered by agent id.  Payments and power at timestep t are discounted by
+// t timesteps (see TimestepSecs) at the annual rate.
+func Economics(db DB, simid []byte, rate float64) (econs []AgentEcon, err error) {
+	dt, err := TimestepSecs(db, simid)
+	if err != nil {
+		return nil, err
+	}
+	byagent := map[int]*AgentEcon{}
+	econ := func(id int) *AgentEcon {
+		e, ok := byagent[id]
+		if !ok {
+			e = &AgentEcon{Agent: id, Costs: map[string]float64{}}
+			byagent[id] = e
+		}
+		return e
+	}
+
+	for _, table := range CostTables {
+		if ok, err := HasTable(db, table); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		rows, err := db.Query("SELECT AgentId,Time,Payment FROM "+table+" WHERE SimId = ?;", simid)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id, t int
+			var pay float64
+			if err := rows.Scan(&id, &t, &pay); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			e := econ(id)
+			e.Costs[table] += pay
+			e.Cost += pay
+			e.DiscCost += pay * Discount(rate, float64(t)*dt)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	ps, err := PowerSeries(db, simid)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range ps {
+		e := econ(p.Agent)
+		mwh := p.Power * dt / nuc.Hour
+		e.Energy += mwh
+		e.DiscEnergy += mwh * Discount(rate, float64(p.Time)*dt)
+	}
+
+	for _, e := range byagent {
+		econs = append(econs, *e)
+	}
+	sort.Slice(econs, func(i, j int) bool { return econs[i].Agent < econs[j].Agent })
+	return econs, nil
+}
@@ -0,0 +1,64 @@
+package query
+
+import (
+	"math"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/nuc"
+)
+
+func TestDiscount(t *testing.T) {
+	if got := Discount(0.1, 2*nuc.Year); math.Abs(got-1/1.21) > 1e-12 {
+		t.Errorf("want %v after two years at 10%%, got %v", 1/1.21, got)
+	}
+	if got := Discount(0, 5*nuc.Year); got != 1 {
+		t.Errorf("want no discount at a zero rate, got %v", got)
+	}
+}
+
+func TestEconomics(t *testing.T) {
+	db := testDb(t,
+		"CREATE TABLE TimeStepDur (SimId BLOB,DurationSecs INTEGER);",
+		"INSERT INTO TimeStepDur VALUES (?,31557600);",
+		"CREATE TABLE CapitalCost (SimId BLOB,AgentId INTEGER,Time INTEGER,Payment REAL);",
+		"CREATE TABLE FuelCost (SimId BLOB,AgentId INTEGER,Time INTEGER,Payment REAL);",
+		"CREATE TABLE TimeSeriesPower (SimId BLOB,AgentId INTEGER,Time INTEGER,Value REAL);",
+		"INSERT INTO CapitalCost VALUES (?,1,0,1000);",
+		"INSERT INTO FuelCost VALUES (?,1,1,110);",
+		"INSERT INTO FuelCost VALUES (?,2,0,5);",
+		"INSERT INTO TimeSeriesPower VALUES (?,1,1,1);",
+	)
+
+	econs, err := Economics(db, testSimId, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(econs) != 2 || econs[0].Agent != 1 || econs[1].Agent != 2 {
+		t.Fatalf("want agents 1 and 2, got %+v", econs)
+	}
+
+	e := econs[0]
+	mwh := nuc.Year / nuc.Hour
+	if e.Costs["CapitalCost"] != 1000 || e.Costs["FuelCost"] != 110 || e.Cost != 1110 {
+		t.Errorf("want 1000 capital and 110 fuel cost, got %+v", e)
+	}
+	if math.Abs(e.DiscCost-1100) > 1e-9 {
+		t.Errorf("want 1100 discounted cost, got %v", e.DiscCost)
+	}
+	if math.Abs(e.Energy-mwh) > 1e-9 || math.Abs(e.DiscEnergy-mwh/1.1) > 1e-9 {
+		t.Errorf("want %v MWh (%v discounted), got %v (%v)", mwh, mwh/1.1, e.Energy, e.DiscEnergy)
+	}
+	want := 1100 / (mwh / 1.1)
+	if lcoe, ok := e.LCOE(); !ok || math.Abs(lcoe-want) > 1e-9 {
+		t.Errorf("want LCOE %v, got %v (ok=%v)", want, lcoe, ok)
+	}
+	if lcoe, ok := econs[1].LCOE(); ok {
+		t.Errorf("want no LCOE without electricity, got %v", lcoe)
+	}
+
+	var total AgentEcon
+	total.Add(econs[0])
+	total.Add(econs[1])
+	if total.Cost != 1115 || total.Costs["FuelCost"] != 115 {
+		t.Errorf("want summed costs, got %+v", total)
+	}
+}
@@ -0,0 +1,119 @@
+package query
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/rwcarlsen/cyan/nuc"
+)
+
+// Cyclus records the inventories of every agent at every timestep in these
+// tables when run with the explicit_inventory (per nuclide) or
+// explicit_inventory_compact (total mass with a serialized composition)
+// control options.
+const (
+	ExplicitTable        = "ExplicitInventory"
+	ExplicitCompactTable = "ExplicitInventoryCompact"
+)
+
+// ErrNoExplicitInventory is returned by explicit inventory queries for
+// simulations that recorded no explicit inventories usable by the query.
+var ErrNoExplicitInventory = errors.New("simulation has no explicit inventory table")
+
+// HasExplicitInventory returns true if the simulation recorded explicit
+// inventories in either ExplicitTable or ExplicitCompactTable.  Inventory
+// series can then be read with ExplicitInvSeries without post processing
+// the database.
+func HasExplicitInventory(db DB, simid []byte) (bool, error) {
+	table, err := ExplicitInvTable(db, simid, false)
+	return table != "", err
+}
+
+// hasExplicit returns true if the named explicit inventory table holds
+// entries for the simulation.
+func hasExplicit(db DB, simid []byte, table string) (bool, error) {
+	if ok, err := HasTable(db, table); err != nil || !ok {
+		return false, err
+	}
+	n := 0
+	err := db.QueryRow("SELECT COUNT(*) FROM (SELECT 1 FROM "+table+" WHERE SimId = ? LIMIT 1);", simid).Scan(&n)
+	return n > 0, err
+}
+
+// ExplicitInvSeries returns the total mass of the listed nuclides held by
+// the listed agents at every timestep of the simulation, read from its
+// explicit inventory tables.  Passing no agents or nuclides defaults to
+// all of them.  Unlike the other inventory series it needs neither the
+// Inventories nor the TimeList table, so the database doesn't have to be
+// post processed.  Totals over all nuclides fall back to
+// ExplicitCompactTable, whose compositions are serialized and can't be
+// filtered by nuclide; ErrNoExplicitInventory is returned when neither
+// table can answer the query.
+func ExplicitInvSeries(db DB, simid []byte, agents []int, nucs ...nuc.Nuc) (xys []XY, err error) {
+	return collectXY(ExplicitInvSeriesIter(db, simid, agents, nucs...))
+}
+
+// ExplicitInvSeriesIter is like ExplicitInvSeries but returns an iterator
+// over the series instead of loading it into memory.
+func ExplicitInvSeriesIter(db DB, simid []byte, agents []int, nucs ...nuc.Nuc) (*XYIterator, error) {
+	table, err := ExplicitInvTable(db, simid, len(nucs) > 0)
+	if err != nil {
+		return nil, err
+	} else if table == "" {
+		return nil, ErrNoExplicitInventory
+	}
+
+	filt := ""
+	if len(agents) > 0 {
+		filt += " AND ex.AgentId IN (" + joinInts(agents) + ")"
+	}
+	if len(nucs) > 0 {
+		ids := make([]int, len(nucs))
+		for i, n := range nucs {
+			ids[i] = int(n)
+		}
+		filt += " AND ex.NucId IN (" + joinInts(ids) + ")"
+	}
+
+	sql := `WITH RECURSIVE ti(Time) AS (
+				SELECT 0 UNION ALL SELECT Time+1 FROM ti
+				WHERE Time+1 < (SELECT Duration FROM Info WHERE SimId = ?)
+			)
+			SELECT ti.Time,IFNULL(SUM(ex.Quantity),0) FROM ti
+			LEFT JOIN ` + table + ` AS ex ON ex.SimId = ? AND ex.Time = ti.Time` + filt + `
+			GROUP BY ti.Time ORDER BY ti.Time;`
+	rows, err := db.Query(sql, simid, simid)
+	if err != nil {
+		return nil, err
+	}
+	return &XYIterator{cursor{rows}}, nil
+}
+
+// ExplicitInvTable returns the explicit inventory table holding entries for
+// the simulation, preferring ExplicitTable, or "" if there is none.  Only
+// ExplicitTable is returned if byNuc is true since the compact table has no
+// per nuclide quantities.
+func ExplicitInvTable(db DB, simid []byte, byNuc bool) (string, error) {
+	tables := []string{ExplicitTable}
+	if !byNuc {
+		tables = append(tables, ExplicitCompactTable)
+	}
+	for _, t := range tables {
+		if ok, err := hasExplicit(db, simid, t); err != nil || ok {
+			return t, err
+		}
+	}
+	return "", nil
+}
+
+// joinInts returns the comma separated list of vals.
+func joinInts(vals []int) string {
+	s := ""
+	for i, v := range vals {
+		if i > 0 {
+			s += ","
+		}
+		s += strconv.Itoa(v)
+	}
+	return s
+}
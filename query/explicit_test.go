@@ -0,0 +1,65 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/nuc"
+)
+
+func TestExplicitInvSeries(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Info VALUES (?,'',2000,1,4);",
+		"CREATE TABLE ExplicitInventory (SimId BLOB,AgentId INTEGER,Time INTEGER,InventoryName TEXT,NucId INTEGER,Quantity REAL);",
+		"INSERT INTO ExplicitInventory VALUES (?,1,0,'core',922350000,1),(?,1,0,'core',922380000,4),(?,1,1,'spent',922350000,2);",
+		"INSERT INTO ExplicitInventory VALUES (?,2,1,'inventory',922380000,8),(?,2,2,'inventory',922380000,8);",
+		"INSERT INTO ExplicitInventory VALUES (X'73696d32',1,0,'core',922350000,100);",
+	)
+
+	if ok, err := HasExplicitInventory(db, testSimId); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Errorf("explicit inventory not found")
+	}
+
+	for _, c := range []struct {
+		Agents []int
+		Nucs   []nuc.Nuc
+		Want   []XY
+	}{
+		{nil, nil, []XY{{0, 5}, {1, 10}, {2, 8}, {3, 0}}},
+		{[]int{1}, nil, []XY{{0, 5}, {1, 2}, {2, 0}, {3, 0}}},
+		{nil, []nuc.Nuc{922350000}, []XY{{0, 1}, {1, 2}, {2, 0}, {3, 0}}},
+		{[]int{1, 2}, []nuc.Nuc{922380000}, []XY{{0, 4}, {1, 8}, {2, 8}, {3, 0}}},
+	} {
+		xys, err := ExplicitInvSeries(db, testSimId, c.Agents, c.Nucs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(xys, c.Want) {
+			t.Errorf("agents %v nucs %v: want %v, got %v", c.Agents, c.Nucs, c.Want, xys)
+		}
+	}
+}
+
+func TestExplicitInvSeriesCompact(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Info VALUES (?,'',2000,1,2);",
+		"CREATE TABLE ExplicitInventoryCompact (SimId BLOB,AgentId INTEGER,Time INTEGER,InventoryName TEXT,Quantity REAL,Composition BLOB);",
+		"INSERT INTO ExplicitInventoryCompact VALUES (?,1,0,'core',3,''),(?,1,1,'core',7,'');",
+	)
+
+	xys, err := ExplicitInvSeries(db, testSimId, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []XY{{0, 3}, {1, 7}}; !reflect.DeepEqual(xys, want) {
+		t.Errorf("want %v, got %v", want, xys)
+	}
+	if _, err := ExplicitInvSeries(db, testSimId, nil, 922350000); err != ErrNoExplicitInventory {
+		t.Errorf("nuclide filter on compact table: want ErrNoExplicitInventory, got %v", err)
+	}
+	if ok, err := HasExplicitInventory(db, []byte("sim2")); err != nil || ok {
+		t.Errorf("sim2: want no explicit inventory, got %v (err %v)", ok, err)
+	}
+}
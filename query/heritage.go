@@ -0,0 +1,128 @@
+package query
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// ResNode is a resource in the heritage of another (see Heritage).
+type ResNode struct {
+	ResId    int
+	Time     int // creation time
+	Quantity float64
+	QualId   int
+	// Parent1 and Parent2 are the resources this one was made from, 0 if
+	// none.
+	Parent1, Parent2 int
+	// Owner is the agent holding the resource when it was created - or -1
+	// if unknown, i.e. it wasn't created by an agent and the simulation
+	// hasn't been post processed.
+	Owner int
+}
+
+// Heritage returns the resources the specified resource was made from,
+// following its parents up to the resources created from nothing, and the
+// resources made from it, following its children down.  Walks stop after
+// maxDepth generations in each direction unless maxDepth is less than 1.
+// The resource itself is first and the rest are in the order found.
+func Heritage(db DB, simid []byte, resid, maxDepth int) (nodes []ResNode, err error) {
+	get, err := rawSql(db, "SELECT ResourceId,TimeCreated,Quantity,QualId,Parent1,Parent2 FROM Resources WHERE SimId = ? AND ResourceId = ?;")
+	if err != nil {
+		return nil, err
+	}
+	kids, err := rawSql(db, "SELECT ResourceId,TimeCreated,Quantity,QualId,Parent1,Parent2 FROM Resources WHERE SimId = ? AND (Parent1 = ? OR Parent2 = ?) ORDER BY ResourceId;")
+	if err != nil {
+		return nil, err
+	}
+
+	n := ResNode{}
+	err = db.QueryRow(get, simid, resid).Scan(&n.ResId, &n.Time, &n.Quantity, &n.QualId, &n.Parent1, &n.Parent2)
+	if err != nil {
+		return nil, err
+	}
+	nodes = append(nodes, n)
+	seen := map[int]bool{resid: true}
+
+	// up through the parents
+	gen := []ResNode{n}
+	for depth := 0; len(gen) > 0 && (maxDepth < 1 || depth < maxDepth); depth++ {
+		var next []ResNode
+		for _, child := range gen {
+			for _, id := range []int{child.Parent1, child.Parent2} {
+				if id == 0 || seen[id] {
+					continue
+				}
+				seen[id] = true
+				p := ResNode{}
+				err := db.QueryRow(get, simid, id).Scan(&p.ResId, &p.Time, &p.Quantity, &p.QualId, &p.Parent1, &p.Parent2)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, p)
+			}
+		}
+		nodes = append(nodes, next...)
+		gen = next
+	}
+
+	// down through the children
+	gen = []ResNode{n}
+	for depth := 0; len(gen) > 0 && (maxDepth < 1 || depth < maxDepth); depth++ {
+		var next []ResNode
+		for _, parent := range gen {
+			rows, err := db.Query(kids, simid, parent.ResId, parent.ResId)
+			if err != nil {
+				return nil, err
+			}
+			for rows.Next() {
+				c := ResNode{}
+				if err := rows.Scan(&c.ResId, &c.Time, &c.Quantity, &c.QualId, &c.Parent1, &c.Parent2); err != nil {
+					rows.Close()
+					return nil, err
+				}
+				if !seen[c.ResId] {
+					seen[c.ResId] = true
+					next = append(next, c)
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return nil, err
+			}
+		}
+		nodes = append(nodes, next...)
+		gen = next
+	}
+
+	for i := range nodes {
+		if nodes[i].Owner, err = creationOwner(db, simid, nodes[i].ResId); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// creationOwner returns the agent holding the resource when it was created,
+// from its first inventory entry or else the agent that created it, or -1
+// if neither is recorded.
+func creationOwner(db DB, simid []byte, resid int) (int, error) {
+	var owner sql.NullInt64
+	err := db.QueryRow("SELECT AgentId FROM Inventories WHERE SimId = ? AND ResourceId = ? ORDER BY StartTime LIMIT 1;", simid, resid).Scan(&owner)
+	if err != nil && err != sql.ErrNoRows && !strings.Contains(err.Error(), "no such table") {
+		return 0, err
+	} else if owner.Valid {
+		return int(owner.Int64), nil
+	}
+
+	s, err := rawSql(db, "SELECT AgentId FROM ResCreators WHERE SimId = ? AND ResourceId = ?;")
+	if err != nil {
+		return 0, err
+	}
+	err = db.QueryRow(s, simid, resid).Scan(&owner)
+	if err != nil && err != sql.ErrNoRows && !strings.Contains(err.Error(), "no such table") {
+		return 0, err
+	} else if owner.Valid {
+		return int(owner.Int64), nil
+	}
+	return -1, nil
+}
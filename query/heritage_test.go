@@ -0,0 +1,50 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHeritage(t *testing.T) {
+	// 1 and 2 are combined into 3, which is split into 4 and 5; 4 is
+	// transmuted into 6
+	db := testDb(t,
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,10,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',0,5,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,3,3,'Material',1,15,'kg',1,1,2);",
+		"INSERT INTO Resources VALUES (?,4,4,'Material',2,12,'kg',1,3,0);",
+		"INSERT INTO Resources VALUES (?,5,5,'Material',2,3,'kg',1,3,0);",
+		"INSERT INTO Resources VALUES (?,6,6,'Material',4,12,'kg',2,4,0);",
+		"INSERT INTO ResCreators VALUES (?,1,7);",
+		"INSERT INTO ResCreators VALUES (?,2,8);",
+		"INSERT INTO Inventories VALUES (?,3,9,1,2,1,15);",
+	)
+
+	nodes, err := Heritage(db, testSimId, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids, owners []int
+	for _, n := range nodes {
+		ids = append(ids, n.ResId)
+		owners = append(owners, n.Owner)
+	}
+	if want := []int{4, 3, 1, 2, 6}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("want resources %v, got %v", want, ids)
+	}
+	if want := []int{-1, 9, 7, 8, -1}; !reflect.DeepEqual(owners, want) {
+		t.Errorf("want owners %v, got %v", want, owners)
+	}
+
+	nodes, err = Heritage(db, testSimId, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = nil
+	for _, n := range nodes {
+		ids = append(ids, n.ResId)
+	}
+	if want := []int{3, 1, 2, 4, 5}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("depth 1: want resources %v, got %v", want, ids)
+	}
+}
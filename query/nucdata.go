@@ -0,0 +1,358 @@
+package query
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/rwcarlsen/cyan/nuc"
+)
+
+// NuclearData provides the nuclide properties needed by decay related
+// queries.
+type NuclearData interface {
+	nuc.DecayData
+	// DecayHeatCoeff returns the thermal power in Watts released by the
+	// decay of one kg of n.
+	DecayHeatCoeff(n nuc.Nuc) float64
+}
+
+// NucProps holds the decay properties of a single nuclide.  See NuclearData
+// for units.
+type NucProps struct {
+	HalfLife  float64
+	DecayHeat float64
+	Daughters []nuc.Branch
+}
+
+// MapData is a NuclearData backed by a map.  Nuclides missing from the map
+// are treated as stable.
+type MapData map[nuc.Nuc]NucProps
+
+func (m MapData) HalfLife(n nuc.Nuc) float64 {
+	if p, ok := m[n]; ok {
+		return p.HalfLife
+	}
+	return math.Inf(1)
+}
+
+func (m MapData) Daughters(n nuc.Nuc) []nuc.Branch { return m[n].Daughters }
+
+func (m MapData) DecayHeatCoeff(n nuc.Nuc) float64 { return m[n].DecayHeat }
+
+// ReadNuclearData parses CSV nuclear data with the columns nuclide,
+// half-life (s), decay heat coefficient (W/kg), and optionally daughters.
+// The first row is a header and is skipped.  Nuclides may be given in any
+// form read by nuc.Parse, and a half-life of "inf" marks a stable nuclide.
+// Daughters are a space separated list of nuclides each optionally followed
+// by ':' and the fraction of decays producing it (default 1), e.g.
+// "Am241:0.99998 U237:2.45e-5".
+func ReadNuclearData(r io.Reader) (MapData, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	recs, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	m := MapData{}
+	for i, rec := range recs {
+		if i == 0 {
+			continue
+		} else if len(rec) != 3 && len(rec) != 4 {
+			return nil, fmt.Errorf("nuclear data row %v: want 3 or 4 columns, got %v", i+1, len(rec))
+		}
+		n, err := nuc.Parse(rec[0])
+		if err != nil {
+			return nil, fmt.Errorf("nuclear data row %v: %v", i+1, err)
+		}
+		var p NucProps
+		if p.HalfLife, err = strconv.ParseFloat(strings.TrimSpace(rec[1]), 64); err != nil {
+			return nil, fmt.Errorf("nuclear data row %v: invalid half-life '%v'", i+1, rec[1])
+		}
+		if p.DecayHeat, err = strconv.ParseFloat(strings.TrimSpace(rec[2]), 64); err != nil {
+			return nil, fmt.Errorf("nuclear data row %v: invalid decay heat '%v'", i+1, rec[2])
+		}
+		if len(rec) == 4 {
+			if p.Daughters, err = parseBranches(rec[3]); err != nil {
+				return nil, fmt.Errorf("nuclear data row %v: %v", i+1, err)
+			}
+		}
+		m[n] = p
+	}
+	return m, nil
+}
+
+// ReadDoseCoeffs parses CSV dose coefficients with the columns nuclide,
+// ingestion (Sv/Bq), and inhalation (Sv/Bq).  The first row is a header and
+// is skipped.
+func ReadDoseCoeffs(r io.Reader) (nuc.DoseTable, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	cr.Comment = '#'
+	recs, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	d := nuc.DoseTable{}
+	for i, rec := range recs {
+		if i == 0 {
+			continue
+		}
+		n, err := nuc.Parse(rec[0])
+		if err != nil {
+			return nil, fmt.Errorf("dose coefficient row %v: %v", i+1, err)
+		}
+		var c nuc.DoseCoeffs
+		if c.Ingestion, err = strconv.ParseFloat(strings.TrimSpace(rec[1]), 64); err != nil {
+			return nil, fmt.Errorf("dose coefficient row %v: invalid ingestion coefficient '%v'", i+1, rec[1])
+		}
+		if c.Inhalation, err = strconv.ParseFloat(strings.TrimSpace(rec[2]), 64); err != nil {
+			return nil, fmt.Errorf("dose coefficient row %v: invalid inhalation coefficient '%v'", i+1, rec[2])
+		}
+		d[n] = c
+	}
+	return d, nil
+}
+
+// parseBranches parses a space separated list of decay branches of the form
+// Daughter[:Frac].
+func parseBranches(s string) ([]nuc.Branch, error) {
+	var branches []nuc.Branch
+	for _, f := range strings.Fields(s) {
+		b := nuc.Branch{Frac: 1}
+		name := f
+		if i := strings.Index(f, ":"); i >= 0 {
+			name = f[:i]
+			frac, err := strconv.ParseFloat(f[i+1:], 64)
+			if err != nil || frac < 0 || frac > 1 {
+				return nil, fmt.Errorf("invalid branch fraction in '%v'", f)
+			}
+			b.Frac = frac
+		}
+		var err error
+		if b.Daughter, err = nuc.Parse(name); err != nil {
+			return nil, err
+		}
+		branches = append(branches, b)
+	}
+	return branches, nil
+}
+
+// DecayHeat returns the thermal power in Watts released by the decay of the
+// material inventory of the listed agent ids for the specified sim id at
+// time t.  Passing no agents defaults to all agents.  Use t=-1 to specify
+// end-of-simulation.
+func DecayHeat(db DB, simid []byte, t int, data NuclearData, agents ...int) (watts float64, err error) {
+	m, err := InvAt(db, simid, t, agents...)
+	if err != nil {
+		return 0, err
+	}
+	for n, qty := range m {
+		watts += float64(qty) * data.DecayHeatCoeff(n)
+	}
+	return watts, nil
+}
+
+// DefaultTimestep is the duration in seconds of the timesteps of simulations
+// that don't record it: one twelfth of a year.
+const DefaultTimestep = 2629846
+
+// TimestepSecs returns the duration in seconds of the simulation's
+// timesteps, read from the TimeStepDur table or DefaultTimestep if it
+// wasn't recorded.
+func TimestepSecs(db DB, simid []byte) (float64, error) {
+	if ok, err := HasTable(db, "TimeStepDur"); err != nil {
+		return 0, err
+	} else if !ok {
+		return DefaultTimestep, nil
+	}
+	var dt sql.NullFloat64
+	err := db.QueryRow("SELECT DurationSecs FROM TimeStepDur WHERE SimId = ?", simid).Scan(&dt)
+	if err == sql.ErrNoRows || err == nil && !dt.Valid {
+		return DefaultTimestep, nil
+	}
+	return dt.Float64, err
+}
+
+// DecayedInvAt is InvAt with the composition of every resource decayed from
+// the time it was created to time t.  Resources are recorded with the
+// composition they had when created, so their isotopics drift from the
+// undecayed inventory the longer they are held.
+func DecayedInvAt(db DB, simid []byte, t int, data nuc.DecayData, agents ...int) (m nuc.Material, err error) {
+	if t == -1 {
+		si, err := SimStat(db, simid)
+		if err != nil {
+			return nil, err
+		}
+		t = si.Duration
+	}
+	_, mats, err := decayedInv(db, simid, t, t+1, data, agents)
+	if err != nil {
+		return nil, err
+	}
+	return mats[0], nil
+}
+
+// DecayedInvSeries returns the material inventory of the listed agent ids
+// at every timestep of the simulation with the composition of every resource
+// decayed from the time it was created to that timestep (see DecayedInvAt).
+// Passing no agents defaults to all agents.
+func DecayedInvSeries(db DB, simid []byte, data nuc.DecayData, agents ...int) (times []int, mats []nuc.Material, err error) {
+	si, err := SimStat(db, simid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return decayedInv(db, simid, 0, si.Duration, data, agents)
+}
+
+// decayedInv returns the decayed inventories of agents at the timesteps t0
+// up to t1.
+func decayedInv(db DB, simid []byte, t0, t1 int, data nuc.DecayData, agents []int) (times []int, mats []nuc.Material, err error) {
+	filt := ""
+	if len(agents) > 0 {
+		filt = " AND inv.AgentId IN (" + joinInts(agents) + ")"
+	}
+	sql := `SELECT inv.StartTime,inv.EndTime,res.TimeCreated,cmp.NucId,SUM(cmp.MassFrac * inv.Quantity)
+			FROM Inventories AS inv
+			INNER JOIN Resources AS res ON res.ResourceId = inv.ResourceId AND res.SimId = inv.SimId
+			INNER JOIN Compositions AS cmp ON cmp.QualId = inv.QualId AND cmp.SimId = inv.SimId
+			WHERE inv.SimId = ? AND inv.StartTime < ? AND inv.EndTime > ?` + filt + `
+			GROUP BY inv.StartTime,inv.EndTime,res.TimeCreated,cmp.NucId;`
+	return decaySeries(db, simid, t0, t1, data, sql, simid, t1, t0)
+}
+
+// DecayedDischarges returns the material sent by the listed agent ids to
+// other agents up to every timestep from 0 to t1 with the composition of
+// every resource decayed from the time it was created to that timestep.
+// Only material of commodity commod is included unless it is empty.  Since
+// discharged material is decayed for as long as it has been held, t1 may be
+// past the end of the simulation to follow its cooling.
+func DecayedDischarges(db DB, simid []byte, t1 int, data nuc.DecayData, commod string, agents ...int) (times []int, mats []nuc.Material, err error) {
+	ids := joinInts(agents)
+	args := []interface{}{simid}
+	filt := ""
+	if commod != "" {
+		filt = " AND tr.Commodity = ?"
+		args = append(args, commod)
+	}
+	sql := `SELECT tr.Time,` + strconv.Itoa(math.MaxInt32) + `,res.TimeCreated,cmp.NucId,SUM(cmp.MassFrac * res.Quantity)
+			FROM Transactions AS tr
+			INNER JOIN Resources AS res ON res.ResourceId = tr.ResourceId AND res.SimId = tr.SimId
+			INNER JOIN Compositions AS cmp ON cmp.QualId = res.QualId AND cmp.SimId = res.SimId
+			WHERE tr.SimId = ? AND tr.SenderId IN (` + ids + `) AND tr.ReceiverId NOT IN (` + ids + `)` + filt + `
+			GROUP BY tr.Time,res.TimeCreated,cmp.NucId;`
+	return decaySeries(db, simid, 0, t1, data, sql, args...)
+}
+
+// DecayedTransfers returns the material transacted at every timestep of
+// the simulation from any of the agent ids in from to any of those in to,
+// with the composition of every resource decayed from the time it was
+// created to the time it was transacted.  Only material of commodity commod
+// is included unless it is empty.  Empty from or to lists match all agents.
+func DecayedTransfers(db DB, simid []byte, data nuc.DecayData, commod string, from, to []int) (times []int, mats []nuc.Material, err error) {
+	si, err := SimStat(db, simid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	args := []interface{}{simid}
+	filt := ""
+	if len(from) > 0 {
+		filt += " AND tr.SenderId IN (" + joinInts(from) + ")"
+	}
+	if len(to) > 0 {
+		filt += " AND tr.ReceiverId IN (" + joinInts(to) + ")"
+	}
+	if commod != "" {
+		filt += " AND tr.Commodity = ?"
+		args = append(args, commod)
+	}
+	sql := `SELECT tr.Time,tr.Time+1,res.TimeCreated,cmp.NucId,SUM(cmp.MassFrac * res.Quantity)
+			FROM Transactions AS tr
+			INNER JOIN Resources AS res ON res.ResourceId = tr.ResourceId AND res.SimId = tr.SimId
+			INNER JOIN Compositions AS cmp ON cmp.QualId = res.QualId AND cmp.SimId = res.SimId
+			WHERE tr.SimId = ?` + filt + `
+			GROUP BY tr.Time,res.TimeCreated,cmp.NucId;`
+	return decaySeries(db, simid, 0, si.Duration, data, sql, args...)
+}
+
+// decaySeries returns the material held at each timestep from t0 up to t1
+// with every resource decayed from the time it was created to that
+// timestep.  The rows of query sql are the start and (exclusive) end of the
+// time a material is held, the time it was created, a nuclide, and its
+// mass.
+func decaySeries(db DB, simid []byte, t0, t1 int, data nuc.DecayData, sql string, args ...interface{}) (times []int, mats []nuc.Material, err error) {
+	dt, err := TimestepSecs(db, simid)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := rawQuery(db, sql, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	// held is a composition held over [start, end) by resources created at
+	// the same time.
+	type held struct {
+		start, end int
+		m          nuc.Material
+	}
+	type key struct{ start, end, created int }
+	byCreated := map[int][]*held{}
+	helds := map[key]*held{}
+	for rows.Next() {
+		var k key
+		var iso int
+		var qty float64
+		if err := rows.Scan(&k.start, &k.end, &k.created, &iso, &qty); err != nil {
+			return nil, nil, err
+		}
+		h := helds[k]
+		if h == nil {
+			h = &held{k.start, k.end, nuc.Material{}}
+			helds[k] = h
+			byCreated[k.created] = append(byCreated[k.created], h)
+		}
+		h.m[nuc.Nuc(iso)] += nuc.Mass(qty)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// decay is linear, so every material created at the same time is
+	// summed and decayed once per timestep
+	for t := t0; t < t1; t++ {
+		m := nuc.Material{}
+		for created, hs := range byCreated {
+			fresh := nuc.Material{}
+			for _, h := range hs {
+				if h.start <= t && h.end > t {
+					for n, qty := range h.m {
+						fresh[n] += qty
+					}
+				}
+			}
+			if len(fresh) == 0 {
+				continue
+			}
+			decayed, err := nuc.Decay(fresh, float64(t-created)*dt, data)
+			if err != nil {
+				return nil, nil, err
+			}
+			for n, qty := range decayed {
+				m[n] += qty
+			}
+		}
+		times = append(times, t)
+		mats = append(mats, m)
+	}
+	return times, mats, nil
+}
@@ -0,0 +1,210 @@
+package query
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/nuc"
+)
+
+const testNucData = `nuclide,halflife,heat
+# Pu238 is listed by id
+942380000,2.77e9,567
+Pu239,7.61e11,1.9
+U238,1.41e17,0
+Fe56,inf,0
+`
+
+func TestReadNuclearData(t *testing.T) {
+	data, err := ReadNuclearData(strings.NewReader(testNucData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 4 {
+		t.Errorf("want 4 nuclides, got %v", data)
+	}
+	if got := data.HalfLife(nuc.Pu239); got != 7.61e11 {
+		t.Errorf("Pu239 half-life: want 7.61e11, got %v", got)
+	}
+	if got := data.HalfLife(260560000); !math.IsInf(got, 1) {
+		t.Errorf("Fe56 half-life: want +Inf, got %v", got)
+	}
+	if got := data.HalfLife(nuc.U235); !math.IsInf(got, 1) {
+		t.Errorf("missing nuclide: want stable, got half-life %v", got)
+	}
+
+	if _, err := ReadNuclearData(strings.NewReader("nuclide,halflife,heat\nPu239,long,1.9\n")); err == nil || !strings.Contains(err.Error(), "row 2") {
+		t.Errorf("want row 2 half-life error, got %v", err)
+	}
+}
+
+func TestDecayHeat(t *testing.T) {
+	data, err := ReadNuclearData(strings.NewReader(testNucData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := testDb(t,
+		"INSERT INTO Compositions VALUES (?,1,942380000,0.1);",
+		"INSERT INTO Compositions VALUES (?,1,942390000,0.9);",
+		"INSERT INTO Compositions VALUES (?,2,922380000,1);",
+		"INSERT INTO Inventories VALUES (?,1,1,0,2147483647,1,2);",
+		"INSERT INTO Inventories VALUES (?,2,2,0,2147483647,2,100);",
+	)
+
+	watts, err := DecayHeat(db, testSimId, 1, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 0.2*567 + 1.8*1.9; math.Abs(watts-want) > 1e-9 {
+		t.Errorf("want %v W, got %v", want, watts)
+	}
+
+	watts, err = DecayHeat(db, testSimId, 1, data, 2)
+	if err != nil {
+		t.Fatal(err)
+	} else if watts != 0 {
+		t.Errorf("U238 only: want 0 W, got %v", watts)
+	}
+}
+
+func TestDecayedInv(t *testing.T) {
+	data, err := ReadNuclearData(strings.NewReader(`nuclide,halflife,heat,daughters
+Pu241,2629846,0,Am241:0.75 U237:0.25
+Am241,inf,0
+`))
+	if err != nil {
+		t.Fatal(err)
+	} else if want := []nuc.Branch{{Daughter: 952410000, Frac: 0.75}, {Daughter: 922370000, Frac: 0.25}}; !reflect.DeepEqual(data[942410000].Daughters, want) {
+		t.Errorf("Pu241 daughters: want %v, got %v", want, data[942410000].Daughters)
+	}
+
+	// 1 kg of Pu241 created at time 1 and held by agent 1 from time 2
+	// onwards, along with a stable kg made at time 0
+	db := testDb(t,
+		"INSERT INTO Info VALUES (?,'',2000,1,5);",
+		"INSERT INTO Resources VALUES (?,1,1,'Material',1,1,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',0,1,'kg',2,0,0);",
+		"INSERT INTO Compositions VALUES (?,1,942410000,1);",
+		"INSERT INTO Compositions VALUES (?,2,952410000,1);",
+		"INSERT INTO Inventories VALUES (?,1,1,2,5,1,1);",
+		"INSERT INTO Inventories VALUES (?,2,1,0,5,2,1);",
+	)
+
+	times, mats, err := DecayedInvSeries(db, testSimId, data, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(times, []int{0, 1, 2, 3, 4}) {
+		t.Fatalf("want times 0-4, got %v", times)
+	}
+	for i, m := range mats {
+		pu, am := 0.0, 1.0
+		if i >= 2 {
+			// one timestep is one half-life
+			pu = math.Pow(0.5, float64(i-1))
+			am += 0.75 * (1 - pu)
+		}
+		if math.Abs(float64(m[942410000])-pu) > 1e-9 || math.Abs(float64(m[952410000])-am) > 1e-9 {
+			t.Errorf("time %v: want %v kg Pu241 and %v kg Am241, got %v", i, pu, am, m)
+		}
+	}
+
+	m, err := DecayedInvAt(db, testSimId, 3, data)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, mats[3]) {
+		t.Errorf("time 3: want %v, got %v", mats[3], m)
+	}
+
+	if dt, err := TimestepSecs(db, testSimId); err != nil || dt != DefaultTimestep {
+		t.Errorf("want default timestep, got %v (%v)", dt, err)
+	}
+}
+
+func TestDecayedDischarges(t *testing.T) {
+	data := MapData{942410000: {HalfLife: DefaultTimestep, Daughters: []nuc.Branch{{Daughter: 952410000, Frac: 1}}}}
+
+	// agent 1 sends 1 kg of Pu241 made at time 0 to agent 2 at time 1, 2 kg
+	// of another commodity at time 2, and 3 kg to itself
+	db := testDb(t,
+		"INSERT INTO Info VALUES (?,'',2000,1,3);",
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,1,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',2,2,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,3,3,'Material',0,3,'kg',1,0,0);",
+		"INSERT INTO Compositions VALUES (?,1,942410000,1);",
+		"INSERT INTO Transactions VALUES (?,1,1,2,1,'fuel',1);",
+		"INSERT INTO Transactions VALUES (?,2,1,2,2,'waste',2);",
+		"INSERT INTO Transactions VALUES (?,3,1,1,3,'fuel',1);",
+	)
+
+	times, mats, err := DecayedDischarges(db, testSimId, 5, data, "fuel", 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(times, []int{0, 1, 2, 3, 4}) {
+		t.Fatalf("want times 0-4, got %v", times)
+	}
+	for i, m := range mats {
+		pu := 0.0
+		if i >= 1 {
+			pu = math.Pow(0.5, float64(i))
+		}
+		if math.Abs(float64(m[942410000])-pu) > 1e-9 || i >= 1 && math.Abs(float64(m.Mass())-1) > 1e-9 {
+			t.Errorf("time %v: want %v kg Pu241 of 1 kg, got %v", i, pu, m)
+		}
+	}
+
+	if _, mats, err = DecayedDischarges(db, testSimId, 3, data, "", 1); err != nil {
+		t.Fatal(err)
+	} else if got := float64(mats[2].Mass()); math.Abs(got-3) > 1e-9 {
+		t.Errorf("all commodities at time 2: want 3 kg, got %v", got)
+	}
+}
+
+func TestDecayedTransfers(t *testing.T) {
+	data := MapData{942410000: {HalfLife: DefaultTimestep, Daughters: []nuc.Branch{{Daughter: 952410000, Frac: 1}}}}
+
+	db := testDb(t,
+		"INSERT INTO Info VALUES (?,'',2000,1,4);",
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,1,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',0,2,'kg',1,0,0);",
+		"INSERT INTO Compositions VALUES (?,1,942410000,1);",
+		"INSERT INTO Transactions VALUES (?,1,1,2,1,'fuel',2);",
+		"INSERT INTO Transactions VALUES (?,2,3,2,2,'fuel',3);",
+	)
+
+	_, mats, err := DecayedTransfers(db, testSimId, data, "", []int{1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(mats) != 4 {
+		t.Fatalf("want 4 timesteps, got %v", len(mats))
+	}
+	for i, m := range mats {
+		want := 0.0
+		if i == 2 {
+			want = 0.25
+		}
+		if got := float64(m[942410000]); math.Abs(got-want) > 1e-9 {
+			t.Errorf("time %v: want %v kg Pu241, got %v", i, want, got)
+		}
+	}
+
+	if _, mats, err = DecayedTransfers(db, testSimId, data, "fuel", nil, []int{2}); err != nil {
+		t.Fatal(err)
+	} else if got := float64(mats[3][942410000]); math.Abs(got-0.25) > 1e-9 {
+		t.Errorf("time 3: want 0.25 kg Pu241, got %v", got)
+	}
+}
+
+func TestReadDoseCoeffs(t *testing.T) {
+	d, err := ReadDoseCoeffs(strings.NewReader("nuclide,ingestion,inhalation\nPu-239,2.5e-7,5e-5\n"))
+	if err != nil {
+		t.Fatal(err)
+	} else if got := d.Coeff(nuc.Pu239, nuc.Inhalation); got != 5e-5 {
+		t.Errorf("Pu239 inhalation: want 5e-5, got %v", got)
+	}
+	if _, err := ReadDoseCoeffs(strings.NewReader("nuclide,ingestion,inhalation\nPu239,x,5e-5\n")); err == nil || !strings.Contains(err.Error(), "row 2") {
+		t.Errorf("want row 2 ingestion error, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package query
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+)
+
+// AgentPower is the electric power in MWe generated by an agent at one
+// timestep.
+type AgentPower struct {
+	Agent int
+	Time  int
+	Power float64
+}
+
+// PowerSeries returns the power recorded in the TimeSeriesPower table for the
+// specified simulation ordered by time and agent - none if the simulation
+// recorded no power.
+func PowerSeries(db DB, simid []byte) (ps []AgentPower, err error) {
+	if ok, err := HasTable(db, "TimeSeriesPower"); err != nil || !ok {
+		return nil, err
+	}
+
+	s := "SELECT AgentId,Time,Value FROM TimeSeriesPower WHERE SimId = ? ORDER BY Time,AgentId;"
+	rows, err := db.Query(s, simid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := AgentPower{}
+		if err := rows.Scan(&p.Agent, &p.Time, &p.Power); err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// PowerCapacities returns the nameplate electric capacity in MWe of every
+// power producing agent in the specified simulation keyed by agent id.
+// Capacities are read from the power_cap column cycamore reactors record in
+// their AgentState tables.  Agents that recorded power in TimeSeriesPower
+// without such a column are given the most power they ever generated.
+func PowerCapacities(db DB, simid []byte) (caps map[int]float64, err error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name LIKE 'AgentState%';")
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, `"`+strings.Replace(name, `"`, `""`, -1)+`"`)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	caps = map[int]float64{}
+	for _, table := range tables {
+		cols, err := tableCols(db, table)
+		if err != nil {
+			return nil, err
+		} else if !cols["power_cap"] {
+			continue
+		}
+		err = scanCaps(db, caps, "SELECT AgentId,MAX(power_cap) FROM "+table+" WHERE SimId = ? GROUP BY AgentId;", simid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ok, err := HasTable(db, "TimeSeriesPower"); err != nil {
+		return nil, err
+	} else if ok {
+		peaks := map[int]float64{}
+		err := scanCaps(db, peaks, "SELECT AgentId,MAX(Value) FROM TimeSeriesPower WHERE SimId = ? GROUP BY AgentId;", simid)
+		if err != nil {
+			return nil, err
+		}
+		for id, peak := range peaks {
+			if _, ok := caps[id]; !ok {
+				caps[id] = peak
+			}
+		}
+	}
+	return caps, nil
+}
+
+// scanCaps adds the (agent id, capacity) rows of query s to caps.
+func scanCaps(db DB, caps map[int]float64, s string, args ...interface{}) error {
+	rows, err := db.Query(s, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var cap sql.NullFloat64
+		if err := rows.Scan(&id, &cap); err != nil {
+			return err
+		}
+		if cap.Valid {
+			caps[id] = cap.Float64
+		}
+	}
+	return rows.Err()
+}
+
+// PowerFactor is the electricity an agent generated over a window of
+// timesteps as a fraction of what it would have generated running at its
+// nameplate capacity (see PowerCapacities) for every timestep of the window
+// it was deployed.
+type PowerFactor struct {
+	Agent int
+	// Capacity is the nameplate capacity in MWe.
+	Capacity float64
+	// Steps is the number of timesteps of the window the agent was deployed.
+	Steps int
+	// Energy is the electricity generated in MWe-timesteps.
+	Energy float64
+	Factor float64
+}
+
+// PowerFactors returns the capacity factor over timesteps t0 <= t < t1 of
+// every power producing agent deployed during them in the specified
+// simulation ordered by agent id.  Use t1=-1 to specify end-of-simulation.
+func PowerFactors(db DB, simid []byte, t0, t1 int) ([]PowerFactor, error) {
+	series, err := PowerFactorSeries(db, simid, t0, t1, 0)
+	if err != nil || len(series) == 0 {
+		return nil, err
+	}
+	return series[0], nil
+}
+
+// PowerFactorSeries returns the capacity factors (see PowerFactors) over
+// successive windows of the given number of timesteps from t0 up to t1 - the
+// last window is cut short at t1.  A window <= 0 specifies the whole
+// interval.  The capacities, power, and agents of the simulation are only
+// queried once for all the windows.  Use t1=-1 to specify
+// end-of-simulation.
+func PowerFactorSeries(db DB, simid []byte, t0, t1, window int) ([][]PowerFactor, error) {
+	if t1 == -1 {
+		si, err := SimStat(db, simid)
+		if err != nil {
+			return nil, err
+		}
+		t1 = si.Duration
+	}
+	if t1 <= t0 {
+		return nil, nil
+	}
+	if window <= 0 {
+		window = t1 - t0
+	}
+	caps, err := PowerCapacities(db, simid)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := PowerSeries(db, simid)
+	if err != nil {
+		return nil, err
+	}
+	ags, err := AllAgents(db, simid, "")
+	if err != nil {
+		return nil, err
+	}
+
+	n := (t1 - t0 + window - 1) / window
+	energy := make([]map[int]float64, n)
+	for i := range energy {
+		energy[i] = map[int]float64{}
+	}
+	for _, p := range ps {
+		if t0 <= p.Time && p.Time < t1 {
+			energy[(p.Time-t0)/window][p.Agent] += p.Power
+		}
+	}
+
+	sort.Slice(ags, func(i, j int) bool { return ags[i].Id < ags[j].Id })
+	series := make([][]PowerFactor, n)
+	for i := range series {
+		w0, w1 := t0+i*window, t0+(i+1)*window
+		if w1 > t1 {
+			w1 = t1
+		}
+		for _, ai := range ags {
+			pf := PowerFactor{Agent: ai.Id, Capacity: caps[ai.Id], Energy: energy[i][ai.Id]}
+			if pf.Capacity <= 0 {
+				continue
+			}
+			for t := w0; t < w1; t++ {
+				if ai.Alive(t) {
+					pf.Steps++
+				}
+			}
+			if pf.Steps == 0 {
+				continue
+			}
+			pf.Factor = pf.Energy / (pf.Capacity * float64(pf.Steps))
+			series[i] = append(series[i], pf)
+		}
+	}
+	return series, nil
+}
+
+// FleetFactor returns the capacity weighted average of the factors of pfs:
+// the electricity they generated in total over what they could have.
+func FleetFactor(pfs []PowerFactor) float64 {
+	var energy, max float64
+	for _, pf := range pfs {
+		energy += pf.Energy
+		max += pf.Capacity * float64(pf.Steps)
+	}
+	if max == 0 {
+		return 0
+	}
+	return energy / max
+}
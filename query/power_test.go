@@ -0,0 +1,142 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPowerSeries(t *testing.T) {
+	db := testDb(t)
+	if ps, err := PowerSeries(db, testSimId); err != nil || len(ps) != 0 {
+		t.Fatalf("want no power without a TimeSeriesPower table, got %v (err=%v)", ps, err)
+	}
+
+	db = testDb(t,
+		"CREATE TABLE TimeSeriesPower (SimId BLOB,AgentId INTEGER,Time INTEGER,Value REAL);",
+		"INSERT INTO TimeSeriesPower VALUES (?,2,1,500);",
+		"INSERT INTO TimeSeriesPower VALUES (?,1,1,900);",
+		"INSERT INTO TimeSeriesPower VALUES (?,1,0,800);",
+		"INSERT INTO TimeSeriesPower VALUES ('sim2',1,0,100);",
+	)
+	ps, err := PowerSeries(db, testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AgentPower{{1, 0, 800}, {1, 1, 900}, {2, 1, 500}}
+	if !reflect.DeepEqual(ps, want) {
+		t.Errorf("want %v, got %v", want, ps)
+	}
+}
+
+func TestPowerCapacities(t *testing.T) {
+	db := testDb(t,
+		"CREATE TABLE TimeSeriesPower (SimId BLOB,AgentId INTEGER,Time INTEGER,Value REAL);",
+		"CREATE TABLE AgentState_cycamore_ReactorInfo (SimId BLOB,AgentId INTEGER,power_cap REAL);",
+		"CREATE TABLE AgentState_agents_SinkInfo (SimId BLOB,AgentId INTEGER,capacity REAL);",
+		"INSERT INTO AgentState_cycamore_ReactorInfo VALUES (?,1,1000);",
+		"INSERT INTO AgentState_agents_SinkInfo VALUES (?,3,50);",
+		"INSERT INTO TimeSeriesPower VALUES (?,1,0,900);",
+		"INSERT INTO TimeSeriesPower VALUES (?,2,0,300);",
+		"INSERT INTO TimeSeriesPower VALUES (?,2,1,450);",
+	)
+	caps, err := PowerCapacities(db, testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]float64{1: 1000, 2: 450}
+	if !reflect.DeepEqual(caps, want) {
+		t.Errorf("want %v, got %v", want, caps)
+	}
+}
+
+func TestPowerFactors(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Info VALUES (?,'',2000,1,4);",
+		"INSERT INTO Agents VALUES (?,1,'Facility','','reactor',0,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,2,'Facility','','reactor',0,-1,1,3);",
+		"INSERT INTO Agents VALUES (?,3,'Facility','','sink',0,-1,0,NULL);",
+		"CREATE TABLE TimeSeriesPower (SimId BLOB,AgentId INTEGER,Time INTEGER,Value REAL);",
+		"CREATE TABLE AgentState_cycamore_ReactorInfo (SimId BLOB,AgentId INTEGER,power_cap REAL);",
+		"INSERT INTO AgentState_cycamore_ReactorInfo VALUES (?,1,100);",
+		"INSERT INTO AgentState_cycamore_ReactorInfo VALUES (?,2,50);",
+		"INSERT INTO TimeSeriesPower VALUES (?,1,0,100);",
+		"INSERT INTO TimeSeriesPower VALUES (?,1,1,50);",
+		"INSERT INTO TimeSeriesPower VALUES (?,1,3,100);",
+		"INSERT INTO TimeSeriesPower VALUES (?,2,1,50);",
+		"INSERT INTO TimeSeriesPower VALUES (?,2,2,25);",
+	)
+
+	pfs, err := PowerFactors(db, testSimId, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PowerFactor{
+		{Agent: 1, Capacity: 100, Steps: 4, Energy: 250, Factor: 0.625},
+		{Agent: 2, Capacity: 50, Steps: 2, Energy: 75, Factor: 0.75},
+	}
+	if !reflect.DeepEqual(pfs, want) {
+		t.Errorf("want %+v, got %+v", want, pfs)
+	}
+	if got := FleetFactor(pfs); got != 325.0/500 {
+		t.Errorf("want fleet factor %v, got %v", 325.0/500, got)
+	}
+
+	pfs, err = PowerFactors(db, testSimId, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pfs) != 1 || pfs[0].Agent != 1 || pfs[0].Factor != 1 {
+		t.Errorf("want only agent 1 at full capacity after agent 2 exits, got %+v", pfs)
+	}
+	if FleetFactor(nil) != 0 {
+		t.Errorf("want a zero factor for an empty fleet")
+	}
+}
+
+func TestPowerFactorSeries(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Info VALUES (?,'',2000,1,5);",
+		"INSERT INTO Agents VALUES (?,1,'Facility','','reactor',0,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,2,'Facility','','reactor',0,-1,1,3);",
+		"CREATE TABLE TimeSeriesPower (SimId BLOB,AgentId INTEGER,Time INTEGER,Value REAL);",
+		"CREATE TABLE AgentState_cycamore_ReactorInfo (SimId BLOB,AgentId INTEGER,power_cap REAL);",
+		"INSERT INTO AgentState_cycamore_ReactorInfo VALUES (?,1,100);",
+		"INSERT INTO AgentState_cycamore_ReactorInfo VALUES (?,2,50);",
+		"INSERT INTO TimeSeriesPower VALUES (?,1,0,100);",
+		"INSERT INTO TimeSeriesPower VALUES (?,1,1,50);",
+		"INSERT INTO TimeSeriesPower VALUES (?,1,4,100);",
+		"INSERT INTO TimeSeriesPower VALUES (?,2,1,50);",
+		"INSERT INTO TimeSeriesPower VALUES (?,2,2,25);",
+	)
+
+	series, err := PowerFactorSeries(db, testSimId, 0, -1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]PowerFactor{
+		{
+			{Agent: 1, Capacity: 100, Steps: 2, Energy: 150, Factor: 0.75},
+			{Agent: 2, Capacity: 50, Steps: 1, Energy: 50, Factor: 1},
+		},
+		{
+			{Agent: 1, Capacity: 100, Steps: 2, Energy: 0, Factor: 0},
+			{Agent: 2, Capacity: 50, Steps: 1, Energy: 25, Factor: 0.5},
+		},
+		{
+			{Agent: 1, Capacity: 100, Steps: 1, Energy: 100, Factor: 1},
+		},
+	}
+	if !reflect.DeepEqual(series, want) {
+		t.Errorf("want %+v, got %+v", want, series)
+	}
+
+	whole, err := PowerFactors(db, testSimId, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	series, err = PowerFactorSeries(db, testSimId, 0, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(series) != 1 || !reflect.DeepEqual(series[0], whole) {
+		t.Errorf("want a single window matching PowerFactors %+v, got %+v", whole, series)
+	}
+}
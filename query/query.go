@@ -3,15 +3,18 @@ package query
 import (
 	"bytes"
 	"database/sql"
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/rwcarlsen/cyan/nuc"
 )
 
 // SimIds returns a list of all simulation ids in the cyclus database for
 // conn.
-func SimIds(db *sql.DB) (ids [][]byte, err error) {
+func SimIds(db DB) (ids [][]byte, err error) {
 	sql := "SELECT SimId FROM Info"
 	rows, err := db.Query(sql)
 	if err != nil {
@@ -40,7 +43,7 @@ func (si SimInfo) String() string {
 	return fmt.Sprintf("%x: dur=%v", si.Id, si.Duration)
 }
 
-func SimStat(db *sql.DB, simid []byte) (si SimInfo, err error) {
+func SimStat(db DB, simid []byte) (si SimInfo, err error) {
 	sql := "SELECT Duration FROM Info WHERE SimId = ?"
 	rows, err := db.Query(sql, simid)
 	if err != nil {
@@ -59,6 +62,250 @@ func SimStat(db *sql.DB, simid []byte) (si SimInfo, err error) {
 	return si, nil
 }
 
+// SimMeta holds summary information about a simulation in a cyclus
+// database.  Fields whose source tables are missing from the database are
+// left zero.
+type SimMeta struct {
+	Id           []byte
+	InitialYear  int
+	InitialMonth int
+	Duration     int
+	// NTimesteps is the number of timesteps in TimeList - the simulation
+	// duration if TimeList hasn't been built yet.
+	NTimesteps int
+	NResources int
+	// NAgents is the number of agents that entered the simulation.
+	NAgents       int
+	NTransactions int
+	Handle        string
+	// CyclusVersion is the version of cyclus that ran the simulation and
+	// Decay its decay mode, both empty if not recorded.
+	CyclusVersion string
+	Decay         string
+	// PostProcessed is true once the simulation's inventories have been
+	// built.
+	PostProcessed bool
+}
+
+func (sm SimMeta) String() string {
+	return fmt.Sprintf("%x: start=%v-%02v, dur=%v, timesteps=%v, resources=%v, agents=%v, transactions=%v, cyclus=%v, post=%v", sm.Id,
+		sm.InitialYear, sm.InitialMonth, sm.Duration, sm.NTimesteps, sm.NResources,
+		sm.NAgents, sm.NTransactions, sm.CyclusVersion, sm.PostProcessed)
+}
+
+// SimList returns summary information for every simulation in the cyclus
+// database.  Simulations are listed in the order they appear in the Info
+// table followed by any only present in the Resources table.
+func SimList(db DB) (sims []SimMeta, err error) {
+	tbls := map[string]bool{}
+	for _, name := range []string{"Info", "SimulationTimeInfo", "TimeList", "Resources", "AgentEntry", "Agents", "Transactions", "DecayMode"} {
+		if tbls[name], err = HasTable(db, name); err != nil {
+			return nil, err
+		}
+	}
+
+	var ids [][]byte
+	seen := map[string]bool{}
+	for _, tbl := range []string{"Info", "Resources"} {
+		if !tbls[tbl] {
+			continue
+		}
+		rows, err := db.Query("SELECT DISTINCT SimId FROM " + tbl)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id []byte
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !seen[string(id)] {
+				seen[string(id)] = true
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// newer cyclus versions moved time info out of the Info table
+	timeTbl := ""
+	if tbls["SimulationTimeInfo"] {
+		timeTbl = "SimulationTimeInfo"
+	} else if tbls["Info"] {
+		timeTbl = "Info"
+	}
+
+	// the version column was renamed once the describe output was recorded
+	infoCols := map[string]bool{}
+	if tbls["Info"] {
+		if infoCols, err = tableCols(db, "Info"); err != nil {
+			return nil, err
+		}
+	}
+	versionCol := ""
+	for _, col := range []string{"CyclusVersionDescribe", "CyclusVersion"} {
+		if versionCol == "" && infoCols[strings.ToLower(col)] {
+			versionCol = col
+		}
+	}
+
+	agentTbl := ""
+	if tbls["AgentEntry"] {
+		agentTbl = "AgentEntry"
+	} else if tbls["Agents"] {
+		agentTbl = "Agents"
+	}
+
+	for _, id := range ids {
+		sm := SimMeta{Id: id}
+		if infoCols["handle"] {
+			var v sql.NullString
+			err := db.QueryRow("SELECT Handle FROM Info WHERE SimId = ?", id).Scan(&v)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+			sm.Handle = v.String
+		}
+		if versionCol != "" {
+			var v sql.NullString
+			err := db.QueryRow("SELECT "+versionCol+" FROM Info WHERE SimId = ?", id).Scan(&v)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+			sm.CyclusVersion = v.String
+		}
+		if tbls["DecayMode"] {
+			var v sql.NullString
+			err := db.QueryRow("SELECT Decay FROM DecayMode WHERE SimId = ?", id).Scan(&v)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+			sm.Decay = v.String
+		}
+
+		if timeTbl != "" {
+			s := "SELECT InitialYear,InitialMonth,Duration FROM " + timeTbl + " WHERE SimId = ?"
+			err := db.QueryRow(s, id).Scan(&sm.InitialYear, &sm.InitialMonth, &sm.Duration)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+		}
+
+		sm.NTimesteps = sm.Duration
+		if tbls["TimeList"] {
+			n := 0
+			if err := db.QueryRow("SELECT COUNT(*) FROM TimeList WHERE SimId = ?", id).Scan(&n); err != nil {
+				return nil, err
+			} else if n > 0 {
+				sm.NTimesteps = n
+			}
+		}
+
+		if tbls["Resources"] {
+			if err := db.QueryRow("SELECT COUNT(*) FROM Resources WHERE SimId = ?", id).Scan(&sm.NResources); err != nil {
+				return nil, err
+			}
+		}
+		if agentTbl != "" {
+			if err := db.QueryRow("SELECT COUNT(*) FROM "+agentTbl+" WHERE SimId = ?", id).Scan(&sm.NAgents); err != nil {
+				return nil, err
+			}
+		}
+		if tbls["Transactions"] {
+			if err := db.QueryRow("SELECT COUNT(*) FROM Transactions WHERE SimId = ?", id).Scan(&sm.NTransactions); err != nil {
+				return nil, err
+			}
+		}
+		for _, tbl := range []string{"InventoriesMeta", "Inventories"} {
+			if !sm.PostProcessed {
+				if sm.PostProcessed, err = hasSimRows(db, tbl, id); err != nil {
+					return nil, err
+				}
+			}
+		}
+		sims = append(sims, sm)
+	}
+	return sims, nil
+}
+
+// hasSimRows returns true if the named table exists and has rows for the
+// simulation.  Unlike HasTable it finds the tables of attached databases,
+// where post processing may have written its output.
+func hasSimRows(db DB, table string, simid []byte) (bool, error) {
+	n := 0
+	err := db.QueryRow("SELECT COUNT(*) FROM (SELECT 1 FROM "+table+" WHERE SimId = ? LIMIT 1);", simid).Scan(&n)
+	if err != nil && strings.Contains(err.Error(), "no such table") {
+		return false, nil
+	}
+	return n > 0, err
+}
+
+// HasTable returns true if the database contains a table with the given
+// name.
+func HasTable(db DB, name string) (bool, error) {
+	n := 0
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name = ?", name).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// SimTimeList returns the timesteps of the specified simulation in TimeList
+// in increasing order.  TimeList holds the timesteps of every simulation in
+// the database, so time series queries must always be restricted to one of
+// them.
+func SimTimeList(db DB, simid []byte) (times []int, err error) {
+	rows, err := db.Query("SELECT Time FROM TimeList WHERE SimId = ? ORDER BY Time;", simid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t int
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return times, nil
+}
+
+// TimeBounds returns the first and last timesteps of the specified
+// simulation from TimeList or, if TimeList hasn't been built for it yet, from
+// its transactions.
+func TimeBounds(db DB, simid []byte) (min, max int, err error) {
+	var tmin, tmax sql.NullInt64
+	ok, err := HasTable(db, "TimeList")
+	if err != nil {
+		return 0, 0, err
+	} else if ok {
+		err := db.QueryRow("SELECT MIN(Time),MAX(Time) FROM TimeList WHERE SimId = ?", simid).Scan(&tmin, &tmax)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if !tmin.Valid {
+		err := db.QueryRow("SELECT MIN(Time),MAX(Time) FROM Transactions WHERE SimId = ?", simid).Scan(&tmin, &tmax)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if !tmin.Valid {
+		return 0, 0, fmt.Errorf("simid %x has no timesteps", simid)
+	}
+	return int(tmin.Int64), int(tmax.Int64), nil
+}
+
 type AgentInfo struct {
 	Id       int
 	Kind     string
@@ -75,7 +322,13 @@ func (ai AgentInfo) String() string {
 		ai.Kind, ai.Impl, ai.Proto, ai.Parent, ai.Lifetime, ai.Enter, ai.Exit)
 }
 
-func AllAgents(db *sql.DB, simid []byte, proto string) (ags []AgentInfo, err error) {
+// Alive returns true if the agent is operating at time t - i.e. it entered
+// at or before t and has not yet exited (see AliveAgents).
+func (ai AgentInfo) Alive(t int) bool {
+	return ai.Enter <= t && (ai.Exit < 0 || t < ai.Exit)
+}
+
+func AllAgents(db DB, simid []byte, proto string) (ags []AgentInfo, err error) {
 	s := `SELECT AgentId,Kind,Spec,Prototype,ParentId,EnterTime,ExitTime,Lifetime FROM
 				Agents
 			WHERE Agents.SimId = ?`
@@ -109,7 +362,221 @@ func AllAgents(db *sql.DB, simid []byte, proto string) (ags []AgentInfo, err err
 	return ags, nil
 }
 
-func DeployCumulative(db *sql.DB, simid []byte, proto string) (xys []XY, err error) {
+// AgentPrototype returns the prototype of the agent with the given id in the
+// specified simulation.  Callers resolving many agents should use
+// PrototypeMap instead.
+func AgentPrototype(db DB, simid []byte, agent int) (proto string, err error) {
+	s := "SELECT Prototype FROM Agents WHERE SimId = ? AND AgentId = ?;"
+	err = db.QueryRow(s, simid, agent).Scan(&proto)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("simid %x has no agent with id %v", simid, agent)
+	}
+	return proto, err
+}
+
+// PrototypeMap returns the prototype of every agent in the specified
+// simulation keyed by agent id.
+func PrototypeMap(db DB, simid []byte) (protos map[int]string, err error) {
+	rows, err := db.Query("SELECT AgentId,Prototype FROM Agents WHERE SimId = ?;", simid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	protos = map[int]string{}
+	for rows.Next() {
+		var id int
+		var proto string
+		if err := rows.Scan(&id, &proto); err != nil {
+			return nil, err
+		}
+		protos[id] = proto
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return protos, nil
+}
+
+// AliveAgents returns the agents in the simulation that are operating at
+// time t - i.e. agents that entered at or before t and have not yet exited.
+// Agents that never exited are always included after their entry.
+func AliveAgents(db DB, simid []byte, t int) (ags []AgentInfo, err error) {
+	s := `SELECT AgentId,Kind,Spec,Prototype,ParentId,EnterTime,ExitTime,Lifetime FROM
+				Agents
+			WHERE Agents.SimId = ? AND Agents.EnterTime <= ?
+				AND (Agents.ExitTime > ? OR Agents.ExitTime IS NULL)`
+	rows, err := db.Query(s, simid, t, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ai := AgentInfo{}
+		var exit sql.NullInt64
+		if err := rows.Scan(&ai.Id, &ai.Kind, &ai.Impl, &ai.Proto, &ai.Parent, &ai.Enter, &exit, &ai.Lifetime); err != nil {
+			return nil, err
+		}
+		if !exit.Valid {
+			exit.Int64 = -1
+		}
+		ai.Exit = int(exit.Int64)
+		ags = append(ags, ai)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ags, nil
+}
+
+// Deployment is the number of agents of a prototype built, decommissioned,
+// and deployed at one timestep.
+type Deployment struct {
+	Time           int
+	Built          int
+	Decommissioned int
+	// Deployed is the number of agents built up to and including the
+	// timestep less those decommissioned before it.  Unlike AgentInfo.Alive,
+	// agents count as deployed at their exit timestep - cyclus decommissions
+	// them at its end - as with cyan's deployed command.
+	Deployed int
+}
+
+// DeploySeries returns the deployment of every prototype of agents of the
+// given kind (e.g. "Facility" - all kinds if empty) at every timestep of the
+// specified simulation (see TimeBounds) keyed by prototype.
+func DeploySeries(db DB, simid []byte, kind string) (deps map[string][]Deployment, err error) {
+	tmin, tmax, err := TimeBounds(db, simid)
+	if err != nil {
+		return nil, err
+	}
+	ags, err := AllAgents(db, simid, "")
+	if err != nil {
+		return nil, err
+	}
+
+	deps = map[string][]Deployment{}
+	for _, ai := range ags {
+		if kind != "" && ai.Kind != kind {
+			continue
+		}
+		ds := deps[ai.Proto]
+		if ds == nil {
+			ds = make([]Deployment, tmax-tmin+1)
+			for i := range ds {
+				ds[i].Time = tmin + i
+			}
+			deps[ai.Proto] = ds
+		}
+		for i := range ds {
+			t := ds[i].Time
+			if ai.Enter == t {
+				ds[i].Built++
+			}
+			if ai.Exit == t {
+				ds[i].Decommissioned++
+			}
+			if ai.Enter <= t && (ai.Exit < 0 || t <= ai.Exit) {
+				ds[i].Deployed++
+			}
+		}
+	}
+	return deps, nil
+}
+
+// Descendants returns the ids of all agents descended from (i.e. built
+// under) the agent with the given id in the specified simulation.
+func Descendants(db DB, simid []byte, agent int) (ids []int, err error) {
+	rows, err := db.Query("SELECT AgentId,ParentId FROM Agents WHERE SimId = ?", simid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	kids := map[int][]int{}
+	for rows.Next() {
+		var id, parent int
+		if err := rows.Scan(&id, &parent); err != nil {
+			return nil, err
+		}
+		kids[parent] = append(kids[parent], id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	seen := map[int]bool{agent: true}
+	for queue := kids[agent]; len(queue) > 0; queue = queue[1:] {
+		id := queue[0]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+		queue = append(queue, kids[id]...)
+	}
+	return ids, nil
+}
+
+// RegionInvSeries returns the total mass of material held by all agents
+// descended from the region agent at every timestep of the simulation.  A
+// resource moving between agents in the region is only counted once since
+// an agent's inventory entry ends when the next one's begins.
+func RegionInvSeries(db DB, simid []byte, region int) (xys []XY, err error) {
+	return collectXY(RegionInvSeriesIter(db, simid, region))
+}
+
+// RegionInvSeriesIter is like RegionInvSeries but returns an iterator over
+// the series instead of loading it into memory.
+func RegionInvSeriesIter(db DB, simid []byte, region int) (*XYIterator, error) {
+	ids, err := Descendants(db, simid, region)
+	if err != nil {
+		return nil, err
+	}
+	return totalInvSeriesIter(db, simid, agentFilt(ids), Sum)
+}
+
+// agentFilt returns an inventory join condition selecting the given agents.
+func agentFilt(ids []int) string {
+	return " AND inv.AgentId IN (" + joinInts(ids) + ")"
+}
+
+// SystemInvSeries returns the total mass of material held by all agents at
+// every timestep of the simulation.
+func SystemInvSeries(db DB, simid []byte) (xys []XY, err error) {
+	return collectXY(SystemInvSeriesIter(db, simid, Sum))
+}
+
+// SystemInvSeriesAgg is like SystemInvSeries but aggregates the quantities
+// of the entries held at each timestep with agg instead of summing them.
+// Timesteps where nothing is held are zero.
+func SystemInvSeriesAgg(db DB, simid []byte, agg Aggregation) (xys []XY, err error) {
+	return collectXY(SystemInvSeriesIter(db, simid, agg))
+}
+
+// SystemInvSeriesIter is like SystemInvSeriesAgg but returns an iterator over
+// the series instead of loading it into memory.
+func SystemInvSeriesIter(db DB, simid []byte, agg Aggregation) (*XYIterator, error) {
+	return totalInvSeriesIter(db, simid, "", agg)
+}
+
+// totalInvSeriesIter aggregates the quantities of the inventory entries
+// matching the join condition filt at every timestep of the simulation.
+func totalInvSeriesIter(db DB, simid []byte, filt string, agg Aggregation) (*XYIterator, error) {
+	sql := `SELECT ti.Time,IFNULL(` + agg.sql("inv.Quantity") + `,0) FROM TimeList AS ti
+			LEFT JOIN Inventories AS inv ON inv.SimId = ti.SimId
+				AND inv.StartTime <= ti.Time AND inv.EndTime > ti.Time` + filt + `
+			WHERE ti.SimId = ?
+			GROUP BY ti.Time ORDER BY ti.Time;`
+	rows, err := db.Query(sql, simid)
+	if err != nil {
+		return nil, err
+	}
+	return &XYIterator{cursor{rows}}, nil
+}
+
+func DeployCumulative(db DB, simid []byte, proto string) (xys []XY, err error) {
 	sql := `SELECT Time, IFNULL(Count, 0) FROM 
 			TimeList LEFT JOIN
 			(SELECT ti.Time AS Timestep,COUNT(*) AS Count FROM
@@ -120,8 +587,9 @@ func DeployCumulative(db *sql.DB, simid []byte, proto string) (xys []XY, err err
 					AND ag.SimId = ?
 					AND ag.Prototype = ?
 				GROUP BY ti.Time
-				ORDER BY ti.Time) AS foo ON foo.Timestep = TimeList.Time;`
-	rows, err := db.Query(sql, simid, proto)
+				ORDER BY ti.Time) AS foo ON foo.Timestep = TimeList.Time
+			WHERE TimeList.SimId = ? ORDER BY Time;`
+	rows, err := db.Query(sql, simid, proto, simid)
 	if err != nil {
 		return nil, err
 	}
@@ -144,37 +612,67 @@ type XY struct {
 	Y float64
 }
 
-func InvSeries(db *sql.DB, simid []byte, agent int, iso int) (xys []XY, err error) {
-	sql := `SELECT ti.Time,SUM(cmp.MassFrac * inv.Quantity) FROM (
+// Aggregation selects how the quantities of the inventory entries held at
+// a timestep are combined by time series queries.
+type Aggregation int
+
+const (
+	// Sum totals quantities.  It is the default.
+	Sum Aggregation = iota
+	// Max and Min select the largest and smallest quantity held.
+	Max
+	Min
+	// Avg averages the quantities held.
+	Avg
+	// Count is the number of entries held regardless of quantity.
+	Count
+)
+
+var aggFuncs = map[Aggregation]string{Sum: "SUM", Max: "MAX", Min: "MIN", Avg: "AVG", Count: "COUNT"}
+
+// sql returns the SQL aggregate of expr for a.  Unknown aggregations sum.
+func (a Aggregation) sql(expr string) string {
+	f, ok := aggFuncs[a]
+	if !ok {
+		f = "SUM"
+	}
+	return f + "(" + expr + ")"
+}
+
+func InvSeries(db DB, simid []byte, agent int, iso int) (xys []XY, err error) {
+	return collectXY(InvSeriesIter(db, simid, agent, iso, Sum))
+}
+
+// InvSeriesAgg is like InvSeries but aggregates the masses of nuclide iso in
+// the entries held by the agent at each timestep with agg instead of summing
+// them.
+func InvSeriesAgg(db DB, simid []byte, agent int, iso int, agg Aggregation) (xys []XY, err error) {
+	return collectXY(InvSeriesIter(db, simid, agent, iso, agg))
+}
+
+// InvSeriesIter is like InvSeriesAgg but returns an iterator over the series
+// instead of loading it into memory.
+func InvSeriesIter(db DB, simid []byte, agent int, iso int, agg Aggregation) (*XYIterator, error) {
+	sql := `SELECT ti.Time,` + agg.sql("cmp.MassFrac * inv.Quantity") + ` FROM (
 				Compositions AS cmp
 				INNER JOIN Inventories AS inv ON inv.QualId = cmp.QualId
 				INNER JOIN TimeList AS ti ON (ti.Time >= inv.StartTime AND ti.Time < inv.EndTime)
 			) WHERE (
 				inv.SimId = ? AND inv.SimId = cmp.SimId AND ti.SimId = inv.SimId
 				AND inv.AgentId = ? AND cmp.NucId = ?
-			) GROUP BY ti.Time,cmp.NucId;`
-	rows, err := db.Query(sql, simid, agent, iso)
+			) GROUP BY ti.Time,cmp.NucId ORDER BY ti.Time;`
+	rows, err := rawQuery(db, sql, simid, agent, iso)
 	if err != nil {
 		return nil, err
 	}
-	for rows.Next() {
-		xy := XY{}
-		if err := rows.Scan(&xy.X, &xy.Y); err != nil {
-			return nil, err
-		}
-		xys = append(xys, xy)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return xys, nil
+	return &XYIterator{cursor{rows}}, nil
 }
 
 // MatCreated returns the total amount of material created by the listed
 // agent ids in the simulation for the given sim id between t0 and t1. Passing no
 // agents defaults to all agents. Use t0=0 to specify beginning-of-simulation.
 // Use t1=-1 to specify end-of-simulation.
-func MatCreated(db *sql.DB, simid []byte, t0, t1 int, agents ...int) (m nuc.Material, err error) {
+func MatCreated(db DB, simid []byte, t0, t1 int, agents ...int) (m nuc.Material, err error) {
 	if t1 == -1 {
 		si, err := SimStat(db, simid)
 		if err != nil {
@@ -206,7 +704,7 @@ func MatCreated(db *sql.DB, simid []byte, t0, t1 int, agents ...int) (m nuc.Mate
 // InvAt returns the material inventory of the listed agent ids for the
 // specified sim id at time t. Passing no agents defaults to all agents. Use
 // t=-1 to specify end-of-simulation.
-func InvAt(db *sql.DB, simid []byte, t int, agents ...int) (m nuc.Material, err error) {
+func InvAt(db DB, simid []byte, t int, agents ...int) (m nuc.Material, err error) {
 	if t == -1 {
 		si, err := SimStat(db, simid)
 		if err != nil {
@@ -233,84 +731,1027 @@ func InvAt(db *sql.DB, simid []byte, t int, agents ...int) (m nuc.Material, err
 	return makeMaterial(db, sql, simid, t, t)
 }
 
-// InvMassAt returns the mass of material inventory of the listed agent ids
-// for the specified sim id at time t. Passing no agents defaults to all
-// agents. Use t=-1 to specify end-of-simulation.
-func InvMassAt(db *sql.DB, simid []byte, t int, agents ...int) (mass float64, err error) {
-	m, err := InvAt(db, simid, t, agents...)
+// NuclideInvAt returns the total mass of every nuclide held in the entire
+// system at time t for the specified sim id.  Use t=-1 to specify
+// end-of-simulation.  Resources without compositions (e.g. products) are
+// left out and reported in missing.
+func NuclideInvAt(db DB, simid []byte, t int) (m nuc.Material, missing Missing, err error) {
+	if t == -1 {
+		si, err := SimStat(db, simid)
+		if err != nil {
+			return nil, Missing{}, err
+		}
+		t = si.Duration
+	}
+
+	m, err = InvAt(db, simid, t)
 	if err != nil {
-		return 0, err
+		return nil, Missing{}, err
 	}
-	return float64(m.Mass()), nil
+	missing, err = MissingCompositions(db, simid, t)
+	if err != nil {
+		return nil, Missing{}, err
+	}
+	return m, missing, nil
 }
 
-type FlowArc struct {
-	SrcId    int
-	DstId    int
-	SrcProto string
-	DstProto string
-	AgentId  int
-	Commod   string
-	Quantity float64
+// Missing describes the resources left out of nuclide level results
+// because their QualIds (i.e. states) have no compositions, so that
+// incomplete output data doesn't go unnoticed.
+type Missing struct {
+	// Resources is the number of inventory entries left out.
+	Resources int
+	// QualIds lists the distinct QualIds of the entries in increasing
+	// order.
+	QualIds []int
 }
 
-func FlowGraph(db *sql.DB, simid []byte, t0, t1 int, groupByProto bool) (arcs []FlowArc, err error) {
-	if t1 == -1 {
+// MissingCompositions reports the resources held by the listed agent ids at
+// time t for the specified sim id that InvAt leaves out for lack of
+// compositions.  Passing no agents defaults to all agents.  Use t=-1 to
+// specify end-of-simulation.
+func MissingCompositions(db DB, simid []byte, t int, agents ...int) (missing Missing, err error) {
+	if t == -1 {
 		si, err := SimStat(db, simid)
 		if err != nil {
-			return nil, err
+			return Missing{}, err
 		}
-		t1 = si.Duration
+		t = si.Duration
 	}
-
-	var sql string
-	if !groupByProto {
-		sql = `SELECT snd.AgentId,rcv.AgentId,snd.Prototype,rcv.Prototype,tr.Commodity,SUM(res.Quantity) FROM (
-					Resources AS res
-					INNER JOIN Transactions AS tr ON tr.ResourceId = res.ResourceId
-					INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId
-					INNER JOIN Agents AS rcv ON rcv.AgentId = tr.ReceiverId
-				) WHERE (
-					res.SimId = ? AND tr.SimId = res.SimId
-					AND tr.Time >= ? AND tr.Time < ?
-				) GROUP BY tr.SenderId,tr.ReceiverId,tr.Commodity;`
-	} else {
-		sql = `SELECT snd.AgentId,rcv.AgentId,snd.Prototype,rcv.Prototype,tr.Commodity,SUM(res.Quantity) FROM (
-					Resources AS res
-					INNER JOIN Transactions AS tr ON tr.ResourceId = res.ResourceId
-					INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId
-					INNER JOIN Agents AS rcv ON rcv.AgentId = tr.ReceiverId
-				) WHERE (
-					res.SimId = ? AND tr.SimId = res.SimId
-					AND tr.Time >= ? AND tr.Time < ?
-				) GROUP BY snd.Prototype,rcv.Prototype,tr.Commodity;`
+	filt := ""
+	if len(agents) > 0 {
+		filt += " AND inv.AgentId IN (" + strconv.Itoa(agents[0])
+		for _, a := range agents[1:] {
+			filt += "," + strconv.Itoa(a)
+		}
+		filt += ") "
 	}
 
-	rows, err := db.Query(sql, simid, t0, t1)
+	sql := `SELECT inv.QualId,COUNT(*) FROM Inventories AS inv
+			WHERE inv.SimId = ? AND inv.StartTime <= ? AND inv.EndTime > ?` + filt + `
+				AND NOT EXISTS (
+					SELECT * FROM Compositions AS cmp
+					WHERE cmp.SimId = inv.SimId AND cmp.QualId = inv.QualId
+				)
+			GROUP BY inv.QualId ORDER BY inv.QualId;`
+	rows, err := rawQuery(db, sql, simid, t, t)
 	if err != nil {
-		return nil, err
+		return Missing{}, err
 	}
+	defer rows.Close()
+
 	for rows.Next() {
-		arc := FlowArc{}
-		if err := rows.Scan(&arc.SrcId, &arc.DstId, &arc.SrcProto, &arc.DstProto, &arc.Commod, &arc.Quantity); err != nil {
-			return nil, err
+		var qual, n int
+		if err := rows.Scan(&qual, &n); err != nil {
+			return Missing{}, err
 		}
-		arcs = append(arcs, arc)
+		missing.QualIds = append(missing.QualIds, qual)
+		missing.Resources += n
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return Missing{}, err
 	}
-	return arcs, nil
+	return missing, nil
 }
 
-func Flow(db *sql.DB, simid []byte, t0, t1 int, fromAgents, toAgents []int) (m nuc.Material, err error) {
-	if t1 == -1 {
-		si, err := SimStat(db, simid)
-		if err != nil {
-			return nil, err
-		}
-		t1 = si.Duration
-	}
+// InvMassAt returns the mass of material inventory of the listed agent ids
+// for the specified sim id at time t. Passing no agents defaults to all
+// agents. Use t=-1 to specify end-of-simulation.
+func InvMassAt(db DB, simid []byte, t int, agents ...int) (mass float64, err error) {
+	m, err := InvAt(db, simid, t, agents...)
+	if err != nil {
+		return 0, err
+	}
+	return float64(m.Mass()), nil
+}
+
+// DiffSims compares the material inventories of agents in two simulations
+// at time t.  Agent ids differ between simulations, so agentMap maps the id
+// of each agent in simA to its counterpart in simB.  The returned diffs are
+// keyed by simA agent id and hold simA's minus simB's mass of every nuclide
+// held by either agent - positive where simA holds more.  Use t=-1 to
+// specify end-of-simulation.
+func DiffSims(db DB, simA, simB []byte, agentMap map[int]int, t int) (diffs map[int]nuc.Material, err error) {
+	diffs = map[int]nuc.Material{}
+	for a, b := range agentMap {
+		ma, err := InvAt(db, simA, t, a)
+		if err != nil {
+			return nil, err
+		}
+		mb, err := InvAt(db, simB, t, b)
+		if err != nil {
+			return nil, err
+		}
+		for n, qty := range mb {
+			ma[n] -= qty
+		}
+		diffs[a] = ma
+	}
+	return diffs, nil
+}
+
+// InvMatrix returns the total mass of material held by each of the listed
+// agent ids at every timestep of the specified sim id.  Passing no agents
+// defaults to all agents.  Row i of qtys holds the inventories at times[i]
+// and column j those of agents[j], with zero where an agent holds nothing.
+func InvMatrix(db DB, simid []byte, agents ...int) (times, ids []int, qtys [][]float64, err error) {
+	if len(agents) == 0 {
+		ags, err := AllAgents(db, simid, "")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, a := range ags {
+			agents = append(agents, a.Id)
+		}
+		sort.Ints(agents)
+	}
+	col := map[int]int{}
+	for j, a := range agents {
+		col[a] = j
+	}
+
+	times, err = SimTimeList(db, simid)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	row := map[int]int{}
+	for i, t := range times {
+		row[t] = i
+		qtys = append(qtys, make([]float64, len(agents)))
+	}
+
+	filt := ""
+	if len(agents) > 0 {
+		filt += " AND inv.AgentId IN (" + strconv.Itoa(agents[0])
+		for _, a := range agents[1:] {
+			filt += "," + strconv.Itoa(a)
+		}
+		filt += ") "
+	}
+	sql := `SELECT ti.Time,inv.AgentId,SUM(inv.Quantity) FROM (
+				Inventories AS inv
+				INNER JOIN TimeList AS ti ON (ti.Time >= inv.StartTime AND ti.Time < inv.EndTime)
+			) WHERE (
+				inv.SimId = ? AND ti.SimId = inv.SimId`
+	sql += filt
+	sql += `) GROUP BY ti.Time,inv.AgentId;`
+	rows, err := db.Query(sql, simid)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t, a int
+		var qty float64
+		if err := rows.Scan(&t, &a, &qty); err != nil {
+			return nil, nil, nil, err
+		}
+		qtys[row[t]][col[a]] = qty
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	return times, agents, qtys, nil
+}
+
+// Residence is the total time a resource spent held by a set of agents.
+type Residence struct {
+	ResId int
+	Time  int
+}
+
+// ResidenceTime returns the total number of timesteps each resource was
+// held by agents of prototype proto in the specified simulation, e.g. as a
+// proxy for fuel irradiation time in reactors.  Resources still held at the
+// end of the simulation are counted until its end.
+func ResidenceTime(db DB, simid []byte, proto string) (res []Residence, err error) {
+	si, err := SimStat(db, simid)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `SELECT inv.ResourceId,SUM(MIN(inv.EndTime, ?) - inv.StartTime) FROM (
+				Inventories AS inv
+				INNER JOIN Agents AS ag ON ag.AgentId = inv.AgentId AND ag.SimId = inv.SimId
+			) WHERE (
+				inv.SimId = ? AND ag.Prototype = ? AND inv.StartTime < ?
+			) GROUP BY inv.ResourceId ORDER BY inv.ResourceId;`
+	rows, err := db.Query(sql, si.Duration, simid, proto, si.Duration)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := Residence{}
+		if err := rows.Scan(&r.ResId, &r.Time); err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// StateChange is the time a resource's lineage took on a new state (i.e.
+// composition).
+type StateChange struct {
+	Time   int
+	QualId int
+}
+
+// StateChanges returns the states the specified resource passed through in
+// order, traced back through its single-parent lineage (e.g. transmutations
+// and splits) to the resource it originated from.  The first entry is the
+// originating resource's creation.  Resources combined from two parents end
+// the trace.
+func StateChanges(db DB, simid []byte, resid int) (changes []StateChange, err error) {
+	sql, err := rawSql(db, "SELECT TimeCreated,QualId,Parent1,Parent2 FROM Resources WHERE SimId = ? AND ResourceId = ?;")
+	if err != nil {
+		return nil, err
+	}
+	for id := resid; id != 0; {
+		var sc StateChange
+		var parent1, parent2 int
+		if err := db.QueryRow(sql, simid, id).Scan(&sc.Time, &sc.QualId, &parent1, &parent2); err != nil {
+			return nil, err
+		}
+		if n := len(changes); n > 0 && changes[n-1].QualId == sc.QualId {
+			changes[n-1] = sc
+		} else {
+			changes = append(changes, sc)
+		}
+		if parent2 != 0 {
+			break
+		}
+		id = parent1
+	}
+
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	return changes, nil
+}
+
+// InvRes is a single resource held in an agent's inventory.
+type InvRes struct {
+	ResId    int
+	QualId   int
+	Quantity float64
+}
+
+// AgentInv is the inventory held by an agent at one time.
+type AgentInv struct {
+	AgentId   int
+	Resources []InvRes
+	// Total is the total quantity of the resources.
+	Total float64
+}
+
+// LatestInv returns the inventory of every agent holding resources at the
+// last timestep of the specified simulation (see TimeBounds) ordered by agent
+// id.  Entries still held at the end of the simulation are included whether
+// or not their end times have been bounded by post.BoundEndTimes.
+func LatestInv(db DB, simid []byte) (invs []AgentInv, err error) {
+	_, t, err := TimeBounds(db, simid)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `SELECT AgentId,ResourceId,QualId,Quantity FROM Inventories
+			WHERE SimId = ? AND StartTime <= ? AND EndTime > ?
+			ORDER BY AgentId,ResourceId;`
+	rows, err := db.Query(sql, simid, t, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var agent int
+		r := InvRes{}
+		if err := rows.Scan(&agent, &r.ResId, &r.QualId, &r.Quantity); err != nil {
+			return nil, err
+		}
+		if n := len(invs); n == 0 || invs[n-1].AgentId != agent {
+			invs = append(invs, AgentInv{AgentId: agent})
+		}
+		inv := &invs[len(invs)-1]
+		inv.Resources = append(inv.Resources, r)
+		inv.Total += r.Quantity
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return invs, nil
+}
+
+// CompPredicate reports whether a resource with the material m (mass of each
+// nuclide in kg) should be selected.
+type CompPredicate func(m nuc.Material) bool
+
+// MinNuclideFrac selects resources in which nuclide n makes up more than
+// frac of the mass.
+func MinNuclideFrac(n nuc.Nuc, frac float64) CompPredicate {
+	return func(m nuc.Material) bool {
+		tot := m.Mass()
+		return tot > 0 && float64(m[n]/tot) > frac
+	}
+}
+
+// MinTotalMass selects resources with a mass of at least kg.
+func MinTotalMass(kg float64) CompPredicate {
+	return func(m nuc.Material) bool { return float64(m.Mass()) >= kg }
+}
+
+// InvWhere returns the resources held by any agent for the specified sim id
+// at time t whose material satisfies pred, ordered by resource id.  Use
+// t=-1 to specify end-of-simulation.  Resources without compositions (e.g.
+// products) are never selected.
+func InvWhere(db DB, simid []byte, t int, pred CompPredicate) (res []InvRes, err error) {
+	if t == -1 {
+		si, err := SimStat(db, simid)
+		if err != nil {
+			return nil, err
+		}
+		t = si.Duration
+	}
+
+	sql := `SELECT inv.ResourceId,inv.QualId,inv.Quantity,cmp.NucId,cmp.MassFrac FROM Inventories AS inv
+			INNER JOIN Compositions AS cmp ON cmp.SimId = inv.SimId AND cmp.QualId = inv.QualId
+			WHERE inv.SimId = ? AND inv.StartTime <= ? AND inv.EndTime > ?
+			ORDER BY inv.ResourceId;`
+	rows, err := rawQuery(db, sql, simid, t, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var r InvRes
+	m := nuc.Material{}
+	flush := func() {
+		if len(m) > 0 && pred(m) {
+			res = append(res, r)
+		}
+		m = nuc.Material{}
+	}
+	for rows.Next() {
+		var next InvRes
+		var n nuc.Nuc
+		var frac float64
+		if err := rows.Scan(&next.ResId, &next.QualId, &next.Quantity, &n, &frac); err != nil {
+			return nil, err
+		}
+		if len(m) == 0 || next.ResId != r.ResId {
+			flush()
+			r = next
+		}
+		m[n] += nuc.Mass(frac * r.Quantity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return res, nil
+}
+
+// Disposal is the material held by sink agents at the end of a simulation.
+type Disposal struct {
+	Resources []InvRes
+	// Total is the total quantity of the resources.
+	Total float64
+	// Material is the mass of each nuclide in the resources.  Resources
+	// without compositions (e.g. products) are left out.
+	Material nuc.Material
+}
+
+// Disposed returns the resources held at the end of the specified
+// simulation by agents of any of the given sink prototypes, ordered by
+// resource id.  Resources that passed through a sink but were later sent on
+// are not included.
+func Disposed(db DB, simid []byte, sinkProtos []string) (d Disposal, err error) {
+	d.Material = nuc.Material{}
+	if len(sinkProtos) == 0 {
+		return d, nil
+	}
+	_, t, err := TimeBounds(db, simid)
+	if err != nil {
+		return Disposal{}, err
+	}
+
+	args := []interface{}{simid, t, t}
+	for _, p := range sinkProtos {
+		args = append(args, p)
+	}
+	from := `FROM Inventories AS inv
+			INNER JOIN Agents AS ag ON ag.SimId = inv.SimId AND ag.AgentId = inv.AgentId`
+	filt := ` WHERE inv.SimId = ? AND inv.StartTime <= ? AND inv.EndTime > ?
+				AND ag.Prototype IN (?` + strings.Repeat(",?", len(sinkProtos)-1) + `)`
+
+	rows, err := rawQuery(db, `SELECT inv.ResourceId,inv.QualId,inv.Quantity `+from+filt+` ORDER BY inv.ResourceId;`, args...)
+	if err != nil {
+		return Disposal{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r := InvRes{}
+		if err := rows.Scan(&r.ResId, &r.QualId, &r.Quantity); err != nil {
+			return Disposal{}, err
+		}
+		d.Resources = append(d.Resources, r)
+		d.Total += r.Quantity
+	}
+	if err := rows.Err(); err != nil {
+		return Disposal{}, err
+	}
+
+	sql := `SELECT cmp.NucId,SUM(cmp.MassFrac * inv.Quantity) ` + from + `
+			INNER JOIN Compositions AS cmp ON cmp.SimId = inv.SimId AND cmp.QualId = inv.QualId` + filt + `
+			GROUP BY cmp.NucId;`
+	if d.Material, err = makeMaterial(db, sql, args...); err != nil {
+		return Disposal{}, err
+	}
+	return d, nil
+}
+
+// InvDelta returns the change in the specified agent's inventory between t0
+// and t1.  Gained holds resources in the inventory at t1 but not at t0 and
+// lost holds resources in the inventory at t0 but not at t1.  Net is the
+// resulting change in inventory mass.
+func InvDelta(db DB, simid []byte, agent int, t0, t1 int) (gained, lost []InvRes, net float64, err error) {
+	gained, err = invOnlyAt(db, simid, agent, t1, t0)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	lost, err = invOnlyAt(db, simid, agent, t0, t1)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	for _, r := range gained {
+		net += r.Quantity
+	}
+	for _, r := range lost {
+		net -= r.Quantity
+	}
+	return gained, lost, net, nil
+}
+
+// invOnlyAt returns resources in agent's inventory at time t but not at time
+// other.
+func invOnlyAt(db DB, simid []byte, agent int, t, other int) (res []InvRes, err error) {
+	sql := `SELECT inv.ResourceId,inv.QualId,inv.Quantity FROM Inventories AS inv
+			WHERE inv.SimId = ? AND inv.AgentId = ?
+				AND inv.StartTime <= ? AND inv.EndTime > ?
+				AND NOT EXISTS (
+					SELECT * FROM Inventories AS prev
+					WHERE prev.SimId = inv.SimId AND prev.AgentId = inv.AgentId
+						AND prev.ResourceId = inv.ResourceId
+						AND prev.StartTime <= ? AND prev.EndTime > ?
+				)
+			ORDER BY inv.ResourceId;`
+	rows, err := db.Query(sql, simid, agent, t, t, other, other)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := InvRes{}
+		if err := rows.Scan(&r.ResId, &r.QualId, &r.Quantity); err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// InvEntry is a single row of the Inventories table: a resource held by an
+// agent over the interval [StartTime, EndTime).
+type InvEntry struct {
+	ResId     int
+	AgentId   int
+	StartTime int
+	EndTime   int
+	QualId    int
+	Quantity  float64
+}
+
+// cursor holds the parts of a streaming query result shared by the
+// iterator types.
+type cursor struct {
+	rows *sql.Rows
+}
+
+// Next advances the iterator to the next row and returns false when there
+// are none left or an error occurred.  The iterator is closed automatically
+// when Next returns false.
+func (c cursor) Next() bool { return c.rows.Next() }
+
+// Err returns the error, if any, encountered during iteration.
+func (c cursor) Err() error { return c.rows.Err() }
+
+// Close releases the iterator's statement and connection.  It is safe to
+// call Close more than once.
+func (c cursor) Close() error { return c.rows.Close() }
+
+// InvIterator steps through the rows of a simulation's Inventories table one
+// at a time.  It must be closed if the caller stops before Next returns
+// false.
+type InvIterator struct {
+	cursor
+}
+
+// InvIter returns an iterator over the inventory entries for the specified
+// sim id ordered by resource id and start time.  Unlike the other queries,
+// entries are not loaded into memory all at once.
+func InvIter(db DB, simid []byte) (*InvIterator, error) {
+	sql := `SELECT ResourceId,AgentId,StartTime,EndTime,QualId,Quantity FROM Inventories
+			WHERE SimId = ? ORDER BY ResourceId,StartTime;`
+	rows, err := db.Query(sql, simid)
+	if err != nil {
+		return nil, err
+	}
+	return &InvIterator{cursor{rows}}, nil
+}
+
+// Scan copies the current entry into e.
+func (it *InvIterator) Scan(e *InvEntry) error {
+	return it.rows.Scan(&e.ResId, &e.AgentId, &e.StartTime, &e.EndTime, &e.QualId, &e.Quantity)
+}
+
+// Fate records where a resource originated and where it ended up.
+type Fate struct {
+	ResId int
+	// CreatorAgent is the agent that created the resource or -1 if it is
+	// the product of a split, combine, or transmute rather than a creation.
+	CreatorAgent int
+	// FinalAgent is the last agent to hold the resource.
+	FinalAgent int
+	// FinalTime is when the resource left FinalAgent's inventory, or
+	// math.MaxInt32 if it was still held at the end of the simulation.
+	FinalTime int
+}
+
+// FateIterator steps through resource fates one at a time.  It must be
+// closed if the caller stops before Next returns false.
+type FateIterator struct {
+	cursor
+}
+
+// ResourceFate returns an iterator over the fate of every resource in the
+// inventories for the specified sim id ordered by resource id.
+func ResourceFate(db DB, simid []byte) (*FateIterator, error) {
+	sql := `SELECT inv.ResourceId,IFNULL(rc.AgentId,-1),inv.AgentId,inv.EndTime FROM Inventories AS inv
+			LEFT JOIN ResCreators AS rc ON rc.SimId = inv.SimId AND rc.ResourceId = inv.ResourceId
+			WHERE inv.SimId = ? AND inv.EndTime = (
+				SELECT MAX(last.EndTime) FROM Inventories AS last
+				WHERE last.SimId = inv.SimId AND last.ResourceId = inv.ResourceId
+			)
+			ORDER BY inv.ResourceId;`
+	rows, err := rawQuery(db, sql, simid)
+	if err != nil {
+		return nil, err
+	}
+	return &FateIterator{cursor{rows}}, nil
+}
+
+// Scan copies the current fate into f.
+func (it *FateIterator) Scan(f *Fate) error {
+	return it.rows.Scan(&f.ResId, &f.CreatorAgent, &f.FinalAgent, &f.FinalTime)
+}
+
+// XYIterator steps through the points of a time series one at a time.  It
+// must be closed if the caller stops before Next returns false.
+type XYIterator struct {
+	cursor
+}
+
+// Scan copies the current point into xy.
+func (it *XYIterator) Scan(xy *XY) error {
+	return it.rows.Scan(&xy.X, &xy.Y)
+}
+
+// collectXY loads all remaining points of the iterator it into memory and
+// closes it.
+func collectXY(it *XYIterator, err error) (xys []XY, _ error) {
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	for it.Next() {
+		xy := XY{}
+		if err := it.Scan(&xy); err != nil {
+			return nil, err
+		}
+		xys = append(xys, xy)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return xys, nil
+}
+
+// Churn is the number of times a resource changed owners.
+type Churn struct {
+	ResId           int
+	NumOwnerChanges int
+}
+
+// OwnerChurn returns the number of owner changes of every resource in the
+// inventories for the specified sim id that changed owners at least once,
+// ordered by resource id.  Each of a resource's inventory entries after the
+// first is counted as an owner change.
+func OwnerChurn(db DB, simid []byte) (churns []Churn, err error) {
+	sql := `SELECT ResourceId,COUNT(*)-1 FROM Inventories WHERE SimId = ?
+			GROUP BY ResourceId HAVING COUNT(*) > 1 ORDER BY ResourceId;`
+	rows, err := db.Query(sql, simid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := Churn{}
+		if err := rows.Scan(&c.ResId, &c.NumOwnerChanges); err != nil {
+			return nil, err
+		}
+		churns = append(churns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return churns, nil
+}
+
+type FlowArc struct {
+	SrcId    int
+	DstId    int
+	SrcProto string
+	DstProto string
+	AgentId  int
+	Commod   string
+	Quantity float64
+}
+
+func FlowGraph(db DB, simid []byte, t0, t1 int, groupByProto bool) (arcs []FlowArc, err error) {
+	if t1 == -1 {
+		si, err := SimStat(db, simid)
+		if err != nil {
+			return nil, err
+		}
+		t1 = si.Duration
+	}
+
+	var sql string
+	if !groupByProto {
+		sql = `SELECT snd.AgentId,rcv.AgentId,snd.Prototype,rcv.Prototype,tr.Commodity,SUM(res.Quantity) FROM (
+					Resources AS res
+					INNER JOIN Transactions AS tr ON tr.ResourceId = res.ResourceId AND tr.SimId = res.SimId
+					INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId AND snd.SimId = tr.SimId
+					INNER JOIN Agents AS rcv ON rcv.AgentId = tr.ReceiverId AND rcv.SimId = tr.SimId
+				) WHERE (
+					res.SimId = ? AND tr.SimId = res.SimId
+					AND tr.Time >= ? AND tr.Time < ?
+				) GROUP BY tr.SenderId,tr.ReceiverId,tr.Commodity;`
+	} else {
+		sql = `SELECT snd.AgentId,rcv.AgentId,snd.Prototype,rcv.Prototype,tr.Commodity,SUM(res.Quantity) FROM (
+					Resources AS res
+					INNER JOIN Transactions AS tr ON tr.ResourceId = res.ResourceId AND tr.SimId = res.SimId
+					INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId AND snd.SimId = tr.SimId
+					INNER JOIN Agents AS rcv ON rcv.AgentId = tr.ReceiverId AND rcv.SimId = tr.SimId
+				) WHERE (
+					res.SimId = ? AND tr.SimId = res.SimId
+					AND tr.Time >= ? AND tr.Time < ?
+				) GROUP BY snd.Prototype,rcv.Prototype,tr.Commodity;`
+	}
+
+	rows, err := rawQuery(db, sql, simid, t0, t1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		arc := FlowArc{}
+		if err := rows.Scan(&arc.SrcId, &arc.DstId, &arc.SrcProto, &arc.DstProto, &arc.Commod, &arc.Quantity); err != nil {
+			return nil, err
+		}
+		arcs = append(arcs, arc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return arcs, nil
+}
+
+// Commodities returns the names of all commodities transacted in the
+// specified simulation in alphabetical order.
+func Commodities(db DB, simid []byte) (commods []string, err error) {
+	rows, err := db.Query("SELECT DISTINCT Commodity FROM Transactions WHERE SimId = ? ORDER BY Commodity;", simid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		commods = append(commods, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return commods, nil
+}
+
+// CommodityFlow returns the total mass of the named commodity transacted
+// between t0 and t1 for the specified sim id.  Use t1=-1 to specify
+// end-of-simulation.
+func CommodityFlow(db DB, simid []byte, commod string, t0, t1 int) (mass float64, err error) {
+	if t1 == -1 {
+		si, err := SimStat(db, simid)
+		if err != nil {
+			return 0, err
+		}
+		t1 = si.Duration
+	}
+
+	sql := `SELECT IFNULL(SUM(res.Quantity),0) FROM (
+				Transactions AS tr
+				INNER JOIN Resources AS res ON res.ResourceId = tr.ResourceId AND res.SimId = tr.SimId
+			) WHERE (
+				tr.SimId = ? AND tr.Commodity = ?
+				AND tr.Time >= ? AND tr.Time < ?
+			);`
+	err = db.QueryRow(sql, simid, commod, t0, t1).Scan(&mass)
+	return mass, err
+}
+
+// Transaction is a single transfer of a resource between agents.
+type Transaction struct {
+	Id        int
+	Time      int
+	Sender    int
+	Receiver  int
+	Commodity string
+	ResId     int
+	Quantity  float64
+}
+
+// TxFilter restricts the transactions selected by TransactionsBetween.  The
+// zero value selects every transaction.
+type TxFilter struct {
+	// Senders and Receivers, if not empty, list the agent ids transactions
+	// must be sent from and received by.
+	Senders   []int
+	Receivers []int
+	// Commodity, if not empty, is the commodity transactions must trade.
+	Commodity string
+	// T0 is the first timestep selected and T1, if positive, ends the
+	// window before the given timestep.
+	T0, T1 int
+}
+
+// TransactionsBetween returns one page of the transactions in the specified
+// simulation matching filt: up to limit transactions after skipping the
+// first offset.  Transactions are ordered by time and id so pages are stable.
+// A limit less than 1 returns all remaining transactions.
+func TransactionsBetween(db DB, simid []byte, filt TxFilter, limit, offset int) (txs []Transaction, err error) {
+	sql := `SELECT tr.TransactionId,tr.Time,tr.SenderId,tr.ReceiverId,tr.Commodity,tr.ResourceId,res.Quantity FROM (
+				Transactions AS tr
+				INNER JOIN Resources AS res ON res.ResourceId = tr.ResourceId AND res.SimId = tr.SimId
+			) WHERE tr.SimId = ? AND tr.Time >= ?`
+	args := []interface{}{simid, filt.T0}
+	if filt.T1 > 0 {
+		sql += " AND tr.Time < ?"
+		args = append(args, filt.T1)
+	}
+	if filt.Commodity != "" {
+		sql += " AND tr.Commodity = ?"
+		args = append(args, filt.Commodity)
+	}
+	agentFilts := []struct {
+		col string
+		ids []int
+	}{{"tr.SenderId", filt.Senders}, {"tr.ReceiverId", filt.Receivers}}
+	for _, f := range agentFilts {
+		if len(f.ids) == 0 {
+			continue
+		}
+		sql += " AND " + f.col + " IN (" + strconv.Itoa(f.ids[0])
+		for _, id := range f.ids[1:] {
+			sql += "," + strconv.Itoa(id)
+		}
+		sql += ")"
+	}
+	if limit < 1 {
+		limit = -1
+	}
+	sql += " ORDER BY tr.Time,tr.TransactionId LIMIT ? OFFSET ?;"
+	args = append(args, limit, offset)
+
+	rows, err := rawQuery(db, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tx := Transaction{}
+		if err := rows.Scan(&tx.Id, &tx.Time, &tx.Sender, &tx.Receiver, &tx.Commodity, &tx.ResId, &tx.Quantity); err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// Utilization is an agent's throughput at one timestep as a fraction of its
+// nominal capacity.
+type Utilization struct {
+	Time int
+	// Factor is the capacity factor clamped to [0,1].
+	Factor float64
+	// Over is true if the throughput exceeded the nominal capacity, which
+	// usually indicates a misconfigured capacity or facility.
+	Over bool
+}
+
+// CapacityFactor returns the capacity factor of the specified agent at every
+// timestep of the simulation (see TimeBounds): the mass of material it
+// received in transactions at each timestep divided by its nominal
+// per-timestep capacity.
+func CapacityFactor(db DB, simid []byte, agent int, nominalPerStep float64) (us []Utilization, err error) {
+	if nominalPerStep <= 0 {
+		return nil, fmt.Errorf("nominal capacity must be positive, got %v", nominalPerStep)
+	}
+	tmin, tmax, err := TimeBounds(db, simid)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `SELECT tr.Time,SUM(res.Quantity) FROM (
+				Transactions AS tr
+				INNER JOIN Resources AS res ON res.ResourceId = tr.ResourceId AND res.SimId = tr.SimId
+			) WHERE tr.SimId = ? AND tr.ReceiverId = ?
+			GROUP BY tr.Time;`
+	rows, err := rawQuery(db, sql, simid, agent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	throughput := map[int]float64{}
+	for rows.Next() {
+		var t int
+		var qty float64
+		if err := rows.Scan(&t, &qty); err != nil {
+			return nil, err
+		}
+		throughput[t] = qty
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for t := tmin; t <= tmax; t++ {
+		u := Utilization{Time: t, Factor: throughput[t] / nominalPerStep}
+		if u.Factor > 1 {
+			u.Factor, u.Over = 1, true
+		}
+		us = append(us, u)
+	}
+	return us, nil
+}
+
+// ProtoPair identifies the flow of material from one prototype to another.
+type ProtoPair struct {
+	From string
+	To   string
+}
+
+// NuclideFlowMatrix returns the mass of nuclide iso transacted from each
+// sending prototype to each receiving prototype between t0 and t1 for the
+// specified sim id.  Use t1=-1 to specify end-of-simulation.
+func NuclideFlowMatrix(db DB, simid []byte, iso nuc.Nuc, t0, t1 int) (m map[ProtoPair]float64, err error) {
+	if t1 == -1 {
+		si, err := SimStat(db, simid)
+		if err != nil {
+			return nil, err
+		}
+		t1 = si.Duration
+	}
+
+	sql := `SELECT snd.Prototype,rcv.Prototype,SUM(cmp.MassFrac * res.Quantity) FROM (
+				Transactions AS tr
+				INNER JOIN Resources AS res ON res.ResourceId = tr.ResourceId AND res.SimId = tr.SimId
+				INNER JOIN Compositions AS cmp ON cmp.QualId = res.QualId AND cmp.SimId = tr.SimId
+				INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId AND snd.SimId = tr.SimId
+				INNER JOIN Agents AS rcv ON rcv.AgentId = tr.ReceiverId AND rcv.SimId = tr.SimId
+			) WHERE (
+				tr.SimId = ? AND cmp.NucId = ?
+				AND tr.Time >= ? AND tr.Time < ?
+			) GROUP BY snd.Prototype,rcv.Prototype;`
+	rows, err := rawQuery(db, sql, simid, int(iso), t0, t1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	m = map[ProtoPair]float64{}
+	for rows.Next() {
+		var pp ProtoPair
+		var qty float64
+		if err := rows.Scan(&pp.From, &pp.To, &qty); err != nil {
+			return nil, err
+		}
+		m[pp] = qty
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FlowMatrix returns the total mass of material transacted from each sending
+// prototype to each receiving prototype between t0 and t1 for the specified
+// sim id along with every prototype of the simulation in sorted order, so
+// callers can list the full matrix including prototypes exchanging nothing.
+// Use t1=-1 to specify end-of-simulation.
+func FlowMatrix(db DB, simid []byte, t0, t1 int) (protos []string, m map[ProtoPair]float64, err error) {
+	if t1 == -1 {
+		si, err := SimStat(db, simid)
+		if err != nil {
+			return nil, nil, err
+		}
+		t1 = si.Duration
+	}
+
+	rows, err := db.Query("SELECT DISTINCT Prototype FROM Agents WHERE SimId = ? ORDER BY Prototype;", simid)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var proto string
+		if err := rows.Scan(&proto); err != nil {
+			return nil, nil, err
+		}
+		protos = append(protos, proto)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	sql := `SELECT snd.Prototype,rcv.Prototype,SUM(res.Quantity) FROM (
+				Transactions AS tr
+				INNER JOIN Resources AS res ON res.ResourceId = tr.ResourceId AND res.SimId = tr.SimId
+				INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId AND snd.SimId = tr.SimId
+				INNER JOIN Agents AS rcv ON rcv.AgentId = tr.ReceiverId AND rcv.SimId = tr.SimId
+			) WHERE (
+				tr.SimId = ? AND tr.Time >= ? AND tr.Time < ?
+			) GROUP BY snd.Prototype,rcv.Prototype;`
+	frows, err := rawQuery(db, sql, simid, t0, t1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer frows.Close()
+
+	m = map[ProtoPair]float64{}
+	for frows.Next() {
+		var pp ProtoPair
+		var qty float64
+		if err := frows.Scan(&pp.From, &pp.To, &qty); err != nil {
+			return nil, nil, err
+		}
+		m[pp] = qty
+	}
+	if err := frows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return protos, m, nil
+}
+
+func Flow(db DB, simid []byte, t0, t1 int, fromAgents, toAgents []int) (m nuc.Material, err error) {
+	if t1 == -1 {
+		si, err := SimStat(db, simid)
+		if err != nil {
+			return nil, err
+		}
+		t1 = si.Duration
+	}
 	filt := " AND tr.SenderId IN (" + strconv.Itoa(fromAgents[0])
 	for _, a := range fromAgents[1:] {
 		filt += "," + strconv.Itoa(a)
@@ -334,9 +1775,173 @@ func Flow(db *sql.DB, simid []byte, t0, t1 int, fromAgents, toAgents []int) (m n
 	return makeMaterial(db, sql, simid, t0, t1)
 }
 
+// EnrichFlows summarizes the material streams of an enrichment facility.
+// Assays are mass fractions of the enriched nuclide.
+type EnrichFlows struct {
+	Feed         float64
+	Product      float64
+	Tails        float64
+	FeedAssay    float64
+	ProductAssay float64
+	TailsAssay   float64
+}
+
+// ErrNoFeed is returned by EnrichmentFlows for agents that received no feed
+// to classify their outgoing streams against.
+var ErrNoFeed = errors.New("enrichment agent received no feed")
+
+// EnrichmentFlows returns the feed, product, and tails streams of the
+// specified enrichment agent over the entire simulation.  Material received
+// of commodity feedCommod is feed - if feedCommod is empty, all material
+// received is feed.  Material sent is classified as product if its fraction
+// of the enriched nuclide iso (e.g. nuc.U235) is above the feed assay and as
+// tails otherwise.  ErrNoFeed is returned if the agent received no feed.
+func EnrichmentFlows(db DB, simid []byte, agent int, iso nuc.Nuc, feedCommod string) (ef EnrichFlows, err error) {
+	feed, err := streamAssays(db, simid, "ReceiverId", agent, iso, feedCommod)
+	if err != nil {
+		return ef, err
+	}
+	out, err := streamAssays(db, simid, "SenderId", agent, iso, "")
+	if err != nil {
+		return ef, err
+	}
+
+	var feediso, prodiso, tailsiso float64
+	for _, st := range feed {
+		ef.Feed += st.Quantity
+		feediso += st.Quantity * st.Assay
+	}
+	if ef.Feed <= 0 {
+		return EnrichFlows{}, ErrNoFeed
+	}
+	ef.FeedAssay = feediso / ef.Feed
+
+	for _, st := range out {
+		if st.Assay > ef.FeedAssay {
+			ef.Product += st.Quantity
+			prodiso += st.Quantity * st.Assay
+		} else {
+			ef.Tails += st.Quantity
+			tailsiso += st.Quantity * st.Assay
+		}
+	}
+	if ef.Product > 0 {
+		ef.ProductAssay = prodiso / ef.Product
+	}
+	if ef.Tails > 0 {
+		ef.TailsAssay = tailsiso / ef.Tails
+	}
+	return ef, nil
+}
+
+// EnrichStep is the enrichment done in one timestep: the product sent and
+// the separative work (kg-SWU) and feed it took.
+type EnrichStep struct {
+	Time    int
+	Product float64
+	SWU     float64
+	Feed    float64
+}
+
+// EnrichmentSeries reconstructs the enrichment done by the listed agent ids
+// at every timestep of the simulation from the product they sent to other
+// agents: material with a fraction of the enriched nuclide iso above
+// feedAssay.  The separative work and feed of each product transaction are
+// those of enriching feed of feedAssay leaving tails of tailsAssay, which
+// must be below it, rather than reconstructed from the feed and tails
+// transactions around it.  An error is returned if any product is pure iso
+// (an assay of 1), which would take infinite separative work.
+func EnrichmentSeries(db DB, simid []byte, agents []int, iso nuc.Nuc, feedAssay, tailsAssay float64) (steps []EnrichStep, err error) {
+	if !(tailsAssay < feedAssay) {
+		return nil, fmt.Errorf("tails assay %v isn't below the feed assay %v", tailsAssay, feedAssay)
+	}
+	si, err := SimStat(db, simid)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := joinInts(agents)
+	sql := `SELECT tr.Time,res.Quantity,TOTAL(CASE WHEN cmp.NucId = ? THEN cmp.MassFrac ELSE 0 END),TOTAL(cmp.MassFrac) FROM (
+				Transactions AS tr
+				INNER JOIN Resources AS res ON res.ResourceId = tr.ResourceId
+				INNER JOIN Compositions AS cmp ON cmp.QualId = res.QualId
+			) WHERE (
+				tr.SimId = ? AND res.SimId = tr.SimId AND cmp.SimId = tr.SimId
+				AND tr.SenderId IN (` + ids + `) AND tr.ReceiverId NOT IN (` + ids + `)
+			) GROUP BY tr.TransactionId;`
+	rows, err := rawQuery(db, sql, int(iso), simid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps = make([]EnrichStep, si.Duration)
+	for t := range steps {
+		steps[t].Time = t
+	}
+	for rows.Next() {
+		var t int
+		var qty, isofrac, tot float64
+		if err := rows.Scan(&t, &qty, &isofrac, &tot); err != nil {
+			return nil, err
+		} else if t < 0 || t >= len(steps) || tot <= 0 {
+			continue
+		}
+		if xp := isofrac / tot; xp >= 1 {
+			return nil, fmt.Errorf("product sent at time %v has an assay of %v: the separative work of pure product is infinite", t, xp)
+		} else if xp > feedAssay {
+			steps[t].Product += qty
+			steps[t].SWU += nuc.SWU(qty, xp, feedAssay, tailsAssay)
+			steps[t].Feed += nuc.FeedMass(qty, xp, feedAssay, tailsAssay)
+		}
+	}
+	return steps, rows.Err()
+}
+
+type stream struct {
+	Quantity float64
+	Assay    float64
+}
+
+// streamAssays returns the quantity and iso mass fraction of each
+// transaction where agent is the party in column col of the Transactions
+// table (i.e. SenderId or ReceiverId).  If commod is not empty, only
+// transactions of that commodity are included.
+func streamAssays(db DB, simid []byte, col string, agent int, iso nuc.Nuc, commod string) (sts []stream, err error) {
+	sql := `SELECT res.Quantity,TOTAL(CASE WHEN cmp.NucId = ? THEN cmp.MassFrac ELSE 0 END),TOTAL(cmp.MassFrac) FROM (
+				Transactions AS tr
+				INNER JOIN Resources AS res ON res.ResourceId = tr.ResourceId
+				INNER JOIN Compositions AS cmp ON cmp.QualId = res.QualId
+			) WHERE (
+				tr.SimId = ? AND res.SimId = tr.SimId AND cmp.SimId = tr.SimId
+				AND tr.` + col + ` = ? AND (? = '' OR tr.Commodity = ?)
+			) GROUP BY tr.TransactionId;`
+	rows, err := rawQuery(db, sql, int(iso), simid, agent, commod, commod)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		st := stream{}
+		var isofrac, tot float64
+		if err := rows.Scan(&st.Quantity, &isofrac, &tot); err != nil {
+			return nil, err
+		}
+		if tot > 0 {
+			st.Assay = isofrac / tot
+		}
+		sts = append(sts, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sts, nil
+}
+
 // EnergyProduced returns the total amount of energy produced between t0 and
 // t1 in Joules. Use t1=-1 to specify end-of-simulation.
-func EnergyProduced(db *sql.DB, simid []byte, t0, t1 int) (float64, error) {
+func EnergyProduced(db DB, simid []byte, t0, t1 int) (float64, error) {
 	t2 := t1 + 1
 	if t1 < 0 {
 		t2 = -1
@@ -378,8 +1983,8 @@ func Index(table string, cols ...string) string {
 	return buf.String()
 }
 
-func makeMaterial(db *sql.DB, sql string, args ...interface{}) (m nuc.Material, err error) {
-	rows, err := db.Query(sql, args...)
+func makeMaterial(db DB, sql string, args ...interface{}) (m nuc.Material, err error) {
+	rows, err := rawQuery(db, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,1234 @@
+package query
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/nuc"
+	_ "github.com/rwcarlsen/go-sqlite3"
+)
+
+var testSimId = []byte("sim1")
+
+var schema = []string{
+	"CREATE TABLE Info (SimId BLOB,Handle TEXT,InitialYear INTEGER,InitialMonth INTEGER,Duration INTEGER);",
+	"CREATE TABLE Agents (SimId BLOB,AgentId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER,ExitTime INTEGER);",
+	"CREATE TABLE Resources (SimId INTEGER,ResourceId INTEGER,ObjId INTEGER,Type TEXT,TimeCreated INTEGER,Quantity REAL,Units TEXT,QualId INTEGER,Parent1 INTEGER,Parent2 INTEGER);",
+	"CREATE TABLE ResCreators (SimId INTEGER,ResourceId INTEGER,AgentId INTEGER);",
+	"CREATE TABLE Compositions (SimId BLOB,QualId INTEGER,NucId INTEGER, MassFrac REAL);",
+	"CREATE TABLE Inventories (SimId BLOB,ResourceId INTEGER,AgentId INTEGER,StartTime INTEGER,EndTime INTEGER,QualId INTEGER,Quantity REAL);",
+	"CREATE TABLE TimeList (SimId BLOB, Time INTEGER);",
+	"CREATE TABLE Transactions (SimId BLOB, TransactionId INTEGER, SenderId INTEGER, ReceiverId INTEGER, ResourceId INTEGER, Commodity TEXT, Time INTEGER);",
+}
+
+// testDb creates a fresh database with the post processed cyclus schema and
+// runs the given statements against it.  Statements may use a single '?'
+// placeholder for the test simid.
+func testDb(t *testing.T, stmts ...string) *sql.DB {
+	dir, err := ioutil.TempDir("", "cyan-query-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+
+	for _, s := range append(schema, stmts...) {
+		if _, err := db.Exec(s, simArgs(s)...); err != nil {
+			t.Fatalf("%v: %v", s, err)
+		}
+	}
+	return db
+}
+
+func simArgs(s string) []interface{} {
+	args := []interface{}{}
+	for _, c := range s {
+		if c == '?' {
+			args = append(args, testSimId)
+		}
+	}
+	return args
+}
+
+func TestPrototypeMap(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Agents VALUES (?,1,'Facility',':a:b','reactor',0,10,1,5);",
+		"INSERT INTO Agents VALUES (?,2,'Facility',':a:b','sink',0,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,3,'Region',':a:b','usa',0,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (X'73696d32',4,'Facility',':a:b','source',0,-1,0,NULL);",
+	)
+
+	protos, err := PrototypeMap(db, testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{1: "reactor", 2: "sink", 3: "usa"}
+	if !reflect.DeepEqual(protos, want) {
+		t.Errorf("want %v, got %v", want, protos)
+	}
+
+	ags, err := AllAgents(db, testSimId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range ags {
+		proto, err := AgentPrototype(db, testSimId, a.Id)
+		if err != nil {
+			t.Fatal(err)
+		} else if proto != protos[a.Id] {
+			t.Errorf("agent %v: AgentPrototype gives %v, PrototypeMap %v", a.Id, proto, protos[a.Id])
+		}
+	}
+
+	if _, err := AgentPrototype(db, testSimId, 4); err == nil || !strings.Contains(err.Error(), "no agent with id 4") {
+		t.Errorf("want missing agent error, got %v", err)
+	}
+}
+
+func TestAliveAgents(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Agents VALUES (?,1,'Facility',':a:b','reactor',0,10,1,5);",
+		"INSERT INTO Agents VALUES (?,2,'Facility',':a:b','sink',0,-1,0,NULL);",
+	)
+
+	alive := func(tm int) map[int]string {
+		ags, err := AliveAgents(db, testSimId, tm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := map[int]string{}
+		for _, a := range ags {
+			m[a.Id] = a.Proto
+		}
+		return m
+	}
+
+	if got := alive(4); got[1] != "reactor" || got[2] != "sink" {
+		t.Errorf("t=4: want agents 1 and 2 alive, got %v", got)
+	}
+	if got := alive(6); len(got) != 1 || got[2] != "sink" {
+		t.Errorf("t=6: want only agent 2 alive, got %v", got)
+	}
+	if got := alive(0); len(got) != 1 || got[2] != "sink" {
+		t.Errorf("t=0: want only agent 2 alive, got %v", got)
+	}
+}
+
+func TestAgentAlive(t *testing.T) {
+	ai := AgentInfo{Enter: 2, Exit: 5}
+	for tm, want := range map[int]bool{1: false, 2: true, 4: true, 5: false} {
+		if got := ai.Alive(tm); got != want {
+			t.Errorf("time %v: want alive=%v, got %v", tm, want, got)
+		}
+	}
+	if ai.Exit = -1; !ai.Alive(100) {
+		t.Errorf("want agents that never exit to stay alive")
+	}
+}
+
+func TestDeploySeries(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO TimeList VALUES (?,0);",
+		"INSERT INTO TimeList VALUES (?,1);",
+		"INSERT INTO TimeList VALUES (?,2);",
+		"INSERT INTO TimeList VALUES (?,3);",
+		"INSERT INTO Agents VALUES (?,1,'Facility',':a:b','reactor',0,-1,0,2);",
+		"INSERT INTO Agents VALUES (?,2,'Facility',':a:b','reactor',0,-1,1,NULL);",
+		"INSERT INTO Agents VALUES (?,3,'Facility',':a:b','reactor',0,-1,1,NULL);",
+		"INSERT INTO Agents VALUES (?,4,'Facility',':a:b','sink',0,-1,3,3);",
+		"INSERT INTO Agents VALUES (?,5,'Inst',':a:b','utility',0,-1,0,NULL);",
+	)
+
+	deps, err := DeploySeries(db, testSimId, "Facility")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]Deployment{
+		"reactor": {{0, 1, 0, 1}, {1, 2, 0, 3}, {2, 0, 1, 3}, {3, 0, 0, 2}},
+		"sink":    {{0, 0, 0, 0}, {1, 0, 0, 0}, {2, 0, 0, 0}, {3, 1, 1, 1}},
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("want %v, got %v", want, deps)
+	}
+
+	deps, err = DeploySeries(db, testSimId, "")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(deps) != 3 || deps["utility"][3].Deployed != 1 {
+		t.Errorf("want all kinds of agents, got %v", deps)
+	}
+}
+
+func TestInvDelta(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Inventories VALUES (?,1,1,0,2147483647,1,10);",
+		"INSERT INTO Inventories VALUES (?,2,2,0,3,1,4);",
+		"INSERT INTO Inventories VALUES (?,2,1,3,2147483647,1,4);",
+		"INSERT INTO Inventories VALUES (?,3,1,0,2,1,7);",
+	)
+
+	gained, lost, net, err := InvDelta(db, testSimId, 1, 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []InvRes{{ResId: 2, QualId: 1, Quantity: 4}}; !reflect.DeepEqual(gained, want) {
+		t.Errorf("gained: want %+v, got %+v", want, gained)
+	}
+	if want := []InvRes{{ResId: 3, QualId: 1, Quantity: 7}}; !reflect.DeepEqual(lost, want) {
+		t.Errorf("lost: want %+v, got %+v", want, lost)
+	}
+	if net != -3 {
+		t.Errorf("net: want -3, got %v", net)
+	}
+}
+
+func TestLatestInv(t *testing.T) {
+	// the simulation ends at t=4, with res 4's entry bounded to that end
+	db := testDb(t,
+		"INSERT INTO TimeList VALUES (?,0);",
+		"INSERT INTO TimeList VALUES (?,4);",
+		"INSERT INTO Compositions VALUES (?,1,922350000,1);",
+		"INSERT INTO Inventories VALUES (?,1,1,0,2147483647,1,10);",
+		"INSERT INTO Inventories VALUES (?,2,2,0,3,1,4);",
+		"INSERT INTO Inventories VALUES (?,2,1,3,2147483647,1,4);",
+		"INSERT INTO Inventories VALUES (?,3,3,0,4,1,7);",
+		"INSERT INTO Inventories VALUES (?,4,3,2,5,1,2);",
+	)
+
+	invs, err := LatestInv(db, testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AgentInv{
+		{AgentId: 1, Resources: []InvRes{{1, 1, 10}, {2, 1, 4}}, Total: 14},
+		{AgentId: 3, Resources: []InvRes{{4, 1, 2}}, Total: 2},
+	}
+	if !reflect.DeepEqual(invs, want) {
+		t.Errorf("\n    want %+v\n    got  %+v", want, invs)
+	}
+
+	for _, inv := range invs {
+		mass, err := InvMassAt(db, testSimId, 4, inv.AgentId)
+		if err != nil {
+			t.Fatal(err)
+		} else if mass != inv.Total {
+			t.Errorf("agent %v: InvMassAt gives %v, LatestInv %v", inv.AgentId, mass, inv.Total)
+		}
+	}
+}
+
+func TestInvWhere(t *testing.T) {
+	// natural (1), low enriched (2), and highly enriched (3) uranium, and a
+	// product without a composition (4)
+	db := testDb(t,
+		"INSERT INTO Compositions VALUES (?,1,922350000,0.007);",
+		"INSERT INTO Compositions VALUES (?,1,922380000,0.993);",
+		"INSERT INTO Compositions VALUES (?,2,922350000,0.05);",
+		"INSERT INTO Compositions VALUES (?,2,922380000,0.95);",
+		"INSERT INTO Compositions VALUES (?,3,922350000,0.9);",
+		"INSERT INTO Compositions VALUES (?,3,922380000,0.1);",
+		"INSERT INTO Inventories VALUES (?,1,1,0,2147483647,1,100);",
+		"INSERT INTO Inventories VALUES (?,2,1,0,2147483647,2,10);",
+		"INSERT INTO Inventories VALUES (?,3,2,0,2147483647,3,2);",
+		"INSERT INTO Inventories VALUES (?,4,2,0,2147483647,4,5);",
+		"INSERT INTO Inventories VALUES (?,5,2,0,3,3,8);",
+		"INSERT INTO Inventories VALUES (?,6,1,5,2147483647,3,1);",
+	)
+
+	for _, c := range []struct {
+		name string
+		pred CompPredicate
+		want []InvRes
+	}{
+		{"U235 > 0.2", MinNuclideFrac(nuc.U235, 0.2), []InvRes{{3, 3, 2}}},
+		{"U235 > 0.01", MinNuclideFrac(nuc.U235, 0.01), []InvRes{{2, 2, 10}, {3, 3, 2}}},
+		{">= 10 kg", MinTotalMass(10), []InvRes{{1, 1, 100}, {2, 2, 10}}},
+	} {
+		got, err := InvWhere(db, testSimId, 4, c.pred)
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: want %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestDisposed(t *testing.T) {
+	// the sink (3) holds res 2 and 3 at the end, the reactor (2) res 4, and
+	// res 1 passed through the sink on its way back to the reactor
+	db := testDb(t,
+		"INSERT INTO TimeList VALUES (?,0);",
+		"INSERT INTO TimeList VALUES (?,6);",
+		"INSERT INTO Agents VALUES (?,2,'Facility',':a:b','reactor',0,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,3,'Facility',':a:b','sink',0,-1,0,NULL);",
+		"INSERT INTO Compositions VALUES (?,1,922350000,0.1);",
+		"INSERT INTO Compositions VALUES (?,1,922380000,0.9);",
+		"INSERT INTO Compositions VALUES (?,2,942390000,1);",
+		"INSERT INTO Inventories VALUES (?,1,3,0,2,1,5);",
+		"INSERT INTO Inventories VALUES (?,1,2,2,2147483647,1,5);",
+		"INSERT INTO Inventories VALUES (?,2,3,1,2147483647,1,10);",
+		"INSERT INTO Inventories VALUES (?,3,3,4,2147483647,2,2);",
+		"INSERT INTO Inventories VALUES (?,4,2,0,2147483647,2,7);",
+	)
+
+	d, err := Disposed(db, testSimId, []string{"sink"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Disposal{
+		Resources: []InvRes{{2, 1, 10}, {3, 2, 2}},
+		Total:     12,
+		Material:  nuc.Material{nuc.U235: 1, nuc.U238: 9, nuc.Pu239: 2},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("\n    want %+v\n    got  %+v", want, d)
+	}
+
+	if d, err := Disposed(db, testSimId, nil); err != nil {
+		t.Fatal(err)
+	} else if len(d.Resources) != 0 || d.Total != 0 {
+		t.Errorf("no sink prototypes: want nothing disposed, got %+v", d)
+	}
+}
+
+var invRows = []string{
+	"INSERT INTO Inventories VALUES (?,2,1,0,3,1,4);",
+	"INSERT INTO Inventories VALUES (?,1,1,0,2147483647,1,10);",
+	"INSERT INTO Inventories VALUES (?,2,2,3,2147483647,1,4);",
+	"INSERT INTO Inventories VALUES (X'73696d32',3,1,0,2,1,7);",
+}
+
+func TestInvIter(t *testing.T) {
+	db := testDb(t, invRows...)
+	it, err := InvIter(db, testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	got := []InvEntry{}
+	for it.Next() {
+		e := InvEntry{}
+		if err := it.Scan(&e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []InvEntry{
+		{ResId: 1, AgentId: 1, StartTime: 0, EndTime: math.MaxInt32, QualId: 1, Quantity: 10},
+		{ResId: 2, AgentId: 1, StartTime: 0, EndTime: 3, QualId: 1, Quantity: 4},
+		{ResId: 2, AgentId: 2, StartTime: 3, EndTime: math.MaxInt32, QualId: 1, Quantity: 4},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant %+v\ngot  %+v", want, got)
+	}
+}
+
+func TestInvIterClose(t *testing.T) {
+	db := testDb(t, invRows...)
+	it, err := InvIter(db, testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() {
+		t.Fatalf("no entries: %v", it.Err())
+	}
+	if n := db.Stats().InUse; n != 1 {
+		t.Fatalf("want 1 connection in use by open iterator, got %v", n)
+	}
+
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.Stats().InUse; n != 0 {
+		t.Errorf("want no connections in use after Close, got %v", n)
+	}
+	if it.Next() {
+		t.Errorf("Next succeeded after Close")
+	}
+}
+
+func TestResourceFate(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO ResCreators VALUES (?,1,1);",
+		"INSERT INTO Inventories VALUES (?,1,1,0,2,1,10);",
+		"INSERT INTO Inventories VALUES (?,1,2,2,4,1,10);",
+		"INSERT INTO Inventories VALUES (?,1,3,4,2147483647,1,10);",
+		"INSERT INTO Inventories VALUES (?,2,1,0,5,1,4);",
+		"INSERT INTO Inventories VALUES (?,3,1,5,2147483647,1,4);",
+	)
+	it, err := ResourceFate(db, testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	got := []Fate{}
+	for it.Next() {
+		f := Fate{}
+		if err := it.Scan(&f); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, f)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Fate{
+		{ResId: 1, CreatorAgent: 1, FinalAgent: 3, FinalTime: math.MaxInt32},
+		{ResId: 2, CreatorAgent: -1, FinalAgent: 1, FinalTime: 5},
+		{ResId: 3, CreatorAgent: -1, FinalAgent: 1, FinalTime: math.MaxInt32},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant %+v\ngot  %+v", want, got)
+	}
+}
+
+func TestRegionInvSeries(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Agents VALUES (?,1,'Region',':a:r','region',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,2,'Inst',':a:i','inst',1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,3,'Facility',':a:f','mine',2,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,4,'Facility',':a:f','mill',2,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,5,'Facility',':a:f','other',-1,-1,0,NULL);",
+		"INSERT INTO TimeList VALUES (?,0);",
+		"INSERT INTO TimeList VALUES (?,1);",
+		"INSERT INTO TimeList VALUES (?,2);",
+		"INSERT INTO TimeList VALUES (?,3);",
+		// res 1 moves from facility 3 to facility 4 at t=2
+		"INSERT INTO Inventories VALUES (?,1,3,0,2,1,10);",
+		"INSERT INTO Inventories VALUES (?,1,4,2,2147483647,1,10);",
+		"INSERT INTO Inventories VALUES (?,2,4,1,3,1,5);",
+		"INSERT INTO Inventories VALUES (?,3,5,0,2147483647,1,100);",
+	)
+
+	got, err := RegionInvSeries(db, testSimId, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []XY{{0, 10}, {1, 15}, {2, 15}, {3, 10}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant %v\ngot  %v", want, got)
+	}
+}
+
+func TestNuclideFlowMatrix(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Agents VALUES (?,1,'Facility',':a:r','reactor',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,2,'Facility',':a:s','separations',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,3,'Facility',':a:f','fabrication',-1,-1,0,NULL);",
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,100,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',2,10,'kg',2,0,0);",
+		"INSERT INTO Resources VALUES (?,3,3,'Material',9,100,'kg',1,0,0);",
+		"INSERT INTO Compositions VALUES (?,1,942390000,0.01);",
+		"INSERT INTO Compositions VALUES (?,1,922380000,0.99);",
+		"INSERT INTO Compositions VALUES (?,2,942390000,0.5);",
+		"INSERT INTO Compositions VALUES (?,2,942400000,0.5);",
+		"INSERT INTO Transactions VALUES (?,1,1,2,1,'spent',1);",
+		"INSERT INTO Transactions VALUES (?,2,2,3,2,'pu',3);",
+		"INSERT INTO Transactions VALUES (?,3,1,2,3,'spent',9);",
+	)
+
+	got, err := NuclideFlowMatrix(db, testSimId, nuc.Pu239, 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[ProtoPair]float64{
+		{"reactor", "separations"}:     1,
+		{"separations", "fabrication"}: 5,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for pp, qty := range want {
+		if math.Abs(got[pp]-qty) > 1e-9 {
+			t.Errorf("%v: want %v, got %v", pp, qty, got[pp])
+		}
+	}
+}
+
+func TestFlowGraph(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Info VALUES (?,'',2000,1,10);",
+		"INSERT INTO Agents VALUES (?,1,'Facility',':a:r','reactor',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,2,'Facility',':a:k','sink',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,3,'Facility',':a:k','sink',-1,-1,0,NULL);",
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,100,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',0,30,'kg',1,0,0);",
+		"INSERT INTO Transactions VALUES (?,1,1,2,1,'spent',1);",
+		"INSERT INTO Transactions VALUES (?,2,1,3,2,'spent',2);",
+		// a second simulation reusing the same agent and resource ids
+		"INSERT INTO Agents VALUES (X'73696d32',1,'Facility',':a:r','reactor',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (X'73696d32',2,'Facility',':a:k','sink',-1,-1,0,NULL);",
+		"INSERT INTO Resources VALUES (X'73696d32',1,1,'Material',0,7,'kg',1,0,0);",
+		"INSERT INTO Transactions VALUES (X'73696d32',1,1,2,1,'spent',1);",
+	)
+
+	arcs, err := FlowGraph(db, testSimId, 0, -1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arcs) != 1 || arcs[0].SrcProto != "reactor" || arcs[0].DstProto != "sink" || arcs[0].Quantity != 130 {
+		t.Errorf("by prototype: want 130 kg from reactor to sink, got %+v", arcs)
+	}
+
+	arcs, err = FlowGraph(db, testSimId, 0, -1, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(arcs) != 2 {
+		t.Errorf("by agent: want 2 arcs, got %+v", arcs)
+	}
+}
+
+func TestFlowMatrix(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Agents VALUES (?,1,'Facility',':a:r','reactor',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,2,'Facility',':a:s','separations',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,3,'Facility',':a:r','reactor',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,4,'Facility',':a:k','sink',-1,-1,0,NULL);",
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,100,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',0,30,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,3,3,'Material',2,10,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,4,4,'Material',9,100,'kg',1,0,0);",
+		"INSERT INTO Transactions VALUES (?,1,1,2,1,'spent',1);",
+		"INSERT INTO Transactions VALUES (?,2,3,2,2,'spent',2);",
+		"INSERT INTO Transactions VALUES (?,3,2,1,3,'pu',3);",
+		"INSERT INTO Transactions VALUES (?,4,1,2,4,'spent',9);",
+	)
+
+	protos, got, err := FlowMatrix(db, testSimId, 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"reactor", "separations", "sink"}; !reflect.DeepEqual(protos, want) {
+		t.Errorf("want prototypes %v, got %v", want, protos)
+	}
+	want := map[ProtoPair]float64{
+		{"reactor", "separations"}: 130,
+		{"separations", "reactor"}: 10,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestOwnerChurn(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Inventories VALUES (?,1,1,0,2,1,10);",
+		"INSERT INTO Inventories VALUES (?,1,2,2,4,1,10);",
+		"INSERT INTO Inventories VALUES (?,1,3,4,2147483647,1,10);",
+		"INSERT INTO Inventories VALUES (?,2,1,0,2147483647,1,4);",
+	)
+	got, err := OwnerChurn(db, testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Churn{{ResId: 1, NumOwnerChanges: 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestInvMatrix(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Agents VALUES (?,2,'Facility',':a:b','sink',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,1,'Facility',':a:b','source',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,3,'Facility',':a:b','idle',-1,-1,0,NULL);",
+		"INSERT INTO TimeList VALUES (?,0);",
+		"INSERT INTO TimeList VALUES (?,1);",
+		"INSERT INTO TimeList VALUES (?,2);",
+		"INSERT INTO Inventories VALUES (?,1,1,0,2,1,10);",
+		"INSERT INTO Inventories VALUES (?,1,2,2,2147483647,1,10);",
+		"INSERT INTO Inventories VALUES (?,2,1,0,1,1,3);",
+	)
+
+	times, agents, qtys, err := InvMatrix(db, testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 2}; !reflect.DeepEqual(times, want) {
+		t.Errorf("times: want %v, got %v", want, times)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(agents, want) {
+		t.Errorf("agents: want %v, got %v", want, agents)
+	}
+	want := [][]float64{
+		{13, 0, 0},
+		{10, 0, 0},
+		{0, 10, 0},
+	}
+	if !reflect.DeepEqual(qtys, want) {
+		t.Errorf("all agents: want %v, got %v", want, qtys)
+	}
+
+	_, agents, qtys, err = InvMatrix(db, testSimId, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]float64{{0}, {0}, {10}}; !reflect.DeepEqual(agents, []int{2}) || !reflect.DeepEqual(qtys, want) {
+		t.Errorf("agent 2: want %v, got %v %v", want, agents, qtys)
+	}
+}
+
+func TestResidenceTime(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Info VALUES (?,'',2000,1,20);",
+		"INSERT INTO Agents VALUES (?,1,'Facility',':a:b','fab',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,2,'Facility',':a:b','reactor',-1,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,3,'Facility',':a:b','reactor',-1,-1,0,NULL);",
+		// res 1 spends 6 timesteps in two different reactors
+		"INSERT INTO Inventories VALUES (?,1,1,0,2,1,10);",
+		"INSERT INTO Inventories VALUES (?,1,2,2,6,1,10);",
+		"INSERT INTO Inventories VALUES (?,1,3,6,8,1,10);",
+		"INSERT INTO Inventories VALUES (?,1,1,8,10,1,10);",
+		// res 2 is still in a reactor at the end of the sim
+		"INSERT INTO Inventories VALUES (?,2,3,15,2147483647,1,10);",
+		"INSERT INTO Inventories VALUES (?,3,1,0,2147483647,1,10);",
+	)
+	got, err := ResidenceTime(db, testSimId, "reactor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Residence{{1, 6}, {2, 5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestSystemInvSeries(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO TimeList VALUES (?,0);",
+		"INSERT INTO TimeList VALUES (?,1);",
+		"INSERT INTO TimeList VALUES (?,2);",
+		"INSERT INTO Inventories VALUES (?,1,3,0,2,1,10);",
+		"INSERT INTO Inventories VALUES (?,1,4,2,2147483647,1,10);",
+		"INSERT INTO Inventories VALUES (?,2,4,1,2,1,5);",
+		"INSERT INTO Inventories VALUES (X'73696d32',3,5,0,2147483647,1,100);",
+	)
+	got, err := SystemInvSeries(db, testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []XY{{0, 10}, {1, 15}, {2, 10}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestSystemInvSeriesIter(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO TimeList VALUES (?,0);",
+		"INSERT INTO TimeList VALUES (?,1);",
+		"INSERT INTO TimeList VALUES (?,2);",
+		"INSERT INTO Inventories VALUES (?,1,3,0,2,1,10);",
+		"INSERT INTO Inventories VALUES (?,2,4,1,2,1,5);",
+	)
+	it, err := SystemInvSeriesIter(db, testSimId, Sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stop after the second point
+	got := []XY{}
+	for it.Next() && len(got) < 2 {
+		xy := XY{}
+		if err := it.Scan(&xy); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, xy)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []XY{{0, 10}, {1, 15}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	if n := db.Stats().InUse; n != 1 {
+		t.Fatalf("want 1 connection in use by open iterator, got %v", n)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.Stats().InUse; n != 0 {
+		t.Errorf("want no connections in use after Close, got %v", n)
+	}
+}
+
+func TestInvSeriesAgg(t *testing.T) {
+	// agent 4 holds 10 kg from t=0, 5 kg more at t=1, and 20 kg more at t=2
+	db := testDb(t,
+		"INSERT INTO TimeList VALUES (?,0);",
+		"INSERT INTO TimeList VALUES (?,1);",
+		"INSERT INTO TimeList VALUES (?,2);",
+		"INSERT INTO TimeList VALUES (?,3);",
+		"INSERT INTO Compositions VALUES (?,1,922350000,1);",
+		"INSERT INTO Inventories VALUES (?,1,4,0,2147483647,1,10);",
+		"INSERT INTO Inventories VALUES (?,2,4,1,3,1,5);",
+		"INSERT INTO Inventories VALUES (?,3,4,2,3,1,20);",
+	)
+
+	for _, c := range []struct {
+		agg  Aggregation
+		want []XY
+	}{
+		{Sum, []XY{{0, 10}, {1, 15}, {2, 35}, {3, 10}}},
+		{Count, []XY{{0, 1}, {1, 2}, {2, 3}, {3, 1}}},
+		{Max, []XY{{0, 10}, {1, 10}, {2, 20}, {3, 10}}},
+		{Min, []XY{{0, 10}, {1, 5}, {2, 5}, {3, 10}}},
+	} {
+		got, err := InvSeriesAgg(db, testSimId, 4, nuc.U235, c.agg)
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("InvSeriesAgg %v: want %v, got %v", c.agg, c.want, got)
+		}
+		got, err = SystemInvSeriesAgg(db, testSimId, c.agg)
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("SystemInvSeriesAgg %v: want %v, got %v", c.agg, c.want, got)
+		}
+	}
+}
+
+func TestNuclideInvAt(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Compositions VALUES (?,1,922350000,0.05);",
+		"INSERT INTO Compositions VALUES (?,1,922380000,0.95);",
+		"INSERT INTO Compositions VALUES (?,2,942390000,0.5);",
+		"INSERT INTO Compositions VALUES (?,2,922380000,0.5);",
+		"INSERT INTO Inventories VALUES (?,1,1,0,2147483647,1,100);",
+		"INSERT INTO Inventories VALUES (?,2,2,2,6,2,10);",
+		"INSERT INTO Inventories VALUES (?,3,2,0,2147483647,7,1);",
+		"INSERT INTO Inventories VALUES (?,4,2,5,2147483647,2,1000);",
+	)
+
+	got, missing, err := NuclideInvAt(db, testSimId, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nuc.Material{nuc.U235: 5, 922380000: 100, nuc.Pu239: 5}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for n, qty := range want {
+		if math.Abs(float64(got[n]-qty)) > 1e-9 {
+			t.Errorf("%v: want %v, got %v", n, qty, got[n])
+		}
+	}
+	if want := (Missing{Resources: 1, QualIds: []int{7}}); !reflect.DeepEqual(missing, want) {
+		t.Errorf("missing compositions: want %+v, got %+v", want, missing)
+	}
+}
+
+func TestMissingCompositions(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Compositions VALUES (?,1,922350000,1);",
+		"INSERT INTO Inventories VALUES (?,1,1,0,2147483647,1,100);",
+		"INSERT INTO Inventories VALUES (?,2,1,0,2147483647,7,1);",
+		"INSERT INTO Inventories VALUES (?,3,2,0,2147483647,7,2);",
+		"INSERT INTO Inventories VALUES (?,4,2,0,2147483647,9,3);",
+		"INSERT INTO Inventories VALUES (?,5,2,5,2147483647,8,3);",
+	)
+
+	// res 2's mass is absent from agent 1's nuclide inventory but reported
+	m, err := InvAt(db, testSimId, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if m.Mass() != 100 {
+		t.Errorf("want 100 kg with compositions, got %v", m.Mass())
+	}
+	missing, err := MissingCompositions(db, testSimId, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if want := (Missing{Resources: 1, QualIds: []int{7}}); !reflect.DeepEqual(missing, want) {
+		t.Errorf("agent 1: want %+v, got %+v", want, missing)
+	}
+
+	missing, err = MissingCompositions(db, testSimId, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if want := (Missing{Resources: 3, QualIds: []int{7, 9}}); !reflect.DeepEqual(missing, want) {
+		t.Errorf("all agents: want %+v, got %+v", want, missing)
+	}
+}
+
+func TestDiffSims(t *testing.T) {
+	// sim2 (X'73696d32') renumbers the agents and its reactor (agent 1 in
+	// sim1, 11 in sim2) holds 10 kg less fuel
+	sim2 := []byte("sim2")
+	db := testDb(t,
+		"INSERT INTO Compositions VALUES (?,1,922350000,0.05);",
+		"INSERT INTO Compositions VALUES (?,1,922380000,0.95);",
+		"INSERT INTO Compositions VALUES (X'73696d32',1,922350000,0.05);",
+		"INSERT INTO Compositions VALUES (X'73696d32',1,922380000,0.95);",
+		"INSERT INTO Inventories VALUES (?,1,1,0,2147483647,1,100);",
+		"INSERT INTO Inventories VALUES (?,2,2,0,2147483647,1,50);",
+		"INSERT INTO Inventories VALUES (X'73696d32',1,11,0,2147483647,1,90);",
+		"INSERT INTO Inventories VALUES (X'73696d32',2,12,0,2147483647,1,50);",
+		"INSERT INTO Inventories VALUES (X'73696d32',3,12,5,2147483647,1,20);",
+	)
+
+	diffs, err := DiffSims(db, testSimId, sim2, map[int]int{1: 11, 2: 12}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]nuc.Material{
+		1: {nuc.U235: 0.5, 922380000: 9.5},
+		2: {nuc.U235: 0, 922380000: 0},
+	}
+	if len(diffs) != len(want) {
+		t.Fatalf("want %v, got %v", want, diffs)
+	}
+	for a, m := range want {
+		for n, qty := range m {
+			if math.Abs(float64(diffs[a][n]-qty)) > 1e-9 {
+				t.Errorf("agent %v %v: want %v, got %v", a, n, qty, diffs[a][n])
+			}
+		}
+	}
+
+	// agent 12 holds an extra 20 kg after t=5
+	diffs, err = DiffSims(db, testSimId, sim2, map[int]int{2: 12}, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := float64(diffs[2].Mass()); math.Abs(got+20) > 1e-9 {
+		t.Errorf("want -20 kg, got %v", got)
+	}
+}
+
+func TestStateChanges(t *testing.T) {
+	// res 1 is transmuted at t=3, split at t=4, and transmuted again at t=6
+	db := testDb(t,
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,10,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,1,'Material',3,10,'kg',2,1,0);",
+		"INSERT INTO Resources VALUES (?,3,1,'Material',4,4,'kg',2,2,0);",
+		"INSERT INTO Resources VALUES (?,4,1,'Material',4,6,'kg',2,2,0);",
+		"INSERT INTO Resources VALUES (?,5,1,'Material',6,4,'kg',3,3,0);",
+		"INSERT INTO Resources VALUES (?,6,1,'Material',7,1,'kg',4,0,0);",
+		"INSERT INTO Resources VALUES (?,7,1,'Material',8,5,'kg',5,5,6);",
+	)
+
+	tests := []struct {
+		resid int
+		want  []StateChange
+	}{
+		{5, []StateChange{{0, 1}, {3, 2}, {6, 3}}},
+		{4, []StateChange{{0, 1}, {3, 2}}},
+		{1, []StateChange{{0, 1}}},
+		// combined resources aren't traced through their parents
+		{7, []StateChange{{8, 5}}},
+	}
+	for _, test := range tests {
+		got, err := StateChanges(db, testSimId, test.resid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("res %v: want %v, got %v", test.resid, test.want, got)
+		}
+	}
+
+	if _, err := StateChanges(db, testSimId, 42); err == nil {
+		t.Errorf("want error for missing resource")
+	}
+}
+
+func TestTransactionsBetween(t *testing.T) {
+	// ids are out of time order to check pages are by time first
+	db := testDb(t,
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,1,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',0,2,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,3,3,'Material',0,3,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,4,4,'Material',0,4,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,5,5,'Material',0,5,'kg',1,0,0);",
+		"INSERT INTO Transactions VALUES (?,5,1,2,1,'fuel',1);",
+		"INSERT INTO Transactions VALUES (?,1,1,2,2,'fuel',3);",
+		"INSERT INTO Transactions VALUES (?,2,1,3,3,'fuel',3);",
+		"INSERT INTO Transactions VALUES (?,3,2,3,4,'waste',4);",
+		"INSERT INTO Transactions VALUES (?,4,1,2,5,'fuel',6);",
+		"INSERT INTO Transactions VALUES (X'73696d32',6,1,2,1,'fuel',2);",
+	)
+
+	var got []int
+	for offset := 0; ; offset += 2 {
+		page, err := TransactionsBetween(db, testSimId, TxFilter{}, 2, offset)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(page) > 2 {
+			t.Fatalf("page at offset %v has %v transactions", offset, len(page))
+		}
+		for _, tx := range page {
+			got = append(got, tx.Id)
+		}
+		if len(page) < 2 {
+			break
+		}
+	}
+	if want := []int{5, 1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("paged ids: want %v, got %v", want, got)
+	}
+
+	filt := TxFilter{Senders: []int{1}, Receivers: []int{2}, Commodity: "fuel", T0: 2, T1: 6}
+	txs, err := TransactionsBetween(db, testSimId, filt, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Transaction{{Id: 1, Time: 3, Sender: 1, Receiver: 2, Commodity: "fuel", ResId: 2, Quantity: 2}}
+	if !reflect.DeepEqual(txs, want) {
+		t.Errorf("filtered:\n    want %+v\n    got  %+v", want, txs)
+	}
+}
+
+func TestCapacityFactor(t *testing.T) {
+	// agent 2 runs at its nominal 10 kg for two steps, half for two, sits
+	// idle for one, and is overloaded at the last
+	db := testDb(t,
+		"INSERT INTO TimeList VALUES (?,0);",
+		"INSERT INTO TimeList VALUES (?,5);",
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,10,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',1,6,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,3,3,'Material',1,4,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,4,4,'Material',2,5,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,5,5,'Material',3,5,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,6,6,'Material',5,12,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,7,7,'Material',4,50,'kg',1,0,0);",
+		"INSERT INTO Transactions VALUES (?,1,1,2,1,'fuel',0);",
+		"INSERT INTO Transactions VALUES (?,2,1,2,2,'fuel',1);",
+		"INSERT INTO Transactions VALUES (?,3,1,2,3,'fuel',1);",
+		"INSERT INTO Transactions VALUES (?,4,1,2,4,'fuel',2);",
+		"INSERT INTO Transactions VALUES (?,5,1,2,5,'fuel',3);",
+		"INSERT INTO Transactions VALUES (?,6,1,2,6,'fuel',5);",
+		"INSERT INTO Transactions VALUES (?,7,1,3,7,'fuel',4);",
+	)
+
+	us, err := CapacityFactor(db, testSimId, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Utilization{
+		{0, 1, false},
+		{1, 1, false},
+		{2, 0.5, false},
+		{3, 0.5, false},
+		{4, 0, false},
+		{5, 1, true},
+	}
+	if !reflect.DeepEqual(us, want) {
+		t.Errorf("\n    want %+v\n    got  %+v", want, us)
+	}
+
+	if _, err := CapacityFactor(db, testSimId, 2, 0); err == nil {
+		t.Errorf("want error for zero nominal capacity")
+	}
+}
+
+func TestCommodityFlow(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,10,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',1,20,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,3,3,'Material',2,90,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,4,4,'Material',8,5,'kg',1,0,0);",
+		"INSERT INTO Transactions VALUES (?,1,1,2,1,'uox_fuel',0);",
+		"INSERT INTO Transactions VALUES (?,2,1,2,2,'uox_fuel',1);",
+		"INSERT INTO Transactions VALUES (?,3,3,4,3,'tails',2);",
+		"INSERT INTO Transactions VALUES (?,4,1,2,4,'uox_fuel',8);",
+	)
+
+	commods, err := Commodities(db, testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"tails", "uox_fuel"}; !reflect.DeepEqual(commods, want) {
+		t.Errorf("commodities: want %v, got %v", want, commods)
+	}
+
+	for _, c := range []struct {
+		Commod string
+		T1     int
+		Want   float64
+	}{
+		{"uox_fuel", 5, 30},
+		{"uox_fuel", 10, 35},
+		{"tails", 5, 90},
+		{"none", 5, 0},
+	} {
+		got, err := CommodityFlow(db, testSimId, c.Commod, 0, c.T1)
+		if err != nil {
+			t.Fatal(err)
+		} else if got != c.Want {
+			t.Errorf("%v [0,%v): want %v, got %v", c.Commod, c.T1, c.Want, got)
+		}
+	}
+}
+
+func TestTimeBounds(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO TimeList VALUES (?,0);",
+		"INSERT INTO TimeList VALUES (?,1);",
+		"INSERT INTO TimeList VALUES (?,2);",
+		"INSERT INTO Transactions VALUES (?,1,1,2,1,'fuel',1);",
+		"INSERT INTO Transactions VALUES (X'73696d32',2,1,2,1,'fuel',3);",
+		"INSERT INTO Transactions VALUES (X'73696d32',3,1,2,1,'fuel',7);",
+	)
+
+	for _, c := range []struct {
+		Id       []byte
+		Min, Max int
+	}{
+		{testSimId, 0, 2},
+		{[]byte("sim2"), 3, 7}, // no TimeList
+	} {
+		min, max, err := TimeBounds(db, c.Id)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Id, err)
+		} else if min != c.Min || max != c.Max {
+			t.Errorf("%s: want [%v, %v], got [%v, %v]", c.Id, c.Min, c.Max, min, max)
+		}
+	}
+
+	if _, _, err := TimeBounds(db, []byte("sim3")); err == nil {
+		t.Errorf("empty sim: want error")
+	}
+}
+
+var twoSims = []string{
+	"INSERT INTO Info VALUES (?,'',2000,1,3);",
+	"INSERT INTO Info VALUES (X'73696d32','',2010,6,5);",
+	"INSERT INTO TimeList VALUES (?,0);",
+	"INSERT INTO TimeList VALUES (?,1);",
+	"INSERT INTO TimeList VALUES (?,2);",
+	"INSERT INTO Resources VALUES (?,1,1,'Material',0,10,'kg',1,0,0);",
+	"INSERT INTO Resources VALUES (?,2,2,'Material',1,10,'kg',1,0,0);",
+	"INSERT INTO Resources VALUES (X'73696d32',1,1,'Material',0,10,'kg',1,0,0);",
+}
+
+func TestSimTimeList(t *testing.T) {
+	// sim2 runs for 5 timesteps to sim1's 3 and has an agent deployed from
+	// t=1 in both
+	db := testDb(t, append(twoSims,
+		"INSERT INTO TimeList VALUES (X'73696d32',0);",
+		"INSERT INTO TimeList VALUES (X'73696d32',1);",
+		"INSERT INTO TimeList VALUES (X'73696d32',2);",
+		"INSERT INTO TimeList VALUES (X'73696d32',3);",
+		"INSERT INTO TimeList VALUES (X'73696d32',4);",
+		"INSERT INTO Agents VALUES (?,1,'Facility',':a:b','reactor',0,-1,1,NULL);",
+		"INSERT INTO Agents VALUES (X'73696d32',1,'Facility',':a:b','reactor',0,-1,1,NULL);",
+		"INSERT INTO Inventories VALUES (?,1,1,0,2147483647,1,10);",
+		"INSERT INTO Inventories VALUES (X'73696d32',1,1,0,2147483647,1,10);",
+	)...)
+
+	for _, c := range []struct {
+		id    []byte
+		times []int
+	}{
+		{testSimId, []int{0, 1, 2}},
+		{[]byte("sim2"), []int{0, 1, 2, 3, 4}},
+	} {
+		times, err := SimTimeList(db, c.id)
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(times, c.times) {
+			t.Errorf("%s: want timesteps %v, got %v", c.id, c.times, times)
+		}
+
+		series := map[string]func() ([]XY, error){
+			"DeployCumulative": func() ([]XY, error) { return DeployCumulative(db, c.id, "reactor") },
+			"SystemInvSeries":  func() ([]XY, error) { return SystemInvSeries(db, c.id) },
+		}
+		for name, f := range series {
+			xys, err := f()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int
+			for _, xy := range xys {
+				got = append(got, xy.X)
+			}
+			if !reflect.DeepEqual(got, c.times) {
+				t.Errorf("%s %v: want timesteps %v, got %v", c.id, name, c.times, got)
+			}
+		}
+	}
+}
+
+func TestSimList(t *testing.T) {
+	db := testDb(t, twoSims...)
+	got, err := SimList(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []SimMeta{
+		{Id: testSimId, InitialYear: 2000, InitialMonth: 1, Duration: 3, NTimesteps: 3, NResources: 2},
+		{Id: []byte("sim2"), InitialYear: 2010, InitialMonth: 6, Duration: 5, NTimesteps: 5, NResources: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant %v\ngot  %v", want, got)
+	}
+}
+
+func TestSimListMeta(t *testing.T) {
+	db := testDb(t,
+		"DROP TABLE Info;",
+		"CREATE TABLE Info (SimId BLOB,Handle TEXT,InitialYear INTEGER,InitialMonth INTEGER,Duration INTEGER,CyclusVersionDescribe TEXT);",
+		"INSERT INTO Info VALUES (?,'base',2000,1,3,'1.5.5-12-g3a1');",
+		"INSERT INTO Info VALUES (X'73696d32','',2010,6,5,NULL);",
+		"CREATE TABLE DecayMode (SimId BLOB,Decay TEXT);",
+		"INSERT INTO DecayMode VALUES (?,'manual');",
+		"INSERT INTO Agents VALUES (?,1,'Facility',':a:b','reactor',0,-1,0,NULL);",
+		"INSERT INTO Agents VALUES (?,2,'Facility',':a:b','sink',0,-1,0,NULL);",
+		"INSERT INTO Transactions VALUES (?,1,1,2,1,'fuel',0);",
+		"INSERT INTO Inventories VALUES (?,1,1,0,2,1,10);",
+	)
+	got, err := SimList(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []SimMeta{
+		{Id: testSimId, InitialYear: 2000, InitialMonth: 1, Duration: 3, NTimesteps: 3,
+			NAgents: 2, NTransactions: 1, Handle: "base", CyclusVersion: "1.5.5-12-g3a1", Decay: "manual", PostProcessed: true},
+		{Id: []byte("sim2"), InitialYear: 2010, InitialMonth: 6, Duration: 5, NTimesteps: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant %v\ngot  %v", want, got)
+	}
+}
+
+func TestSimListNoInfo(t *testing.T) {
+	db := testDb(t, append(twoSims, "DROP TABLE Info;", "DROP TABLE TimeList;")...)
+	got, err := SimList(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []SimMeta{
+		{Id: testSimId, NResources: 2},
+		{Id: []byte("sim2"), NResources: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nwant %v\ngot  %v", want, got)
+	}
+}
+
+func TestEnrichmentFlows(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,100,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',1,10,'kg',2,1,0);",
+		"INSERT INTO Resources VALUES (?,3,3,'Material',1,90,'kg',3,1,0);",
+		"INSERT INTO Compositions VALUES (?,1,922350000,0.007);",
+		"INSERT INTO Compositions VALUES (?,1,922380000,0.993);",
+		"INSERT INTO Compositions VALUES (?,2,922350000,0.043);",
+		"INSERT INTO Compositions VALUES (?,2,922380000,0.957);",
+		"INSERT INTO Compositions VALUES (?,3,922350000,0.003);",
+		"INSERT INTO Compositions VALUES (?,3,922380000,0.997);",
+		"INSERT INTO Transactions VALUES (?,1,1,5,1,'natu',0);",
+		"INSERT INTO Transactions VALUES (?,2,5,2,2,'leu',1);",
+		"INSERT INTO Transactions VALUES (?,3,5,3,3,'tails',1);",
+	)
+
+	ef, err := EnrichmentFlows(db, testSimId, 5, nuc.U235, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byCommod, err := EnrichmentFlows(db, testSimId, 5, nuc.U235, "natu"); err != nil {
+		t.Fatal(err)
+	} else if byCommod != ef {
+		t.Errorf("feed commodity natu: want %+v, got %+v", ef, byCommod)
+	}
+	if _, err := EnrichmentFlows(db, testSimId, 5, nuc.U235, "leu"); err != ErrNoFeed {
+		t.Errorf("feed commodity leu: want ErrNoFeed, got %v", err)
+	}
+	if _, err := EnrichmentFlows(db, testSimId, 1, nuc.U235, ""); err != ErrNoFeed {
+		t.Errorf("agent without feed: want ErrNoFeed, got %v", err)
+	}
+
+	want := EnrichFlows{Feed: 100, Product: 10, Tails: 90, FeedAssay: 0.007, ProductAssay: 0.043, TailsAssay: 0.003}
+	for _, c := range []struct {
+		Name      string
+		Got, Want float64
+	}{
+		{"Feed", ef.Feed, want.Feed},
+		{"Product", ef.Product, want.Product},
+		{"Tails", ef.Tails, want.Tails},
+		{"FeedAssay", ef.FeedAssay, want.FeedAssay},
+		{"ProductAssay", ef.ProductAssay, want.ProductAssay},
+		{"TailsAssay", ef.TailsAssay, want.TailsAssay},
+	} {
+		if math.Abs(c.Got-c.Want) > 1e-9 {
+			t.Errorf("%v: want %v, got %v", c.Name, c.Want, c.Got)
+		}
+	}
+}
+
+func TestEnrichmentSeries(t *testing.T) {
+	db := testDb(t,
+		"INSERT INTO Info VALUES (?,'',2000,1,3);",
+		"INSERT INTO Resources VALUES (?,1,1,'Material',0,100,'kg',1,0,0);",
+		"INSERT INTO Resources VALUES (?,2,2,'Material',1,10,'kg',2,1,0);",
+		"INSERT INTO Resources VALUES (?,3,3,'Material',1,90,'kg',3,1,0);",
+		"INSERT INTO Resources VALUES (?,4,4,'Material',2,5,'kg',2,1,0);",
+		"INSERT INTO Compositions VALUES (?,1,922350000,0.007);",
+		"INSERT INTO Compositions VALUES (?,1,922380000,0.993);",
+		"INSERT INTO Compositions VALUES (?,2,922350000,0.043);",
+		"INSERT INTO Compositions VALUES (?,2,922380000,0.957);",
+		"INSERT INTO Compositions VALUES (?,3,922350000,0.003);",
+		"INSERT INTO Compositions VALUES (?,3,922380000,0.997);",
+		"INSERT INTO Transactions VALUES (?,1,1,5,1,'natu',0);",
+		"INSERT INTO Transactions VALUES (?,2,5,2,2,'leu',1);",
+		"INSERT INTO Transactions VALUES (?,3,5,3,3,'tails',1);",
+		"INSERT INTO Transactions VALUES (?,4,5,2,4,'leu',2);",
+		"INSERT INTO Transactions VALUES (?,5,5,5,4,'leu',2);",
+	)
+
+	steps, err := EnrichmentSeries(db, testSimId, []int{5}, nuc.U235, 0.007, 0.003)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(steps) != 3 {
+		t.Fatalf("want 3 timesteps, got %v", steps)
+	}
+	for i, p := range []float64{0, 10, 5} {
+		st := steps[i]
+		swu, feed := nuc.SWU(p, 0.043, 0.007, 0.003), nuc.FeedMass(p, 0.043, 0.007, 0.003)
+		if st.Time != i || st.Product != p || math.Abs(st.SWU-swu) > 1e-9 || math.Abs(st.Feed-feed) > 1e-9 {
+			t.Errorf("time %v: want %v kg product taking %v SWU and %v kg feed, got %+v", i, p, swu, feed, st)
+		}
+	}
+	if math.Abs(steps[1].Feed-100) > 1e-9 {
+		t.Errorf("time 1: want the 100 kg of feed received, got %v", steps[1].Feed)
+	}
+
+	if _, err := EnrichmentSeries(db, testSimId, []int{5}, nuc.U235, 0.003, 0.007); err == nil {
+		t.Errorf("want tails above feed assay error")
+	}
+
+	// pure U235 product would take infinite separative work
+	if _, err := db.Exec("INSERT INTO Compositions VALUES (?,5,922350000,1);", testSimId); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec("INSERT INTO Resources VALUES (?,5,5,'Material',2,1,'kg',5,1,0);", testSimId); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec("INSERT INTO Transactions VALUES (?,6,5,2,5,'heu',2);", testSimId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnrichmentSeries(db, testSimId, []int{5}, nuc.U235, 0.007, 0.003); err == nil {
+		t.Errorf("want pure product error")
+	}
+}
@@ -0,0 +1,199 @@
+package query
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ColAlias is the Old name of column Col of a raw cyclus output table.
+type ColAlias struct {
+	Table, Col, Old string
+}
+
+// ColAliases lists the raw output columns read by cyan that older cyclus
+// versions named differently: agents were models (ModelId) and resource
+// qualities states (StateId).
+var ColAliases = []ColAlias{
+	{"AgentEntry", "AgentId", "ModelId"},
+	{"AgentExit", "AgentId", "ModelId"},
+	{"ResCreators", "AgentId", "ModelId"},
+	{"Resources", "QualId", "StateId"},
+	{"Compositions", "QualId", "StateId"},
+}
+
+// StaleCols returns the aliased columns of existing tables in db that have
+// their old name but not the current one.  It is empty for databases
+// written by current cyclus versions.
+func StaleCols(db DB) (stale []ColAlias, err error) {
+	cols := map[string]map[string]bool{}
+	for _, a := range ColAliases {
+		if cols[a.Table] == nil {
+			if cols[a.Table], err = tableCols(db, a.Table); err != nil {
+				return nil, err
+			}
+		}
+		if c := cols[a.Table]; c[strings.ToLower(a.Old)] && !c[strings.ToLower(a.Col)] {
+			stale = append(stale, a)
+		}
+	}
+	return stale, nil
+}
+
+// tableCols returns the lower cased column names of the named table - none
+// if it doesn't exist.
+func tableCols(db DB, table string) (map[string]bool, error) {
+	rows, err := db.Query("PRAGMA table_info(" + table + ");")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols := map[string]bool{}
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notnull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		cols[strings.ToLower(name)] = true
+	}
+	return cols, rows.Err()
+}
+
+// AliasSql rewrites statement s to read the stale columns (see StaleCols)
+// under their current names.  Every table with stale columns selected FROM
+// or JOINed in s is replaced by a subquery selecting its old columns as the
+// current ones, keeping any alias the statement gives it.  The tables
+// themselves are left untouched.
+func AliasSql(s string, stale []ColAlias) string {
+	if len(stale) == 0 {
+		return s
+	}
+	sels := map[string]string{}
+	var names []string
+	for _, a := range stale {
+		if _, ok := sels[a.Table]; !ok {
+			names = append(names, a.Table)
+			sels[a.Table] = "*"
+		}
+		sels[a.Table] += "," + a.Old + " AS " + a.Col
+	}
+	re := aliasRe(names)
+	return re.ReplaceAllStringFunc(s, func(m string) string {
+		sub := re.FindStringSubmatch(m)
+		table := names[0]
+		for _, n := range names {
+			if strings.EqualFold(n, sub[2]) {
+				table = n
+			}
+		}
+		alias := sub[3]
+		if alias == "" {
+			alias = " AS " + table
+		}
+		return sub[1] + " (SELECT " + sels[table] + " FROM " + table + ")" + alias
+	})
+}
+
+// aliasRes caches the expressions matching references to each set of tables
+// rewritten by AliasSql.
+var aliasRes sync.Map
+
+// aliasRe returns an expression matching the tables selected FROM or JOINed
+// in a statement, capturing the keyword, table, and any alias.
+func aliasRe(tables []string) *regexp.Regexp {
+	key := strings.Join(tables, "|")
+	if re, ok := aliasRes.Load(key); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(`(?i)\b(FROM|JOIN)\s+(` + key + `)\b(\s+AS\s+\w+)?`)
+	aliasRes.Store(key, re)
+	return re
+}
+
+// Schema is the layout of the cyclus output tables of a database, detected
+// once by ReadSchema so statements can be mapped onto it without inspecting
+// the database again.
+type Schema struct {
+	// Tables holds the lower cased names of the tables in the database.
+	Tables map[string]bool
+	// Stale lists the columns still having their old names (see StaleCols).
+	Stale []ColAlias
+}
+
+// ReadSchema detects the schema of db.
+func ReadSchema(db DB) (*Schema, error) {
+	stale, err := StaleCols(db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table';")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sch := &Schema{Tables: map[string]bool{}, Stale: stale}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		sch.Tables[strings.ToLower(name)] = true
+	}
+	return sch, rows.Err()
+}
+
+// HasTable returns true if the schema has the named table.
+func (s *Schema) HasTable(name string) bool { return s.Tables[strings.ToLower(name)] }
+
+// Legacy returns true if the schema has columns named by older cyclus
+// versions.
+func (s *Schema) Legacy() bool { return len(s.Stale) > 0 }
+
+// WithSchema returns db with every statement rewritten by AliasSql for the
+// stale columns of sch, so statements written for the current cyclus schema
+// read databases written by older versions.  Query functions run through it
+// don't inspect the database's schema again.
+func WithSchema(db DB, sch *Schema) DB {
+	return schemaDB{db, sch}
+}
+
+type schemaDB struct {
+	db  DB
+	sch *Schema
+}
+
+func (s schemaDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(AliasSql(query, s.sch.Stale), args...)
+}
+
+func (s schemaDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(AliasSql(query, s.sch.Stale), args...)
+}
+
+// rawSql is AliasSql for the stale columns of db.  Statements run through
+// WithSchema are already rewritten.
+func rawSql(db DB, s string) (string, error) {
+	if _, ok := db.(schemaDB); ok {
+		return s, nil
+	}
+	stale, err := StaleCols(db)
+	if err != nil {
+		return "", err
+	}
+	return AliasSql(s, stale), nil
+}
+
+// rawQuery is db.Query for statements reading raw cyclus output tables that
+// may have a legacy schema.
+func rawQuery(db DB, s string, args ...interface{}) (*sql.Rows, error) {
+	s, err := rawSql(db, s)
+	if err != nil {
+		return nil, err
+	}
+	return db.Query(s, args...)
+}
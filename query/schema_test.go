@@ -0,0 +1,101 @@
+package query
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/nuc"
+)
+
+func TestAliasSql(t *testing.T) {
+	stale := []ColAlias{{"Resources", "QualId", "StateId"}, {"ResCreators", "AgentId", "ModelId"}}
+	for _, c := range []struct{ Sql, Want string }{
+		{
+			"SELECT QualId FROM Resources WHERE SimId = ?",
+			"SELECT QualId FROM (SELECT *,StateId AS QualId FROM Resources) AS Resources WHERE SimId = ?",
+		}, {
+			"SELECT rc.AgentId FROM Resources As res INNER JOIN ResCreators AS rc ON rc.ResourceId = res.ResourceId",
+			"SELECT rc.AgentId FROM (SELECT *,StateId AS QualId FROM Resources) As res INNER JOIN (SELECT *,ModelId AS AgentId FROM ResCreators) AS rc ON rc.ResourceId = res.ResourceId",
+		}, {
+			"SELECT QualId FROM Inventories JOIN ResourcesExtra AS x",
+			"SELECT QualId FROM Inventories JOIN ResourcesExtra AS x",
+		},
+	} {
+		if got := AliasSql(c.Sql, stale); got != c.Want {
+			t.Errorf("\n    want %v\n    got  %v", c.Want, got)
+		}
+	}
+	if s := "SELECT QualId FROM Resources"; AliasSql(s, nil) != s {
+		t.Errorf("current schema: statement rewritten")
+	}
+}
+
+func TestLegacyQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cyan-query-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, s := range []string{
+		"CREATE TABLE Compositions (SimId BLOB,StateId INTEGER,NucId INTEGER, MassFrac REAL);",
+		"CREATE TABLE Inventories (SimId BLOB,ResourceId INTEGER,AgentId INTEGER,StartTime INTEGER,EndTime INTEGER,QualId INTEGER,Quantity REAL);",
+		"INSERT INTO Compositions VALUES (?,1,922350000,0.25);",
+		"INSERT INTO Compositions VALUES (?,1,922380000,0.75);",
+		"INSERT INTO Inventories VALUES (?,1,1,0,2147483647,1,4);",
+	} {
+		if _, err := db.Exec(s, simArgs(s)...); err != nil {
+			t.Fatalf("%v: %v", s, err)
+		}
+	}
+
+	m, err := InvAt(db, testSimId, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if m[nuc.U235] != 1 || m[nuc.U238] != 3 {
+		t.Errorf("want 1 kg U235 and 3 kg U238, got %v", m)
+	}
+}
+
+func TestWithSchema(t *testing.T) {
+	db := testDb(t,
+		"DROP TABLE Compositions;",
+		"CREATE TABLE Compositions (SimId BLOB,StateId INTEGER,NucId INTEGER, MassFrac REAL);",
+		"INSERT INTO Compositions VALUES (?,1,922350000,0.25);",
+		"INSERT INTO Compositions VALUES (?,1,922380000,0.75);",
+		"INSERT INTO Inventories VALUES (?,1,1,0,2147483647,1,4);",
+	)
+
+	sch, err := ReadSchema(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sch.Legacy() {
+		t.Errorf("legacy schema not detected")
+	} else if !sch.HasTable("compositions") || sch.HasTable("AgentEntry") {
+		t.Errorf("wrong tables: %v", sch.Tables)
+	}
+
+	sdb := WithSchema(db, sch)
+	n := 0
+	if err := sdb.QueryRow("SELECT COUNT(*) FROM Compositions WHERE QualId = 1;").Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Errorf("want 2 compositions with QualId 1, got %v", n)
+	}
+
+	m, err := InvAt(sdb, testSimId, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if m[nuc.U235] != 1 || m[nuc.U238] != 3 {
+		t.Errorf("want 1 kg U235 and 3 kg U238, got %v", m)
+	}
+}
@@ -0,0 +1,143 @@
+package query
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+)
+
+// SynthOpts configures GenSyntheticSim.
+type SynthOpts struct {
+	// Roots is the number of resources created from nothing.
+	Roots int
+	// Branching is the number of equal parts each resource is split into
+	// and Depth the number of times resources are split, so each root has
+	// Branching^Depth descendants at the bottom of its genealogy.
+	Branching int
+	Depth     int
+	// Churn is the number of times each resource is traded before it is
+	// split.
+	Churn int
+	// Agents is the number of agents trading resources.  It defaults to 10.
+	Agents int
+	// Seed seeds the choice of trading partners.
+	Seed int64
+}
+
+// synthSchema holds the raw cyclus output tables written by
+// GenSyntheticSim.
+var synthSchema = []string{
+	"CREATE TABLE IF NOT EXISTS Info (SimId BLOB,Handle TEXT,InitialYear INTEGER,InitialMonth INTEGER,Duration INTEGER);",
+	"CREATE TABLE IF NOT EXISTS AgentEntry (SimId BLOB,AgentId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER);",
+	"CREATE TABLE IF NOT EXISTS Resources (SimId INTEGER,ResourceId INTEGER,ObjId INTEGER,Type TEXT,TimeCreated INTEGER,Quantity REAL,Units TEXT,QualId INTEGER,Parent1 INTEGER,Parent2 INTEGER);",
+	"CREATE TABLE IF NOT EXISTS ResCreators (SimId INTEGER,ResourceId INTEGER,AgentId INTEGER);",
+	"CREATE TABLE IF NOT EXISTS Compositions (SimId BLOB,QualId INTEGER,NucId INTEGER, MassFrac REAL);",
+	"CREATE TABLE IF NOT EXISTS Transactions (SimId BLOB, TransactionId INTEGER, SenderId INTEGER, ReceiverId INTEGER, ResourceId INTEGER, Commodity TEXT, Time INTEGER);",
+}
+
+// GenSyntheticSim writes a synthetic simulation with the given id to the
+// raw cyclus output tables of db (creating them if necessary) for testing
+// and benchmarking inventory builds.  Every root is created with 100 kg of
+// low enriched uranium at t=0 by one of the agents.  Each resource is then
+// traded opts.Churn times, one trade per timestep, before being split into
+// opts.Branching parts on the next timestep, down to opts.Depth splits.
+// Mass is conserved: the children of every resource sum to its quantity.
+func GenSyntheticSim(db *sql.DB, simid []byte, opts SynthOpts) error {
+	if opts.Roots < 0 || opts.Branching < 1 || opts.Depth < 0 || opts.Churn < 0 {
+		return fmt.Errorf("invalid synthetic sim options %+v", opts)
+	}
+	if opts.Agents < 1 {
+		opts.Agents = 10
+	}
+
+	for _, s := range synthSchema {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	step := opts.Churn + 1
+	stmts := []string{
+		fmt.Sprintf("INSERT INTO Info VALUES (?,'synthetic',2000,1,%v);", (opts.Depth+1)*step),
+		"INSERT INTO Compositions VALUES (?,1,922350000,0.05);",
+		"INSERT INTO Compositions VALUES (?,1,922380000,0.95);",
+	}
+	for _, s := range stmts {
+		if _, err := tx.Exec(s, simid); err != nil {
+			return err
+		}
+	}
+	for a := 1; a <= opts.Agents; a++ {
+		s := "INSERT INTO AgentEntry VALUES (?,?,'Facility',':agents:Trader','trader',0,-1,0);"
+		if _, err := tx.Exec(s, simid, a); err != nil {
+			return err
+		}
+	}
+
+	resStmt, err := tx.Prepare("INSERT INTO Resources VALUES (?,?,?,'Material',?,?,'kg',1,?,0);")
+	if err != nil {
+		return err
+	}
+	defer resStmt.Close()
+	trStmt, err := tx.Prepare("INSERT INTO Transactions VALUES (?,?,?,?,?,'stuff',?);")
+	if err != nil {
+		return err
+	}
+	defer trStmt.Close()
+
+	type res struct {
+		id, owner int
+		qty       float64
+	}
+	r := rand.New(rand.NewSource(opts.Seed))
+	nextId, nextTx := 1, 1
+	var level []res
+	for i := 0; i < opts.Roots; i++ {
+		rs := res{id: nextId, owner: i%opts.Agents + 1, qty: 100}
+		nextId++
+		if _, err := resStmt.Exec(simid, rs.id, rs.id, 0, rs.qty, 0); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO ResCreators VALUES (?,?,?);", simid, rs.id, rs.owner); err != nil {
+			return err
+		}
+		level = append(level, rs)
+	}
+
+	for d := 0; d <= opts.Depth; d++ {
+		t0 := d * step
+		var next []res
+		for _, rs := range level {
+			for i := 1; i <= opts.Churn && opts.Agents > 1; i++ {
+				to := r.Intn(opts.Agents-1) + 1
+				if to >= rs.owner {
+					to++
+				}
+				if _, err := trStmt.Exec(simid, nextTx, rs.owner, to, rs.id, t0+i); err != nil {
+					return err
+				}
+				nextTx++
+				rs.owner = to
+			}
+			if d == opts.Depth {
+				continue
+			}
+			for i := 0; i < opts.Branching; i++ {
+				kid := res{id: nextId, owner: rs.owner, qty: rs.qty / float64(opts.Branching)}
+				nextId++
+				if _, err := resStmt.Exec(simid, kid.id, rs.id, t0+step, kid.qty, rs.id); err != nil {
+					return err
+				}
+				next = append(next, kid)
+			}
+		}
+		level = next
+	}
+	return tx.Commit()
+}
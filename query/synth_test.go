@@ -0,0 +1,64 @@
+package query
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+)
+
+func TestGenSyntheticSim(t *testing.T) {
+	db := testDb(t)
+	opts := SynthOpts{Roots: 3, Branching: 2, Depth: 2, Churn: 2, Seed: 1}
+	if err := GenSyntheticSim(db, testSimId, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := []struct {
+		Sql  string
+		Want int
+	}{
+		{"SELECT COUNT(*) FROM Resources WHERE SimId = ?", 3 * (1 + 2 + 4)},
+		{"SELECT COUNT(*) FROM ResCreators WHERE SimId = ?", 3},
+		{"SELECT COUNT(*) FROM Transactions WHERE SimId = ?", 3 * (1 + 2 + 4) * 2},
+		{"SELECT COUNT(*) FROM Transactions WHERE SimId = ? AND SenderId = ReceiverId", 0},
+		{"SELECT Duration FROM Info WHERE SimId = ?", 9},
+	}
+	for _, c := range counts {
+		var n int
+		if err := db.QueryRow(c.Sql, testSimId).Scan(&n); err != nil {
+			t.Fatal(err)
+		} else if n != c.Want {
+			t.Errorf("%v: want %v, got %v", c.Sql, c.Want, n)
+		}
+	}
+	checkBalance(t, db)
+
+	if err := GenSyntheticSim(db, []byte("sim2"), SynthOpts{Branching: 0}); err == nil {
+		t.Error("want error for zero branching")
+	}
+}
+
+// checkBalance verifies that the quantities of the children of every
+// resource sum to the quantity of their parent.
+func checkBalance(t *testing.T, db *sql.DB) {
+	rows, err := db.Query(`SELECT p.ResourceId,p.Quantity,SUM(k.Quantity) FROM Resources AS p
+		INNER JOIN Resources AS k ON k.Parent1 = p.ResourceId AND k.SimId = p.SimId
+		WHERE p.SimId = ? GROUP BY p.ResourceId,p.Quantity;`, testSimId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var qty, kids float64
+		if err := rows.Scan(&id, &qty, &kids); err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(qty-kids) > 1e-9 {
+			t.Errorf("resource %v: quantity %v but children sum to %v", id, qty, kids)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}